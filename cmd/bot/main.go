@@ -4,11 +4,16 @@ import (
 	"context"
 	"flag"
 	"log"
+	"net/http"
+	"os"
 	"time"
 
 	"vpn-telegram-bot/internal/config"
 	"vpn-telegram-bot/internal/database"
+	"vpn-telegram-bot/internal/fsm"
 	"vpn-telegram-bot/internal/handlers"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/payment"
 	"vpn-telegram-bot/internal/service"
 
 	tele "gopkg.in/telebot.v3"
@@ -21,6 +26,8 @@ func main() {
 	// Парсим флаги
 	configPath := flag.String("config", "config.yaml", "path to config file")
 	migrationsPath := flag.String("migrations", "db/migrations", "path to migrations directory")
+	reconcile := flag.Bool("reconcile", false, "compare user balances with the ledger and exit")
+	fakePayments := flag.Bool("fake-payments", false, "allow the fake payment gateway (local development only)")
 	flag.Parse()
 
 	// Загружаем конфигурацию
@@ -49,12 +56,77 @@ func main() {
 		log.Println("🧪 Running in MOCK MODE (APP_ENV=local)")
 		vpnProvider = service.NewMockVPNProvider()
 	} else {
-		log.Println("🚀 Running in PRODUCTION MODE (APP_ENV=production)")
-		vpnProvider = service.NewMarzbanProvider(cfg.Marzban)
+		log.Printf("🚀 Running in PRODUCTION MODE (APP_ENV=production, panel=%s)", cfg.VPNPanel)
+		switch cfg.VPNPanel {
+		case config.PanelXUI:
+			vpnProvider = service.NewXUIProvider(cfg.XUI)
+		default:
+			vpnProvider = service.NewMarzbanProvider(cfg.Marzban)
+		}
+	}
+
+	// Загружаем ноды (панель из конфига используется для продуктов без нод)
+	nodes := service.NewNodeManager(db, vpnProvider, cfg.IsMockMode())
+	if err := nodes.Reload(ctx); err != nil {
+		log.Printf("⚠️ %v", err)
 	}
 
 	// Создаём сервис
-	svc := service.New(db, vpnProvider)
+	svc := service.New(db, nodes)
+
+	// Сверка балансов с главной книгой вместо запуска бота
+	if *reconcile {
+		os.Exit(runReconcile(ctx, svc))
+	}
+
+	// Тестовый шлюз зачисляет оплату по любому POST на вебхук: только локально и по явному флагу.
+	// Один экземпляр на все способы оплаты — вебхук находит шлюз по имени
+	var fakeGateway *payment.FakeGateway
+	fake := func() *payment.FakeGateway {
+		if !*fakePayments || !cfg.IsMockMode() {
+			log.Fatalf("Fake payment gateway requires APP_ENV=local and the -fake-payments flag")
+		}
+		if fakeGateway == nil {
+			fakeGateway = payment.NewFakeGateway(cfg.Server.PublicURL)
+		}
+		return fakeGateway
+	}
+
+	// Подключаем платёжные шлюзы (без шлюза оплата принимается вручную через поддержку)
+	switch cfg.Payment.Card {
+	case "yookassa":
+		svc.SetPaymentGateway(payment.MethodCard, payment.NewYooKassaGateway(cfg.Payment.YooKassa))
+	case "fake":
+		svc.SetPaymentGateway(payment.MethodCard, fake())
+	case "":
+		if *fakePayments && cfg.IsMockMode() {
+			svc.SetPaymentGateway(payment.MethodCard, fake())
+		}
+	default:
+		log.Fatalf("Unknown card payment gateway: %s", cfg.Payment.Card)
+	}
+
+	switch cfg.Payment.Crypto {
+	case "cryptobot":
+		svc.SetPaymentGateway(payment.MethodCrypto, payment.NewCryptoBotGateway(cfg.Payment.CryptoBot))
+	case "fake":
+		svc.SetPaymentGateway(payment.MethodCrypto, fake())
+	case "":
+	default:
+		log.Fatalf("Unknown crypto payment gateway: %s", cfg.Payment.Crypto)
+	}
+
+	if cfg.Payment.Stars.Enabled {
+		svc.EnableStars(cfg.Payment.Stars.RubPerStar)
+	}
+
+	if cfg.Trial.Enabled {
+		svc.EnableTrial(service.TrialSettings{
+			ProductID: cfg.Trial.ProductID,
+			Days:      cfg.Trial.Days,
+			TrafficGB: *cfg.Trial.TrafficGB,
+		})
+	}
 
 	// Настраиваем бота
 	pref := tele.Settings{
@@ -67,20 +139,67 @@ func main() {
 		log.Fatalf("Failed to create bot: %v", err)
 	}
 
+	// Состояния диалогов (мастера, ожидание ввода)
+	var sessionStore fsm.Store
+	switch cfg.Sessions.Backend {
+	case fsm.BackendPostgres:
+		sessionStore = fsm.NewPostgresStore(db)
+	case fsm.BackendMemory:
+		sessionStore = fsm.NewMemoryStore()
+	default:
+		log.Fatalf("Unknown sessions backend: %s", cfg.Sessions.Backend)
+	}
+	sessions := fsm.New(sessionStore, time.Duration(cfg.Sessions.TTLMinutes)*time.Minute)
+	sessions.StartCleanup(10 * time.Minute)
+	defer sessions.StopCleanup()
+
 	// Регистрируем обработчики
-	h := handlers.New(svc, cfg.Telegram.AdminIDs, SupportGroupID)
+	h := handlers.New(svc, cfg.Telegram.AdminIDs, SupportGroupID, sessions)
+	if cfg.Payment.SBP.Details != "" {
+		h.SetManualSBP(cfg.Payment.SBP.Details, cfg.Payment.SBP.ReviewChatID)
+	}
+	if cfg.Server.PublicURL != "" {
+		h.SetSubscriptionBaseURL(cfg.Server.PublicURL)
+	}
 	h.Register(bot)
 	h.RegisterAdmin(bot)
 
 	// Support Bridge: слушаем ответы в группе поддержки
 	h.RegisterSupportBridge(bot, SupportGroupID)
 
-	// Создаём и запускаем Watchdog
+	// HTTP сервер для вебхуков платёжных систем и ссылок подписки
+	mux := http.NewServeMux()
+	mux.Handle("POST /webhooks/payment/{gateway}", h.PaymentWebhookHandler())
+	mux.Handle("GET /sub/{token}", h.SubscriptionFeedHandler())
+	httpServer := &http.Server{
+		Addr:              cfg.Server.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("🌐 HTTP server listening on %s", cfg.Server.Listen)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("❌ HTTP server error: %v", err)
+		}
+	}()
+	defer httpServer.Shutdown(context.Background())
+
+	// Создаём и запускаем Watchdog: панель из конфига и все включённые ноды
 	watchdogConfig := service.DefaultWatchdogConfig()
-	watchdog := service.NewWatchdog(bot, cfg.Telegram.AdminIDs, vpnProvider, watchdogConfig)
+	watchdog := service.NewWatchdog(bot, cfg.Telegram.AdminIDs, nodes, watchdogConfig)
 	watchdog.Start()
 	defer watchdog.Stop()
 
+	// Запускаем планировщик напоминаний и отключения истёкших подписок
+	scheduler := service.NewScheduler(bot, svc, service.DefaultSchedulerConfig())
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	// Опрос неоплаченных счетов (на случай, если вебхук не дошёл) и повтор несостоявшихся активаций
+	invoicePoller := service.NewInvoicePoller(svc, service.DefaultInvoicePollerConfig())
+	invoicePoller.Start()
+	defer invoicePoller.Stop()
+
 	// Регистрируем команду для тестирования Watchdog (только для админов)
 	bot.Handle("/watchdog_test", func(c tele.Context) error {
 		for _, adminID := range cfg.Telegram.AdminIDs {
@@ -95,5 +214,27 @@ func main() {
 	log.Printf("🐸 Bot @%s started!", bot.Me.Username)
 	log.Printf("👑 Admin IDs: %v", cfg.Telegram.AdminIDs)
 	log.Println("🐕 Watchdog monitoring active")
+	log.Println("⏰ Expiry scheduler active")
 	bot.Start()
 }
+
+// runReconcile печатает расхождения users.balance с главной книгой. Код выхода 1 — есть расхождения
+func runReconcile(ctx context.Context, svc *service.Service) int {
+	report, err := svc.ReconcileLedger(ctx)
+	if err != nil {
+		log.Printf("❌ Reconciliation failed: %v", err)
+		return 2
+	}
+	for _, d := range report.Drift {
+		log.Printf("⚠️ User %d (tg %d): balance %.2f, ledger %.2f, drift %+.2f",
+			d.UserID, d.TelegramID, models.Rubles(d.Balance), models.Rubles(d.Ledger), models.Rubles(d.Balance-d.Ledger))
+	}
+	if report.UnbalancedPostings > 0 {
+		log.Printf("⚠️ Unbalanced ledger postings: %d", report.UnbalancedPostings)
+	}
+	if !report.OK() {
+		return 1
+	}
+	log.Println("✅ Balances match the ledger")
+	return 0
+}
@@ -0,0 +1,12 @@
+-- Migration: 007_subscription_vpn_username
+-- Description: Store VPN panel username on subscriptions
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS vpn_username VARCHAR(100);
+
+-- Backfill from key_string (username is embedded in the link remark / mock key)
+UPDATE subscriptions
+SET vpn_username = substring(key_string from '((?:gift_)?tg_[0-9]+_[0-9]+)')
+WHERE vpn_username IS NULL
+  AND key_string ~ 'tg_[0-9]+_[0-9]+';
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_vpn_username ON subscriptions(vpn_username);
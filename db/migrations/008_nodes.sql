@@ -0,0 +1,30 @@
+-- Migration: 008_nodes
+-- Description: Multi-node server registry with per-product node assignment
+
+-- VPN nodes (each node is a separate panel)
+CREATE TABLE IF NOT EXISTS nodes (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE,
+    panel_type VARCHAR(20) NOT NULL DEFAULT 'marzban', -- marzban | 3xui
+    base_url VARCHAR(255) NOT NULL,
+    username VARCHAR(100) NOT NULL DEFAULT '',
+    password VARCHAR(255) NOT NULL DEFAULT '',
+    inbound_id INT NOT NULL DEFAULT 0,                 -- only for 3xui
+    public_host VARCHAR(255) NOT NULL DEFAULT '',      -- only for 3xui
+    flow VARCHAR(50) NOT NULL DEFAULT '',              -- only for 3xui
+    capacity INT NOT NULL DEFAULT 0,                   -- max active subscriptions, 0 = unlimited
+    is_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Which nodes serve which product (location)
+CREATE TABLE IF NOT EXISTS product_nodes (
+    product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    node_id BIGINT NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    PRIMARY KEY (product_id, node_id)
+);
+
+-- Node the subscription was placed on (NULL = default panel from config)
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS node_id BIGINT REFERENCES nodes(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_node ON subscriptions(node_id);
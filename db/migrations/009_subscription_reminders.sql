@@ -0,0 +1,13 @@
+-- Migration: 009_subscription_reminders
+-- Description: Track sent expiry reminders so restarts do not send duplicates
+
+CREATE TABLE IF NOT EXISTS subscription_reminders (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL,          -- 3d | 1d | expired
+    expires_at TIMESTAMP NOT NULL,      -- expiry date the reminder was sent for (new date after extension = new reminders)
+    sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(subscription_id, kind, expires_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_expires_active ON subscriptions(expires_at) WHERE is_active = true;
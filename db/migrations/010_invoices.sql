@@ -0,0 +1,19 @@
+-- Migration: 010_invoices
+-- Description: Invoices for automated payment gateways
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    gateway VARCHAR(50) NOT NULL,               -- yookassa | fake | ...
+    external_id VARCHAR(255),                   -- payment ID at the provider
+    purpose VARCHAR(50) NOT NULL DEFAULT 'top_up',
+    amount DECIMAL(10,2) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending | paid | canceled
+    payment_url TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    paid_at TIMESTAMP,
+    UNIQUE(gateway, external_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoices_user ON invoices(user_id);
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(status);
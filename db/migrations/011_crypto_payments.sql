@@ -0,0 +1,11 @@
+-- Migration: 011_crypto_payments
+-- Description: Plan invoices and crypto bonus days
+
+-- Plan purchase invoices (purpose = 'subscription')
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS product_id BIGINT REFERENCES products(id);
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS months INT NOT NULL DEFAULT 0;
+-- Bonus days granted by the payment method (+7 days for crypto)
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS bonus_days INT NOT NULL DEFAULT 0;
+
+-- Bonus days from crypto top-ups, applied to the next purchased subscription
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_bonus_days INT NOT NULL DEFAULT 0;
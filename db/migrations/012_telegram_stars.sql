@@ -0,0 +1,11 @@
+-- Migration: 012_telegram_stars
+-- Description: Telegram Stars (XTR) payments stored as invoices of the telegram_stars gateway
+
+-- Price in Stars the invoice was issued for (0 for RUB gateways)
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS amount_stars INT NOT NULL DEFAULT 0;
+
+-- Subscription activated by the paid invoice (needed to revoke it on refund)
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS subscription_id BIGINT REFERENCES subscriptions(id) ON DELETE SET NULL;
+
+-- For telegram_stars external_id holds telegram_payment_charge_id:
+-- UNIQUE(gateway, external_id) makes duplicate successful_payment updates a no-op
@@ -0,0 +1,10 @@
+-- Migration: 013_receipt_review
+-- Description: Manual SBP receipts reviewed by admins (pending top_up transactions)
+
+-- Telegram file_id of the receipt photo uploaded by the user
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS receipt_file_id TEXT;
+-- Admin (telegram_id) who approved or rejected the receipt
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reviewed_by BIGINT;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_transactions_pending ON transactions(created_at) WHERE status = 'pending';
@@ -0,0 +1,38 @@
+-- Migration: 014_support_tickets
+-- Description: Durable support tickets and their message history
+
+CREATE TABLE IF NOT EXISTS tickets (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id),
+    status VARCHAR(20) NOT NULL DEFAULT 'new',  -- new | waiting | replied | closed
+    assignee_id BIGINT,                         -- telegram_id of the admin handling the ticket
+    composing BOOLEAN NOT NULL DEFAULT true,    -- support mode: user messages go to the ticket
+    group_message_id INT,                       -- last ticket message in the support group
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    closed_at TIMESTAMP
+);
+
+-- At most one open ticket per user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tickets_open_user ON tickets(user_id) WHERE status <> 'closed';
+CREATE INDEX IF NOT EXISTS idx_tickets_status ON tickets(status);
+
+CREATE TABLE IF NOT EXISTS ticket_messages (
+    id BIGSERIAL PRIMARY KEY,
+    ticket_id BIGINT NOT NULL REFERENCES tickets(id) ON DELETE CASCADE,
+    from_admin BOOLEAN NOT NULL DEFAULT false,
+    sender_id BIGINT NOT NULL,                  -- telegram_id of the author
+    kind VARCHAR(20) NOT NULL DEFAULT 'text',   -- text | photo | document | voice | video | sticker
+    text TEXT,                                  -- message text or caption
+    group_message_id INT,                       -- copy of the message in the support group
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_ticket_messages_ticket ON ticket_messages(ticket_id);
+CREATE INDEX IF NOT EXISTS idx_ticket_messages_group_msg ON ticket_messages(group_message_id) WHERE group_message_id IS NOT NULL;
+
+-- Pinned dashboard message in the support group
+CREATE TABLE IF NOT EXISTS support_dashboard (
+    chat_id BIGINT PRIMARY KEY,
+    message_id INT NOT NULL
+);
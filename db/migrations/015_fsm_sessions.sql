@@ -0,0 +1,12 @@
+-- Migration: 015_fsm_sessions
+-- Description: Per-user dialog state (wizards, input modes) with expiry
+
+CREATE TABLE IF NOT EXISTS fsm_sessions (
+    user_id BIGINT PRIMARY KEY,          -- telegram_id
+    state VARCHAR(64) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_fsm_sessions_expires ON fsm_sessions(expires_at);
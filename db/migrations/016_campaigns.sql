@@ -0,0 +1,18 @@
+-- Migration: 016_campaigns
+-- Description: Discount campaigns (flash sales) with schedule and product/plan filters
+
+CREATE TABLE IF NOT EXISTS campaigns (
+    id BIGSERIAL PRIMARY KEY,
+    discount_percent INT NOT NULL CHECK (discount_percent > 0 AND discount_percent < 100),
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL,
+    product_id BIGINT REFERENCES products(id) ON DELETE CASCADE,  -- NULL = all products
+    months INT,                                                   -- NULL = all plans
+    created_by BIGINT,                                            -- telegram_id of the admin
+    announced_at TIMESTAMP,                                       -- start announcement was broadcast
+    cancelled_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_campaigns_period ON campaigns(starts_at, ends_at) WHERE cancelled_at IS NULL;
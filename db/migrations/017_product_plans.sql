@@ -0,0 +1,29 @@
+-- Migration: 017_product_plans
+-- Description: Configurable subscription plans per product (duration, price or discount)
+
+CREATE TABLE IF NOT EXISTS product_plans (
+    id BIGSERIAL PRIMARY KEY,
+    product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    months INT NOT NULL DEFAULT 0 CHECK (months >= 0),
+    days INT NOT NULL DEFAULT 0 CHECK (days >= 0),
+    price DECIMAL(10,2) CHECK (price > 0),     -- fixed price; NULL = base_price * period minus discount
+    discount_percent INT NOT NULL DEFAULT 0 CHECK (discount_percent >= 0 AND discount_percent < 100),
+    sort_order INT NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (months > 0 OR days > 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_plans_product ON product_plans(product_id, sort_order);
+
+-- Plans that used to be hardcoded: 1/3/6/12 months with 0/0/10/20% discount
+INSERT INTO product_plans (product_id, months, discount_percent, sort_order)
+SELECT p.id, v.months, v.discount_percent, v.sort_order
+FROM products p
+CROSS JOIN (VALUES (1, 0, 1), (3, 0, 2), (6, 10, 3), (12, 20, 4)) AS v(months, discount_percent, sort_order)
+WHERE NOT EXISTS (SELECT 1 FROM product_plans pp WHERE pp.product_id = p.id);
+
+-- Plan purchase invoices: purchased plan and the day part of its duration
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS plan_id BIGINT REFERENCES product_plans(id) ON DELETE SET NULL;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS days INT NOT NULL DEFAULT 0;
@@ -0,0 +1,31 @@
+-- Migration: 018_traffic_quotas
+-- Description: Traffic quotas for products and plans, add-on traffic packs, monthly quota reset
+
+-- Product quota: 0 = unlimited; traffic_reset = 'no_reset' | 'month'
+ALTER TABLE products ADD COLUMN IF NOT EXISTS traffic_limit_gb INT NOT NULL DEFAULT 0 CHECK (traffic_limit_gb >= 0);
+ALTER TABLE products ADD COLUMN IF NOT EXISTS traffic_reset VARCHAR(20) NOT NULL DEFAULT 'no_reset';
+
+-- Plan quota overrides the product one; NULL = product quota, 0 = unlimited
+ALTER TABLE product_plans ADD COLUMN IF NOT EXISTS traffic_limit_gb INT CHECK (traffic_limit_gb >= 0);
+
+-- Subscription quota in bytes for the current period: base limit + purchased add-ons
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS traffic_limit BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS traffic_extra BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS traffic_reset VARCHAR(20) NOT NULL DEFAULT 'no_reset';
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS traffic_reset_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_traffic_reset ON subscriptions(traffic_reset_at)
+    WHERE traffic_reset_at IS NOT NULL;
+
+-- Add-on traffic packs bought from balance for a limited subscription
+CREATE TABLE IF NOT EXISTS traffic_packs (
+    id BIGSERIAL PRIMARY KEY,
+    product_id BIGINT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    traffic_gb INT NOT NULL CHECK (traffic_gb > 0),
+    price DECIMAL(10,2) NOT NULL CHECK (price > 0),
+    sort_order INT NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_traffic_packs_product ON traffic_packs(product_id, sort_order);
@@ -0,0 +1,13 @@
+-- Migration: 019_device_limits
+-- Description: Device (IP) limits for products and plans, extra device slots bought from balance
+
+-- Product limit: 0 = unlimited; device_slot_price = monthly price of one extra device, NULL = slots are not sold
+ALTER TABLE products ADD COLUMN IF NOT EXISTS device_limit INT NOT NULL DEFAULT 0 CHECK (device_limit >= 0);
+ALTER TABLE products ADD COLUMN IF NOT EXISTS device_slot_price DECIMAL(10,2) CHECK (device_slot_price > 0);
+
+-- Plan limit overrides the product one; NULL = product limit, 0 = unlimited
+ALTER TABLE product_plans ADD COLUMN IF NOT EXISTS device_limit INT CHECK (device_limit >= 0);
+
+-- Subscription limit for the current period: base limit + purchased slots
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS device_limit INT NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS extra_devices INT NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+-- Migration: 020_subscription_tokens
+-- Description: Random per-subscription token for the /sub/{token} subscription URL
+
+-- Volatile default gives every existing row its own token
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS sub_token VARCHAR(64) NOT NULL DEFAULT replace(gen_random_uuid()::text, '-', '');
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_subscriptions_sub_token ON subscriptions(sub_token);
@@ -0,0 +1,16 @@
+-- Migration: 021_audit_log
+-- Description: Audit log of sensitive actions (key rotation) by users and admins
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id BIGINT NOT NULL,                -- Telegram ID of who performed the action
+    actor_role VARCHAR(10) NOT NULL,         -- 'user' | 'admin'
+    action VARCHAR(50) NOT NULL,
+    user_id BIGINT REFERENCES users(id),     -- affected user
+    subscription_id BIGINT REFERENCES subscriptions(id) ON DELETE SET NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_subscription ON audit_log(subscription_id, action, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log(user_id, created_at);
@@ -0,0 +1,11 @@
+-- Migration: 022_trials
+-- Description: One-time free trial claims (one per user)
+
+CREATE TABLE IF NOT EXISTS trial_claims (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL UNIQUE REFERENCES users(id),
+    subscription_id BIGINT REFERENCES subscriptions(id) ON DELETE SET NULL, -- NULL while the trial key is being created
+    claimed_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_trial_claims_claimed_at ON trial_claims(claimed_at);
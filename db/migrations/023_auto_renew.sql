@@ -0,0 +1,9 @@
+-- Migration: 023_auto_renew
+-- Description: Auto-renewal of subscriptions from balance by the last purchased plan
+
+-- Plan of the last purchase or extension (NULL = gift/trial or plan deleted)
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS plan_id BIGINT REFERENCES product_plans(id) ON DELETE SET NULL;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS auto_renew BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS auto_renew_attempted_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_auto_renew ON subscriptions(expires_at) WHERE auto_renew = true AND is_active = true;
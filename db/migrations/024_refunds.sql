@@ -0,0 +1,11 @@
+-- Migration: 024_refunds
+-- Description: Link purchases to subscriptions and refunds to the purchase they return
+
+-- Subscription paid for by the purchase (or returned by the refund)
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS subscription_id BIGINT REFERENCES subscriptions(id) ON DELETE SET NULL;
+-- Purchase returned by the refund
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS refund_of BIGINT REFERENCES transactions(id);
+-- How the refund was paid out: 'balance' (credited in the bot) or 'external' (paid outside, balance untouched)
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS refund_method VARCHAR(16);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_subscription ON transactions(subscription_id) WHERE subscription_id IS NOT NULL;
@@ -0,0 +1,36 @@
+-- Migration: 025_ledger
+-- Description: Double-entry ledger in kopecks for user balances
+
+-- Every balance change is a posting of two entries with opposite amounts:
+-- the user's account ('user' + user_id) and a system counter account
+CREATE SEQUENCE IF NOT EXISTS ledger_posting_seq;
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id BIGSERIAL PRIMARY KEY,
+    posting_id BIGINT NOT NULL,
+    account VARCHAR(32) NOT NULL,
+    user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+    amount BIGINT NOT NULL, -- kopecks, positive = credit to the account
+    reason VARCHAR(32) NOT NULL,
+    transaction_id BIGINT REFERENCES transactions(id) ON DELETE SET NULL,
+    subscription_id BIGINT REFERENCES subscriptions(id) ON DELETE SET NULL,
+    promo_id BIGINT REFERENCES promo_codes(id) ON DELETE SET NULL,
+    invoice_id BIGINT REFERENCES invoices(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CHECK ((account = 'user') = (user_id IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_user ON ledger_entries(user_id) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_posting ON ledger_entries(posting_id);
+
+-- Opening balances: current balances become the first posting of each user
+WITH opening AS (
+    SELECT u.id AS user_id, ROUND(u.balance * 100)::BIGINT AS amount, nextval('ledger_posting_seq') AS posting_id
+    FROM users u
+    WHERE u.balance <> 0
+      AND NOT EXISTS (SELECT 1 FROM ledger_entries l WHERE l.user_id = u.id)
+)
+INSERT INTO ledger_entries (posting_id, account, user_id, amount, reason)
+SELECT posting_id, 'user', user_id, amount, 'opening_balance' FROM opening
+UNION ALL
+SELECT posting_id, 'opening', NULL, -amount, 'opening_balance' FROM opening;
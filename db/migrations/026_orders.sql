@@ -0,0 +1,26 @@
+-- Migration: 026_orders
+-- Description: Balance purchase orders: charge and provisioning tracked by one row with an idempotency key
+
+CREATE TABLE IF NOT EXISTS orders (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    idempotency_key VARCHAR(128) NOT NULL,
+    kind VARCHAR(16) NOT NULL, -- 'new' or 'extend'
+    product_id BIGINT NOT NULL REFERENCES products(id),
+    plan_id BIGINT REFERENCES product_plans(id) ON DELETE SET NULL,
+    -- Extended subscription, or the one created by the order once completed
+    subscription_id BIGINT REFERENCES subscriptions(id) ON DELETE SET NULL,
+    amount DECIMAL(10,2) NOT NULL,
+    bonus_days INT NOT NULL DEFAULT 0,
+    -- Panel client of a new subscription, saved before it is created so a crashed order can be cleaned up
+    node_id BIGINT REFERENCES nodes(id) ON DELETE SET NULL,
+    vpn_username VARCHAR(255),
+    purchase_id BIGINT REFERENCES transactions(id),
+    status VARCHAR(16) NOT NULL DEFAULT 'pending', -- pending, completed, failed
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_orders_pending ON orders(created_at) WHERE status = 'pending';
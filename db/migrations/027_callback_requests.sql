@@ -0,0 +1,17 @@
+-- Migration: 027_callback_requests
+-- Description: Deduplication of money-moving callback queries (Telegram retries, double taps)
+
+CREATE TABLE IF NOT EXISTS callback_requests (
+    id BIGSERIAL PRIMARY KEY,
+    callback_id VARCHAR(64) NOT NULL UNIQUE,
+    telegram_id BIGINT NOT NULL,
+    -- User, button and message state: equal for repeated taps on the same button
+    fingerprint VARCHAR(255) NOT NULL UNIQUE,
+    status VARCHAR(16) NOT NULL DEFAULT 'processing', -- processing, done
+    -- Message the handler answered with, replayed to repeats
+    result JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_callback_requests_created ON callback_requests(created_at);
@@ -0,0 +1,14 @@
+-- Migration: 028_currencies
+-- Description: Display currencies with admin-managed exchange rates and per-user preferred currency
+
+-- Prices, balances and the ledger stay in RUB; other currencies are converted for display
+CREATE TABLE IF NOT EXISTS currencies (
+    code VARCHAR(8) PRIMARY KEY,
+    rate DECIMAL(18,6) NOT NULL CHECK (rate > 0), -- RUB per one unit of the currency
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_by BIGINT -- Telegram ID of the admin who set the rate
+);
+
+INSERT INTO currencies (code, rate) VALUES ('RUB', 1) ON CONFLICT (code) DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS currency VARCHAR(8) NOT NULL DEFAULT 'RUB';
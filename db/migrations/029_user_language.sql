@@ -0,0 +1,8 @@
+-- Migration: 029_user_language
+-- Description: Interface language: Telegram language_code seen last and an optional language chosen in settings
+
+-- Language picked by the user in settings; NULL means auto-detect from language_code
+ALTER TABLE users ADD COLUMN IF NOT EXISTS language VARCHAR(8);
+
+-- language_code of the user's Telegram client, remembered so broadcasts can pick a variant without an update
+ALTER TABLE users ADD COLUMN IF NOT EXISTS language_code VARCHAR(16);
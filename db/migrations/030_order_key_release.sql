@@ -0,0 +1,6 @@
+-- Migration: 030_order_key_release
+-- Description: A failed order releases its idempotency key so the same purchase can be retried
+
+-- The key stays unique only among pending and completed orders
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_user_id_idempotency_key_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_orders_idempotency_key ON orders(user_id, idempotency_key) WHERE status <> 'failed';
@@ -0,0 +1,16 @@
+-- Migration: 031_auto_renew_attempts
+-- Description: Auto-renew attempts are logged per subscription instead of zero-amount transactions
+
+CREATE TABLE IF NOT EXISTS auto_renew_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    order_id BIGINT REFERENCES orders(id) ON DELETE SET NULL, -- NULL when no order was created (e.g. insufficient balance)
+    status VARCHAR(20) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_auto_renew_attempts_subscription ON auto_renew_attempts(subscription_id, created_at);
+
+-- Attempts used to be written as zero-amount auto_renew transactions
+DELETE FROM transactions WHERE type = 'auto_renew' AND amount = 0;
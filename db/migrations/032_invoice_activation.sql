@@ -0,0 +1,7 @@
+-- Migration: 032_invoice_activation
+-- Description: Paid subscription invoices stay pending activation until the subscription is created
+
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS activation_pending BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS activation_error TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_invoices_activation_pending ON invoices(paid_at) WHERE activation_pending = true;
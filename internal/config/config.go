@@ -12,10 +12,22 @@ type Config struct {
 	Telegram    TelegramConfig `yaml:"telegram"`
 	Database    DatabaseConfig `yaml:"database"`
 	Marzban     MarzbanConfig  `yaml:"marzban"`
+	XUI         XUIConfig      `yaml:"xui"`
+	VPNPanel    string         `yaml:"vpn_panel"` // "marzban" (по умолчанию) или "3xui"
+	Server      ServerConfig   `yaml:"server"`
+	Payment     PaymentConfig  `yaml:"payment"`
+	Sessions    SessionsConfig `yaml:"sessions"`
+	Trial       TrialConfig    `yaml:"trial"`
 	DatabaseURL string         `yaml:"-"` // Loaded from environment
 	AppEnv      string         `yaml:"-"` // "local" = mock mode, "production" = real Marzban
 }
 
+// Типы поддерживаемых VPN панелей
+const (
+	PanelMarzban = "marzban"
+	PanelXUI     = "3xui"
+)
+
 // TelegramConfig настройки Telegram бота
 type TelegramConfig struct {
 	Token    string  `yaml:"token"`
@@ -39,6 +51,72 @@ type MarzbanConfig struct {
 	Password string `yaml:"password"`
 }
 
+// XUIConfig настройки панели 3X-UI / X-UI
+type XUIConfig struct {
+	BaseURL   string `yaml:"base_url"` // адрес панели вместе с web base path
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+	InboundID int    `yaml:"inbound_id"` // inbound, в который добавляются клиенты
+	Host      string `yaml:"host"`       // публичный адрес сервера для ссылок (по умолчанию хост из base_url)
+	Flow      string `yaml:"flow"`       // flow для VLESS клиентов, например xtls-rprx-vision
+}
+
+// ServerConfig настройки HTTP сервера (вебхуки платёжных систем, ссылки подписки)
+type ServerConfig struct {
+	Listen    string `yaml:"listen"`     // адрес, например ":8080"
+	PublicURL string `yaml:"public_url"` // внешний адрес сервера, например https://bot.example.com (без него ссылки подписки не выдаются)
+}
+
+// SessionsConfig хранилище состояний диалогов (мастера, ожидание ввода)
+type SessionsConfig struct {
+	Backend    string `yaml:"backend"`     // "postgres" (по умолчанию) или "memory"
+	TTLMinutes int    `yaml:"ttl_minutes"` // через сколько минут бездействия состояние сбрасывается, по умолчанию 30
+}
+
+// TrialConfig бесплатная пробная подписка (одна на аккаунт, только для тех, у кого не было подписок)
+type TrialConfig struct {
+	Enabled   bool  `yaml:"enabled"`
+	ProductID int64 `yaml:"product_id"` // продукт пробной подписки, по умолчанию 1
+	Days      int   `yaml:"days"`       // срок, по умолчанию 3 дня
+	TrafficGB *int  `yaml:"traffic_gb"` // квота трафика, по умолчанию 5 ГБ (0 = без ограничения)
+}
+
+// PaymentConfig настройки платёжных шлюзов
+type PaymentConfig struct {
+	Card      string          `yaml:"card"`   // шлюз для СБП/карт: "yookassa", "fake" или пусто (ручная оплата)
+	Crypto    string          `yaml:"crypto"` // шлюз для криптовалюты: "cryptobot", "fake" или пусто (ручная оплата)
+	YooKassa  YooKassaConfig  `yaml:"yookassa"`
+	CryptoBot CryptoBotConfig `yaml:"cryptobot"`
+	Stars     StarsConfig     `yaml:"stars"`
+	SBP       ManualSBPConfig `yaml:"sbp"`
+}
+
+// ManualSBPConfig ручная оплата СБП по реквизитам с проверкой чека админом (если шлюз для card не подключён)
+type ManualSBPConfig struct {
+	Details      string `yaml:"details"`        // реквизиты для перевода (Markdown)
+	ReviewChatID int64  `yaml:"review_chat_id"` // чат, куда приходят чеки на проверку (по умолчанию группа поддержки)
+}
+
+// StarsConfig настройки оплаты Telegram Stars (XTR)
+type StarsConfig struct {
+	Enabled    bool    `yaml:"enabled"`
+	RubPerStar float64 `yaml:"rub_per_star"` // курс пересчёта цены в звёзды, по умолчанию 1.5 ₽ за ⭐️
+}
+
+// YooKassaConfig настройки ЮKassa
+type YooKassaConfig struct {
+	ShopID    string `yaml:"shop_id"`
+	SecretKey string `yaml:"secret_key"`
+	ReturnURL string `yaml:"return_url"` // куда вернуть пользователя после оплаты (например ссылка на бота)
+}
+
+// CryptoBotConfig настройки Crypto Pay API (@CryptoBot)
+type CryptoBotConfig struct {
+	Token   string   `yaml:"token"`
+	Testnet bool     `yaml:"testnet"` // использовать @CryptoTestnetBot
+	Assets  []string `yaml:"assets"`  // принимаемые монеты, по умолчанию USDT, TON, BTC
+}
+
 // Load загружает конфигурацию из файла и окружения
 func Load(path string) (*Config, error) {
 	// Load .env file if it exists (ignore error if file doesn't exist)
@@ -60,6 +138,31 @@ func Load(path string) (*Config, error) {
 	if cfg.AppEnv == "" {
 		cfg.AppEnv = "local" // Default to mock mode for safety
 	}
+	if cfg.VPNPanel == "" {
+		cfg.VPNPanel = PanelMarzban
+	}
+	if cfg.Server.Listen == "" {
+		cfg.Server.Listen = ":8080"
+	}
+	if cfg.Sessions.Backend == "" {
+		cfg.Sessions.Backend = "postgres"
+	}
+	if cfg.Sessions.TTLMinutes <= 0 {
+		cfg.Sessions.TTLMinutes = 30
+	}
+	if cfg.Trial.ProductID == 0 {
+		cfg.Trial.ProductID = 1
+	}
+	if cfg.Trial.Days <= 0 {
+		cfg.Trial.Days = 3
+	}
+	if cfg.Trial.TrafficGB == nil {
+		trafficGB := 5
+		cfg.Trial.TrafficGB = &trafficGB
+	}
+	if cfg.Payment.Stars.RubPerStar <= 0 {
+		cfg.Payment.Stars.RubPerStar = 1.5
+	}
 
 	return &cfg, nil
 }
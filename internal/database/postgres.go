@@ -11,6 +11,7 @@ import (
 
 	"vpn-telegram-bot/internal/models"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -137,8 +138,9 @@ func (db *DB) GetOrCreateUser(ctx context.Context, telegramID int64, username st
 		INSERT INTO users (telegram_id, username)
 		VALUES ($1, $2)
 		ON CONFLICT (telegram_id) DO UPDATE SET username = EXCLUDED.username
-		RETURNING id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at
-	`, telegramID, username).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt)
+		RETURNING id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at, currency,
+			COALESCE(language, ''), COALESCE(language_code, '')
+	`, telegramID, username).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt, &user.Currency, &user.Language, &user.LanguageCode)
 
 	if err != nil {
 		return nil, err
@@ -155,8 +157,9 @@ func (db *DB) CreateUserWithReferrer(ctx context.Context, telegramID int64, user
 		INSERT INTO users (telegram_id, username, referrer_id)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (telegram_id) DO NOTHING
-		RETURNING id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at
-	`, telegramID, username, referrerTelegramID).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt)
+		RETURNING id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at, currency,
+			COALESCE(language, ''), COALESCE(language_code, '')
+	`, telegramID, username, referrerTelegramID).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt, &user.Currency, &user.Language, &user.LanguageCode)
 
 	if err != nil {
 		// Если пользователь уже существует, просто получим его
@@ -179,9 +182,10 @@ func (db *DB) UserExists(ctx context.Context, telegramID int64) (bool, error) {
 func (db *DB) GetUserByTelegramIDForReferral(ctx context.Context, telegramID int64) (*models.User, error) {
 	var user models.User
 	err := db.Pool.QueryRow(ctx, `
-		SELECT id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at
+		SELECT id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at, currency,
+			COALESCE(language, ''), COALESCE(language_code, '')
 		FROM users WHERE telegram_id = $1
-	`, telegramID).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt)
+	`, telegramID).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt, &user.Currency, &user.Language, &user.LanguageCode)
 
 	if err != nil {
 		return nil, err
@@ -194,9 +198,10 @@ func (db *DB) GetUserByTelegramIDForReferral(ctx context.Context, telegramID int
 func (db *DB) GetUserByTelegramID(ctx context.Context, telegramID int64) (*models.User, error) {
 	var user models.User
 	err := db.Pool.QueryRow(ctx, `
-		SELECT id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at
+		SELECT id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at, currency,
+			COALESCE(language, ''), COALESCE(language_code, '')
 		FROM users WHERE telegram_id = $1
-	`, telegramID).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt)
+	`, telegramID).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt, &user.Currency, &user.Language, &user.LanguageCode)
 
 	if err != nil {
 		return nil, err
@@ -210,7 +215,8 @@ func (db *DB) GetUserByTelegramID(ctx context.Context, telegramID int64) (*model
 // GetAllProducts получает все продукты
 func (db *DB) GetAllProducts(ctx context.Context) ([]models.Product, error) {
 	rows, err := db.Pool.Query(ctx, `
-		SELECT id, name, country_flag, base_price, marzban_tag, COALESCE(description, ''), sort_order
+		SELECT id, name, country_flag, base_price, marzban_tag, COALESCE(description, ''), sort_order, traffic_limit_gb, traffic_reset,
+			device_limit, device_slot_price
 		FROM products ORDER BY sort_order
 	`)
 	if err != nil {
@@ -221,7 +227,8 @@ func (db *DB) GetAllProducts(ctx context.Context) ([]models.Product, error) {
 	var products []models.Product
 	for rows.Next() {
 		var p models.Product
-		if err := rows.Scan(&p.ID, &p.Name, &p.CountryFlag, &p.BasePrice, &p.MarzbanTag, &p.Description, &p.SortOrder); err != nil {
+		if err := rows.Scan(&p.ID, &p.Name, &p.CountryFlag, &p.BasePrice, &p.MarzbanTag, &p.Description, &p.SortOrder, &p.TrafficLimitGB, &p.TrafficReset,
+			&p.DeviceLimit, &p.DeviceSlotPrice); err != nil {
 			return nil, err
 		}
 		products = append(products, p)
@@ -234,9 +241,11 @@ func (db *DB) GetAllProducts(ctx context.Context) ([]models.Product, error) {
 func (db *DB) GetProductByID(ctx context.Context, id int64) (*models.Product, error) {
 	var p models.Product
 	err := db.Pool.QueryRow(ctx, `
-		SELECT id, name, country_flag, base_price, marzban_tag, COALESCE(description, ''), sort_order
+		SELECT id, name, country_flag, base_price, marzban_tag, COALESCE(description, ''), sort_order, traffic_limit_gb, traffic_reset,
+			device_limit, device_slot_price
 		FROM products WHERE id = $1
-	`, id).Scan(&p.ID, &p.Name, &p.CountryFlag, &p.BasePrice, &p.MarzbanTag, &p.Description, &p.SortOrder)
+	`, id).Scan(&p.ID, &p.Name, &p.CountryFlag, &p.BasePrice, &p.MarzbanTag, &p.Description, &p.SortOrder, &p.TrafficLimitGB, &p.TrafficReset,
+		&p.DeviceLimit, &p.DeviceSlotPrice)
 
 	if err != nil {
 		return nil, err
@@ -245,18 +254,236 @@ func (db *DB) GetProductByID(ctx context.Context, id int64) (*models.Product, er
 	return &p, nil
 }
 
+// UpdateProductTraffic сохраняет квоту трафика продукта и стратегию её сброса
+func (db *DB) UpdateProductTraffic(ctx context.Context, id int64, limitGB int, reset string) error {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE products SET traffic_limit_gb = $2, traffic_reset = $3 WHERE id = $1
+	`, id, limitGB, reset)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateProductDevices сохраняет лимит устройств продукта и цену дополнительного устройства (nil — не продаются)
+func (db *DB) UpdateProductDevices(ctx context.Context, id int64, limit int, slotPrice *float64) error {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE products SET device_limit = $2, device_slot_price = $3 WHERE id = $1
+	`, id, limit, slotPrice)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// === Product Plan Methods ===
+
+// productPlanColumns колонки плана в порядке productPlanScanDest
+const productPlanColumns = `id, product_id, months, days, price, discount_percent, sort_order, is_active, created_at, updated_at, traffic_limit_gb, device_limit`
+
+func productPlanScanDest(p *models.ProductPlan) []any {
+	return []any{&p.ID, &p.ProductID, &p.Months, &p.Days, &p.Price, &p.DiscountPercent, &p.SortOrder, &p.IsActive, &p.CreatedAt, &p.UpdatedAt, &p.TrafficLimitGB, &p.DeviceLimit}
+}
+
+// GetProductPlans получает планы продукта в порядке отображения (activeOnly — только включённые)
+func (db *DB) GetProductPlans(ctx context.Context, productID int64, activeOnly bool) ([]models.ProductPlan, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+productPlanColumns+` FROM product_plans
+		WHERE product_id = $1 AND (is_active OR NOT $2)
+		ORDER BY sort_order, id
+	`, productID, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []models.ProductPlan
+	for rows.Next() {
+		var p models.ProductPlan
+		if err := rows.Scan(productPlanScanDest(&p)...); err != nil {
+			return nil, err
+		}
+		plans = append(plans, p)
+	}
+	return plans, rows.Err()
+}
+
+// GetProductPlanByID получает план по ID
+func (db *DB) GetProductPlanByID(ctx context.Context, id int64) (*models.ProductPlan, error) {
+	var p models.ProductPlan
+	err := db.Pool.QueryRow(ctx, `
+		SELECT `+productPlanColumns+` FROM product_plans WHERE id = $1
+	`, id).Scan(productPlanScanDest(&p)...)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CreateProductPlan создаёт план в конце списка планов продукта
+func (db *DB) CreateProductPlan(ctx context.Context, p *models.ProductPlan) (*models.ProductPlan, error) {
+	var created models.ProductPlan
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO product_plans (product_id, months, days, price, discount_percent, sort_order, is_active, traffic_limit_gb, device_limit)
+		SELECT $1, $2, $3, $4, $5, COALESCE(MAX(sort_order), 0) + 1, $6, $7, $8
+		FROM product_plans WHERE product_id = $1
+		RETURNING `+productPlanColumns,
+		p.ProductID, p.Months, p.Days, p.Price, p.DiscountPercent, p.IsActive, p.TrafficLimitGB, p.DeviceLimit,
+	).Scan(productPlanScanDest(&created)...)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// UpdateProductPlan сохраняет срок, цену, скидку, квоту, лимит устройств, порядок и видимость плана
+func (db *DB) UpdateProductPlan(ctx context.Context, p *models.ProductPlan) error {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE product_plans
+		SET months = $2, days = $3, price = $4, discount_percent = $5, sort_order = $6, is_active = $7,
+			traffic_limit_gb = $8, device_limit = $9, updated_at = NOW()
+		WHERE id = $1
+	`, p.ID, p.Months, p.Days, p.Price, p.DiscountPercent, p.SortOrder, p.IsActive, p.TrafficLimitGB, p.DeviceLimit)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteProductPlan удаляет план (в оплаченных счетах ссылка на него обнуляется)
+func (db *DB) DeleteProductPlan(ctx context.Context, id int64) error {
+	_, err := db.Pool.Exec(ctx, `DELETE FROM product_plans WHERE id = $1`, id)
+	return err
+}
+
+// === Traffic Pack Methods ===
+
+// trafficPackColumns колонки пакета трафика в порядке trafficPackScanDest
+const trafficPackColumns = `id, product_id, traffic_gb, price, sort_order, is_active, created_at`
+
+func trafficPackScanDest(p *models.TrafficPack) []any {
+	return []any{&p.ID, &p.ProductID, &p.TrafficGB, &p.Price, &p.SortOrder, &p.IsActive, &p.CreatedAt}
+}
+
+// GetTrafficPacks получает пакеты трафика продукта (activeOnly — только включённые)
+func (db *DB) GetTrafficPacks(ctx context.Context, productID int64, activeOnly bool) ([]models.TrafficPack, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+trafficPackColumns+` FROM traffic_packs
+		WHERE product_id = $1 AND (is_active OR NOT $2)
+		ORDER BY sort_order, id
+	`, productID, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var packs []models.TrafficPack
+	for rows.Next() {
+		var p models.TrafficPack
+		if err := rows.Scan(trafficPackScanDest(&p)...); err != nil {
+			return nil, err
+		}
+		packs = append(packs, p)
+	}
+	return packs, rows.Err()
+}
+
+// GetTrafficPackByID получает пакет трафика по ID
+func (db *DB) GetTrafficPackByID(ctx context.Context, id int64) (*models.TrafficPack, error) {
+	var p models.TrafficPack
+	err := db.Pool.QueryRow(ctx, `
+		SELECT `+trafficPackColumns+` FROM traffic_packs WHERE id = $1
+	`, id).Scan(trafficPackScanDest(&p)...)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CreateTrafficPack создаёт пакет трафика в конце списка пакетов продукта
+func (db *DB) CreateTrafficPack(ctx context.Context, p *models.TrafficPack) (*models.TrafficPack, error) {
+	var created models.TrafficPack
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO traffic_packs (product_id, traffic_gb, price, sort_order)
+		SELECT $1, $2, $3, COALESCE(MAX(sort_order), 0) + 1
+		FROM traffic_packs WHERE product_id = $1
+		RETURNING `+trafficPackColumns,
+		p.ProductID, p.TrafficGB, p.Price,
+	).Scan(trafficPackScanDest(&created)...)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// SetTrafficPackActive включает или скрывает пакет трафика
+func (db *DB) SetTrafficPackActive(ctx context.Context, id int64, active bool) error {
+	tag, err := db.Pool.Exec(ctx, `UPDATE traffic_packs SET is_active = $2 WHERE id = $1`, id, active)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteTrafficPack удаляет пакет трафика
+func (db *DB) DeleteTrafficPack(ctx context.Context, id int64) error {
+	_, err := db.Pool.Exec(ctx, `DELETE FROM traffic_packs WHERE id = $1`, id)
+	return err
+}
+
 // === Subscription Methods ===
 
+// subscriptionColumns колонки подписки (алиас s) в порядке subscriptionScanDest
+const subscriptionColumns = `s.id, s.user_id, s.product_id, s.node_id, COALESCE(s.vpn_username, ''), s.key_string, s.expires_at, s.is_active, s.created_at,
+	s.traffic_limit, s.traffic_extra, s.traffic_reset, s.traffic_reset_at, s.device_limit, s.extra_devices, s.sub_token,
+	s.plan_id, s.auto_renew, s.auto_renew_attempted_at`
+
+func subscriptionScanDest(s *models.Subscription) []any {
+	return []any{
+		&s.ID, &s.UserID, &s.ProductID, &s.NodeID, &s.VPNUsername, &s.KeyString, &s.ExpiresAt, &s.IsActive, &s.CreatedAt,
+		&s.TrafficLimit, &s.TrafficExtra, &s.TrafficReset, &s.TrafficResetAt, &s.DeviceLimit, &s.ExtraDevices, &s.SubToken,
+		&s.PlanID, &s.AutoRenew, &s.AutoRenewAttemptedAt,
+	}
+}
+
+// subscriptionProductScanDest колонки подписки и присоединённого продукта (p.id, p.name, p.country_flag, p.base_price, p.marzban_tag)
+func subscriptionProductScanDest(s *models.Subscription, p *models.Product) []any {
+	return append(subscriptionScanDest(s), &p.ID, &p.Name, &p.CountryFlag, &p.BasePrice, &p.MarzbanTag)
+}
+
+// rowQuerier выполняет запрос вне транзакции (пул) или внутри неё (pgx.Tx)
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 // CreateSubscription создаёт подписку
-func (db *DB) CreateSubscription(ctx context.Context, userID, productID int64, keyString string, expiresAt time.Time) (*models.Subscription, error) {
+func (db *DB) CreateSubscription(ctx context.Context, s *models.Subscription) (*models.Subscription, error) {
+	return insertSubscription(ctx, db.Pool, s)
+}
+
+// insertSubscription сохраняет подписку через q
+func insertSubscription(ctx context.Context, q rowQuerier, s *models.Subscription) (*models.Subscription, error) {
 	var sub models.Subscription
-	err := db.Pool.QueryRow(ctx, `
-		INSERT INTO subscriptions (user_id, product_id, key_string, expires_at)
-		VALUES ($1, $2, $3, $4)
-		RETURNING id, user_id, product_id, key_string, expires_at, is_active, created_at
-	`, userID, productID, keyString, expiresAt).Scan(
-		&sub.ID, &sub.UserID, &sub.ProductID, &sub.KeyString, &sub.ExpiresAt, &sub.IsActive, &sub.CreatedAt,
-	)
+	err := q.QueryRow(ctx, `
+		INSERT INTO subscriptions AS s (user_id, product_id, node_id, vpn_username, key_string, expires_at,
+			traffic_limit, traffic_reset, traffic_reset_at, device_limit, plan_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING `+subscriptionColumns,
+		s.UserID, s.ProductID, s.NodeID, s.VPNUsername, s.KeyString, s.ExpiresAt,
+		s.TrafficLimit, s.TrafficReset, s.TrafficResetAt, s.DeviceLimit, s.PlanID,
+	).Scan(subscriptionScanDest(&sub)...)
 
 	if err != nil {
 		return nil, err
@@ -268,7 +495,7 @@ func (db *DB) CreateSubscription(ctx context.Context, userID, productID int64, k
 // GetUserSubscriptions получает подписки пользователя
 func (db *DB) GetUserSubscriptions(ctx context.Context, userID int64) ([]models.Subscription, error) {
 	rows, err := db.Pool.Query(ctx, `
-		SELECT s.id, s.user_id, s.product_id, s.key_string, s.expires_at, s.is_active, s.created_at,
+		SELECT `+subscriptionColumns+`,
 			   p.id, p.name, p.country_flag, p.base_price, p.marzban_tag
 		FROM subscriptions s
 		JOIN products p ON s.product_id = p.id
@@ -284,10 +511,7 @@ func (db *DB) GetUserSubscriptions(ctx context.Context, userID int64) ([]models.
 	for rows.Next() {
 		var s models.Subscription
 		var p models.Product
-		if err := rows.Scan(
-			&s.ID, &s.UserID, &s.ProductID, &s.KeyString, &s.ExpiresAt, &s.IsActive, &s.CreatedAt,
-			&p.ID, &p.Name, &p.CountryFlag, &p.BasePrice, &p.MarzbanTag,
-		); err != nil {
+		if err := rows.Scan(subscriptionProductScanDest(&s, &p)...); err != nil {
 			return nil, err
 		}
 		s.Product = &p
@@ -303,15 +527,12 @@ func (db *DB) GetSubscriptionByID(ctx context.Context, id int64) (*models.Subscr
 	var p models.Product
 
 	err := db.Pool.QueryRow(ctx, `
-		SELECT s.id, s.user_id, s.product_id, s.key_string, s.expires_at, s.is_active, s.created_at,
+		SELECT `+subscriptionColumns+`,
 			   p.id, p.name, p.country_flag, p.base_price, p.marzban_tag
 		FROM subscriptions s
 		JOIN products p ON s.product_id = p.id
 		WHERE s.id = $1
-	`, id).Scan(
-		&s.ID, &s.UserID, &s.ProductID, &s.KeyString, &s.ExpiresAt, &s.IsActive, &s.CreatedAt,
-		&p.ID, &p.Name, &p.CountryFlag, &p.BasePrice, &p.MarzbanTag,
-	)
+	`, id).Scan(subscriptionProductScanDest(&s, &p)...)
 
 	if err != nil {
 		return nil, err
@@ -321,574 +542,2399 @@ func (db *DB) GetSubscriptionByID(ctx context.Context, id int64) (*models.Subscr
 	return &s, nil
 }
 
-// ExtendSubscription продлевает подписку
-func (db *DB) ExtendSubscription(ctx context.Context, id int64, newExpiresAt time.Time) error {
-	_, err := db.Pool.Exec(ctx, `
-		UPDATE subscriptions SET expires_at = $1, is_active = true WHERE id = $2
-	`, newExpiresAt, id)
-	return err
-}
-
-// === Admin Methods ===
+// GetSubscriptionByToken получает подписку по токену ссылки подписки
+func (db *DB) GetSubscriptionByToken(ctx context.Context, token string) (*models.Subscription, error) {
+	var s models.Subscription
+	var p models.Product
 
-// GetAdminStats возвращает статистику для админ-панели
-func (db *DB) GetAdminStats(ctx context.Context) (*models.AdminStats, error) {
-	stats := &models.AdminStats{}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT `+subscriptionColumns+`,
+			   p.id, p.name, p.country_flag, p.base_price, p.marzban_tag
+		FROM subscriptions s
+		JOIN products p ON s.product_id = p.id
+		WHERE s.sub_token = $1
+	`, token).Scan(subscriptionProductScanDest(&s, &p)...)
 
-	// Total users
-	err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM users`).Scan(&stats.TotalUsers)
 	if err != nil {
 		return nil, err
 	}
 
-	// New users today
-	err = db.Pool.QueryRow(ctx, `
-		SELECT COUNT(*) FROM users 
-		WHERE created_at >= CURRENT_DATE
-	`).Scan(&stats.NewUsersToday)
+	s.Product = &p
+	return &s, nil
+}
+
+// extendSubscriptionTx продлевает подписку до sub.ExpiresAt и назначает квоту и лимит устройств нового периода
+// (докупленные трафик и устройства обнуляются)
+func extendSubscriptionTx(ctx context.Context, tx pgx.Tx, sub *models.Subscription) error {
+	tag, err := tx.Exec(ctx, `
+		UPDATE subscriptions
+		SET expires_at = $1, is_active = true,
+			traffic_limit = $2, traffic_extra = 0, traffic_reset = $3, traffic_reset_at = $4,
+			device_limit = $5, extra_devices = 0, plan_id = $6, auto_renew_attempted_at = NULL
+		WHERE id = $7
+	`, sub.ExpiresAt, sub.TrafficLimit, sub.TrafficReset, sub.TrafficResetAt, sub.DeviceLimit, sub.PlanID, sub.ID)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("subscription %d not found", sub.ID)
 	}
+	return nil
+}
 
-	// Active subscriptions
-	err = db.Pool.QueryRow(ctx, `
-		SELECT COUNT(*) FROM subscriptions 
-		WHERE is_active = true AND expires_at > NOW()
-	`).Scan(&stats.ActiveSubscriptions)
+// SetSubscriptionAutoRenew включает или выключает автопродление подписки пользователя
+func (db *DB) SetSubscriptionAutoRenew(ctx context.Context, userID, subID int64, enabled bool) (bool, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions SET auto_renew = $3, auto_renew_attempted_at = NULL
+		WHERE id = $1 AND user_id = $2
+	`, subID, userID, enabled)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
+	return tag.RowsAffected() == 1, nil
+}
 
-	// Revenue today
-	err = db.Pool.QueryRow(ctx, `
-		SELECT COALESCE(SUM(amount), 0) FROM transactions 
-		WHERE type = 'purchase' AND status = 'completed' 
-		AND created_at >= CURRENT_DATE
-	`).Scan(&stats.RevenueToday)
+// GetSubscriptionsDueAutoRenew возвращает активные подписки с автопродлением, истекающие до before,
+// по которым не было попытки после retryAfter
+func (db *DB) GetSubscriptionsDueAutoRenew(ctx context.Context, before, retryAfter time.Time) ([]models.ExpiringSubscription, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+subscriptionColumns+`,
+			   p.id, p.name, p.country_flag, p.base_price, p.marzban_tag,
+			   u.telegram_id
+		FROM subscriptions s
+		JOIN products p ON s.product_id = p.id
+		JOIN users u ON s.user_id = u.id
+		WHERE s.auto_renew = true AND s.is_active = true
+		  AND s.expires_at > NOW() AND s.expires_at <= $1
+		  AND (s.auto_renew_attempted_at IS NULL OR s.auto_renew_attempted_at < $2)
+		ORDER BY s.expires_at
+	`, before, retryAfter)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	// Revenue this month
-	err = db.Pool.QueryRow(ctx, `
-		SELECT COALESCE(SUM(amount), 0) FROM transactions 
-		WHERE type = 'purchase' AND status = 'completed' 
-		AND created_at >= DATE_TRUNC('month', CURRENT_DATE)
-	`).Scan(&stats.RevenueMonth)
-	if err != nil {
-		return nil, err
+	var subs []models.ExpiringSubscription
+	for rows.Next() {
+		var s models.ExpiringSubscription
+		var p models.Product
+		if err := rows.Scan(append(subscriptionProductScanDest(&s.Subscription, &p), &s.TelegramID)...); err != nil {
+			return nil, err
+		}
+		s.Product = &p
+		subs = append(subs, s)
 	}
 
-	// Revenue all time
-	err = db.Pool.QueryRow(ctx, `
-		SELECT COALESCE(SUM(amount), 0) FROM transactions 
-		WHERE type = 'purchase' AND status = 'completed'
-	`).Scan(&stats.RevenueAllTime)
+	return subs, rows.Err()
+}
+
+// ClaimAutoRenewAttempt фиксирует попытку автопродления. Возвращает false, если попытка
+// уже сделана после retryAfter (другим экземпляром планировщика)
+func (db *DB) ClaimAutoRenewAttempt(ctx context.Context, subID int64, retryAfter time.Time) (bool, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions SET auto_renew_attempted_at = NOW()
+		WHERE id = $1 AND (auto_renew_attempted_at IS NULL OR auto_renew_attempted_at < $2)
+	`, subID, retryAfter)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-
-	return stats, nil
+	return tag.RowsAffected() == 1, nil
 }
 
-// GetUserByID получает пользователя по ID
-func (db *DB) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
-	var user models.User
-	err := db.Pool.QueryRow(ctx, `
-		SELECT id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at
-		FROM users WHERE id = $1
-	`, id).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt)
+// CreateAutoRenewAttempt записывает попытку автопродления подписки. orderID — заказ, созданный попыткой (nil, если до заказа не дошло)
+func (db *DB) CreateAutoRenewAttempt(ctx context.Context, subID int64, orderID *int64, status, reason string) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO auto_renew_attempts (subscription_id, order_id, status, error)
+		VALUES ($1, $2, $3, $4)
+	`, subID, orderID, status, reason)
+	return err
+}
 
+// GetActiveSubscriptionsExpiringBetween возвращает активные подписки с expires_at в (from, to],
+// для которых ещё не отправлено напоминание kind. Подписки не длиннее minLength (если он задан) пропускаются:
+// напоминание «осталось 3 дня» о трёхдневном пробном периоде пришло бы сразу после выдачи
+func (db *DB) GetActiveSubscriptionsExpiringBetween(ctx context.Context, from, to time.Time, kind string, minLength time.Duration) ([]models.ExpiringSubscription, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+subscriptionColumns+`,
+			   p.id, p.name, p.country_flag, p.base_price, p.marzban_tag,
+			   u.telegram_id
+		FROM subscriptions s
+		JOIN products p ON s.product_id = p.id
+		JOIN users u ON s.user_id = u.id
+		WHERE s.is_active = true
+		  AND s.expires_at > $1 AND s.expires_at <= $2
+		  AND (s.auto_renew = false OR $3 = 'expired') -- with auto-renew the user is notified about the renewal instead
+		  AND ($4::float8 = 0 OR s.expires_at - s.created_at > make_interval(secs => $4::float8))
+		  AND NOT EXISTS (
+			SELECT 1 FROM subscription_reminders r
+			WHERE r.subscription_id = s.id AND r.kind = $3 AND r.expires_at = s.expires_at
+		  )
+		ORDER BY s.expires_at
+	`, from, to, kind, minLength.Seconds())
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	var subs []models.ExpiringSubscription
+	for rows.Next() {
+		var s models.ExpiringSubscription
+		var p models.Product
+		if err := rows.Scan(append(subscriptionProductScanDest(&s.Subscription, &p), &s.TelegramID)...); err != nil {
+			return nil, err
+		}
+		s.Product = &p
+		subs = append(subs, s)
+	}
 
-	return &user, nil
+	return subs, rows.Err()
 }
 
-// FindUserByUsername ищет пользователя по username
-func (db *DB) FindUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	var user models.User
-	err := db.Pool.QueryRow(ctx, `
-		SELECT id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at
-		FROM users WHERE LOWER(username) = LOWER($1)
-	`, username).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt)
-
+// ClaimReminder записывает отправку напоминания.
+// Возвращает false, если такое напоминание уже было отправлено
+func (db *DB) ClaimReminder(ctx context.Context, subID int64, kind string, expiresAt time.Time) (bool, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		INSERT INTO subscription_reminders (subscription_id, kind, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (subscription_id, kind, expires_at) DO NOTHING
+	`, subID, kind, expiresAt)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
+	return tag.RowsAffected() == 1, nil
+}
 
-	return &user, nil
+// DeactivateSubscription помечает подписку неактивной.
+// Условие по expires_at защищает от гонки с продлением
+func (db *DB) DeactivateSubscription(ctx context.Context, id int64, expiresAt time.Time) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE subscriptions SET is_active = false WHERE id = $1 AND expires_at = $2
+	`, id, expiresAt)
+	return err
 }
 
-// AddUserBalance добавляет баланс пользователю и создаёт транзакцию
-func (db *DB) AddUserBalance(ctx context.Context, userID int64, amount float64, txType string) error {
+// PurchaseTrafficPack списывает price с баланса и добавляет extraBytes к квоте активной подписки пользователя.
+// onUpdated получает обновлённую подписку и вызывается внутри транзакции: при ошибке всё откатывается.
+// Возвращает false, если подписка не найдена, не принадлежит пользователю, истекла или безлимитна
+func (db *DB) PurchaseTrafficPack(ctx context.Context, userID, subID, extraBytes int64, price float64, onUpdated func(ctx context.Context, sub *models.Subscription) error) (bool, error) {
+	return db.purchaseSubscriptionAddon(ctx, userID, price, `
+		UPDATE subscriptions s SET traffic_extra = traffic_extra + $3
+		WHERE id = $1 AND user_id = $2 AND is_active = true AND expires_at > NOW() AND traffic_limit > 0
+		RETURNING `+subscriptionColumns,
+		[]any{subID, userID, extraBytes}, onUpdated)
+}
+
+// PurchaseDeviceSlots списывает price с баланса и добавляет slots устройств к лимиту активной подписки пользователя,
+// если докупленных устройств станет не больше maxExtra. onUpdated — как в PurchaseTrafficPack.
+// Возвращает false, если подписка не найдена, не принадлежит пользователю, истекла, без лимита или лимит докупки исчерпан
+func (db *DB) PurchaseDeviceSlots(ctx context.Context, userID, subID int64, slots, maxExtra int, price float64, onUpdated func(ctx context.Context, sub *models.Subscription) error) (bool, error) {
+	return db.purchaseSubscriptionAddon(ctx, userID, price, `
+		UPDATE subscriptions s SET extra_devices = extra_devices + $3
+		WHERE id = $1 AND user_id = $2 AND is_active = true AND expires_at > NOW() AND device_limit > 0
+		  AND extra_devices + $3 <= $4
+		RETURNING `+subscriptionColumns,
+		[]any{subID, userID, slots, maxExtra}, onUpdated)
+}
+
+// purchaseSubscriptionAddon в одной транзакции выполняет update подписки (RETURNING subscriptionColumns)
+// и списывает price с баланса с записью транзакции покупки
+func (db *DB) purchaseSubscriptionAddon(ctx context.Context, userID int64, price float64, update string, args []any, onUpdated func(ctx context.Context, sub *models.Subscription) error) (bool, error) {
 	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer tx.Rollback(ctx)
 
-	// Update balance
-	_, err = tx.Exec(ctx, `
-		UPDATE users SET balance = balance + $1 WHERE id = $2
-	`, amount, userID)
+	var sub models.Subscription
+	err = tx.QueryRow(ctx, update, args...).Scan(subscriptionScanDest(&sub)...)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	// Create transaction record
-	_, err = tx.Exec(ctx, `
-		INSERT INTO transactions (user_id, amount, type, status)
-		VALUES ($1, $2, $3, 'completed')
-	`, userID, amount, txType)
+	var balance float64
+	err = tx.QueryRow(ctx, `SELECT balance FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&balance)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if balance < price {
+		return false, fmt.Errorf("insufficient balance: have %.2f, need %.2f", balance, price)
 	}
 
-	return tx.Commit(ctx)
-}
-
-// GetUserTransactions получает транзакции пользователя
-func (db *DB) GetUserTransactions(ctx context.Context, userID int64, limit int) ([]models.Transaction, error) {
-	rows, err := db.Pool.Query(ctx, `
-		SELECT id, user_id, amount, type, status, created_at
-		FROM transactions WHERE user_id = $1
-		ORDER BY created_at DESC LIMIT $2
-	`, userID, limit)
+	var purchaseID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO transactions (user_id, amount, type, status)
+		VALUES ($1, $2, 'purchase', 'completed')
+		RETURNING id
+	`, userID, -price).Scan(&purchaseID)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	err = postBalanceTx(ctx, tx, ledgerPosting{
+		UserID: userID, Amount: -models.Kopecks(price), Counter: models.LedgerSales, Reason: models.TransactionPurchase,
+		TransactionID: &purchaseID, SubscriptionID: &sub.ID,
+	})
+	if err != nil {
+		return false, err
 	}
-	defer rows.Close()
 
-	var transactions []models.Transaction
-	for rows.Next() {
-		var t models.Transaction
-		if err := rows.Scan(&t.ID, &t.UserID, &t.Amount, &t.Type, &t.Status, &t.CreatedAt); err != nil {
-			return nil, err
+	if onUpdated != nil {
+		if err := onUpdated(ctx, &sub); err != nil {
+			return false, err
 		}
-		transactions = append(transactions, t)
 	}
 
-	return transactions, nil
+	return true, tx.Commit(ctx)
 }
 
-// GetAllUserTelegramIDs возвращает все telegram_id для рассылки
-func (db *DB) GetAllUserTelegramIDs(ctx context.Context) ([]int64, error) {
-	rows, err := db.Pool.Query(ctx, `SELECT telegram_id FROM users`)
+// RotateSubscriptionKey переносит подписку на нового клиента VPN панели, выдаёт новый токен ссылки подписки
+// и пишет запись аудита. Перенос выполняется, только если клиент подписки всё ещё oldVPNUsername.
+// onUpdated вызывается внутри транзакции после UPDATE: если он вернёт ошибку, изменение откатывается
+func (db *DB) RotateSubscriptionKey(ctx context.Context, subID int64, oldVPNUsername, vpnUsername, keyString string, audit *models.AuditEntry, onUpdated func(ctx context.Context) error) (*models.Subscription, error) {
+	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	defer tx.Rollback(ctx)
 
-	var ids []int64
-	for rows.Next() {
-		var id int64
-		if err := rows.Scan(&id); err != nil {
+	var sub models.Subscription
+	err = tx.QueryRow(ctx, `
+		UPDATE subscriptions AS s
+		SET vpn_username = $3, key_string = $4, sub_token = replace(gen_random_uuid()::text, '-', '')
+		WHERE s.id = $1 AND s.vpn_username = $2
+		RETURNING `+subscriptionColumns,
+		subID, oldVPNUsername, vpnUsername, keyString,
+	).Scan(subscriptionScanDest(&sub)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertAuditEntry(ctx, tx, audit); err != nil {
+		return nil, err
+	}
+
+	if onUpdated != nil {
+		if err := onUpdated(ctx); err != nil {
 			return nil, err
 		}
-		ids = append(ids, id)
 	}
 
-	return ids, nil
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &sub, nil
 }
 
-// TopUpBalanceWithReferral пополняет баланс и начисляет реферальный бонус
-// Возвращает: referrerTelegramID (если есть), referralBonus, error
-func (db *DB) TopUpBalanceWithReferral(ctx context.Context, userID int64, amount float64) (*int64, float64, error) {
+// CancelSubscription досрочно отключает подписку, записывает возврат refund (nil — без возврата) и аудит.
+// Отмена выполняется, только если подписка активна и её срок всё ещё expiresAt (не продлили параллельно).
+// Возврат с RefundMethod = balance зачисляется на баланс в той же транзакции.
+// onCancelled вызывается внутри транзакции (удаление клиента в панели): при ошибке изменения откатываются
+func (db *DB) CancelSubscription(ctx context.Context, subID int64, expiresAt time.Time, refund *models.Transaction, audit *models.AuditEntry, onCancelled func(ctx context.Context) error) (*models.Subscription, error) {
 	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer tx.Rollback(ctx)
 
-	// 1. Пополняем баланс пользователя
-	_, err = tx.Exec(ctx, `
-		UPDATE users SET balance = balance + $1 WHERE id = $2
-	`, amount, userID)
+	var sub models.Subscription
+	err = tx.QueryRow(ctx, `
+		UPDATE subscriptions AS s SET is_active = false, auto_renew = false
+		WHERE s.id = $1 AND s.is_active = true AND s.expires_at = $2
+		RETURNING `+subscriptionColumns,
+		subID, expiresAt,
+	).Scan(subscriptionScanDest(&sub)...)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	// 2. Создаём транзакцию пополнения
-	_, err = tx.Exec(ctx, `
-		INSERT INTO transactions (user_id, amount, type, status)
-		VALUES ($1, $2, 'top_up', 'completed')
-	`, userID, amount)
-	if err != nil {
-		return nil, 0, err
+	if refund != nil {
+		err = tx.QueryRow(ctx, `
+			INSERT INTO transactions (user_id, amount, type, status, subscription_id, refund_of, refund_method)
+			VALUES ($1, $2, 'refund', 'completed', $3, $4, $5)
+			RETURNING id, created_at
+		`, refund.UserID, refund.Amount, subID, refund.RefundOf, refund.RefundMethod).Scan(&refund.ID, &refund.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if refund.RefundMethod == models.RefundToBalance {
+			err = postBalanceTx(ctx, tx, ledgerPosting{
+				UserID: refund.UserID, Amount: models.Kopecks(refund.Amount), Counter: models.LedgerSales, Reason: models.TransactionRefund,
+				TransactionID: &refund.ID, SubscriptionID: &subID,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	// 3. Проверяем есть ли реферер
-	var referrerTelegramID *int64
-	err = tx.QueryRow(ctx, `
-		SELECT referrer_id FROM users WHERE id = $1
-	`, userID).Scan(&referrerTelegramID)
-	if err != nil {
-		return nil, 0, err
+	if err := insertAuditEntry(ctx, tx, audit); err != nil {
+		return nil, err
 	}
 
-	var referralBonus float64 = 0
+	if onCancelled != nil {
+		if err := onCancelled(ctx); err != nil {
+			return nil, err
+		}
+	}
 
-	// 4. Если есть реферер - начисляем ему 25%
-	if referrerTelegramID != nil {
-		referralBonus = amount * 0.25
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
 
-		// Получаем ID реферера по telegram_id
-		var referrerID int64
-		err = tx.QueryRow(ctx, `
-			SELECT id FROM users WHERE telegram_id = $1
-		`, *referrerTelegramID).Scan(&referrerID)
-		if err == nil {
-			// Начисляем бонус рефереру на баланс и в статистику
-			_, err = tx.Exec(ctx, `
-				UPDATE users SET balance = balance + $1, total_ref_earnings = COALESCE(total_ref_earnings, 0) + $1 WHERE id = $2
-			`, referralBonus, referrerID)
-			if err != nil {
-				return nil, 0, err
-			}
+// GetSubscriptionPurchases возвращает невозвращённые покупки, оплатившие подписку, от новых к старым
+func (db *DB) GetSubscriptionPurchases(ctx context.Context, subID int64) ([]models.Transaction, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT t.id, t.user_id, t.amount, t.type, t.status, t.created_at, t.subscription_id
+		FROM transactions t
+		WHERE t.subscription_id = $1 AND t.type = 'purchase' AND t.status = 'completed'
+		  AND NOT EXISTS (SELECT 1 FROM transactions r WHERE r.refund_of = t.id)
+		ORDER BY t.created_at DESC
+	`, subID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-			// Создаём транзакцию реферального бонуса
-			_, err = tx.Exec(ctx, `
-				INSERT INTO transactions (user_id, amount, type, status)
-				VALUES ($1, $2, 'referral_bonus', 'completed')
-			`, referrerID, referralBonus)
-			if err != nil {
-				return nil, 0, err
-			}
+	var purchases []models.Transaction
+	for rows.Next() {
+		var t models.Transaction
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Amount, &t.Type, &t.Status, &t.CreatedAt, &t.SubscriptionID); err != nil {
+			return nil, err
 		}
+		purchases = append(purchases, t)
 	}
+	return purchases, rows.Err()
+}
 
-	if err := tx.Commit(ctx); err != nil {
-		return nil, 0, err
+// GetSubscriptionsDueTrafficReset возвращает активные подписки с ежемесячной квотой, у которых наступил сброс
+func (db *DB) GetSubscriptionsDueTrafficReset(ctx context.Context, now time.Time) ([]models.Subscription, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+subscriptionColumns+`
+		FROM subscriptions s
+		WHERE s.is_active = true AND s.expires_at > $1
+		  AND s.traffic_limit > 0 AND s.traffic_reset_at <= $1
+		ORDER BY s.traffic_reset_at
+	`, now)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	return referrerTelegramID, referralBonus, nil
+	var subs []models.Subscription
+	for rows.Next() {
+		var s models.Subscription
+		if err := rows.Scan(subscriptionScanDest(&s)...); err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	return subs, rows.Err()
 }
 
-// DeductBalance списывает баланс пользователя
-func (db *DB) DeductBalance(ctx context.Context, userID int64, amount float64) error {
+// ResetSubscriptionTraffic начинает новый месяц квоты: докупленный трафик обнуляется,
+// сброс переносится на nextResetAt. Условие по resetAt защищает от повторного сброса.
+// onUpdated вызывается внутри транзакции; false — сброс уже выполнен
+func (db *DB) ResetSubscriptionTraffic(ctx context.Context, id int64, resetAt, nextResetAt time.Time, onUpdated func(ctx context.Context) error) (bool, error) {
 	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 	defer tx.Rollback(ctx)
 
-	// Проверяем достаточно ли средств
-	var balance float64
-	err = tx.QueryRow(ctx, `SELECT balance FROM users WHERE id = $1`, userID).Scan(&balance)
+	tag, err := tx.Exec(ctx, `
+		UPDATE subscriptions SET traffic_extra = 0, traffic_reset_at = $3
+		WHERE id = $1 AND traffic_reset_at = $2
+	`, id, resetAt, nextResetAt)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
 	}
 
-	if balance < amount {
-		return fmt.Errorf("insufficient balance: have %.2f, need %.2f", balance, amount)
+	if onUpdated != nil {
+		if err := onUpdated(ctx); err != nil {
+			return false, err
+		}
 	}
 
-	// Списываем
-	_, err = tx.Exec(ctx, `
-		UPDATE users SET balance = balance - $1 WHERE id = $2
-	`, amount, userID)
+	return true, tx.Commit(ctx)
+}
+
+// === Invoice Methods ===
+
+// CreateInvoice создаёт счёт в статусе pending
+func (db *DB) CreateInvoice(ctx context.Context, inv *models.Invoice) (*models.Invoice, error) {
+	created := *inv
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO invoices (user_id, gateway, purpose, amount, amount_stars, product_id, plan_id, months, days, bonus_days)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, status, created_at
+	`, inv.UserID, inv.Gateway, inv.Purpose, inv.Amount, inv.AmountStars, inv.ProductID, inv.PlanID, inv.Months, inv.Days, inv.BonusDays).Scan(
+		&created.ID, &created.Status, &created.CreatedAt,
+	)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &created, nil
+}
 
-	// Создаём транзакцию покупки
-	_, err = tx.Exec(ctx, `
-		INSERT INTO transactions (user_id, amount, type, status)
-		VALUES ($1, $2, 'purchase', 'completed')
-	`, userID, -amount)
+// SetInvoicePayment сохраняет ID платежа у провайдера и ссылку на оплату
+func (db *DB) SetInvoicePayment(ctx context.Context, id int64, externalID, paymentURL string) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE invoices SET external_id = $1, payment_url = $2 WHERE id = $3
+	`, externalID, paymentURL, id)
+	return err
+}
+
+// GetInvoiceByID получает счёт по ID
+func (db *DB) GetInvoiceByID(ctx context.Context, id int64) (*models.Invoice, error) {
+	var inv models.Invoice
+	err := db.Pool.QueryRow(ctx, `
+		SELECT `+invoiceColumns+`
+		FROM invoices
+		WHERE id = $1
+	`, id).Scan(invoiceScanDest(&inv)...)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &inv, nil
+}
 
-	return tx.Commit(ctx)
+// AttachInvoiceExternalID привязывает ID платежа к счёту, у которого его ещё нет.
+// Возвращает false, если к счёту уже привязан платёж
+func (db *DB) AttachInvoiceExternalID(ctx context.Context, id int64, externalID string) (bool, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE invoices SET external_id = $1 WHERE id = $2 AND external_id IS NULL
+	`, externalID, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
 }
 
-// GetReferralCount возвращает количество рефералов пользователя
-func (db *DB) GetReferralCount(ctx context.Context, telegramID int64) (int, error) {
-	var count int
-	err := db.Pool.QueryRow(ctx, `
-		SELECT COUNT(*) FROM users WHERE referrer_id = $1
-	`, telegramID).Scan(&count)
-	return count, err
+// CompleteInvoiceActivation запоминает подписку, созданную по оплаченному счёту, и снимает ожидание активации.
+// Возвращает false, если активацию уже завершил другой вызов
+func (db *DB) CompleteInvoiceActivation(ctx context.Context, id, subID int64) (bool, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE invoices SET subscription_id = $1, activation_pending = false, activation_error = ''
+		WHERE id = $2 AND activation_pending = true
+	`, subID, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
 }
 
-// GetUserReferrals возвращает список рефералов пользователя
-func (db *DB) GetUserReferrals(ctx context.Context, telegramID int64) ([]*models.User, error) {
+// FailInvoiceActivation запоминает причину неудачной активации. final снимает ожидание: активацию больше не повторяют.
+// Возвращает false, если активацию уже завершил или прекратил другой вызов
+func (db *DB) FailInvoiceActivation(ctx context.Context, id int64, reason string, final bool) (bool, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE invoices SET activation_error = $2, activation_pending = NOT $3
+		WHERE id = $1 AND activation_pending = true
+	`, id, reason, final)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetInvoicesAwaitingActivation возвращает оплаченные после since счета на подписку, подписка по которым ещё не создана
+func (db *DB) GetInvoicesAwaitingActivation(ctx context.Context, since time.Time) ([]models.Invoice, error) {
 	rows, err := db.Pool.Query(ctx, `
-		SELECT id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at
-		FROM users WHERE referrer_id = $1
-		ORDER BY created_at DESC
-		LIMIT 50
-	`, telegramID)
+		SELECT `+invoiceColumns+`
+		FROM invoices
+		WHERE activation_pending = true AND status = 'paid' AND paid_at > $1
+		ORDER BY id
+	`, since)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var users []*models.User
+	var invoices []models.Invoice
 	for rows.Next() {
-		var user models.User
-		err := rows.Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt)
+		var inv models.Invoice
+		if err := rows.Scan(invoiceScanDest(&inv)...); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+	return invoices, rows.Err()
+}
+
+// RefundInvoice помечает оплаченный счёт возвращённым.
+// Если по счёту не создана подписка, зачисленная сумма списывается с баланса (нужен достаточный остаток).
+// onRefunded вызывается внутри транзакции (возврат у провайдера): при ошибке изменения откатываются
+func (db *DB) RefundInvoice(ctx context.Context, gateway, externalID string, onRefunded func(ctx context.Context, inv *models.Invoice) error) (*models.Invoice, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var inv models.Invoice
+	err = tx.QueryRow(ctx, `
+		UPDATE invoices SET status = 'refunded', activation_pending = false
+		WHERE gateway = $1 AND external_id = $2 AND status = 'paid'
+		RETURNING `+invoiceColumns+`
+	`, gateway, externalID).Scan(invoiceScanDest(&inv)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if inv.SubscriptionID == nil {
+		var refundID int64
+		err = tx.QueryRow(ctx, `
+			INSERT INTO transactions (user_id, amount, type, status)
+			VALUES ($1, $2, 'refund', 'completed')
+			RETURNING id
+		`, inv.UserID, -inv.Amount).Scan(&refundID)
 		if err != nil {
 			return nil, err
 		}
-		users = append(users, &user)
+
+		err = postBalanceTx(ctx, tx, ledgerPosting{
+			UserID: inv.UserID, Amount: -models.Kopecks(inv.Amount), Counter: models.LedgerPayments, Reason: models.TransactionRefund,
+			TransactionID: &refundID, InvoiceID: &inv.ID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to refund invoice %d: %w", inv.ID, err)
+		}
 	}
 
-	return users, nil
-}
+	if err := onRefunded(ctx, &inv); err != nil {
+		return nil, err
+	}
 
-// GetReferralsPaginated возвращает список рефералов с пагинацией и сортировкой по доходу
-func (db *DB) GetReferralsPaginated(ctx context.Context, referrerTelegramID int64, page int, perPage int) (*models.ReferralListResult, error) {
-	result := &models.ReferralListResult{
-		CurrentPage: page,
-		Referrals:   make([]*models.ReferralInfo, 0),
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
 	}
+	inv.Status = models.InvoiceRefunded
+	return &inv, nil
+}
 
-	// 1. Получаем общее количество рефералов и общий доход
+// GetInvoiceByExternalID получает счёт по ID платежа у провайдера
+func (db *DB) GetInvoiceByExternalID(ctx context.Context, gateway, externalID string) (*models.Invoice, error) {
+	var inv models.Invoice
 	err := db.Pool.QueryRow(ctx, `
-		SELECT COUNT(*), COALESCE(SUM(t.amount), 0)
-		FROM users u
-		LEFT JOIN transactions t ON t.user_id = (SELECT id FROM users WHERE telegram_id = $1) 
-			AND t.type = 'referral_bonus' AND t.status = 'completed'
-		WHERE u.referrer_id = $1
-	`, referrerTelegramID).Scan(&result.TotalCount, &result.TotalEarnings)
+		SELECT `+invoiceColumns+`
+		FROM invoices
+		WHERE gateway = $1 AND external_id = $2
+	`, gateway, externalID).Scan(invoiceScanDest(&inv)...)
 	if err != nil {
 		return nil, err
 	}
+	return &inv, nil
+}
 
-	// Вычисляем количество страниц
-	if result.TotalCount == 0 {
-		result.TotalPages = 1
-		return result, nil
+// PayInvoice помечает счёт оплаченным и пополняет баланс с учётом реферальной программы.
+// Счёт на подписку остаётся ожидающим активации до CompleteInvoiceActivation.
+// Зачисление происходит ровно один раз: повторный вызов для оплаченного счёта возвращает credited=false
+func (db *DB) PayInvoice(ctx context.Context, id int64) (credited bool, referrerTelegramID *int64, referralBonus float64, err error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return false, nil, 0, err
 	}
+	defer tx.Rollback(ctx)
 
-	result.TotalPages = (result.TotalCount + perPage - 1) / perPage
-	if page > result.TotalPages {
-		page = result.TotalPages
+	var userID int64
+	var amount float64
+	var purpose string
+	var bonusDays int
+	err = tx.QueryRow(ctx, `
+		UPDATE invoices SET status = 'paid', paid_at = NOW(), activation_pending = (purpose = 'subscription')
+		WHERE id = $1 AND status = 'pending'
+		RETURNING user_id, amount, purpose, bonus_days
+	`, id).Scan(&userID, &amount, &purpose, &bonusDays)
+	if err == pgx.ErrNoRows {
+		return false, nil, 0, nil
+	}
+	if err != nil {
+		return false, nil, 0, err
 	}
-	result.CurrentPage = page
 
-	offset := (page - 1) * perPage
+	// Оплата всегда сначала зачисляется на баланс (подписка покупается уже с баланса)
+	referrerTelegramID, referralBonus, err = topUpBalanceWithReferralTx(ctx, tx, userID, amount, &id)
+	if err != nil {
+		return false, nil, 0, err
+	}
 
-	// 2. Получаем рефералов с их доходом (доход = 25% от их пополнений)
-	// Доход считаем как сумму referral_bonus транзакций, связанных с этим рефералом
+	// Бонусные дни пополнения копятся до следующей покупки подписки
+	if purpose == models.InvoicePurposeTopUp && bonusDays > 0 {
+		_, err = tx.Exec(ctx, `
+			UPDATE users SET pending_bonus_days = pending_bonus_days + $1 WHERE id = $2
+		`, bonusDays, userID)
+		if err != nil {
+			return false, nil, 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, nil, 0, err
+	}
+
+	return true, referrerTelegramID, referralBonus, nil
+}
+
+// GetPendingInvoices возвращает неоплаченные счета шлюза, созданные после since
+func (db *DB) GetPendingInvoices(ctx context.Context, gateway string, since time.Time) ([]models.Invoice, error) {
 	rows, err := db.Pool.Query(ctx, `
-		WITH referral_earnings AS (
-			SELECT 
-				u.telegram_id,
-				u.username,
-				u.created_at,
-				COALESCE(
-					(SELECT SUM(t.amount) * 0.25 
-					 FROM transactions t 
-					 WHERE t.user_id = u.id 
-					 AND t.type IN ('top_up', 'purchase') 
-					 AND t.status = 'completed'
-					), 0
-				) as generated_revenue
-			FROM users u
-			WHERE u.referrer_id = $1
-		)
-		SELECT telegram_id, username, generated_revenue, created_at
-		FROM referral_earnings
-		ORDER BY generated_revenue DESC, created_at DESC
-		LIMIT $2 OFFSET $3
-	`, referrerTelegramID, perPage, offset)
+		SELECT `+invoiceColumns+`
+		FROM invoices
+		WHERE gateway = $1 AND status = 'pending' AND external_id IS NOT NULL AND created_at > $2
+		ORDER BY id
+	`, gateway, since)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	var invoices []models.Invoice
 	for rows.Next() {
-		var ref models.ReferralInfo
-		err := rows.Scan(&ref.TelegramID, &ref.Username, &ref.GeneratedRevenue, &ref.JoinedAt)
-		if err != nil {
+		var inv models.Invoice
+		if err := rows.Scan(invoiceScanDest(&inv)...); err != nil {
 			return nil, err
 		}
-		result.Referrals = append(result.Referrals, &ref)
+		invoices = append(invoices, inv)
 	}
-
-	return result, nil
+	return invoices, rows.Err()
 }
 
-// ================= PROMO CODES =================
+// invoiceColumns колонки счёта в порядке invoiceScanDest
+const invoiceColumns = `id, user_id, gateway, COALESCE(external_id, ''), purpose, amount, amount_stars, status,
+			   COALESCE(payment_url, ''), product_id, plan_id, months, days, bonus_days, subscription_id, created_at, paid_at,
+			   activation_pending, activation_error`
 
-// CreatePromoCode создаёт новый промокод
-func (db *DB) CreatePromoCode(ctx context.Context, code string, amount float64, maxActivations int) (*models.PromoCode, error) {
-	var promo models.PromoCode
+func invoiceScanDest(inv *models.Invoice) []any {
+	return []any{
+		&inv.ID, &inv.UserID, &inv.Gateway, &inv.ExternalID, &inv.Purpose, &inv.Amount, &inv.AmountStars, &inv.Status,
+		&inv.PaymentURL, &inv.ProductID, &inv.PlanID, &inv.Months, &inv.Days, &inv.BonusDays, &inv.SubscriptionID, &inv.CreatedAt, &inv.PaidAt,
+		&inv.ActivationPending, &inv.ActivationError,
+	}
+}
+
+// CancelInvoice отменяет неоплаченный счёт
+func (db *DB) CancelInvoice(ctx context.Context, id int64) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE invoices SET status = 'canceled' WHERE id = $1 AND status = 'pending'
+	`, id)
+	return err
+}
+
+// === Receipt Methods ===
+
+// CreateReceiptTransaction создаёт пополнение в статусе pending с фото чека (ждёт проверки админом)
+func (db *DB) CreateReceiptTransaction(ctx context.Context, userID int64, amount float64, receiptFileID string) (*models.Transaction, error) {
+	t := &models.Transaction{
+		UserID:        userID,
+		Amount:        amount,
+		Type:          models.TransactionTopUp,
+		Status:        models.TransactionPending,
+		ReceiptFileID: receiptFileID,
+	}
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO transactions (user_id, amount, type, status, receipt_file_id)
+		VALUES ($1, $2, 'top_up', 'pending', $3)
+		RETURNING id, created_at
+	`, userID, amount, receiptFileID).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetPendingReceipts возвращает чеки, ожидающие проверки (старые первыми)
+func (db *DB) GetPendingReceipts(ctx context.Context, limit int) ([]models.Transaction, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+receiptColumns+`
+		FROM transactions
+		WHERE status = 'pending' AND receipt_file_id IS NOT NULL
+		ORDER BY created_at
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var receipts []models.Transaction
+	for rows.Next() {
+		var t models.Transaction
+		if err := rows.Scan(receiptScanDest(&t)...); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, t)
+	}
+	return receipts, rows.Err()
+}
+
+// ApproveReceipt подтверждает чек: транзакция становится выполненной, баланс пополняется
+// с реферальным бонусом (как в TopUpBalanceWithReferral). Уже рассмотренный чек даёт pgx.ErrNoRows
+func (db *DB) ApproveReceipt(ctx context.Context, id, reviewerTelegramID int64) (*models.Transaction, *int64, float64, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var t models.Transaction
+	err = tx.QueryRow(ctx, `
+		UPDATE transactions SET status = 'completed', reviewed_by = $2, reviewed_at = NOW()
+		WHERE id = $1 AND status = 'pending' AND receipt_file_id IS NOT NULL
+		RETURNING `+receiptColumns+`
+	`, id, reviewerTelegramID).Scan(receiptScanDest(&t)...)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	referrerTelegramID, referralBonus, err := creditBalanceWithReferralTx(ctx, tx, t.UserID, t.Amount, t.ID, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, nil, 0, err
+	}
+	return &t, referrerTelegramID, referralBonus, nil
+}
+
+// RejectReceipt отклоняет чек. Уже рассмотренный чек даёт pgx.ErrNoRows
+func (db *DB) RejectReceipt(ctx context.Context, id, reviewerTelegramID int64) (*models.Transaction, error) {
+	var t models.Transaction
+	err := db.Pool.QueryRow(ctx, `
+		UPDATE transactions SET status = 'cancelled', reviewed_by = $2, reviewed_at = NOW()
+		WHERE id = $1 AND status = 'pending' AND receipt_file_id IS NOT NULL
+		RETURNING `+receiptColumns+`
+	`, id, reviewerTelegramID).Scan(receiptScanDest(&t)...)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// receiptColumns колонки транзакции с чеком в порядке receiptScanDest
+const receiptColumns = `id, user_id, amount, type, status, created_at,
+			   COALESCE(receipt_file_id, ''), reviewed_by, reviewed_at`
+
+func receiptScanDest(t *models.Transaction) []any {
+	return []any{
+		&t.ID, &t.UserID, &t.Amount, &t.Type, &t.Status, &t.CreatedAt,
+		&t.ReceiptFileID, &t.ReviewedBy, &t.ReviewedAt,
+	}
+}
+
+// === Node Methods ===
+
+// GetNodes возвращает все ноды с количеством активных подписок
+func (db *DB) GetNodes(ctx context.Context) ([]models.Node, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT n.id, n.name, n.panel_type, n.base_url, n.username, n.password,
+			   n.inbound_id, n.public_host, n.flow, n.capacity, n.is_enabled, n.created_at,
+			   (SELECT COUNT(*) FROM subscriptions s
+			    WHERE s.node_id = n.id AND s.is_active = true AND s.expires_at > NOW())
+		FROM nodes n
+		ORDER BY n.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanNodes(rows)
+}
+
+// GetProductNodes возвращает включённые ноды продукта с количеством активных подписок
+func (db *DB) GetProductNodes(ctx context.Context, productID int64) ([]models.Node, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT n.id, n.name, n.panel_type, n.base_url, n.username, n.password,
+			   n.inbound_id, n.public_host, n.flow, n.capacity, n.is_enabled, n.created_at,
+			   (SELECT COUNT(*) FROM subscriptions s
+			    WHERE s.node_id = n.id AND s.is_active = true AND s.expires_at > NOW())
+		FROM nodes n
+		JOIN product_nodes pn ON pn.node_id = n.id
+		WHERE pn.product_id = $1 AND n.is_enabled = true
+		ORDER BY n.id
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanNodes(rows)
+}
+
+// SetNodeEnabled включает или выключает ноду
+func (db *DB) SetNodeEnabled(ctx context.Context, nodeID int64, enabled bool) error {
+	_, err := db.Pool.Exec(ctx, `UPDATE nodes SET is_enabled = $1 WHERE id = $2`, enabled, nodeID)
+	return err
+}
+
+func scanNodes(rows pgx.Rows) ([]models.Node, error) {
+	var nodes []models.Node
+	for rows.Next() {
+		var n models.Node
+		if err := rows.Scan(
+			&n.ID, &n.Name, &n.PanelType, &n.BaseURL, &n.Username, &n.Password,
+			&n.InboundID, &n.PublicHost, &n.Flow, &n.Capacity, &n.IsEnabled, &n.CreatedAt,
+			&n.ActiveSubscriptions,
+		); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, rows.Err()
+}
+
+// === Admin Methods ===
+
+// GetAdminStats возвращает статистику для админ-панели
+func (db *DB) GetAdminStats(ctx context.Context) (*models.AdminStats, error) {
+	stats := &models.AdminStats{}
+
+	// Total users
+	err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM users`).Scan(&stats.TotalUsers)
+	if err != nil {
+		return nil, err
+	}
+
+	// New users today
+	err = db.Pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM users 
+		WHERE created_at >= CURRENT_DATE
+	`).Scan(&stats.NewUsersToday)
+	if err != nil {
+		return nil, err
+	}
+
+	// Active subscriptions
+	err = db.Pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM subscriptions 
+		WHERE is_active = true AND expires_at > NOW()
+	`).Scan(&stats.ActiveSubscriptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Revenue today
+	err = db.Pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM transactions 
+		WHERE type = 'purchase' AND status = 'completed' 
+		AND created_at >= CURRENT_DATE
+	`).Scan(&stats.RevenueToday)
+	if err != nil {
+		return nil, err
+	}
+
+	// Revenue this month
+	err = db.Pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM transactions 
+		WHERE type = 'purchase' AND status = 'completed' 
+		AND created_at >= DATE_TRUNC('month', CURRENT_DATE)
+	`).Scan(&stats.RevenueMonth)
+	if err != nil {
+		return nil, err
+	}
+
+	// Revenue all time
+	err = db.Pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(amount), 0) FROM transactions 
+		WHERE type = 'purchase' AND status = 'completed'
+	`).Scan(&stats.RevenueAllTime)
+	if err != nil {
+		return nil, err
+	}
+
+	// Trials: claimed, still active, converted (a subscription bought after the claim: a completed order
+	// or a paid subscription invoice; balance top-ups and failed purchases do not count)
+	err = db.Pool.QueryRow(ctx, `
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE s.is_active = true AND s.expires_at > NOW()),
+			COUNT(*) FILTER (WHERE EXISTS (
+				SELECT 1 FROM orders o
+				WHERE o.user_id = tc.user_id AND o.status = 'completed' AND o.created_at >= tc.claimed_at
+			) OR EXISTS (
+				SELECT 1 FROM invoices i
+				WHERE i.user_id = tc.user_id AND i.purpose = 'subscription' AND i.status = 'paid' AND i.paid_at >= tc.claimed_at
+			))
+		FROM trial_claims tc
+		LEFT JOIN subscriptions s ON s.id = tc.subscription_id
+	`).Scan(&stats.TrialsClaimed, &stats.TrialsActive, &stats.TrialsConverted)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// GetUserByID получает пользователя по ID
+func (db *DB) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	var user models.User
+	err := db.Pool.QueryRow(ctx, `
+		SELECT id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at, currency,
+			COALESCE(language, ''), COALESCE(language_code, '')
+		FROM users WHERE id = $1
+	`, id).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt, &user.Currency, &user.Language, &user.LanguageCode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// FindUserByUsername ищет пользователя по username
+func (db *DB) FindUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	err := db.Pool.QueryRow(ctx, `
+		SELECT id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at, currency,
+			COALESCE(language, ''), COALESCE(language_code, '')
+		FROM users WHERE LOWER(username) = LOWER($1)
+	`, username).Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt, &user.Currency, &user.Language, &user.LanguageCode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// AddUserBalance добавляет баланс пользователю и создаёт транзакцию
+func (db *DB) AddUserBalance(ctx context.Context, userID int64, amount float64, txType string) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Create transaction record
+	var txID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO transactions (user_id, amount, type, status)
+		VALUES ($1, $2, $3, 'completed')
+		RETURNING id
+	`, userID, amount, txType).Scan(&txID)
+	if err != nil {
+		return err
+	}
+
+	// Update balance
+	reason := models.TransactionType(txType)
+	err = postBalanceTx(ctx, tx, ledgerPosting{
+		UserID: userID, Amount: models.Kopecks(amount), Counter: ledgerCounterAccount(reason), Reason: reason,
+		TransactionID: &txID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ledgerCounterAccount встречный счёт книги для движения баланса по типу транзакции
+func ledgerCounterAccount(reason models.TransactionType) string {
+	switch reason {
+	case models.TransactionTopUp:
+		return models.LedgerPayments
+	case models.TransactionPurchase, models.TransactionRefund:
+		return models.LedgerSales
+	case models.TransactionReferralBonus:
+		return models.LedgerReferral
+	case models.TransactionPromoBonus:
+		return models.LedgerPromo
+	default:
+		return models.LedgerManual
+	}
+}
+
+// GetUserTransactions получает транзакции пользователя
+func (db *DB) GetUserTransactions(ctx context.Context, userID int64, limit int) ([]models.Transaction, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, user_id, amount, type, status, created_at
+		FROM transactions WHERE user_id = $1
+		ORDER BY created_at DESC LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []models.Transaction
+	for rows.Next() {
+		var t models.Transaction
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Amount, &t.Type, &t.Status, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, t)
+	}
+
+	return transactions, nil
+}
+
+// GetAllUserTelegramIDs возвращает все telegram_id для рассылки
+func (db *DB) GetAllUserTelegramIDs(ctx context.Context) ([]int64, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT telegram_id FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// TopUpBalanceWithReferral пополняет баланс и начисляет реферальный бонус
+func (db *DB) TopUpBalanceWithReferral(ctx context.Context, userID int64, amount float64) (*int64, float64, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	referrerTelegramID, referralBonus, err := topUpBalanceWithReferralTx(ctx, tx, userID, amount, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	return referrerTelegramID, referralBonus, nil
+}
+
+// topUpBalanceWithReferralTx пополняет баланс и начисляет реферальный бонус внутри транзакции.
+// invoiceID — оплаченный счёт (nil для пополнений без счёта)
+func topUpBalanceWithReferralTx(ctx context.Context, tx pgx.Tx, userID int64, amount float64, invoiceID *int64) (*int64, float64, error) {
+	// Создаём транзакцию пополнения
+	var topUpID int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO transactions (user_id, amount, type, status)
+		VALUES ($1, $2, 'top_up', 'completed')
+		RETURNING id
+	`, userID, amount).Scan(&topUpID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return creditBalanceWithReferralTx(ctx, tx, userID, amount, topUpID, invoiceID)
+}
+
+// creditBalanceWithReferralTx зачисляет сумму на баланс и 25% рефереру (запись пополнения topUpID создаёт вызывающий)
+func creditBalanceWithReferralTx(ctx context.Context, tx pgx.Tx, userID int64, amount float64, topUpID int64, invoiceID *int64) (*int64, float64, error) {
+	// 1. Пополняем баланс пользователя
+	err := postBalanceTx(ctx, tx, ledgerPosting{
+		UserID: userID, Amount: models.Kopecks(amount), Counter: models.LedgerPayments, Reason: models.TransactionTopUp,
+		TransactionID: &topUpID, InvoiceID: invoiceID,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 2. Проверяем есть ли реферер
+	var referrerTelegramID *int64
+	err = tx.QueryRow(ctx, `
+		SELECT referrer_id FROM users WHERE id = $1
+	`, userID).Scan(&referrerTelegramID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var referralBonus float64 = 0
+
+	// 3. Если есть реферер - начисляем ему 25%
+	if referrerTelegramID != nil {
+		bonus := models.Kopecks(amount * 0.25)
+		referralBonus = models.Rubles(bonus)
+
+		// Получаем ID реферера по telegram_id
+		var referrerID int64
+		err = tx.QueryRow(ctx, `
+			SELECT id FROM users WHERE telegram_id = $1
+		`, *referrerTelegramID).Scan(&referrerID)
+		if err == nil {
+			// Создаём транзакцию реферального бонуса
+			var bonusID int64
+			err = tx.QueryRow(ctx, `
+				INSERT INTO transactions (user_id, amount, type, status)
+				VALUES ($1, $2, 'referral_bonus', 'completed')
+				RETURNING id
+			`, referrerID, referralBonus).Scan(&bonusID)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			// Начисляем бонус рефереру на баланс и в статистику
+			err = postBalanceTx(ctx, tx, ledgerPosting{
+				UserID: referrerID, Amount: bonus, Counter: models.LedgerReferral, Reason: models.TransactionReferralBonus,
+				TransactionID: &bonusID, InvoiceID: invoiceID,
+			})
+			if err != nil {
+				return nil, 0, err
+			}
+			_, err = tx.Exec(ctx, `
+				UPDATE users SET total_ref_earnings = COALESCE(total_ref_earnings, 0) + $1::numeric / 100 WHERE id = $2
+			`, bonus, referrerID)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	return referrerTelegramID, referralBonus, nil
+}
+
+// refundPurchaseTx возвращает сумму покупки на баланс внутри транзакции
+func refundPurchaseTx(ctx context.Context, tx pgx.Tx, userID, purchaseID int64, amount float64) error {
+	var refundID int64
+	var subID *int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO transactions (user_id, amount, type, status, subscription_id, refund_of, refund_method)
+		SELECT $1, $2, 'refund', 'completed', subscription_id, id, $4
+		FROM transactions WHERE id = $3
+		RETURNING id, subscription_id
+	`, userID, amount, purchaseID, models.RefundToBalance).Scan(&refundID, &subID)
+	if err != nil {
+		return err
+	}
+
+	return postBalanceTx(ctx, tx, ledgerPosting{
+		UserID: userID, Amount: models.Kopecks(amount), Counter: models.LedgerSales, Reason: models.TransactionRefund,
+		TransactionID: &refundID, SubscriptionID: subID,
+	})
+}
+
+// GetReferralCount возвращает количество рефералов пользователя
+func (db *DB) GetReferralCount(ctx context.Context, telegramID int64) (int, error) {
+	var count int
+	err := db.Pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM users WHERE referrer_id = $1
+	`, telegramID).Scan(&count)
+	return count, err
+}
+
+// GetUserReferrals возвращает список рефералов пользователя
+func (db *DB) GetUserReferrals(ctx context.Context, telegramID int64) ([]*models.User, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, telegram_id, username, balance, referrer_id, COALESCE(total_ref_earnings, 0), created_at, currency,
+			COALESCE(language, ''), COALESCE(language_code, '')
+		FROM users WHERE referrer_id = $1
+		ORDER BY created_at DESC
+		LIMIT 50
+	`, telegramID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*models.User
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(&user.ID, &user.TelegramID, &user.Username, &user.Balance, &user.ReferrerID, &user.TotalRefEarnings, &user.CreatedAt, &user.Currency, &user.Language, &user.LanguageCode)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	return users, nil
+}
+
+// GetReferralsPaginated возвращает список рефералов с пагинацией и сортировкой по доходу
+func (db *DB) GetReferralsPaginated(ctx context.Context, referrerTelegramID int64, page int, perPage int) (*models.ReferralListResult, error) {
+	result := &models.ReferralListResult{
+		CurrentPage: page,
+		Referrals:   make([]*models.ReferralInfo, 0),
+	}
+
+	// 1. Получаем общее количество рефералов и общий доход
+	err := db.Pool.QueryRow(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(t.amount), 0)
+		FROM users u
+		LEFT JOIN transactions t ON t.user_id = (SELECT id FROM users WHERE telegram_id = $1) 
+			AND t.type = 'referral_bonus' AND t.status = 'completed'
+		WHERE u.referrer_id = $1
+	`, referrerTelegramID).Scan(&result.TotalCount, &result.TotalEarnings)
+	if err != nil {
+		return nil, err
+	}
+
+	// Вычисляем количество страниц
+	if result.TotalCount == 0 {
+		result.TotalPages = 1
+		return result, nil
+	}
+
+	result.TotalPages = (result.TotalCount + perPage - 1) / perPage
+	if page > result.TotalPages {
+		page = result.TotalPages
+	}
+	result.CurrentPage = page
+
+	offset := (page - 1) * perPage
+
+	// 2. Получаем рефералов с их доходом (доход = 25% от их пополнений)
+	// Доход считаем как сумму referral_bonus транзакций, связанных с этим рефералом
+	rows, err := db.Pool.Query(ctx, `
+		WITH referral_earnings AS (
+			SELECT 
+				u.telegram_id,
+				u.username,
+				u.created_at,
+				COALESCE(
+					(SELECT SUM(t.amount) * 0.25 
+					 FROM transactions t 
+					 WHERE t.user_id = u.id 
+					 AND t.type IN ('top_up', 'purchase') 
+					 AND t.status = 'completed'
+					), 0
+				) as generated_revenue
+			FROM users u
+			WHERE u.referrer_id = $1
+		)
+		SELECT telegram_id, username, generated_revenue, created_at
+		FROM referral_earnings
+		ORDER BY generated_revenue DESC, created_at DESC
+		LIMIT $2 OFFSET $3
+	`, referrerTelegramID, perPage, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ref models.ReferralInfo
+		err := rows.Scan(&ref.TelegramID, &ref.Username, &ref.GeneratedRevenue, &ref.JoinedAt)
+		if err != nil {
+			return nil, err
+		}
+		result.Referrals = append(result.Referrals, &ref)
+	}
+
+	return result, nil
+}
+
+// ================= PROMO CODES =================
+
+// CreatePromoCode создаёт новый промокод
+func (db *DB) CreatePromoCode(ctx context.Context, code string, amount float64, maxActivations int) (*models.PromoCode, error) {
+	var promo models.PromoCode
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO promo_codes (code, amount, max_activations)
+		VALUES (UPPER($1), $2, $3)
+		RETURNING id, code, amount, max_activations, activations_used, is_active, created_at
+	`, code, amount, maxActivations).Scan(
+		&promo.ID, &promo.Code, &promo.Amount, &promo.MaxActivations,
+		&promo.ActivationsUsed, &promo.IsActive, &promo.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+// GetPromoByCode получает промокод по коду
+func (db *DB) GetPromoByCode(ctx context.Context, code string) (*models.PromoCode, error) {
+	var promo models.PromoCode
+	err := db.Pool.QueryRow(ctx, `
+		SELECT id, code, amount, max_activations, activations_used, is_active, created_at
+		FROM promo_codes WHERE UPPER(code) = UPPER($1)
+	`, code).Scan(
+		&promo.ID, &promo.Code, &promo.Amount, &promo.MaxActivations,
+		&promo.ActivationsUsed, &promo.IsActive, &promo.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &promo, nil
+}
+
+// HasUserActivatedPromo проверяет, активировал ли пользователь промокод
+func (db *DB) HasUserActivatedPromo(ctx context.Context, promoID int64, telegramID int64) (bool, error) {
+	var exists bool
+	err := db.Pool.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM promo_activations WHERE promo_id = $1 AND telegram_id = $2)
+	`, promoID, telegramID).Scan(&exists)
+	return exists, err
+}
+
+// ActivatePromoCode активирует промокод для пользователя
+func (db *DB) ActivatePromoCode(ctx context.Context, promoID int64, userID int64, telegramID int64, amount float64) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// 1. Добавляем запись об активации
+	_, err = tx.Exec(ctx, `
+		INSERT INTO promo_activations (promo_id, user_id, telegram_id)
+		VALUES ($1, $2, $3)
+	`, promoID, userID, telegramID)
+	if err != nil {
+		return err
+	}
+
+	// 2. Увеличиваем счётчик активаций
+	_, err = tx.Exec(ctx, `
+		UPDATE promo_codes SET activations_used = activations_used + 1 WHERE id = $1
+	`, promoID)
+	if err != nil {
+		return err
+	}
+
+	// 3. Создаём транзакцию
+	var txID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO transactions (user_id, amount, type, status)
+		VALUES ($1, $2, 'promo_bonus', 'completed')
+		RETURNING id
+	`, userID, amount).Scan(&txID)
+	if err != nil {
+		return err
+	}
+
+	// 4. Начисляем баланс пользователю
+	err = postBalanceTx(ctx, tx, ledgerPosting{
+		UserID: userID, Amount: models.Kopecks(amount), Counter: models.LedgerPromo, Reason: models.TransactionPromoBonus,
+		TransactionID: &txID, PromoID: &promoID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// GetAllPromoCodes получает все промокоды
+func (db *DB) GetAllPromoCodes(ctx context.Context) ([]*models.PromoCode, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, code, amount, max_activations, activations_used, is_active, created_at
+		FROM promo_codes ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var promos []*models.PromoCode
+	for rows.Next() {
+		var promo models.PromoCode
+		err := rows.Scan(&promo.ID, &promo.Code, &promo.Amount, &promo.MaxActivations,
+			&promo.ActivationsUsed, &promo.IsActive, &promo.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		promos = append(promos, &promo)
+	}
+	return promos, nil
+}
+
+// DeletePromoCode удаляет промокод
+func (db *DB) DeletePromoCode(ctx context.Context, code string) error {
+	_, err := db.Pool.Exec(ctx, `DELETE FROM promo_codes WHERE UPPER(code) = UPPER($1)`, code)
+	return err
+}
+
+// GetTopReferrers возвращает топ-10 рефоводов
+func (db *DB) GetTopReferrers(ctx context.Context, limit int) ([]*models.TopReferrer, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT 
+			u.telegram_id,
+			u.username,
+			COUNT(r.id) as referral_count,
+			COALESCE(u.total_ref_earnings, 0) as total_revenue
+		FROM users u
+		LEFT JOIN users r ON r.referrer_id = u.telegram_id
+		GROUP BY u.id, u.telegram_id, u.username, u.total_ref_earnings
+		HAVING COUNT(r.id) > 0
+		ORDER BY total_revenue DESC, referral_count DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var referrers []*models.TopReferrer
+	for rows.Next() {
+		var ref models.TopReferrer
+		err := rows.Scan(&ref.TelegramID, &ref.Username, &ref.ReferralCount, &ref.TotalRevenue)
+		if err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, &ref)
+	}
+	return referrers, nil
+}
+
+// GetPromoStats возвращает статистику по промокодам
+func (db *DB) GetPromoStats(ctx context.Context) ([]*models.PromoStats, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT 
+			p.code,
+			p.amount,
+			p.max_activations,
+			p.activations_used,
+			(p.amount * p.activations_used) as total_bonus_paid
+		FROM promo_codes p
+		WHERE p.is_active = true
+		ORDER BY p.activations_used DESC, p.created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*models.PromoStats
+	for rows.Next() {
+		var s models.PromoStats
+		err := rows.Scan(&s.Code, &s.Amount, &s.MaxActivations, &s.ActivationsUsed, &s.TotalBonusPaid)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, &s)
+	}
+	return stats, nil
+}
+
+// === Ticket Methods ===
+
+// ticketColumns колонки тикета (t) с данными пользователя (u) в порядке ticketScanDest
+const ticketColumns = `t.id, t.user_id, t.status, t.assignee_id, t.composing, COALESCE(t.group_message_id, 0),
+			   t.created_at, t.updated_at, t.closed_at, u.telegram_id, COALESCE(u.username, ''),
+			   (SELECT COUNT(*) FROM ticket_messages m WHERE m.ticket_id = t.id AND NOT m.from_admin)`
+
+func ticketScanDest(t *models.Ticket) []any {
+	return []any{
+		&t.ID, &t.UserID, &t.Status, &t.AssigneeID, &t.Composing, &t.GroupMessageID,
+		&t.CreatedAt, &t.UpdatedAt, &t.ClosedAt, &t.TelegramID, &t.Username, &t.MessageCount,
+	}
+}
+
+// OpenTicket возвращает открытый тикет пользователя (создаёт новый, если его нет) и включает режим поддержки
+func (db *DB) OpenTicket(ctx context.Context, userID int64) (*models.Ticket, error) {
+	var ticketID int64
 	err := db.Pool.QueryRow(ctx, `
-		INSERT INTO promo_codes (code, amount, max_activations)
-		VALUES (UPPER($1), $2, $3)
-		RETURNING id, code, amount, max_activations, activations_used, is_active, created_at
-	`, code, amount, maxActivations).Scan(
-		&promo.ID, &promo.Code, &promo.Amount, &promo.MaxActivations,
-		&promo.ActivationsUsed, &promo.IsActive, &promo.CreatedAt)
+		INSERT INTO tickets (user_id) VALUES ($1)
+		ON CONFLICT (user_id) WHERE status <> 'closed'
+		DO UPDATE SET composing = true
+		RETURNING id
+	`, userID).Scan(&ticketID)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetTicketByID(ctx, ticketID)
+}
+
+// GetTicketByID получает тикет по ID
+func (db *DB) GetTicketByID(ctx context.Context, id int64) (*models.Ticket, error) {
+	var t models.Ticket
+	err := db.Pool.QueryRow(ctx, `
+		SELECT `+ticketColumns+`
+		FROM tickets t JOIN users u ON u.id = t.user_id
+		WHERE t.id = $1
+	`, id).Scan(ticketScanDest(&t)...)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetOpenTicketByTelegramID получает открытый тикет пользователя
+func (db *DB) GetOpenTicketByTelegramID(ctx context.Context, telegramID int64) (*models.Ticket, error) {
+	var t models.Ticket
+	err := db.Pool.QueryRow(ctx, `
+		SELECT `+ticketColumns+`
+		FROM tickets t JOIN users u ON u.id = t.user_id
+		WHERE u.telegram_id = $1 AND t.status <> 'closed'
+	`, telegramID).Scan(ticketScanDest(&t)...)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetTicketByGroupMessage находит тикет по сообщению в группе поддержки
+func (db *DB) GetTicketByGroupMessage(ctx context.Context, groupMessageID int) (*models.Ticket, error) {
+	var t models.Ticket
+	err := db.Pool.QueryRow(ctx, `
+		SELECT `+ticketColumns+`
+		FROM tickets t JOIN users u ON u.id = t.user_id
+		WHERE t.id = (SELECT ticket_id FROM ticket_messages WHERE group_message_id = $1 ORDER BY id DESC LIMIT 1)
+	`, groupMessageID).Scan(ticketScanDest(&t)...)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// IsTicketComposing проверяет, находится ли пользователь в режиме поддержки
+func (db *DB) IsTicketComposing(ctx context.Context, telegramID int64) (bool, error) {
+	var composing bool
+	err := db.Pool.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM tickets t JOIN users u ON u.id = t.user_id
+			WHERE u.telegram_id = $1 AND t.status <> 'closed' AND t.composing
+		)
+	`, telegramID).Scan(&composing)
+	return composing, err
+}
+
+// StopTicketComposing выключает режим поддержки. Тикет без сообщений удаляется
+func (db *DB) StopTicketComposing(ctx context.Context, telegramID int64) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM tickets t USING users u
+		WHERE u.id = t.user_id AND u.telegram_id = $1 AND t.status = 'new'
+	`, telegramID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE tickets t SET composing = false
+		FROM users u
+		WHERE u.id = t.user_id AND u.telegram_id = $1 AND t.status <> 'closed'
+	`, telegramID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// AddUserTicketMessage сохраняет сообщение пользователя в открытый тикет (создавая его при необходимости)
+// и переводит тикет в статус "ожидает ответа"
+func (db *DB) AddUserTicketMessage(ctx context.Context, userID int64, msg *models.TicketMessage) (*models.Ticket, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO tickets (user_id, status, group_message_id) VALUES ($1, 'waiting', NULLIF($2, 0))
+		ON CONFLICT (user_id) WHERE status <> 'closed'
+		DO UPDATE SET status = 'waiting', updated_at = NOW(),
+			group_message_id = COALESCE(EXCLUDED.group_message_id, tickets.group_message_id)
+		RETURNING id
+	`, userID, msg.GroupMessageID).Scan(&msg.TicketID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertTicketMessageTx(ctx, tx, msg); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return db.GetTicketByID(ctx, msg.TicketID)
+}
+
+// AddAdminTicketMessage сохраняет ответ поддержки: тикет становится "отвечен",
+// ответивший админ назначается исполнителем, если исполнителя ещё нет
+func (db *DB) AddAdminTicketMessage(ctx context.Context, msg *models.TicketMessage) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE tickets SET status = 'replied', updated_at = NOW(), assignee_id = COALESCE(assignee_id, $2)
+		WHERE id = $1 AND status <> 'closed'
+	`, msg.TicketID, msg.SenderID)
+	if err != nil {
+		return err
+	}
+
+	if err := insertTicketMessageTx(ctx, tx, msg); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func insertTicketMessageTx(ctx context.Context, tx pgx.Tx, msg *models.TicketMessage) error {
+	return tx.QueryRow(ctx, `
+		INSERT INTO ticket_messages (ticket_id, from_admin, sender_id, kind, text, group_message_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0))
+		RETURNING id, created_at
+	`, msg.TicketID, msg.FromAdmin, msg.SenderID, msg.Kind, msg.Text, msg.GroupMessageID).Scan(&msg.ID, &msg.CreatedAt)
+}
+
+// CloseTicket закрывает открытый тикет пользователя
+func (db *DB) CloseTicket(ctx context.Context, telegramID int64) (*models.Ticket, error) {
+	var ticketID int64
+	err := db.Pool.QueryRow(ctx, `
+		UPDATE tickets t SET status = 'closed', composing = false, updated_at = NOW(), closed_at = NOW()
+		FROM users u
+		WHERE u.id = t.user_id AND u.telegram_id = $1 AND t.status <> 'closed'
+		RETURNING t.id
+	`, telegramID).Scan(&ticketID)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetTicketByID(ctx, ticketID)
+}
+
+// GetActiveTickets возвращает тикеты с перепиской, ожидающие ответа или отвеченные
+func (db *DB) GetActiveTickets(ctx context.Context) ([]models.Ticket, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+ticketColumns+`
+		FROM tickets t JOIN users u ON u.id = t.user_id
+		WHERE t.status IN ('waiting', 'replied')
+		ORDER BY t.status = 'waiting' DESC, t.updated_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTickets(rows)
+}
+
+// GetUserTickets возвращает историю обращений пользователя (новые первыми)
+func (db *DB) GetUserTickets(ctx context.Context, telegramID int64, limit int) ([]models.Ticket, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+ticketColumns+`
+		FROM tickets t JOIN users u ON u.id = t.user_id
+		WHERE u.telegram_id = $1 AND t.status <> 'new'
+		ORDER BY t.created_at DESC
+		LIMIT $2
+	`, telegramID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTickets(rows)
+}
+
+func scanTickets(rows pgx.Rows) ([]models.Ticket, error) {
+	var tickets []models.Ticket
+	for rows.Next() {
+		var t models.Ticket
+		if err := rows.Scan(ticketScanDest(&t)...); err != nil {
+			return nil, err
+		}
+		tickets = append(tickets, t)
+	}
+	return tickets, rows.Err()
+}
+
+// GetSupportDashboard возвращает ID закреплённого сообщения dashboard в чате (0 = не создан)
+func (db *DB) GetSupportDashboard(ctx context.Context, chatID int64) (int, error) {
+	var msgID int
+	err := db.Pool.QueryRow(ctx, `
+		SELECT message_id FROM support_dashboard WHERE chat_id = $1
+	`, chatID).Scan(&msgID)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	return msgID, err
+}
+
+// SetSupportDashboard сохраняет ID сообщения dashboard
+func (db *DB) SetSupportDashboard(ctx context.Context, chatID int64, msgID int) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO support_dashboard (chat_id, message_id) VALUES ($1, $2)
+		ON CONFLICT (chat_id) DO UPDATE SET message_id = EXCLUDED.message_id
+	`, chatID, msgID)
+	return err
+}
+
+// === Audit Log Methods ===
+
+// insertAuditEntry пишет запись аудита (в транзакции действия, чтобы запись и действие не расходились)
+func insertAuditEntry(ctx context.Context, tx pgx.Tx, e *models.AuditEntry) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO audit_log (actor_id, actor_role, action, user_id, subscription_id, details)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, e.ActorID, e.ActorRole, e.Action, e.UserID, e.SubscriptionID, e.Details)
+	return err
+}
+
+// CountAuditEntries считает записи аудита подписки с действием action от роли actorRole начиная с since
+func (db *DB) CountAuditEntries(ctx context.Context, subscriptionID int64, action, actorRole string, since time.Time) (int, error) {
+	var count int
+	err := db.Pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM audit_log
+		WHERE subscription_id = $1 AND action = $2 AND actor_role = $3 AND created_at >= $4
+	`, subscriptionID, action, actorRole, since).Scan(&count)
+	return count, err
+}
+
+// === Ledger Methods ===
+
+// ledgerPosting движение по балансу пользователя: Amount (копейки, > 0 — зачисление) проводится
+// по счёту пользователя и с обратным знаком по встречному счёту Counter
+type ledgerPosting struct {
+	UserID         int64
+	Amount         int64
+	Counter        string
+	Reason         models.TransactionType
+	TransactionID  *int64
+	SubscriptionID *int64
+	PromoID        *int64
+	InvoiceID      *int64
+}
+
+// postBalanceTx меняет users.balance на сумму проводки и записывает проводку в книгу внутри транзакции.
+// Баланс не может стать отрицательным: такое списание возвращает ошибку
+func postBalanceTx(ctx context.Context, tx pgx.Tx, p ledgerPosting) error {
+	tag, err := tx.Exec(ctx, `
+		UPDATE users SET balance = balance + $1::numeric / 100
+		WHERE id = $2 AND balance + $1::numeric / 100 >= 0
+	`, p.Amount, p.UserID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("insufficient balance: user %d, amount %.2f", p.UserID, models.Rubles(p.Amount))
+	}
+
+	_, err = tx.Exec(ctx, `
+		WITH posting AS (SELECT nextval('ledger_posting_seq') AS id)
+		INSERT INTO ledger_entries (posting_id, account, user_id, amount, reason, transaction_id, subscription_id, promo_id, invoice_id)
+		SELECT posting.id, 'user', $1::bigint, $2::bigint, $4::text, $5::bigint, $6::bigint, $7::bigint, $8::bigint FROM posting
+		UNION ALL
+		SELECT posting.id, $3::text, NULL, -$2::bigint, $4::text, $5::bigint, $6::bigint, $7::bigint, $8::bigint FROM posting
+	`, p.UserID, p.Amount, p.Counter, p.Reason, p.TransactionID, p.SubscriptionID, p.PromoID, p.InvoiceID)
+	return err
+}
+
+// GetLedgerDrift возвращает пользователей, у которых users.balance расходится с суммой записей книги
+func (db *DB) GetLedgerDrift(ctx context.Context) ([]models.LedgerDrift, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT u.id, u.telegram_id, ROUND(u.balance * 100)::bigint, COALESCE(l.total, 0)::bigint
+		FROM users u
+		LEFT JOIN (
+			SELECT user_id, SUM(amount) AS total FROM ledger_entries
+			WHERE account = 'user' GROUP BY user_id
+		) l ON l.user_id = u.id
+		WHERE ROUND(u.balance * 100) <> COALESCE(l.total, 0)
+		ORDER BY u.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var drift []models.LedgerDrift
+	for rows.Next() {
+		var d models.LedgerDrift
+		if err := rows.Scan(&d.UserID, &d.TelegramID, &d.Balance, &d.Ledger); err != nil {
+			return nil, err
+		}
+		drift = append(drift, d)
+	}
+	return drift, rows.Err()
+}
+
+// CountUnbalancedPostings считает проводки, записи которых в сумме не дают 0 (нарушение двойной записи)
+func (db *DB) CountUnbalancedPostings(ctx context.Context) (int, error) {
+	var count int
+	err := db.Pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM (
+			SELECT posting_id FROM ledger_entries GROUP BY posting_id HAVING SUM(amount) <> 0
+		) unbalanced
+	`).Scan(&count)
+	return count, err
+}
+
+// === Order Methods ===
+
+// orderColumns колонки заказа в порядке orderScanDest
+const orderColumns = `id, user_id, idempotency_key, kind, product_id, plan_id, subscription_id, amount, bonus_days,
+	node_id, COALESCE(vpn_username, ''), purchase_id, status, COALESCE(error, ''), created_at, updated_at`
+
+func orderScanDest(o *models.Order) []any {
+	return []any{
+		&o.ID, &o.UserID, &o.IdempotencyKey, &o.Kind, &o.ProductID, &o.PlanID, &o.SubscriptionID, &o.Amount, &o.BonusDays,
+		&o.NodeID, &o.VPNUsername, &o.PurchaseID, &o.Status, &o.Error, &o.CreatedAt, &o.UpdatedAt,
+	}
+}
+
+// GetOrderByKey возвращает ожидающий или завершённый заказ пользователя по ключу идемпотентности.
+// Отменённый заказ ключ не держит: покупку с тем же ключом можно повторить
+func (db *DB) GetOrderByKey(ctx context.Context, userID int64, key string) (*models.Order, error) {
+	var o models.Order
+	err := db.Pool.QueryRow(ctx, `
+		SELECT `+orderColumns+` FROM orders WHERE user_id = $1 AND idempotency_key = $2 AND status <> 'failed'
+	`, userID, key).Scan(orderScanDest(&o)...)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// CreateOrder создаёт заказ и в той же транзакции списывает o.Amount с баланса (запись покупки и проводка по книге).
+// Новой подписке к o.BonusDays добавляются накопленные бонусные дни пользователя.
+// Возвращает false, если ожидающий или завершённый заказ с таким ключом уже есть (ничего не списывается)
+func (db *DB) CreateOrder(ctx context.Context, o *models.Order) (*models.Order, bool, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var order models.Order
+	err = tx.QueryRow(ctx, `
+		INSERT INTO orders (user_id, idempotency_key, kind, product_id, plan_id, subscription_id, amount, bonus_days, node_id, vpn_username)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''))
+		ON CONFLICT (user_id, idempotency_key) WHERE status <> 'failed' DO NOTHING
+		RETURNING `+orderColumns,
+		o.UserID, o.IdempotencyKey, o.Kind, o.ProductID, o.PlanID, o.SubscriptionID, o.Amount, o.BonusDays, o.NodeID, o.VPNUsername,
+	).Scan(orderScanDest(&order)...)
+	if err == pgx.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Бонусные дни за пополнение криптовалютой копятся до следующей новой подписки
+	if order.Kind == models.OrderNew {
+		var pending int
+		err = tx.QueryRow(ctx, `
+			UPDATE users u SET pending_bonus_days = 0
+			FROM (SELECT id, pending_bonus_days FROM users WHERE id = $1 FOR UPDATE) old
+			WHERE u.id = old.id
+			RETURNING old.pending_bonus_days
+		`, order.UserID).Scan(&pending)
+		if err != nil {
+			return nil, false, err
+		}
+		order.BonusDays += pending
+	}
+
+	var purchaseID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO transactions (user_id, amount, type, status, subscription_id)
+		VALUES ($1, $2, 'purchase', 'completed', $3)
+		RETURNING id
+	`, order.UserID, -order.Amount, order.SubscriptionID).Scan(&purchaseID)
+	if err != nil {
+		return nil, false, err
+	}
+	err = postBalanceTx(ctx, tx, ledgerPosting{
+		UserID: order.UserID, Amount: -models.Kopecks(order.Amount), Counter: models.LedgerSales, Reason: models.TransactionPurchase,
+		TransactionID: &purchaseID, SubscriptionID: order.SubscriptionID,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE orders SET purchase_id = $2, bonus_days = $3 WHERE id = $1
+	`, order.ID, purchaseID, order.BonusDays)
+	if err != nil {
+		return nil, false, err
+	}
+	order.PurchaseID = &purchaseID
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+	return &order, true, nil
+}
+
+// completeOrderTx завершает ожидающий заказ подпиской subID и привязывает к ней покупку.
+// Заказ, уже отменённый восстановлением, даёт pgx.ErrNoRows
+func completeOrderTx(ctx context.Context, tx pgx.Tx, orderID, subID int64) error {
+	var purchaseID *int64
+	err := tx.QueryRow(ctx, `
+		UPDATE orders SET status = 'completed', subscription_id = $2, updated_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+		RETURNING purchase_id
+	`, orderID, subID).Scan(&purchaseID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &promo, nil
+	if purchaseID == nil {
+		return nil
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE transactions SET subscription_id = $1 WHERE id = $2
+	`, subID, *purchaseID)
+	return err
 }
 
-// GetPromoByCode получает промокод по коду
-func (db *DB) GetPromoByCode(ctx context.Context, code string) (*models.PromoCode, error) {
-	var promo models.PromoCode
-	err := db.Pool.QueryRow(ctx, `
-		SELECT id, code, amount, max_activations, activations_used, is_active, created_at
-		FROM promo_codes WHERE UPPER(code) = UPPER($1)
-	`, code).Scan(
-		&promo.ID, &promo.Code, &promo.Amount, &promo.MaxActivations,
-		&promo.ActivationsUsed, &promo.IsActive, &promo.CreatedAt)
+// CompleteOrderSubscription сохраняет подписку, созданную по заказу, и завершает заказ одной транзакцией.
+// Если заказ уже не ожидает (отменён восстановлением), подписка не сохраняется и возвращается pgx.ErrNoRows
+func (db *DB) CompleteOrderSubscription(ctx context.Context, orderID int64, s *models.Subscription) (*models.Subscription, error) {
+	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return &promo, nil
-}
+	defer tx.Rollback(ctx)
 
-// HasUserActivatedPromo проверяет, активировал ли пользователь промокод
-func (db *DB) HasUserActivatedPromo(ctx context.Context, promoID int64, telegramID int64) (bool, error) {
-	var exists bool
-	err := db.Pool.QueryRow(ctx, `
-		SELECT EXISTS(SELECT 1 FROM promo_activations WHERE promo_id = $1 AND telegram_id = $2)
-	`, promoID, telegramID).Scan(&exists)
-	return exists, err
+	sub, err := insertSubscription(ctx, tx, s)
+	if err != nil {
+		return nil, err
+	}
+	if err := completeOrderTx(ctx, tx, orderID, sub.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return sub, nil
 }
 
-// ActivatePromoCode активирует промокод для пользователя
-func (db *DB) ActivatePromoCode(ctx context.Context, promoID int64, userID int64, telegramID int64, amount float64) error {
+// CompleteOrderExtension продлевает подписку до sub.ExpiresAt с ограничениями нового периода и завершает заказ
+// одной транзакцией. onUpdated вызывается внутри транзакции (продление в панели): при ошибке всё откатывается.
+// Если заказ уже не ожидает, возвращается pgx.ErrNoRows
+func (db *DB) CompleteOrderExtension(ctx context.Context, orderID int64, sub *models.Subscription, onUpdated func(ctx context.Context) error) error {
 	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx)
 
-	// 1. Добавляем запись об активации
-	_, err = tx.Exec(ctx, `
-		INSERT INTO promo_activations (promo_id, user_id, telegram_id)
-		VALUES ($1, $2, $3)
-	`, promoID, userID, telegramID)
-	if err != nil {
+	if err := extendSubscriptionTx(ctx, tx, sub); err != nil {
+		return err
+	}
+	if err := completeOrderTx(ctx, tx, orderID, sub.ID); err != nil {
 		return err
 	}
 
-	// 2. Увеличиваем счётчик активаций
-	_, err = tx.Exec(ctx, `
-		UPDATE promo_codes SET activations_used = activations_used + 1 WHERE id = $1
-	`, promoID)
+	if onUpdated != nil {
+		if err := onUpdated(ctx); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// FailOrder отменяет ожидающий заказ: сумма возвращается на баланс (возврат связан с покупкой заказа),
+// бонусные дни — пользователю. Уже завершённый или отменённый заказ даёт pgx.ErrNoRows
+func (db *DB) FailOrder(ctx context.Context, orderID int64, reason string) (*models.Order, error) {
+	tx, err := db.Pool.Begin(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer tx.Rollback(ctx)
 
-	// 3. Начисляем баланс пользователю
-	_, err = tx.Exec(ctx, `
-		UPDATE users SET balance = balance + $1 WHERE id = $2
-	`, amount, userID)
+	var order models.Order
+	err = tx.QueryRow(ctx, `
+		UPDATE orders SET status = 'failed', error = $2, updated_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+		RETURNING `+orderColumns,
+		orderID, reason,
+	).Scan(orderScanDest(&order)...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// 4. Создаём транзакцию
-	_, err = tx.Exec(ctx, `
-		INSERT INTO transactions (user_id, amount, type, status)
-		VALUES ($1, $2, 'promo_bonus', 'completed')
-	`, userID, amount)
+	if order.PurchaseID != nil {
+		if err := refundPurchaseTx(ctx, tx, order.UserID, *order.PurchaseID, order.Amount); err != nil {
+			return nil, err
+		}
+	}
+	if order.BonusDays > 0 {
+		_, err = tx.Exec(ctx, `
+			UPDATE users SET pending_bonus_days = pending_bonus_days + $1 WHERE id = $2
+		`, order.BonusDays, order.UserID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// GetStalePendingOrders возвращает заказы, ожидающие с момента раньше before (процесс упал во время покупки)
+func (db *DB) GetStalePendingOrders(ctx context.Context, before time.Time) ([]models.Order, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT `+orderColumns+` FROM orders
+		WHERE status = 'pending' AND created_at < $1
+		ORDER BY created_at
+	`, before)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	return tx.Commit(ctx)
+	var orders []models.Order
+	for rows.Next() {
+		var o models.Order
+		if err := rows.Scan(orderScanDest(&o)...); err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+	return orders, rows.Err()
 }
 
-// GetAllPromoCodes получает все промокоды
-func (db *DB) GetAllPromoCodes(ctx context.Context) ([]*models.PromoCode, error) {
+// === Currency Methods ===
+
+// GetCurrencies возвращает валюты с курсами: базовая первой, остальные по коду
+func (db *DB) GetCurrencies(ctx context.Context) ([]models.Currency, error) {
 	rows, err := db.Pool.Query(ctx, `
-		SELECT id, code, amount, max_activations, activations_used, is_active, created_at
-		FROM promo_codes ORDER BY created_at DESC
-	`)
+		SELECT code, rate, updated_at, updated_by FROM currencies
+		ORDER BY code <> $1, code
+	`, models.BaseCurrency)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var promos []*models.PromoCode
+	var currencies []models.Currency
 	for rows.Next() {
-		var promo models.PromoCode
-		err := rows.Scan(&promo.ID, &promo.Code, &promo.Amount, &promo.MaxActivations,
-			&promo.ActivationsUsed, &promo.IsActive, &promo.CreatedAt)
-		if err != nil {
+		var cur models.Currency
+		if err := rows.Scan(&cur.Code, &cur.Rate, &cur.UpdatedAt, &cur.UpdatedBy); err != nil {
 			return nil, err
 		}
-		promos = append(promos, &promo)
+		currencies = append(currencies, cur)
 	}
-	return promos, nil
+	return currencies, rows.Err()
 }
 
-// DeletePromoCode удаляет промокод
-func (db *DB) DeletePromoCode(ctx context.Context, code string) error {
-	_, err := db.Pool.Exec(ctx, `DELETE FROM promo_codes WHERE UPPER(code) = UPPER($1)`, code)
+// GetCurrency возвращает валюту с курсом по коду
+func (db *DB) GetCurrency(ctx context.Context, code string) (*models.Currency, error) {
+	var cur models.Currency
+	err := db.Pool.QueryRow(ctx, `
+		SELECT code, rate, updated_at, updated_by FROM currencies WHERE code = $1
+	`, code).Scan(&cur.Code, &cur.Rate, &cur.UpdatedAt, &cur.UpdatedBy)
+	if err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
+
+// SetCurrencyRate добавляет валюту или обновляет её курс
+func (db *DB) SetCurrencyRate(ctx context.Context, code string, rate float64, adminTelegramID int64) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO currencies (code, rate, updated_by) VALUES ($1, $2, $3)
+		ON CONFLICT (code) DO UPDATE SET rate = EXCLUDED.rate, updated_by = EXCLUDED.updated_by, updated_at = NOW()
+	`, code, rate, adminTelegramID)
 	return err
 }
 
-// GetTopReferrers возвращает топ-10 рефоводов
-func (db *DB) GetTopReferrers(ctx context.Context, limit int) ([]*models.TopReferrer, error) {
+// SetUserCurrency меняет валюту отображения сумм пользователя
+func (db *DB) SetUserCurrency(ctx context.Context, userID int64, code string) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE users SET currency = $2 WHERE id = $1
+	`, userID, code)
+	return err
+}
+
+// === Language Methods ===
+
+// SetUserLanguage меняет язык интерфейса пользователя (пусто — определять по language_code)
+func (db *DB) SetUserLanguage(ctx context.Context, userID int64, lang string) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE users SET language = NULLIF($2, '') WHERE id = $1
+	`, userID, lang)
+	return err
+}
+
+// SetUserLanguageCode запоминает language_code клиента Telegram пользователя
+func (db *DB) SetUserLanguageCode(ctx context.Context, userID int64, code string) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE users SET language_code = $2 WHERE id = $1
+	`, userID, code)
+	return err
+}
+
+// GetBroadcastRecipients возвращает получателей рассылки: Telegram ID, язык и валюту каждого пользователя
+func (db *DB) GetBroadcastRecipients(ctx context.Context) ([]models.User, error) {
 	rows, err := db.Pool.Query(ctx, `
-		SELECT 
-			u.telegram_id,
-			u.username,
-			COUNT(r.id) as referral_count,
-			COALESCE(u.total_ref_earnings, 0) as total_revenue
-		FROM users u
-		LEFT JOIN users r ON r.referrer_id = u.telegram_id
-		GROUP BY u.id, u.telegram_id, u.username, u.total_ref_earnings
-		HAVING COUNT(r.id) > 0
-		ORDER BY total_revenue DESC, referral_count DESC
-		LIMIT $1
-	`, limit)
+		SELECT id, telegram_id, currency, COALESCE(language, ''), COALESCE(language_code, '') FROM users
+	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var referrers []*models.TopReferrer
+	var users []models.User
 	for rows.Next() {
-		var ref models.TopReferrer
-		err := rows.Scan(&ref.TelegramID, &ref.Username, &ref.ReferralCount, &ref.TotalRevenue)
-		if err != nil {
+		var user models.User
+		if err := rows.Scan(&user.ID, &user.TelegramID, &user.Currency, &user.Language, &user.LanguageCode); err != nil {
 			return nil, err
 		}
-		referrers = append(referrers, &ref)
+		users = append(users, user)
 	}
-	return referrers, nil
+	return users, rows.Err()
 }
 
-// GetPromoStats возвращает статистику по промокодам
-func (db *DB) GetPromoStats(ctx context.Context) ([]*models.PromoStats, error) {
-	rows, err := db.Pool.Query(ctx, `
-		SELECT 
-			p.code,
-			p.amount,
-			p.max_activations,
-			p.activations_used,
-			(p.amount * p.activations_used) as total_bonus_paid
-		FROM promo_codes p
-		WHERE p.is_active = true
-		ORDER BY p.activations_used DESC, p.created_at DESC
-	`)
+// === Callback Request Methods ===
+
+// ClaimCallback регистрирует обработку callback. Возвращает true, если её можно выполнять;
+// иначе — false и более раннюю обработку с тем же ID callback или отпечатком.
+// Обработки, начатые раньше since, не мешают: их отпечаток занимается заново
+func (db *DB) ClaimCallback(ctx context.Context, telegramID int64, callbackID, fingerprint string, since time.Time) (*models.CallbackRequest, bool, error) {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM callback_requests WHERE (fingerprint = $1 OR callback_id = $2) AND created_at < $3
+	`, fingerprint, callbackID, since)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var id int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO callback_requests (callback_id, telegram_id, fingerprint)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		RETURNING id
+	`, callbackID, telegramID, fingerprint).Scan(&id)
+	if err == nil {
+		return &models.CallbackRequest{ID: id, CallbackID: callbackID, TelegramID: telegramID, Fingerprint: fingerprint, Status: models.CallbackProcessing}, true, tx.Commit(ctx)
+	}
+	if err != pgx.ErrNoRows {
+		return nil, false, err
+	}
+
+	var r models.CallbackRequest
+	err = tx.QueryRow(ctx, `
+		SELECT id, callback_id, telegram_id, fingerprint, status, result, created_at, completed_at
+		FROM callback_requests
+		WHERE fingerprint = $1 OR callback_id = $2
+		ORDER BY created_at
+		LIMIT 1
+	`, fingerprint, callbackID).Scan(&r.ID, &r.CallbackID, &r.TelegramID, &r.Fingerprint, &r.Status, &r.Result, &r.CreatedAt, &r.CompletedAt)
+	if err != nil {
+		return nil, false, err
+	}
+	return &r, false, tx.Commit(ctx)
+}
+
+// CompleteCallback отмечает обработку callback завершённой и сохраняет ответ для повторов
+func (db *DB) CompleteCallback(ctx context.Context, id int64, result []byte) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE callback_requests SET status = 'done', result = $2, completed_at = NOW() WHERE id = $1
+	`, id, result)
+	return err
+}
+
+// DeleteCallback удаляет обработку callback
+func (db *DB) DeleteCallback(ctx context.Context, id int64) error {
+	_, err := db.Pool.Exec(ctx, `
+		DELETE FROM callback_requests WHERE id = $1
+	`, id)
+	return err
+}
+
+// DeleteCallbacksBefore удаляет обработки callback, начатые раньше before
+func (db *DB) DeleteCallbacksBefore(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		DELETE FROM callback_requests WHERE created_at < $1
+	`, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// === Trial Methods ===
+
+// IsTrialEligible может ли пользователь взять пробную подписку: у него не было ни подписок, ни пробного периода
+func (db *DB) IsTrialEligible(ctx context.Context, userID int64) (bool, error) {
+	var eligible bool
+	err := db.Pool.QueryRow(ctx, `
+		SELECT NOT EXISTS (SELECT 1 FROM subscriptions WHERE user_id = $1)
+		   AND NOT EXISTS (SELECT 1 FROM trial_claims WHERE user_id = $1)
+	`, userID).Scan(&eligible)
+	return eligible, err
+}
+
+// CreateTrialClaim атомарно резервирует пробный период пользователя.
+// Возвращает false, если пользователь уже брал пробный период или у него были подписки
+func (db *DB) CreateTrialClaim(ctx context.Context, userID int64) (int64, bool, error) {
+	var id int64
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO trial_claims (user_id)
+		SELECT $1 WHERE NOT EXISTS (SELECT 1 FROM subscriptions WHERE user_id = $1)
+		ON CONFLICT (user_id) DO NOTHING
+		RETURNING id
+	`, userID).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// SetTrialSubscription привязывает выданную пробную подписку к заявке
+func (db *DB) SetTrialSubscription(ctx context.Context, claimID, subscriptionID int64) error {
+	_, err := db.Pool.Exec(ctx, `UPDATE trial_claims SET subscription_id = $2 WHERE id = $1`, claimID, subscriptionID)
+	return err
+}
+
+// DeleteTrialClaim снимает резерв пробного периода (ключ выдать не удалось)
+func (db *DB) DeleteTrialClaim(ctx context.Context, claimID int64) error {
+	_, err := db.Pool.Exec(ctx, `DELETE FROM trial_claims WHERE id = $1 AND subscription_id IS NULL`, claimID)
+	return err
+}
+
+// === FSM Session Methods ===
+
+// GetFSMSession возвращает неистёкшее состояние диалога пользователя
+func (db *DB) GetFSMSession(ctx context.Context, userID int64) (*models.FSMSession, error) {
+	s := &models.FSMSession{}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT user_id, state, data, expires_at FROM fsm_sessions
+		WHERE user_id = $1 AND expires_at > NOW()
+	`, userID).Scan(&s.UserID, &s.State, &s.Data, &s.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveFSMSession сохраняет состояние диалога (заменяя предыдущее) со сроком жизни ttl.
+// Срок считается по часам БД, s.ExpiresAt заполняется из результата
+func (db *DB) SaveFSMSession(ctx context.Context, s *models.FSMSession, ttl time.Duration) error {
+	return db.Pool.QueryRow(ctx, `
+		INSERT INTO fsm_sessions (user_id, state, data, expires_at, updated_at)
+		VALUES ($1, $2, $3, NOW() + make_interval(secs => $4), NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			state = EXCLUDED.state, data = EXCLUDED.data,
+			expires_at = EXCLUDED.expires_at, updated_at = NOW()
+		RETURNING expires_at
+	`, s.UserID, s.State, s.Data, ttl.Seconds()).Scan(&s.ExpiresAt)
+}
+
+// DeleteFSMSession сбрасывает состояние диалога пользователя
+func (db *DB) DeleteFSMSession(ctx context.Context, userID int64) error {
+	_, err := db.Pool.Exec(ctx, `DELETE FROM fsm_sessions WHERE user_id = $1`, userID)
+	return err
+}
+
+// DeleteExpiredFSMSessions удаляет истёкшие состояния
+func (db *DB) DeleteExpiredFSMSessions(ctx context.Context) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM fsm_sessions WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// === Campaign Methods ===
+
+const campaignColumns = `id, discount_percent, starts_at, ends_at, product_id, months,
+	COALESCE(created_by, 0), announced_at, cancelled_at, created_at`
+
+func campaignScanDest(c *models.Campaign) []any {
+	return []any{&c.ID, &c.DiscountPercent, &c.StartsAt, &c.EndsAt, &c.ProductID, &c.Months,
+		&c.CreatedBy, &c.AnnouncedAt, &c.CancelledAt, &c.CreatedAt}
+}
+
+// CreateCampaign создаёт акцию
+func (db *DB) CreateCampaign(ctx context.Context, c *models.Campaign) (*models.Campaign, error) {
+	created := &models.Campaign{}
+	err := db.Pool.QueryRow(ctx, `
+		INSERT INTO campaigns (discount_percent, starts_at, ends_at, product_id, months, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+campaignColumns,
+		c.DiscountPercent, c.StartsAt, c.EndsAt, c.ProductID, c.Months, c.CreatedBy,
+	).Scan(campaignScanDest(created)...)
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// GetCurrentCampaigns возвращает действующие и запланированные (не закончившиеся к now) акции
+func (db *DB) GetCurrentCampaigns(ctx context.Context, now time.Time) ([]models.Campaign, error) {
+	return db.queryCampaigns(ctx, `
+		SELECT `+campaignColumns+` FROM campaigns
+		WHERE cancelled_at IS NULL AND ends_at > $1
+		ORDER BY starts_at, id
+	`, now)
+}
+
+// GetCampaignsToAnnounce возвращает начавшиеся акции, о которых ещё не было рассылки
+func (db *DB) GetCampaignsToAnnounce(ctx context.Context, now time.Time) ([]models.Campaign, error) {
+	return db.queryCampaigns(ctx, `
+		SELECT `+campaignColumns+` FROM campaigns
+		WHERE cancelled_at IS NULL AND announced_at IS NULL AND starts_at <= $1 AND ends_at > $1
+		ORDER BY starts_at, id
+	`, now)
+}
+
+func (db *DB) queryCampaigns(ctx context.Context, query string, args ...any) ([]models.Campaign, error) {
+	rows, err := db.Pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var stats []*models.PromoStats
+	var campaigns []models.Campaign
 	for rows.Next() {
-		var s models.PromoStats
-		err := rows.Scan(&s.Code, &s.Amount, &s.MaxActivations, &s.ActivationsUsed, &s.TotalBonusPaid)
-		if err != nil {
+		var c models.Campaign
+		if err := rows.Scan(campaignScanDest(&c)...); err != nil {
 			return nil, err
 		}
-		stats = append(stats, &s)
+		campaigns = append(campaigns, c)
 	}
-	return stats, nil
+	return campaigns, rows.Err()
+}
+
+// MarkCampaignAnnounced отмечает рассылку об акции; false — уже отмечена
+func (db *DB) MarkCampaignAnnounced(ctx context.Context, id int64) (bool, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE campaigns SET announced_at = NOW() WHERE id = $1 AND announced_at IS NULL
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// CancelCampaign отменяет акцию; false — акция не найдена или уже отменена
+func (db *DB) CancelCampaign(ctx context.Context, id int64) (bool, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE campaigns SET cancelled_at = NOW() WHERE id = $1 AND cancelled_at IS NULL
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// CancelActiveCampaigns отменяет все действующие в момент now акции (запланированные не трогает)
+func (db *DB) CancelActiveCampaigns(ctx context.Context, now time.Time) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `
+		UPDATE campaigns SET cancelled_at = NOW()
+		WHERE cancelled_at IS NULL AND starts_at <= $1 AND ends_at > $1
+	`, now)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
 }
@@ -0,0 +1,198 @@
+package fsm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// DefaultTTL время жизни состояния по умолчанию
+const DefaultTTL = 30 * time.Minute
+
+// Store хранилище состояний диалогов пользователей
+type Store interface {
+	// Get возвращает неистёкшее состояние или nil, если его нет
+	Get(ctx context.Context, userID int64) (*models.FSMSession, error)
+	// Save сохраняет состояние (заменяя предыдущее) со сроком жизни ttl
+	Save(ctx context.Context, s *models.FSMSession, ttl time.Duration) error
+	// Delete сбрасывает состояние пользователя
+	Delete(ctx context.Context, userID int64) error
+	// DeleteExpired удаляет истёкшие состояния
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// Session текущее состояние пользователя, передаётся в обработчик шага
+type Session struct {
+	UserID    int64
+	State     string
+	ExpiresAt time.Time
+	data      []byte
+}
+
+// Decode распаковывает данные шага в v
+func (s *Session) Decode(v any) error {
+	if len(s.data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(s.data, v)
+}
+
+// StepHandler обрабатывает сообщение пользователя, находящегося в состоянии
+type StepHandler func(c tele.Context, s *Session) error
+
+type step struct {
+	handler    StepHandler
+	allowMedia bool
+}
+
+// Machine маршрутизирует сообщения пользователей по их состоянию.
+// Каждый сценарий (рассылка, мастер промокодов, ввод чека...) регистрирует свои шаги через Handle
+type Machine struct {
+	store Store
+	ttl   time.Duration
+
+	mu    sync.RWMutex
+	steps map[string]step
+
+	cleanupMu sync.Mutex
+	stopChan  chan struct{}
+}
+
+// New создаёт Machine поверх хранилища; ttl <= 0 — DefaultTTL
+func New(store Store, ttl time.Duration) *Machine {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Machine{
+		store: store,
+		ttl:   ttl,
+		steps: make(map[string]step),
+	}
+}
+
+// Handle регистрирует обработчик текстовых сообщений в состоянии state
+func (m *Machine) Handle(state string, h StepHandler) {
+	m.register(state, step{handler: h})
+}
+
+// HandleAny регистрирует обработчик любых сообщений (текст, фото, документы) в состоянии state
+func (m *Machine) HandleAny(state string, h StepHandler) {
+	m.register(state, step{handler: h, allowMedia: true})
+}
+
+func (m *Machine) register(state string, s step) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.steps[state]; exists {
+		panic(fmt.Sprintf("fsm: state %q registered twice", state))
+	}
+	m.steps[state] = s
+}
+
+// Set переводит пользователя в состояние state с данными data (сериализуются в JSON)
+func (m *Machine) Set(ctx context.Context, userID int64, state string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("fsm: failed to encode %s data: %w", state, err)
+	}
+	return m.store.Save(ctx, &models.FSMSession{UserID: userID, State: state, Data: raw}, m.ttl)
+}
+
+// Get возвращает текущее состояние пользователя или nil
+func (m *Machine) Get(ctx context.Context, userID int64) (*Session, error) {
+	s, err := m.store.Get(ctx, userID)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	return &Session{UserID: s.UserID, State: s.State, ExpiresAt: s.ExpiresAt, data: s.Data}, nil
+}
+
+// Lookup возвращает состояние пользователя, только если оно равно state, и распаковывает его данные в v
+func (m *Machine) Lookup(ctx context.Context, userID int64, state string, v any) (bool, error) {
+	s, err := m.Get(ctx, userID)
+	if err != nil || s == nil || s.State != state {
+		return false, err
+	}
+	if v != nil {
+		if err := s.Decode(v); err != nil {
+			return false, fmt.Errorf("fsm: failed to decode %s data: %w", state, err)
+		}
+	}
+	return true, nil
+}
+
+// Reset сбрасывает состояние пользователя
+func (m *Machine) Reset(ctx context.Context, userID int64) error {
+	return m.store.Delete(ctx, userID)
+}
+
+// Dispatch передаёт сообщение обработчику текущего состояния отправителя.
+// handled = false, если состояния нет, для него не зарегистрирован шаг
+// или шаг принимает только текст, а пришло медиа
+func (m *Machine) Dispatch(c tele.Context) (handled bool, err error) {
+	if c.Sender() == nil || c.Message() == nil {
+		return false, nil
+	}
+
+	s, err := m.Get(context.Background(), c.Sender().ID)
+	if err != nil {
+		return false, fmt.Errorf("fsm: failed to load state of %d: %w", c.Sender().ID, err)
+	}
+	if s == nil {
+		return false, nil
+	}
+
+	m.mu.RLock()
+	st, ok := m.steps[s.State]
+	m.mu.RUnlock()
+	if !ok || (!st.allowMedia && c.Message().Text == "") {
+		return false, nil
+	}
+
+	return true, st.handler(c, s)
+}
+
+// StartCleanup периодически удаляет истёкшие состояния из хранилища
+func (m *Machine) StartCleanup(interval time.Duration) {
+	m.cleanupMu.Lock()
+	defer m.cleanupMu.Unlock()
+	if m.stopChan != nil {
+		return
+	}
+	m.stopChan = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				n, err := m.store.DeleteExpired(context.Background())
+				if err != nil {
+					log.Printf("⚠️ FSM cleanup failed: %v", err)
+				} else if n > 0 {
+					log.Printf("🧹 FSM: removed %d expired sessions", n)
+				}
+			}
+		}
+	}(m.stopChan)
+}
+
+// StopCleanup останавливает очистку
+func (m *Machine) StopCleanup() {
+	m.cleanupMu.Lock()
+	defer m.cleanupMu.Unlock()
+	if m.stopChan != nil {
+		close(m.stopChan)
+		m.stopChan = nil
+	}
+}
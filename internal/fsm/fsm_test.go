@@ -0,0 +1,155 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeContext сообщение пользователя; остальные методы tele.Context тестам не нужны
+type fakeContext struct {
+	tele.Context
+	sender  *tele.User
+	message *tele.Message
+}
+
+func (f *fakeContext) Sender() *tele.User     { return f.sender }
+func (f *fakeContext) Message() *tele.Message { return f.message }
+
+func textMessage(userID int64, text string) *fakeContext {
+	return &fakeContext{sender: &tele.User{ID: userID}, message: &tele.Message{Text: text}}
+}
+
+func photoMessage(userID int64) *fakeContext {
+	return &fakeContext{sender: &tele.User{ID: userID}, message: &tele.Message{Photo: &tele.Photo{}}}
+}
+
+func TestDispatch(t *testing.T) {
+	m := New(NewMemoryStore(), time.Hour)
+	ctx := context.Background()
+
+	var called []string
+	errStep := errors.New("step failed")
+	m.Handle("text_only", func(c tele.Context, s *Session) error {
+		called = append(called, "text_only")
+		return nil
+	})
+	m.HandleAny("any", func(c tele.Context, s *Session) error {
+		called = append(called, "any")
+		return nil
+	})
+	m.Handle("failing", func(c tele.Context, s *Session) error {
+		return errStep
+	})
+
+	tests := []struct {
+		name        string
+		state       string // пусто — состояния нет
+		c           *fakeContext
+		wantHandled bool
+		wantCalled  string
+		wantErr     error
+	}{
+		{name: "no state", c: textMessage(1, "hi")},
+		{name: "unknown state", state: "unknown", c: textMessage(1, "hi")},
+		{name: "text to text step", state: "text_only", c: textMessage(1, "hi"), wantHandled: true, wantCalled: "text_only"},
+		{name: "media to text step", state: "text_only", c: photoMessage(1)},
+		{name: "text to any step", state: "any", c: textMessage(1, "hi"), wantHandled: true, wantCalled: "any"},
+		{name: "media to any step", state: "any", c: photoMessage(1), wantHandled: true, wantCalled: "any"},
+		{name: "step error", state: "failing", c: textMessage(1, "hi"), wantHandled: true, wantErr: errStep},
+		{name: "no message", state: "any", c: &fakeContext{sender: &tele.User{ID: 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = nil
+			if err := m.Reset(ctx, 1); err != nil {
+				t.Fatalf("Reset: %v", err)
+			}
+			if tt.state != "" {
+				if err := m.Set(ctx, 1, tt.state, nil); err != nil {
+					t.Fatalf("Set: %v", err)
+				}
+			}
+
+			handled, err := m.Dispatch(tt.c)
+			if handled != tt.wantHandled || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Dispatch = %v, %v; want %v, %v", handled, err, tt.wantHandled, tt.wantErr)
+			}
+			if (tt.wantCalled == "" && len(called) != 0) || (tt.wantCalled != "" && (len(called) != 1 || called[0] != tt.wantCalled)) {
+				t.Errorf("called %v, want %q", called, tt.wantCalled)
+			}
+		})
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	m := New(NewMemoryStore(), 0)
+	m.Handle("state", func(c tele.Context, s *Session) error { return nil })
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a state twice did not panic")
+		}
+	}()
+	m.HandleAny("state", func(c tele.Context, s *Session) error { return nil })
+}
+
+func TestSessionDecode(t *testing.T) {
+	type promoDraft struct {
+		Code    string   `json:"code"`
+		Percent int      `json:"percent"`
+		Plans   []int64  `json:"plans"`
+		Expires *float64 `json:"expires"`
+	}
+
+	m := New(NewMemoryStore(), time.Hour)
+	ctx := context.Background()
+
+	want := promoDraft{Code: "SPRING", Percent: 15, Plans: []int64{1, 3}}
+	if err := m.Set(ctx, 7, "promo_wizard", want); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	s, err := m.Get(ctx, 7)
+	if err != nil || s == nil {
+		t.Fatalf("Get = %+v, %v", s, err)
+	}
+	if s.UserID != 7 || s.State != "promo_wizard" || time.Until(s.ExpiresAt) <= 0 {
+		t.Errorf("session = %+v", s)
+	}
+	var got promoDraft
+	if err := s.Decode(&got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got.Code != want.Code || got.Percent != want.Percent || len(got.Plans) != 2 || got.Plans[1] != 3 || got.Expires != nil {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+
+	// Lookup распаковывает данные только в ожидаемом состоянии
+	var looked promoDraft
+	if ok, err := m.Lookup(ctx, 7, "promo_wizard", &looked); !ok || err != nil || looked.Code != "SPRING" {
+		t.Errorf("Lookup = %v, %v, %+v", ok, err, looked)
+	}
+	if ok, err := m.Lookup(ctx, 7, "broadcast", &looked); ok || err != nil {
+		t.Errorf("Lookup of another state = %v, %v; want false", ok, err)
+	}
+
+	// Данные другого вида — ошибка распаковки
+	if err := m.Set(ctx, 7, "promo_wizard", "not an object"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if ok, err := m.Lookup(ctx, 7, "promo_wizard", &looked); ok || err == nil {
+		t.Errorf("Lookup of malformed data = %v, %v; want error", ok, err)
+	}
+
+	// Шаг без данных: Decode ничего не меняет
+	empty := &Session{UserID: 7, State: "waiting"}
+	untouched := promoDraft{Code: "KEEP"}
+	if err := empty.Decode(&untouched); err != nil || untouched.Code != "KEEP" {
+		t.Errorf("Decode of empty data = %v, %+v", err, untouched)
+	}
+}
@@ -0,0 +1,114 @@
+package fsm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"vpn-telegram-bot/internal/database"
+	"vpn-telegram-bot/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Бэкенды хранилища состояний
+const (
+	BackendPostgres = "postgres"
+	BackendMemory   = "memory"
+)
+
+// MemoryStore хранит состояния в памяти процесса (теряются при перезапуске)
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[int64]models.FSMSession
+}
+
+// NewMemoryStore создаёт хранилище в памяти
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[int64]models.FSMSession)}
+}
+
+// Get возвращает неистёкшее состояние пользователя
+func (s *MemoryStore) Get(ctx context.Context, userID int64) (*models.FSMSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[userID]
+	if !ok {
+		return nil, nil
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		delete(s.sessions, userID)
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// Save сохраняет состояние пользователя
+func (s *MemoryStore) Save(ctx context.Context, session *models.FSMSession, ttl time.Duration) error {
+	session.ExpiresAt = time.Now().Add(ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.UserID] = *session
+	return nil
+}
+
+// Delete сбрасывает состояние пользователя
+func (s *MemoryStore) Delete(ctx context.Context, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, userID)
+	return nil
+}
+
+// DeleteExpired удаляет истёкшие состояния
+func (s *MemoryStore) DeleteExpired(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var n int64
+	for userID, session := range s.sessions {
+		if !now.Before(session.ExpiresAt) {
+			delete(s.sessions, userID)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// PostgresStore хранит состояния в таблице fsm_sessions (переживают перезапуск бота)
+type PostgresStore struct {
+	db *database.DB
+}
+
+// NewPostgresStore создаёт хранилище в PostgreSQL
+func NewPostgresStore(db *database.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Get возвращает неистёкшее состояние пользователя
+func (s *PostgresStore) Get(ctx context.Context, userID int64) (*models.FSMSession, error) {
+	session, err := s.db.GetFSMSession(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return session, err
+}
+
+// Save сохраняет состояние пользователя
+func (s *PostgresStore) Save(ctx context.Context, session *models.FSMSession, ttl time.Duration) error {
+	return s.db.SaveFSMSession(ctx, session, ttl)
+}
+
+// Delete сбрасывает состояние пользователя
+func (s *PostgresStore) Delete(ctx context.Context, userID int64) error {
+	return s.db.DeleteFSMSession(ctx, userID)
+}
+
+// DeleteExpired удаляет истёкшие состояния
+func (s *PostgresStore) DeleteExpired(ctx context.Context) (int64, error) {
+	return s.db.DeleteExpiredFSMSessions(ctx)
+}
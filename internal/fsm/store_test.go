@@ -0,0 +1,103 @@
+package fsm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+)
+
+func TestMemoryStoreTTL(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	if err := store.Save(ctx, &models.FSMSession{UserID: 1, State: "live"}, time.Hour); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := store.Save(ctx, &models.FSMSession{UserID: 2, State: "expired"}, -time.Second); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	live, err := store.Get(ctx, 1)
+	if err != nil || live == nil || live.State != "live" {
+		t.Fatalf("Get live = %+v, %v", live, err)
+	}
+	if d := time.Until(live.ExpiresAt); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("live session expires in %s, want about 1h", d)
+	}
+
+	// Истёкшее состояние не отдаётся и сразу удаляется
+	if expired, err := store.Get(ctx, 2); err != nil || expired != nil {
+		t.Fatalf("Get expired = %+v, %v; want nil", expired, err)
+	}
+	if _, ok := store.sessions[2]; ok {
+		t.Error("expired session is still stored after Get")
+	}
+
+	// Новое состояние заменяет предыдущее и продлевает срок
+	if err := store.Save(ctx, &models.FSMSession{UserID: 1, State: "next"}, time.Hour); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if next, _ := store.Get(ctx, 1); next == nil || next.State != "next" {
+		t.Errorf("Get after replace = %+v, want state next", next)
+	}
+
+	if err := store.Delete(ctx, 1); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if deleted, _ := store.Get(ctx, 1); deleted != nil {
+		t.Errorf("Get after Delete = %+v, want nil", deleted)
+	}
+}
+
+func TestMemoryStoreDeleteExpired(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	store.Save(ctx, &models.FSMSession{UserID: 1, State: "live"}, time.Hour)
+	store.Save(ctx, &models.FSMSession{UserID: 2, State: "expired"}, -time.Second)
+	store.Save(ctx, &models.FSMSession{UserID: 3, State: "expired"}, -time.Minute)
+
+	n, err := store.DeleteExpired(ctx)
+	if err != nil || n != 2 {
+		t.Fatalf("DeleteExpired = %d, %v; want 2", n, err)
+	}
+	if len(store.sessions) != 1 {
+		t.Errorf("%d sessions left, want 1", len(store.sessions))
+	}
+	if n, _ := store.DeleteExpired(ctx); n != 0 {
+		t.Errorf("second DeleteExpired = %d, want 0", n)
+	}
+}
+
+func TestMachineCleanup(t *testing.T) {
+	store := NewMemoryStore()
+	m := New(store, 20*time.Millisecond)
+	ctx := context.Background()
+
+	if err := m.Set(ctx, 1, "waiting", nil); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	m.StartCleanup(10 * time.Millisecond)
+	m.StartCleanup(10 * time.Millisecond) // повторный запуск ничего не делает
+	defer m.StopCleanup()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		store.mu.Lock()
+		left := len(store.sessions)
+		store.mu.Unlock()
+		if left == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired session was not cleaned up")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	m.StopCleanup()
+	m.StopCleanup() // повторная остановка безопасна
+}
@@ -7,153 +7,64 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
+	"vpn-telegram-bot/internal/fsm"
+	"vpn-telegram-bot/internal/i18n"
 	"vpn-telegram-bot/internal/models"
 
 	tele "gopkg.in/telebot.v3"
 )
 
-// broadcastState хранит состояние рассылки
-type broadcastState struct {
-	mu             sync.Mutex
-	isActive       bool
-	waitingMsg     bool
-	waitingConfirm bool
-	adminID        int64
-	message        *tele.Message
-}
-
-var broadcast = &broadcastState{}
-
-// issueState хранит состояние выдачи ключа
-type issueState struct {
-	mu       sync.Mutex
-	sessions map[int64]*issueSession
-}
-
-type issueSession struct {
-	step      int // 1=product, 2=days, 3=userID
-	productID int64
-	days      int
-}
-
-var issue = &issueState{
-	sessions: make(map[int64]*issueSession),
-}
-
-// adminSearchState хранит состояние поиска пользователя
-type adminSearchState struct {
-	mu       sync.Mutex
-	waiting  map[int64]bool  // adminID -> waiting for user input
-	addBalTo map[int64]int64 // adminID -> targetUserID (waiting for amount)
-}
-
-var adminSearch = &adminSearchState{
-	waiting:  make(map[int64]bool),
-	addBalTo: make(map[int64]int64),
-}
-
-// promoWizardState хранит состояние создания промокода
-type promoWizardState struct {
-	mu       sync.Mutex
-	sessions map[int64]*promoWizardSession
-}
-
-type promoWizardSession struct {
-	step   int     // 1=code, 2=amount, 3=activations
-	code   string
-	amount float64
-}
-
-var promoWizard = &promoWizardState{
-	sessions: make(map[int64]*promoWizardSession),
-}
-
-// promoDeleteState хранит состояние удаления промокода
-type promoDeleteState struct {
-	mu      sync.Mutex
-	waiting map[int64]bool
-}
-
-var promoDelete = &promoDeleteState{
-	waiting: make(map[int64]bool),
-}
-
-// userPromoState хранит состояние ввода промокода пользователем
-type userPromoState struct {
-	mu      sync.RWMutex
-	waiting map[int64]bool // userID -> waiting for promo code
-}
-
-var userPromo = &userPromoState{
-	waiting: make(map[int64]bool),
-}
-
-// SetUserPromoMode устанавливает режим ввода промокода
-func SetUserPromoMode(userID int64, active bool) {
-	userPromo.mu.Lock()
-	defer userPromo.mu.Unlock()
-	if active {
-		userPromo.waiting[userID] = true
-	} else {
-		delete(userPromo.waiting, userID)
-	}
-}
+// broadcastActive выставлен, пока выполняется рассылка (одна на процесс)
+var broadcastActive atomic.Bool
 
-// IsUserInPromoMode проверяет режим ввода промокода
-func IsUserInPromoMode(userID int64) bool {
-	userPromo.mu.RLock()
-	defer userPromo.mu.RUnlock()
-	return userPromo.waiting[userID]
+// broadcastDraft сообщение для рассылки (хранится в состоянии до подтверждения).
+// Получатели, для языка которых нет варианта, получают основное сообщение
+type broadcastDraft struct {
+	Kind        string                    `json:"kind"` // text | photo | document | video
+	FileID      string                    `json:"file_id,omitempty"`
+	Text        string                    `json:"text"`                   // текст или подпись
+	Variants    map[string]broadcastDraft `json:"variants,omitempty"`     // варианты по языкам интерфейса
+	VariantLang string                    `json:"variant_lang,omitempty"` // язык варианта, который ожидается
 }
 
-// supportState хранит состояние тикет-системы поддержки
-type supportState struct {
-	mu              sync.RWMutex
-	userInSupport   map[int64]bool  // userID -> в режиме поддержки
-	adminReplyingTo map[int64]int64 // adminID -> userID которому отвечает
+// issueData данные сценария выдачи ключа
+type issueData struct {
+	ProductID int64 `json:"product_id"`
+	Days      int   `json:"days"`
 }
 
-var support = &supportState{
-	userInSupport:   make(map[int64]bool),
-	adminReplyingTo: make(map[int64]int64),
+// promoWizardData данные мастера создания промокода
+type promoWizardData struct {
+	Code   string  `json:"code"`
+	Amount float64 `json:"amount"`
 }
 
 // IsUserInSupportMode проверяет, находится ли пользователь в режиме поддержки
-func IsUserInSupportMode(userID int64) bool {
-	support.mu.RLock()
-	defer support.mu.RUnlock()
-	return support.userInSupport[userID]
+func (h *Handler) IsUserInSupportMode(userID int64) bool {
+	active, err := h.svc.IsInSupportMode(context.Background(), userID)
+	if err != nil {
+		log.Printf("Failed to check support mode for user %d: %v", userID, err)
+		return false
+	}
+	return active
 }
 
-// SetUserSupportMode устанавливает режим поддержки для пользователя
-func SetUserSupportMode(userID int64, active bool) {
-	support.mu.Lock()
-	defer support.mu.Unlock()
+// SetUserSupportMode включает режим поддержки (открывает тикет) или выключает его (тикет остаётся открытым)
+func (h *Handler) SetUserSupportMode(user *tele.User, active bool) {
+	ctx := context.Background()
 	if active {
-		support.userInSupport[userID] = true
-	} else {
-		delete(support.userInSupport, userID)
+		// Незаконченный сценарий перехватывал бы сообщения в поддержку
+		h.resetState(user.ID)
+		if _, err := h.svc.StartSupport(ctx, user.ID, user.Username); err != nil {
+			log.Printf("Failed to open ticket for user %d: %v", user.ID, err)
+		}
+		return
 	}
-}
-
-// GetAdminReplyTarget возвращает ID пользователя, которому админ отвечает
-func GetAdminReplyTarget(adminID int64) int64 {
-	support.mu.RLock()
-	defer support.mu.RUnlock()
-	return support.adminReplyingTo[adminID]
-}
-
-// SetAdminReplyTarget устанавливает пользователя для ответа админа
-func SetAdminReplyTarget(adminID int64, userID int64) {
-	support.mu.Lock()
-	defer support.mu.Unlock()
-	if userID > 0 {
-		support.adminReplyingTo[adminID] = userID
-	} else {
-		delete(support.adminReplyingTo, adminID)
+	if err := h.svc.StopSupport(ctx, user.ID); err != nil {
+		log.Printf("Failed to stop support mode for user %d: %v", user.ID, err)
 	}
 }
 
@@ -196,16 +107,37 @@ func (h *Handler) RegisterAdmin(b *tele.Bot) {
 	adminGroup.Handle("/issue", h.HandleIssueStart)
 	adminGroup.Handle("/broadcast", h.HandleAdminBroadcast)
 	adminGroup.Handle("/ahelp", h.HandleAdminHelp)
+	adminGroup.Handle("/refundstars", h.HandleRefundStars)
+	adminGroup.Handle("/reconcile", h.HandleAdminReconcile)
 
 	// Flash Sale
 	h.RegisterFlashSale(b, adminGroup)
 
+	// VPN ноды
+	h.RegisterNodes(adminGroup)
+
+	// Тарифные планы продуктов
+	h.RegisterPlans(adminGroup)
+
+	// Чеки СБП на проверке
+	h.RegisterReceipts(b, adminGroup)
+
+	// Перевыпуск ключей подписок
+	h.RegisterKeysAdmin(adminGroup)
+
+	// Отмена подписок с возвратом
+	h.RegisterRefundsAdmin(adminGroup)
+
+	// Курсы валют
+	h.RegisterCurrencyAdmin(adminGroup)
+
 	// Admin callbacks
 	adminGroup.Handle(&tele.Btn{Unique: "admin_stats"}, h.HandleAdminStats)
 	adminGroup.Handle(&tele.Btn{Unique: "admin_users"}, h.HandleAdminUsers)
 	adminGroup.Handle(&tele.Btn{Unique: "admin_broadcast"}, h.HandleAdminBroadcast)
 	adminGroup.Handle(&tele.Btn{Unique: "admin_cancel_broadcast"}, h.HandleCancelBroadcast)
 	adminGroup.Handle(&tele.Btn{Unique: "admin_confirm_broadcast"}, h.HandleConfirmBroadcast)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_broadcast_variant"}, h.HandleBroadcastVariant)
 	adminGroup.Handle(&tele.Btn{Unique: "admin_back"}, h.HandleAdmin)
 	adminGroup.Handle(&tele.Btn{Unique: "admin_issue"}, h.HandleIssueStart)
 	adminGroup.Handle(&tele.Btn{Unique: "admin_help"}, h.HandleAdminHelp)
@@ -246,13 +178,24 @@ func (h *Handler) RegisterAdmin(b *tele.Bot) {
 	// Support ticket management (close ticket from group)
 	b.Handle(&tele.Btn{Unique: "admin_close_ticket"}, h.HandleAdminCloseTicket)
 
-	// Handle text messages for broadcast, issue, user search, and support reply
+	// Шаги админских сценариев, ожидающие ввод
+	h.fsm.HandleAny(stateBroadcastMessage, h.HandleBroadcastMessage)
+	h.fsm.HandleAny(stateBroadcastVariant, h.HandleBroadcastVariantMessage)
+	h.fsm.Handle(stateFindUser, h.HandleAdminFindUserInput)
+	h.fsm.Handle(stateAddBalance, h.HandleAdminAddBalAmount)
+	h.fsm.Handle(stateIssueUser, h.HandleIssueUserID)
+	h.fsm.Handle(statePromoCode, h.HandleAdminPromoCodeInput)
+	h.fsm.Handle(statePromoAmount, h.HandleAdminPromoAmountInput)
+	h.fsm.Handle(statePromoActivations, h.HandleAdminPromoActivationsInput)
+	h.fsm.Handle(statePromoDelete, h.HandleAdminPromoDeleteInput)
+	h.fsm.Handle(stateSupportReply, h.HandleSupportAdminReply)
+
+	// Handle text messages: support bridge, dialog steps, support mode
 	b.Handle(tele.OnText, func(c tele.Context) error {
 		userID := c.Sender().ID
 
 		// DEBUG: Log every text message
 		log.Printf("📨 OnText received from user %d, chat %d, text: %s", userID, c.Chat().ID, c.Text())
-		log.Printf("📨 Support mode check: isAdmin=%v, inSupportMode=%v", h.isAdmin(userID), IsUserInSupportMode(userID))
 
 		// === SUPPORT GROUP BRIDGE (Admin replies) ===
 		// Проверяем если это сообщение из группы поддержки
@@ -261,83 +204,22 @@ func (h *Handler) RegisterAdmin(b *tele.Bot) {
 			return h.handleSupportGroupMessage(c)
 		}
 
-		// === USER PROMO CODE MODE ===
-		if !h.isAdmin(userID) && IsUserInPromoMode(userID) {
-			return h.HandleUserPromoInput(c)
+		// === DIALOG STEPS (промокод, рассылка, поиск, мастера...) ===
+		if handled, err := h.dispatchState(c); handled {
+			return err
 		}
 
 		// === USER SUPPORT MODE ===
 		// Check if user is in support chat mode (ANY user, including admins for testing)
-		if IsUserInSupportMode(userID) {
+		if h.IsUserInSupportMode(userID) {
 			log.Printf("🎫 User %d in support mode, forwarding message to support group", userID)
 			return h.HandleSupportUserMessage(c)
 		}
 
-		// === ADMIN HANDLERS ===
-		if !h.isAdmin(userID) {
-			return nil
-		}
-
-		// Check if admin is replying to support ticket
-		replyTarget := GetAdminReplyTarget(userID)
-		if replyTarget > 0 {
-			return h.HandleSupportAdminReply(c, replyTarget)
-		}
-
-		// Check if admin is waiting for broadcast message
-		broadcast.mu.Lock()
-		waitingBroadcast := broadcast.waitingMsg && broadcast.adminID == userID
-		broadcast.mu.Unlock()
-
-		if waitingBroadcast {
-			return h.HandleBroadcastMessage(c)
-		}
-
-		// Check if admin is waiting for user search input
-		adminSearch.mu.Lock()
-		waitingSearch := adminSearch.waiting[userID]
-		addBalTarget := adminSearch.addBalTo[userID]
-		adminSearch.mu.Unlock()
-
-		if addBalTarget > 0 {
-			return h.HandleAdminAddBalAmount(c, addBalTarget)
-		}
-
-		if waitingSearch {
-			return h.HandleAdminFindUserInput(c)
-		}
-
-		// Check if admin is in issue flow waiting for user ID
-		issue.mu.Lock()
-		session, exists := issue.sessions[c.Sender().ID]
-		issue.mu.Unlock()
-
-		if exists && session.step == 3 {
-			return h.HandleIssueUserID(c)
-		}
-
-		// Check if admin is in promo wizard
-		promoWizard.mu.Lock()
-		promoSession, promoExists := promoWizard.sessions[c.Sender().ID]
-		promoWizard.mu.Unlock()
-
-		if promoExists {
-			return h.HandleAdminPromoWizardInput(c, promoSession)
-		}
-
-		// Check if admin is deleting promo
-		promoDelete.mu.Lock()
-		deletingPromo := promoDelete.waiting[c.Sender().ID]
-		promoDelete.mu.Unlock()
-
-		if deletingPromo {
-			return h.HandleAdminPromoDeleteInput(c)
-		}
-
 		return nil
 	})
 
-	// Handle photo messages for broadcast and support
+	// Handle photo messages: support bridge, dialog steps (receipt, broadcast), support mode
 	b.Handle(tele.OnPhoto, func(c tele.Context) error {
 		userID := c.Sender().ID
 
@@ -346,20 +228,16 @@ func (h *Handler) RegisterAdmin(b *tele.Bot) {
 			return h.handleSupportGroupMessage(c)
 		}
 
+		// Чек СБП, сообщение для рассылки
+		if handled, err := h.dispatchState(c); handled {
+			return err
+		}
+
 		// User support mode - forward photos too (ANY user, including admins)
-		if IsUserInSupportMode(userID) {
+		if h.IsUserInSupportMode(userID) {
 			log.Printf("🎫 User %d in support mode, forwarding photo", userID)
 			return h.HandleSupportUserMessage(c)
 		}
-
-		// Admin broadcast
-		broadcast.mu.Lock()
-		waiting := broadcast.waitingMsg && broadcast.adminID == userID
-		broadcast.mu.Unlock()
-
-		if waiting && h.isAdmin(userID) {
-			return h.HandleBroadcastMessage(c)
-		}
 		return nil
 	})
 
@@ -370,14 +248,16 @@ func (h *Handler) RegisterAdmin(b *tele.Bot) {
 	b.Handle("/init_dashboard", h.HandleInitDashboard)
 
 	// Initialize support tracker
-	InitSupportTracker(b, h.supportGroupID)
+	InitSupportTracker(b, h.supportGroupID, h.svc)
 }
 
 // ================= ADMIN PANEL =================
 
-// HandleAdmin показывает админ-панель (GUI Dashboard)
+// HandleAdmin показывает админ-панель (GUI Dashboard). Кнопки «Отмена» ведут сюда, поэтому
+// незаконченный сценарий сбрасывается
 func (h *Handler) HandleAdmin(c tele.Context) error {
 	ctx := context.Background()
+	h.resetState(c.Sender().ID)
 
 	// Получаем статистику для дашборда
 	stats, err := h.svc.GetAdminStats(ctx)
@@ -386,11 +266,23 @@ func (h *Handler) HandleAdmin(c tele.Context) error {
 		stats = &models.AdminStats{} // fallback to zeros
 	}
 
-	// Проверяем активную распродажу
+	// Проверяем активные и запланированные распродажи
 	var saleStatus string
-	if flashSale.IsActive() {
-		saleStatus = fmt.Sprintf("\n🔥 *Распродажа:* -%d%% (до %s)",
-			flashSale.GetDiscount(), flashSale.GetEndTime().Format("15:04"))
+	campaigns, err := h.svc.GetCurrentCampaigns(ctx)
+	if err != nil {
+		log.Printf("Error getting campaigns: %v", err)
+	}
+	var scheduled int
+	for i := range campaigns {
+		if !campaigns[i].IsActive(time.Now()) {
+			scheduled++
+		} else if saleStatus == "" {
+			saleStatus = fmt.Sprintf("\n🔥 *Распродажа:* -%d%% (до %s)",
+				campaigns[i].DiscountPercent, campaigns[i].EndsAt.Format("15:04"))
+		}
+	}
+	if scheduled > 0 {
+		saleStatus += fmt.Sprintf("\n📅 *Запланировано акций:* %d", scheduled)
 	}
 
 	// Форматируем dashboard
@@ -398,13 +290,13 @@ func (h *Handler) HandleAdmin(c tele.Context) error {
 
 📅 *Сводка за сегодня:*
 ➕ Новых пользователей: *%d*
-💰 Прибыль за сутки: *%.0f ₽*
+💰 Прибыль за сутки: *%s*
 💎 Активных подписок: *%d*
 👥 Всего пользователей: *%d*%s
 
 _Выберите действие в меню ниже:_`,
 		stats.NewUsersToday,
-		stats.RevenueToday,
+		models.RUB.Format(stats.RevenueToday),
 		stats.ActiveSubscriptions,
 		stats.TotalUsers,
 		saleStatus)
@@ -427,6 +319,10 @@ _Выберите действие в меню ниже:_`,
 			menu.Data("🔑 Выдать ключ", "admin_issue"),
 			menu.Data("📜 Команды", "admin_help"),
 		),
+		menu.Row(
+			menu.Data("🌐 Серверы", "admin_nodes"),
+			menu.Data("💲 Тарифы", "admin_plans"),
+		),
 		menu.Row(menu.Data("⬅️ Выход", "back_main")),
 	)
 
@@ -451,16 +347,26 @@ func (h *Handler) HandleAdminStats(c tele.Context) error {
 🔑 *Активные подписки:* %d
 
 💰 *Доход:*
-• Сегодня: %.2f ₽
-• За месяц: %.2f ₽
-• Всего: %.2f ₽`,
+• Сегодня: %s
+• За месяц: %s
+• Всего: %s`,
 		stats.TotalUsers,
 		stats.ActiveSubscriptions,
-		stats.RevenueToday,
-		stats.RevenueMonth,
-		stats.RevenueAllTime,
+		models.RUB.Format(stats.RevenueToday),
+		models.RUB.Format(stats.RevenueMonth),
+		models.RUB.Format(stats.RevenueAllTime),
 	)
 
+	if stats.TrialsClaimed > 0 {
+		text += fmt.Sprintf(`
+
+🎁 *Пробный период:*
+• Выдано: %d (действуют: %d)
+• Оплатили после: %d (%.1f%%)`,
+			stats.TrialsClaimed, stats.TrialsActive,
+			stats.TrialsConverted, float64(stats.TrialsConverted)*100/float64(stats.TrialsClaimed))
+	}
+
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
 		menu.Row(menu.Data("🔄 Обновить", "admin_stats")),
@@ -499,10 +405,7 @@ func (h *Handler) HandleAdminUsers(c tele.Context) error {
 
 // HandleAdminFindUserStart начинает интерактивный поиск пользователя
 func (h *Handler) HandleAdminFindUserStart(c tele.Context) error {
-	adminSearch.mu.Lock()
-	adminSearch.waiting[c.Sender().ID] = true
-	delete(adminSearch.addBalTo, c.Sender().ID)
-	adminSearch.mu.Unlock()
+	h.setState(c.Sender().ID, stateFindUser, nil)
 
 	text := `🔎 *Поиск пользователя*
 
@@ -522,10 +425,8 @@ _(Или перешлите любое сообщение от него)_`
 }
 
 // HandleAdminFindUserInput обрабатывает ввод ID пользователя
-func (h *Handler) HandleAdminFindUserInput(c tele.Context) error {
-	adminSearch.mu.Lock()
-	delete(adminSearch.waiting, c.Sender().ID)
-	adminSearch.mu.Unlock()
+func (h *Handler) HandleAdminFindUserInput(c tele.Context, _ *fsm.Session) error {
+	h.resetState(c.Sender().ID)
 
 	query := strings.TrimSpace(c.Text())
 	query = strings.TrimPrefix(query, "@")
@@ -556,7 +457,7 @@ func (h *Handler) showUserProfile(c tele.Context, profile *models.UserProfile) e
 	if profile.User.Username != "" {
 		sb.WriteString(fmt.Sprintf("📝 Username: @%s\n", profile.User.Username))
 	}
-	sb.WriteString(fmt.Sprintf("💰 Баланс: *%.0f ₽*\n", profile.User.Balance))
+	sb.WriteString(fmt.Sprintf("💰 Баланс: *%s*\n", models.RUB.Format(profile.User.Balance)))
 	sb.WriteString(fmt.Sprintf("📅 Регистрация: %s\n", profile.User.CreatedAt.Format("02.01.2006")))
 
 	// Subscriptions
@@ -583,23 +484,33 @@ func (h *Handler) showUserProfile(c tele.Context, profile *models.UserProfile) e
 			count = 5
 		}
 		for _, tx := range profile.Transactions[:count] {
-			sb.WriteString(fmt.Sprintf("• %.0f ₽ (%s) — %s\n",
-				tx.Amount, tx.Type, tx.CreatedAt.Format("02.01")))
+			sb.WriteString(fmt.Sprintf("• %s (%s) — %s\n",
+				models.RUB.Format(tx.Amount), tx.Type, tx.CreatedAt.Format("02.01")))
 		}
 	}
 
 	menu := &tele.ReplyMarkup{}
 	userIDStr := strconv.FormatInt(profile.User.TelegramID, 10)
-	menu.Inline(
+	rows := []tele.Row{
 		menu.Row(
 			menu.Data("💳 Пополнить баланс", "admin_addbal_user", userIDStr),
 			menu.Data("🎁 Подарить ключ", "admin_gift_user", userIDStr),
 		),
-		menu.Row(
-			menu.Data("🔎 Найти другого", "admin_find_user"),
-			menu.Data("⬅️ Назад", "admin_back"),
-		),
-	)
+	}
+	for _, sub := range profile.Subscriptions {
+		if sub.IsActive && sub.ExpiresAt.After(time.Now()) {
+			subIDStr := strconv.FormatInt(sub.ID, 10)
+			rows = append(rows,
+				menu.Row(menu.Data(fmt.Sprintf("🔄 Перевыпустить ключ №%d", sub.ID), "admin_key_rotate", subIDStr)),
+				menu.Row(menu.Data(fmt.Sprintf("💸 Отменить с возвратом №%d", sub.ID), "admin_refund", subIDStr)),
+			)
+		}
+	}
+	rows = append(rows, menu.Row(
+		menu.Data("🔎 Найти другого", "admin_find_user"),
+		menu.Data("⬅️ Назад", "admin_back"),
+	))
+	menu.Inline(rows...)
 
 	return c.Send(sb.String(), menu, tele.ModeMarkdown)
 }
@@ -617,10 +528,7 @@ func (h *Handler) HandleAdminAddBalStart(c tele.Context) error {
 	}
 
 	// Иначе спрашиваем ID
-	adminSearch.mu.Lock()
-	adminSearch.waiting[c.Sender().ID] = true
-	delete(adminSearch.addBalTo, c.Sender().ID)
-	adminSearch.mu.Unlock()
+	h.setState(c.Sender().ID, stateFindUser, nil)
 
 	text := `💳 *Пополнение баланса*
 
@@ -639,17 +547,10 @@ func (h *Handler) HandleAdminAddBalStart(c tele.Context) error {
 
 // promptAddBalAmount спрашивает сумму пополнения
 func (h *Handler) promptAddBalAmount(c tele.Context, userID int64) error {
-	adminSearch.mu.Lock()
-	delete(adminSearch.waiting, c.Sender().ID)
-	adminSearch.addBalTo[c.Sender().ID] = userID
-	adminSearch.mu.Unlock()
-
 	// Проверяем, существует ли пользователь
 	user, err := h.svc.GetUserByTelegramID(context.Background(), userID)
 	if err != nil {
-		adminSearch.mu.Lock()
-		delete(adminSearch.addBalTo, c.Sender().ID)
-		adminSearch.mu.Unlock()
+		h.resetState(c.Sender().ID)
 
 		menu := &tele.ReplyMarkup{}
 		menu.Inline(
@@ -658,20 +559,21 @@ func (h *Handler) promptAddBalAmount(c tele.Context, userID int64) error {
 		)
 		return c.Send(fmt.Sprintf("❌ Пользователь с ID `%d` не найден.", userID), menu, tele.ModeMarkdown)
 	}
+	h.setState(c.Sender().ID, stateAddBalance, targetUserData{UserID: userID})
 
 	text := fmt.Sprintf(`💳 *Пополнение баланса*
 
 👤 Пользователь: `+"`%d`"+`
-💰 Текущий баланс: *%.0f ₽*
+💰 Текущий баланс: *%s*
 
-👇 Введите сумму пополнения (в рублях):`, user.TelegramID, user.Balance)
+👇 Введите сумму пополнения (в рублях):`, user.TelegramID, models.RUB.Format(user.Balance))
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
 		menu.Row(
-			menu.Data("100 ₽", "admin_addbal_amount", fmt.Sprintf("%d:100", userID)),
-			menu.Data("450 ₽", "admin_addbal_amount", fmt.Sprintf("%d:450", userID)),
-			menu.Data("1000 ₽", "admin_addbal_amount", fmt.Sprintf("%d:1000", userID)),
+			menu.Data(models.RUB.Format(100), "admin_addbal_amount", fmt.Sprintf("%d:100", userID)),
+			menu.Data(models.RUB.Format(450), "admin_addbal_amount", fmt.Sprintf("%d:450", userID)),
+			menu.Data(models.RUB.Format(1000), "admin_addbal_amount", fmt.Sprintf("%d:1000", userID)),
 		),
 		menu.Row(menu.Data("❌ Отмена", "admin_back")),
 	)
@@ -683,10 +585,14 @@ func (h *Handler) promptAddBalAmount(c tele.Context, userID int64) error {
 }
 
 // HandleAdminAddBalAmount обрабатывает ввод суммы
-func (h *Handler) HandleAdminAddBalAmount(c tele.Context, targetUserID int64) error {
-	adminSearch.mu.Lock()
-	delete(adminSearch.addBalTo, c.Sender().ID)
-	adminSearch.mu.Unlock()
+func (h *Handler) HandleAdminAddBalAmount(c tele.Context, s *fsm.Session) error {
+	h.resetState(c.Sender().ID)
+
+	var target targetUserData
+	if err := s.Decode(&target); err != nil || target.UserID == 0 {
+		return c.Send("❌ Сессия истекла. Начните заново: /admin")
+	}
+	targetUserID := target.UserID
 
 	amount, err := strconv.ParseFloat(strings.TrimSpace(c.Text()), 64)
 	if err != nil || amount <= 0 {
@@ -707,7 +613,7 @@ func (h *Handler) addBalanceToUser(c tele.Context, telegramID int64, amount floa
 		return c.Send(fmt.Sprintf("❌ Ошибка: %v", err))
 	}
 
-	text := fmt.Sprintf("✅ *Баланс пополнен!*\n\n👤 Пользователь: `%d`\n💰 Сумма: *+%.0f ₽*", telegramID, amount)
+	text := fmt.Sprintf("✅ *Баланс пополнен!*\n\n👤 Пользователь: `%d`\n💰 Сумма: *+%s*", telegramID, models.RUB.Format(amount))
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
@@ -735,19 +641,7 @@ func (h *Handler) HandleFlashQuick(c tele.Context) error {
 		return c.Send("❌ Неверные параметры")
 	}
 
-	// Устанавливаем скидку
-	flashSale.Set(percent, hours)
-	endTime := flashSale.GetEndTime()
-
-	log.Printf("[FLASH SALE] Admin %d started quick %d%% sale for %d hours", c.Sender().ID, percent, hours)
-
-	c.Edit(fmt.Sprintf("✅ *Флеш-распродажа запущена!*\n\n🔥 Скидка: *%d%%*\n⏰ До: *%s*\n\n📤 Запускаю рассылку...",
-		percent, endTime.Format("02.01 15:04")), tele.ModeMarkdown)
-
-	// Запускаем рассылку в горутине
-	go h.broadcastFlashSale(c.Bot(), c.Sender().ID, percent, hours, endTime)
-
-	return nil
+	return h.startCampaign(c, flashSaleData{Percent: percent, Hours: hours}.campaign(c.Sender().ID))
 }
 
 // HandleAdminAddBalUser пополняет баланс конкретного пользователя (из профиля)
@@ -773,6 +667,7 @@ func (h *Handler) HandleAdminAddBalAmountCallback(c tele.Context) error {
 		return c.Send("❌ Неверные параметры")
 	}
 
+	h.resetState(c.Sender().ID)
 	return h.addBalanceToUser(c, userID, amount)
 }
 
@@ -909,7 +804,7 @@ func (h *Handler) HandleFindUser(c tele.Context) error {
 	sb.WriteString(fmt.Sprintf("👤 *Пользователь #%d*\n\n", profile.User.ID))
 	sb.WriteString(fmt.Sprintf("🆔 Telegram ID: `%d`\n", profile.User.TelegramID))
 	sb.WriteString(fmt.Sprintf("📝 Username: @%s\n", profile.User.Username))
-	sb.WriteString(fmt.Sprintf("💰 Баланс: %.2f ₽\n", profile.User.Balance))
+	sb.WriteString(fmt.Sprintf("💰 Баланс: %s\n", models.RUB.Format(profile.User.Balance)))
 	sb.WriteString(fmt.Sprintf("📅 Регистрация: %s\n\n", profile.User.CreatedAt.Format("02.01.2006")))
 
 	// Subscriptions
@@ -928,8 +823,8 @@ func (h *Handler) HandleFindUser(c tele.Context) error {
 	if len(profile.Transactions) > 0 {
 		sb.WriteString("\n💳 *Последние транзакции:*\n")
 		for _, tx := range profile.Transactions[:min(5, len(profile.Transactions))] {
-			sb.WriteString(fmt.Sprintf("• %.2f ₽ (%s) — %s\n",
-				tx.Amount, tx.Type, tx.CreatedAt.Format("02.01.06")))
+			sb.WriteString(fmt.Sprintf("• %s (%s) — %s\n",
+				models.RUB.Format(tx.Amount), tx.Type, tx.CreatedAt.Format("02.01.06")))
 		}
 	}
 
@@ -957,7 +852,7 @@ func (h *Handler) HandleAddBalance(c tele.Context) error {
 		return c.Send(fmt.Sprintf("❌ Ошибка: %v", err))
 	}
 
-	return c.Send(fmt.Sprintf("✅ Баланс пользователя %d пополнен на %.2f ₽", telegramID, amount))
+	return c.Send(fmt.Sprintf("✅ Баланс пользователя %d пополнен на %s", telegramID, models.RUB.Format(amount)))
 }
 
 // HandleGiftSub дарит подписку пользователю
@@ -1019,21 +914,17 @@ func (h *Handler) HandleGiftSub(c tele.Context) error {
 
 // HandleAdminBroadcast начинает рассылку
 func (h *Handler) HandleAdminBroadcast(c tele.Context) error {
-	broadcast.mu.Lock()
-	if broadcast.isActive {
-		broadcast.mu.Unlock()
+	if broadcastActive.Load() {
 		return c.Send("❌ Рассылка уже выполняется. Дождитесь завершения.")
 	}
-	broadcast.waitingMsg = true
-	broadcast.waitingConfirm = false
-	broadcast.adminID = c.Sender().ID
-	broadcast.message = nil
-	broadcast.mu.Unlock()
+	h.setState(c.Sender().ID, stateBroadcastMessage, nil)
 
 	text := `📢 *Рассылка*
 
 Отправьте сообщение (текст, фото или перешлите пост из канала), которое будет разослано всем пользователям.
 
+После этого можно добавить варианты на других языках — их получат пользователи с этим языком интерфейса.
+
 ⚠️ Для отмены нажмите кнопку ниже.`
 
 	menu := &tele.ReplyMarkup{}
@@ -1049,12 +940,7 @@ func (h *Handler) HandleAdminBroadcast(c tele.Context) error {
 
 // HandleCancelBroadcast отменяет рассылку
 func (h *Handler) HandleCancelBroadcast(c tele.Context) error {
-	broadcast.mu.Lock()
-	broadcast.waitingMsg = false
-	broadcast.waitingConfirm = false
-	broadcast.adminID = 0
-	broadcast.message = nil
-	broadcast.mu.Unlock()
+	h.resetState(c.Sender().ID)
 
 	if c.Callback() != nil {
 		return h.HandleAdmin(c)
@@ -1062,115 +948,182 @@ func (h *Handler) HandleCancelBroadcast(c tele.Context) error {
 	return c.Send("❌ Рассылка отменена.")
 }
 
-// HandleBroadcastMessage обрабатывает сообщение для рассылки (запрос подтверждения)
-func (h *Handler) HandleBroadcastMessage(c tele.Context) error {
-	broadcast.mu.Lock()
-	if !broadcast.waitingMsg || broadcast.adminID != c.Sender().ID {
-		broadcast.mu.Unlock()
-		return nil
+// newBroadcastDraft сохраняет содержимое сообщения для рассылки
+func newBroadcastDraft(msg *tele.Message) broadcastDraft {
+	switch {
+	case msg.Photo != nil:
+		return broadcastDraft{Kind: "photo", FileID: msg.Photo.FileID, Text: msg.Caption}
+	case msg.Document != nil:
+		return broadcastDraft{Kind: "document", FileID: msg.Document.FileID, Text: msg.Caption}
+	case msg.Video != nil:
+		return broadcastDraft{Kind: "video", FileID: msg.Video.FileID, Text: msg.Caption}
+	default:
+		return broadcastDraft{Kind: "text", Text: msg.Text}
 	}
-	broadcast.waitingMsg = false
-	broadcast.waitingConfirm = true
-	broadcast.message = c.Message()
-	broadcast.mu.Unlock()
+}
 
-	// Get user count
-	userIDs, err := h.svc.GetAllUserTelegramIDs(context.Background())
-	if err != nil {
-		broadcast.mu.Lock()
-		broadcast.waitingConfirm = false
-		broadcast.mu.Unlock()
-		return c.Send(fmt.Sprintf("❌ Ошибка получения списка пользователей: %v", err))
+// content собирает сообщение для отправки из сохранённого черновика
+func (d broadcastDraft) content() interface{} {
+	file := tele.File{FileID: d.FileID}
+	switch d.Kind {
+	case "photo":
+		return &tele.Photo{File: file, Caption: d.Text}
+	case "document":
+		return &tele.Document{File: file, Caption: d.Text}
+	case "video":
+		return &tele.Video{File: file, Caption: d.Text}
+	default:
+		return d.Text
 	}
+}
 
-	totalUsers := len(userIDs)
+// forLang сообщение для получателя с языком интерфейса lang
+func (d broadcastDraft) forLang(lang string) broadcastDraft {
+	if variant, ok := d.Variants[lang]; ok {
+		return variant
+	}
+	return d
+}
 
-	text := fmt.Sprintf(`📢 *Подтверждение рассылки*
+// HandleBroadcastMessage обрабатывает сообщение для рассылки (запрос подтверждения)
+func (h *Handler) HandleBroadcastMessage(c tele.Context, _ *fsm.Session) error {
+	return h.showBroadcastConfirm(c, newBroadcastDraft(c.Message()))
+}
 
-Сообщение будет отправлено *%d* пользователям.
+// HandleBroadcastVariant запрашивает вариант рассылки на другом языке
+func (h *Handler) HandleBroadcastVariant(c tele.Context) error {
+	var draft broadcastDraft
+	if !h.lookupState(c.Sender().ID, stateBroadcastConfirm, &draft) {
+		return c.Send("❌ Нет сообщения для рассылки.")
+	}
+	lang := c.Callback().Data
+	if !i18n.IsSupported(lang) || lang == i18n.Default {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Неизвестный язык"})
+	}
 
-Отправить?`, totalUsers)
+	draft.VariantLang = lang
+	h.setState(c.Sender().ID, stateBroadcastVariant, draft)
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(
-			menu.Data("✅ Да, отправить", "admin_confirm_broadcast"),
-			menu.Data("❌ Отмена", "admin_cancel_broadcast"),
-		),
+		menu.Row(menu.Data("❌ Отменить", "admin_cancel_broadcast")),
 	)
+	return c.Edit(fmt.Sprintf("📢 *Вариант рассылки: %s*\n\nОтправьте сообщение для пользователей с этим языком интерфейса.", i18n.Name(lang)),
+		menu, tele.ModeMarkdown)
+}
 
-	return c.Send(text, menu, tele.ModeMarkdown)
+// HandleBroadcastVariantMessage сохраняет вариант рассылки и возвращает к подтверждению
+func (h *Handler) HandleBroadcastVariantMessage(c tele.Context, s *fsm.Session) error {
+	var draft broadcastDraft
+	if err := s.Decode(&draft); err != nil {
+		return err
+	}
+	if draft.Variants == nil {
+		draft.Variants = make(map[string]broadcastDraft)
+	}
+	draft.Variants[draft.VariantLang] = newBroadcastDraft(c.Message())
+	draft.VariantLang = ""
+	return h.showBroadcastConfirm(c, draft)
+}
+
+// showBroadcastConfirm показывает, сколько пользователей и на каком языке получат рассылку, и ждёт подтверждения
+func (h *Handler) showBroadcastConfirm(c tele.Context, draft broadcastDraft) error {
+	recipients, err := h.svc.GetBroadcastRecipients(context.Background())
+	if err != nil {
+		h.resetState(c.Sender().ID)
+		return c.Send(fmt.Sprintf("❌ Ошибка получения списка пользователей: %v", err))
+	}
+
+	h.setState(c.Sender().ID, stateBroadcastConfirm, draft)
+
+	byLang := make(map[string]int)
+	for i := range recipients {
+		byLang[h.svc.UserLanguage(&recipients[i])]++
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📢 *Подтверждение рассылки*\n\n")
+	sb.WriteString(fmt.Sprintf("Сообщение будет отправлено *%d* пользователям:\n", len(recipients)))
+	for _, lang := range i18n.Supported() {
+		version := "основное сообщение"
+		if _, ok := draft.Variants[lang]; ok {
+			version = "свой вариант"
+		}
+		sb.WriteString(fmt.Sprintf("• %s: %d — %s\n", i18n.Name(lang), byLang[lang], version))
+	}
+	sb.WriteString("\nОтправить?")
+
+	menu := &tele.ReplyMarkup{}
+	rows := []tele.Row{menu.Row(
+		menu.Data("✅ Да, отправить", "admin_confirm_broadcast"),
+		menu.Data("❌ Отмена", "admin_cancel_broadcast"),
+	)}
+	for _, lang := range i18n.Supported() {
+		if lang == i18n.Default {
+			continue
+		}
+		action := "➕ Вариант"
+		if _, ok := draft.Variants[lang]; ok {
+			action = "✏️ Заменить вариант"
+		}
+		rows = append(rows, menu.Row(menu.Data(action+": "+i18n.Name(lang), "admin_broadcast_variant", lang)))
+	}
+	menu.Inline(rows...)
+
+	return c.Send(sb.String(), menu, tele.ModeMarkdown)
 }
 
 // HandleConfirmBroadcast подтверждает и запускает рассылку
 func (h *Handler) HandleConfirmBroadcast(c tele.Context) error {
-	broadcast.mu.Lock()
-	if !broadcast.waitingConfirm || broadcast.adminID != c.Sender().ID || broadcast.message == nil {
-		broadcast.mu.Unlock()
+	var draft broadcastDraft
+	if !h.lookupState(c.Sender().ID, stateBroadcastConfirm, &draft) {
 		return c.Send("❌ Нет сообщения для рассылки.")
 	}
-	broadcast.waitingConfirm = false
-	broadcast.isActive = true
-	msg := broadcast.message
-	broadcast.mu.Unlock()
+	if !broadcastActive.CompareAndSwap(false, true) {
+		return c.Send("❌ Рассылка уже выполняется. Дождитесь завершения.")
+	}
+	h.resetState(c.Sender().ID)
 
-	// Get all user IDs
-	userIDs, err := h.svc.GetAllUserTelegramIDs(context.Background())
+	// Получатели с языком интерфейса — по нему выбирается вариант сообщения
+	recipients, err := h.svc.GetBroadcastRecipients(context.Background())
 	if err != nil {
-		broadcast.mu.Lock()
-		broadcast.isActive = false
-		broadcast.mu.Unlock()
+		broadcastActive.Store(false)
 		return c.Send(fmt.Sprintf("❌ Ошибка получения списка пользователей: %v", err))
 	}
 
-	totalUsers := len(userIDs)
+	totalUsers := len(recipients)
 
-	log.Printf("[BROADCAST] Admin %d started broadcast to %d users", c.Sender().ID, totalUsers)
+	log.Printf("[BROADCAST] Admin %d started broadcast to %d users (%d language variants)", c.Sender().ID, totalUsers, len(draft.Variants))
 
 	c.Edit(fmt.Sprintf("📤 *Рассылка запущена!*\n\nОтправляю сообщение %d пользователям...", totalUsers), tele.ModeMarkdown)
 
 	// Run broadcast in goroutine
 	go func() {
+		defer broadcastActive.Store(false)
+
 		bot := c.Bot()
 		adminID := c.Sender().ID
+		contents := make(map[string]interface{})
 
 		var sent, failed int
 		ticker := time.NewTicker(50 * time.Millisecond) // 20 messages per second
 		defer ticker.Stop()
 
-		for _, userID := range userIDs {
+		for i := range recipients {
 			<-ticker.C
 
-			var err error
-			if msg.Photo != nil {
-				// Send photo with caption
-				photo := &tele.Photo{
-					File:    msg.Photo.File,
-					Caption: msg.Caption,
-				}
-				_, err = bot.Send(&tele.User{ID: userID}, photo, tele.ModeMarkdown)
-			} else if msg.Document != nil {
-				// Send document
-				doc := &tele.Document{
-					File:    msg.Document.File,
-					Caption: msg.Caption,
-				}
-				_, err = bot.Send(&tele.User{ID: userID}, doc, tele.ModeMarkdown)
-			} else if msg.Video != nil {
-				// Send video
-				video := &tele.Video{
-					File:    msg.Video.File,
-					Caption: msg.Caption,
-				}
-				_, err = bot.Send(&tele.User{ID: userID}, video, tele.ModeMarkdown)
-			} else {
-				// Send text
-				_, err = bot.Send(&tele.User{ID: userID}, msg.Text, tele.ModeMarkdown)
+			user := &recipients[i]
+			lang := h.svc.UserLanguage(user)
+			content, ok := contents[lang]
+			if !ok {
+				content = draft.forLang(lang).content()
+				contents[lang] = content
 			}
 
+			_, err := bot.Send(&tele.User{ID: user.TelegramID}, content, tele.ModeMarkdown)
 			if err != nil {
 				failed++
-				log.Printf("[BROADCAST] Failed for user %d: %v", userID, err)
+				log.Printf("[BROADCAST] Failed for user %d: %v", user.TelegramID, err)
 			} else {
 				sent++
 			}
@@ -1183,11 +1136,6 @@ func (h *Handler) HandleConfirmBroadcast(c tele.Context) error {
 		}
 
 		// Final report
-		broadcast.mu.Lock()
-		broadcast.isActive = false
-		broadcast.message = nil
-		broadcast.mu.Unlock()
-
 		log.Printf("[BROADCAST] Finished. Sent: %d, Failed: %d", sent, failed)
 
 		bot.Send(&tele.User{ID: adminID},
@@ -1211,9 +1159,7 @@ func min(a, b int) int {
 // HandleIssueStart начинает процесс выдачи ключа
 func (h *Handler) HandleIssueStart(c tele.Context) error {
 	// Clear any existing session
-	issue.mu.Lock()
-	issue.sessions[c.Sender().ID] = &issueSession{step: 1}
-	issue.mu.Unlock()
+	h.setState(c.Sender().ID, stateIssue, issueData{})
 
 	// Get products
 	products, err := h.svc.GetAllProducts(context.Background())
@@ -1248,15 +1194,10 @@ func (h *Handler) HandleIssueProduct(c tele.Context) error {
 		return c.Send("❌ Ошибка")
 	}
 
-	issue.mu.Lock()
-	session, exists := issue.sessions[c.Sender().ID]
-	if !exists {
-		issue.mu.Unlock()
+	if !h.lookupState(c.Sender().ID, stateIssue, nil) {
 		return h.HandleIssueStart(c)
 	}
-	session.productID = productID
-	session.step = 2
-	issue.mu.Unlock()
+	h.setState(c.Sender().ID, stateIssue, issueData{ProductID: productID})
 
 	product, err := h.svc.GetProductByID(context.Background(), productID)
 	if err != nil {
@@ -1289,17 +1230,17 @@ func (h *Handler) HandleIssueDays(c tele.Context) error {
 		return c.Send("❌ Ошибка")
 	}
 
-	issue.mu.Lock()
-	session, exists := issue.sessions[c.Sender().ID]
-	if !exists {
-		issue.mu.Unlock()
+	var session issueData
+	if !h.lookupState(c.Sender().ID, stateIssue, &session) || session.ProductID == 0 {
 		return h.HandleIssueStart(c)
 	}
-	session.days = days
-	session.step = 3
-	issue.mu.Unlock()
+	session.Days = days
+	h.setState(c.Sender().ID, stateIssueUser, session)
 
-	product, _ := h.svc.GetProductByID(context.Background(), session.productID)
+	product, err := h.svc.GetProductByID(context.Background(), session.ProductID)
+	if err != nil {
+		return c.Send("❌ Продукт не найден")
+	}
 
 	text := fmt.Sprintf(`🔑 *Выдача ключа*
 
@@ -1319,17 +1260,14 @@ func (h *Handler) HandleIssueDays(c tele.Context) error {
 }
 
 // HandleIssueUserID обрабатывает ввод user ID
-func (h *Handler) HandleIssueUserID(c tele.Context) error {
-	issue.mu.Lock()
-	session, exists := issue.sessions[c.Sender().ID]
-	if !exists || session.step != 3 {
-		issue.mu.Unlock()
-		return nil
+func (h *Handler) HandleIssueUserID(c tele.Context, s *fsm.Session) error {
+	var session issueData
+	if err := s.Decode(&session); err != nil {
+		return err
 	}
-	productID := session.productID
-	days := session.days
-	delete(issue.sessions, c.Sender().ID)
-	issue.mu.Unlock()
+	productID := session.ProductID
+	days := session.Days
+	h.resetState(c.Sender().ID)
 
 	telegramID, err := strconv.ParseInt(strings.TrimSpace(c.Text()), 10, 64)
 	if err != nil {
@@ -1371,16 +1309,13 @@ func (h *Handler) HandleIssueUserID(c tele.Context) error {
 
 // HandleIssueNoUser создаёт ключ без привязки к пользователю
 func (h *Handler) HandleIssueNoUser(c tele.Context) error {
-	issue.mu.Lock()
-	session, exists := issue.sessions[c.Sender().ID]
-	if !exists || session.step != 3 {
-		issue.mu.Unlock()
+	var session issueData
+	if !h.lookupState(c.Sender().ID, stateIssueUser, &session) {
 		return h.HandleIssueStart(c)
 	}
-	productID := session.productID
-	days := session.days
-	delete(issue.sessions, c.Sender().ID)
-	issue.mu.Unlock()
+	productID := session.ProductID
+	days := session.Days
+	h.resetState(c.Sender().ID)
 
 	// Create key for admin (system key)
 	sub, err := h.svc.GiftSubscription(context.Background(), c.Sender().ID, productID, days)
@@ -1413,10 +1348,6 @@ func (h *Handler) HandleIssueNoUser(c tele.Context) error {
 
 // HandleIssueCancel отменяет выдачу ключа
 func (h *Handler) HandleIssueCancel(c tele.Context) error {
-	issue.mu.Lock()
-	delete(issue.sessions, c.Sender().ID)
-	issue.mu.Unlock()
-
 	return h.HandleAdmin(c)
 }
 
@@ -1432,8 +1363,8 @@ func (h *Handler) NotifyAdminSale(bot *tele.Bot, username string, userID int64,
 
 👤 Пользователь: @%s (ID: `+"`%d`"+`)
 📦 Тариф: %s %s (%d мес.)
-💵 Сумма: %.0f ₽`,
-		username, userID, productFlag, productName, months, amount)
+💵 Сумма: %s`,
+		username, userID, productFlag, productName, months, models.RUB.Format(amount))
 
 	for _, adminID := range h.adminIDs {
 		_, err := bot.Send(&tele.User{ID: adminID}, text, tele.ModeMarkdown)
@@ -1477,11 +1408,20 @@ func (h *Handler) HandleAdminHelp(c tele.Context) error {
 *👥 Пользователи:*
 /find <ID> — найти пользователя
 /addbal <ID> <сумма> — пополнить баланс
+/receipts — чеки СБП на проверке
+/refundstars <charge\_id> — вернуть оплату звёздами
+/reconcile — сверка балансов с главной книгой
 
 *🔑 Ключи:*
 /issue — интерактивная выдача
 /gift <ID> <product> <дней> — быстрая выдача
 
+*🌐 Серверы и тарифы:*
+/nodes — ноды и их загрузка
+/plans — тарифные планы продуктов
+/rates — курсы валют
+/setrate <код> <курс> — задать курс валюты
+
 *📢 Маркетинг:*
 /broadcast — начать рассылку
 /flashsale — запустить акцию
@@ -1530,7 +1470,7 @@ func (h *Handler) HandleSupportUserMessage(c tele.Context) error {
 	supportGroup := &tele.Chat{ID: h.supportGroupID}
 
 	// Формируем заголовок с #user_ тегом (КРИТИЧНО для ответа!)
-	header := fmt.Sprintf("🎫 #user_%d\n👤 %s | 💰 %.0f ₽\n━━━━━━━━━━━━━━━━━━━━\n", userID, usernameStr, balance)
+	header := fmt.Sprintf("🎫 #user_%d\n👤 %s | 💰 %s\n━━━━━━━━━━━━━━━━━━━━\n", userID, usernameStr, models.RUB.Format(balance))
 
 	// Кнопка "Закрыть тикет" для админа (с userID в payload)
 	adminMenu := &tele.ReplyMarkup{}
@@ -1539,6 +1479,10 @@ func (h *Handler) HandleSupportUserMessage(c tele.Context) error {
 	)
 
 	// 1. Отправляем сообщение в группу с тегом в тексте и кнопкой
+	msg := &models.TicketMessage{SenderID: userID, Kind: "text", Text: c.Message().Text}
+	var sent *tele.Message
+	var err error
+
 	if c.Message().Photo != nil {
 		// Фото: добавляем тег в caption
 		photo := c.Message().Photo
@@ -1549,7 +1493,8 @@ func (h *Handler) HandleSupportUserMessage(c tele.Context) error {
 			caption += "[Фото без подписи]"
 		}
 		photo.Caption = caption
-		_, err := c.Bot().Send(supportGroup, photo, adminMenu)
+		msg.Kind, msg.Text = "photo", c.Message().Caption
+		sent, err = c.Bot().Send(supportGroup, photo, adminMenu)
 		if err != nil {
 			log.Printf("Failed to send support photo: %v", err)
 			return c.Send("❌ Ошибка отправки. Попробуйте позже.")
@@ -1564,21 +1509,23 @@ func (h *Handler) HandleSupportUserMessage(c tele.Context) error {
 			caption += "[Документ]"
 		}
 		doc.Caption = caption
-		_, err := c.Bot().Send(supportGroup, doc, adminMenu)
+		msg.Kind, msg.Text = "document", c.Message().Caption
+		sent, err = c.Bot().Send(supportGroup, doc, adminMenu)
 		if err != nil {
 			log.Printf("Failed to send support document: %v", err)
 			return c.Send("❌ Ошибка отправки. Попробуйте позже.")
 		}
 	} else if c.Message().Voice != nil {
 		// Голосовое: сначала отправляем текст с тегом, потом голосовое
-		_, err := c.Bot().Send(supportGroup, header+"[Голосовое сообщение ниже]", adminMenu)
+		msg.Kind = "voice"
+		sent, err = c.Bot().Send(supportGroup, header+"[Голосовое сообщение ниже]", adminMenu)
 		if err == nil {
 			c.Bot().Send(supportGroup, c.Message().Voice)
 		}
 	} else {
 		// Текст: добавляем тег в начало
 		text := header + c.Message().Text
-		_, err := c.Bot().Send(supportGroup, text, adminMenu)
+		sent, err = c.Bot().Send(supportGroup, text, adminMenu)
 		if err != nil {
 			log.Printf("Failed to send support text: %v", err)
 			return c.Send("❌ Ошибка отправки. Попробуйте позже.")
@@ -1588,11 +1535,18 @@ func (h *Handler) HandleSupportUserMessage(c tele.Context) error {
 	log.Printf("🎫 Support ticket sent to group from user %d", userID)
 
 	// 2. НЕ сбрасываем режим — пользователь может отправить ещё сообщения (фото, уточнения)
-	// SetUserSupportMode(userID, false) — убрано для seamless mode
+	// Сохраняем сообщение в тикет: по ID сообщения в группе ответ админа найдёт тикет
+	if sent != nil {
+		msg.GroupMessageID = sent.ID
+	}
+	if user != nil {
+		if _, err := h.svc.AddUserTicketMessage(ctx, user.ID, msg); err != nil {
+			log.Printf("Failed to save ticket message from user %d: %v", userID, err)
+		}
+	}
 
-	// 3. Обновляем трекер и dashboard
+	// 3. Обновляем dashboard
 	if tracker := GetTracker(); tracker != nil {
-		tracker.AddOrUpdateTicket(userID, username, 0) // groupMsgID можно добавить если сохранять
 		go tracker.UpdateDashboard()
 	}
 
@@ -1608,7 +1562,7 @@ func (h *Handler) HandleSupportReplyStart(c tele.Context) error {
 	}
 
 	// Устанавливаем режим ответа для админа
-	SetAdminReplyTarget(c.Sender().ID, userID)
+	h.setState(c.Sender().ID, stateSupportReply, targetUserData{UserID: userID})
 
 	text := fmt.Sprintf(`✍️ *Ответ на тикет*
 
@@ -1627,9 +1581,15 @@ _(Отправьте /cancel чтобы отменить)_`, userID)
 }
 
 // HandleSupportAdminReply отправляет ответ админа пользователю
-func (h *Handler) HandleSupportAdminReply(c tele.Context, targetUserID int64) error {
+func (h *Handler) HandleSupportAdminReply(c tele.Context, s *fsm.Session) error {
+	var target targetUserData
+	if err := s.Decode(&target); err != nil {
+		return err
+	}
+	targetUserID := target.UserID
+
 	// Сбрасываем режим ответа
-	SetAdminReplyTarget(c.Sender().ID, 0)
+	h.resetState(c.Sender().ID)
 
 	// Формируем ответ для пользователя
 	replyText := fmt.Sprintf("👨‍💻 *Поддержка:*\n\n%s", c.Message().Text)
@@ -1640,6 +1600,18 @@ func (h *Handler) HandleSupportAdminReply(c tele.Context, targetUserID int64) er
 		return c.Send(fmt.Sprintf("❌ Не удалось отправить ответ: %v", err))
 	}
 
+	// Сохраняем ответ в открытый тикет пользователя
+	ticket, err := h.svc.GetOpenTicket(context.Background(), targetUserID)
+	if err != nil {
+		log.Printf("Failed to load ticket of user %d: %v", targetUserID, err)
+	}
+	if ticket != nil {
+		h.saveAdminTicketMessage(ticket.ID, c.Sender().ID, c.Message())
+		if tracker := GetTracker(); tracker != nil {
+			go tracker.UpdateDashboard()
+		}
+	}
+
 	// Подтверждение админу
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
@@ -1654,11 +1626,11 @@ func (h *Handler) HandleSupportAdminReply(c tele.Context, targetUserID int64) er
 func (h *Handler) HandleStopSupport(c tele.Context) error {
 	userID := c.Sender().ID
 
-	if !IsUserInSupportMode(userID) {
+	if !h.IsUserInSupportMode(userID) {
 		return c.Send("ℹ️ Вы не находитесь в режиме поддержки.")
 	}
 
-	SetUserSupportMode(userID, false)
+	h.SetUserSupportMode(c.Sender(), false)
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
@@ -1670,7 +1642,6 @@ func (h *Handler) HandleStopSupport(c tele.Context) error {
 
 // HandleSupportCancelReply отменяет режим ответа на тикет
 func (h *Handler) HandleSupportCancelReply(c tele.Context) error {
-	SetAdminReplyTarget(c.Sender().ID, 0)
 	return h.HandleAdmin(c)
 }
 
@@ -1699,9 +1670,7 @@ func (h *Handler) HandleAdminPromo(c tele.Context) error {
 
 // HandleAdminPromoCreate начинает создание промокода
 func (h *Handler) HandleAdminPromoCreate(c tele.Context) error {
-	promoWizard.mu.Lock()
-	promoWizard.sessions[c.Sender().ID] = &promoWizardSession{step: 1}
-	promoWizard.mu.Unlock()
+	h.setState(c.Sender().ID, statePromoCode, nil)
 
 	text := `➕ *Создание промокода*
 
@@ -1717,102 +1686,104 @@ _Например: SALE50, START2025, VIP100_`
 	return c.Edit(text, menu, tele.ModeMarkdown)
 }
 
-// HandleAdminPromoWizardInput обрабатывает ввод в визарде создания промокода
-func (h *Handler) HandleAdminPromoWizardInput(c tele.Context, session *promoWizardSession) error {
+// HandleAdminPromoCodeInput шаг 1 мастера: название кода
+func (h *Handler) HandleAdminPromoCodeInput(c tele.Context, _ *fsm.Session) error {
 	input := strings.TrimSpace(c.Text())
+	if len(input) < 3 || len(input) > 20 {
+		return c.Send("❌ Код должен быть от 3 до 20 символов. Попробуйте снова:")
+	}
+	// Проверяем, не существует ли уже
+	existing, _ := h.svc.GetPromoByCode(context.Background(), input)
+	if existing != nil {
+		return c.Send("❌ Такой промокод уже существует. Введите другой:")
+	}
 
-	switch session.step {
-	case 1: // Ввод кода
-		if len(input) < 3 || len(input) > 20 {
-			return c.Send("❌ Код должен быть от 3 до 20 символов. Попробуйте снова:")
-		}
-		// Проверяем, не существует ли уже
-		existing, _ := h.svc.GetPromoByCode(context.Background(), input)
-		if existing != nil {
-			return c.Send("❌ Такой промокод уже существует. Введите другой:")
-		}
-
-		promoWizard.mu.Lock()
-		session.code = strings.ToUpper(input)
-		session.step = 2
-		promoWizard.mu.Unlock()
+	session := promoWizardData{Code: strings.ToUpper(input)}
+	h.setState(c.Sender().ID, statePromoAmount, session)
 
-		text := fmt.Sprintf(`➕ *Создание промокода*
+	text := fmt.Sprintf(`➕ *Создание промокода*
 
 📝 Код: ` + "`%s`" + `
 
 *Шаг 2/3:* Введите сумму бонуса (в рублях)
 
-_Например: 100_`, session.code)
+_Например: 100_`, session.Code)
 
-		menu := &tele.ReplyMarkup{}
-		menu.Inline(
-			menu.Row(menu.Data("❌ Отмена", "admin_promo_cancel")),
-		)
-		return c.Send(text, menu, tele.ModeMarkdown)
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("❌ Отмена", "admin_promo_cancel")),
+	)
+	return c.Send(text, menu, tele.ModeMarkdown)
+}
 
-	case 2: // Ввод суммы
-		amount, err := strconv.ParseFloat(input, 64)
-		if err != nil || amount <= 0 {
-			return c.Send("❌ Некорректная сумма. Введите положительное число:")
-		}
+// HandleAdminPromoAmountInput шаг 2 мастера: сумма бонуса
+func (h *Handler) HandleAdminPromoAmountInput(c tele.Context, s *fsm.Session) error {
+	var session promoWizardData
+	if err := s.Decode(&session); err != nil {
+		return err
+	}
+
+	amount, err := strconv.ParseFloat(strings.TrimSpace(c.Text()), 64)
+	if err != nil || amount <= 0 {
+		return c.Send("❌ Некорректная сумма. Введите положительное число:")
+	}
 
-		promoWizard.mu.Lock()
-		session.amount = amount
-		session.step = 3
-		promoWizard.mu.Unlock()
+	session.Amount = amount
+	h.setState(c.Sender().ID, statePromoActivations, session)
 
-		text := fmt.Sprintf(`➕ *Создание промокода*
+	text := fmt.Sprintf(`➕ *Создание промокода*
 
 📝 Код: `+"`%s`"+`
-💰 Сумма: *%.0f ₽*
+💰 Сумма: *%s*
 
 *Шаг 3/3:* Введите количество активаций
 
-_Например: 50_`, session.code, session.amount)
+_Например: 50_`, session.Code, models.RUB.Format(session.Amount))
 
-		menu := &tele.ReplyMarkup{}
-		menu.Inline(
-			menu.Row(menu.Data("❌ Отмена", "admin_promo_cancel")),
-		)
-		return c.Send(text, menu, tele.ModeMarkdown)
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("❌ Отмена", "admin_promo_cancel")),
+	)
+	return c.Send(text, menu, tele.ModeMarkdown)
+}
 
-	case 3: // Ввод количества активаций
-		activations, err := strconv.Atoi(input)
-		if err != nil || activations <= 0 {
-			return c.Send("❌ Некорректное количество. Введите положительное число:")
-		}
+// HandleAdminPromoActivationsInput шаг 3 мастера: количество активаций, создание промокода
+func (h *Handler) HandleAdminPromoActivationsInput(c tele.Context, s *fsm.Session) error {
+	var session promoWizardData
+	if err := s.Decode(&session); err != nil {
+		return err
+	}
 
-		// Создаём промокод
-		promo, err := h.svc.CreatePromoCode(context.Background(), session.code, session.amount, activations)
+	activations, err := strconv.Atoi(strings.TrimSpace(c.Text()))
+	if err != nil || activations <= 0 {
+		return c.Send("❌ Некорректное количество. Введите положительное число:")
+	}
 
-		// Очищаем сессию
-		promoWizard.mu.Lock()
-		delete(promoWizard.sessions, c.Sender().ID)
-		promoWizard.mu.Unlock()
+	// Создаём промокод
+	promo, err := h.svc.CreatePromoCode(context.Background(), session.Code, session.Amount, activations)
 
-		if err != nil {
-			return c.Send(fmt.Sprintf("❌ Ошибка создания: %v", err))
-		}
+	// Очищаем сессию
+	h.resetState(c.Sender().ID)
 
-		text := fmt.Sprintf(`✅ *Промокод создан!*
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Ошибка создания: %v", err))
+	}
+
+	text := fmt.Sprintf(`✅ *Промокод создан!*
 
 📝 Код: `+"`%s`"+`
-💰 Сумма: *%.0f ₽*
+💰 Сумма: *%s*
 🔢 Активаций: *%d*
 
 Пользователи могут активировать его через кнопку "🎟 Промокод" в главном меню.`,
-			promo.Code, promo.Amount, promo.MaxActivations)
+		promo.Code, models.RUB.Format(promo.Amount), promo.MaxActivations)
 
-		menu := &tele.ReplyMarkup{}
-		menu.Inline(
-			menu.Row(menu.Data("➕ Создать ещё", "admin_promo_create")),
-			menu.Row(menu.Data("⬅️ К промокодам", "admin_promo")),
-		)
-		return c.Send(text, menu, tele.ModeMarkdown)
-	}
-
-	return nil
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("➕ Создать ещё", "admin_promo_create")),
+		menu.Row(menu.Data("⬅️ К промокодам", "admin_promo")),
+	)
+	return c.Send(text, menu, tele.ModeMarkdown)
 }
 
 // HandleAdminPromoList показывает список промокодов
@@ -1845,8 +1816,8 @@ _Нет активных промокодов._`
 		if !p.IsActive || p.ActivationsUsed >= p.MaxActivations {
 			status = "❌"
 		}
-		sb.WriteString(fmt.Sprintf("%d. `%s` — *%.0f₽* (исп: %d/%d) %s\n",
-			i+1, p.Code, p.Amount, p.ActivationsUsed, p.MaxActivations, status))
+		sb.WriteString(fmt.Sprintf("%d. `%s` — *%s* (исп: %d/%d) %s\n",
+			i+1, p.Code, models.RUB.Format(p.Amount), p.ActivationsUsed, p.MaxActivations, status))
 	}
 
 	menu := &tele.ReplyMarkup{}
@@ -1863,9 +1834,7 @@ _Нет активных промокодов._`
 
 // HandleAdminPromoDelete начинает удаление промокода
 func (h *Handler) HandleAdminPromoDelete(c tele.Context) error {
-	promoDelete.mu.Lock()
-	promoDelete.waiting[c.Sender().ID] = true
-	promoDelete.mu.Unlock()
+	h.setState(c.Sender().ID, statePromoDelete, nil)
 
 	text := `🗑 *Удаление промокода*
 
@@ -1882,10 +1851,8 @@ _Например: SALE50_`
 }
 
 // HandleAdminPromoDeleteInput обрабатывает удаление промокода
-func (h *Handler) HandleAdminPromoDeleteInput(c tele.Context) error {
-	promoDelete.mu.Lock()
-	delete(promoDelete.waiting, c.Sender().ID)
-	promoDelete.mu.Unlock()
+func (h *Handler) HandleAdminPromoDeleteInput(c tele.Context, _ *fsm.Session) error {
+	h.resetState(c.Sender().ID)
 
 	code := strings.TrimSpace(c.Text())
 
@@ -1917,13 +1884,7 @@ func (h *Handler) HandleAdminPromoDeleteInput(c tele.Context) error {
 
 // HandleAdminPromoCancel отменяет действие с промокодами
 func (h *Handler) HandleAdminPromoCancel(c tele.Context) error {
-	promoWizard.mu.Lock()
-	delete(promoWizard.sessions, c.Sender().ID)
-	promoWizard.mu.Unlock()
-
-	promoDelete.mu.Lock()
-	delete(promoDelete.waiting, c.Sender().ID)
-	promoDelete.mu.Unlock()
+	h.resetState(c.Sender().ID)
 
 	return h.HandleAdminPromo(c)
 }
@@ -1961,11 +1922,11 @@ _Нет активных промокодов._`
 		}
 		sb.WriteString(fmt.Sprintf("%d. `%s`\n", i+1, p.Code))
 		sb.WriteString(fmt.Sprintf("   ├ Активаций: *%d / %d* (%d%%)\n", p.ActivationsUsed, p.MaxActivations, percent))
-		sb.WriteString(fmt.Sprintf("   └ Выдано бонусов: *%.0f ₽*\n\n", p.TotalBonusPaid))
+		sb.WriteString(fmt.Sprintf("   └ Выдано бонусов: *%s*\n\n", models.RUB.Format(p.TotalBonusPaid)))
 		totalBonusPaid += p.TotalBonusPaid
 	}
 
-	sb.WriteString(fmt.Sprintf("💰 *Всего выдано:* %.0f ₽", totalBonusPaid))
+	sb.WriteString(fmt.Sprintf("💰 *Всего выдано:* %s", models.RUB.Format(totalBonusPaid)))
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
@@ -2029,7 +1990,7 @@ _Пока нет пользователей с рефералами._`
 
 		sb.WriteString(fmt.Sprintf("%d. %s*%s* (ID: `%d`)\n", i+1, medal, username, ref.TelegramID))
 		sb.WriteString(fmt.Sprintf("   ├ Пригласил: *%d чел.*\n", ref.ReferralCount))
-		sb.WriteString(fmt.Sprintf("   └ Принес в кассу: *%.0f ₽*\n\n", ref.TotalRevenue))
+		sb.WriteString(fmt.Sprintf("   └ Принес в кассу: *%s*\n\n", models.RUB.Format(ref.TotalRevenue)))
 	}
 
 	sb.WriteString("_💡 Совет: Свяжитесь с лидерами для улучшения условий._")
@@ -2049,8 +2010,8 @@ _Пока нет пользователей с рефералами._`
 // ================= USER PROMO CODE ACTIVATION =================
 
 // HandleUserPromoInput обрабатывает ввод промокода пользователем
-func (h *Handler) HandleUserPromoInput(c tele.Context) error {
-	SetUserPromoMode(c.Sender().ID, false)
+func (h *Handler) HandleUserPromoInput(c tele.Context, _ *fsm.Session) error {
+	h.resetState(c.Sender().ID)
 
 	code := strings.TrimSpace(c.Text())
 	if len(code) < 3 {
@@ -2084,7 +2045,7 @@ func (h *Handler) HandleUserPromoInput(c tele.Context) error {
 	text := fmt.Sprintf(`✅ *Успешно!*
 
 Промокод `+"`%s`"+` активирован.
-💰 На ваш баланс зачислено: *%.0f ₽*`, strings.ToUpper(code), amount)
+💰 На ваш баланс зачислено: *%s*`, strings.ToUpper(code), h.svc.UserCurrency(ctx, user).Format(amount))
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
@@ -2121,11 +2082,21 @@ func (h *Handler) handleSupportGroupMessage(c tele.Context) error {
 
 	replyTo := c.Message().ReplyTo
 
-	// Ищем user ID в тексте сообщения (паттерн #user_123456)
+	ctx := context.Background()
 	var targetUserID int64
 
+	// 0. Ищем тикет по сообщению в группе, на которое отвечают
+	ticket, err := h.svc.FindTicketByGroupMessage(ctx, replyTo.ID)
+	if err != nil {
+		log.Printf("Support bridge: Failed to find ticket by message %d: %v", replyTo.ID, err)
+	}
+	if ticket != nil {
+		targetUserID = ticket.TelegramID
+	}
+
+	// Иначе ищем user ID в тексте сообщения (паттерн #user_123456)
 	// 1. Проверяем текст сообщения на которое отвечают
-	if replyTo.Text != "" {
+	if targetUserID == 0 && replyTo.Text != "" {
 		targetUserID = extractUserIDFromTicket(replyTo.Text)
 		log.Printf("Support bridge: Checking replyTo.Text='%s', extracted ID=%d", replyTo.Text[:min(50, len(replyTo.Text))], targetUserID)
 	}
@@ -2170,7 +2141,6 @@ func (h *Handler) handleSupportGroupMessage(c tele.Context) error {
 
 	// Отправляем контент с кнопками в зависимости от типа сообщения
 	msg := c.Message()
-	var err error
 
 	if msg.Photo != nil {
 		// Фото с кнопками
@@ -2204,9 +2174,18 @@ func (h *Handler) handleSupportGroupMessage(c tele.Context) error {
 		return nil
 	}
 
-	// Обновляем трекер — помечаем как "отвечено"
+	// Сохраняем ответ в тикет — он становится "отвечено"
+	if ticket == nil {
+		ticket, err = h.svc.GetOpenTicket(ctx, targetUserID)
+		if err != nil {
+			log.Printf("Support bridge: Failed to load ticket of user %d: %v", targetUserID, err)
+		}
+	}
+	if ticket != nil {
+		h.saveAdminTicketMessage(ticket.ID, c.Sender().ID, msg)
+	}
+
 	if tracker := GetTracker(); tracker != nil {
-		tracker.SetTicketReplied(targetUserID)
 		go tracker.UpdateDashboard()
 	}
 
@@ -2214,6 +2193,45 @@ func (h *Handler) handleSupportGroupMessage(c tele.Context) error {
 	return nil
 }
 
+// saveAdminTicketMessage сохраняет ответ поддержки в тикет
+func (h *Handler) saveAdminTicketMessage(ticketID, adminID int64, m *tele.Message) {
+	msg := &models.TicketMessage{
+		TicketID:       ticketID,
+		SenderID:       adminID,
+		Kind:           ticketMessageKind(m),
+		Text:           m.Text,
+		GroupMessageID: m.ID,
+	}
+	if m.Caption != "" {
+		msg.Text = m.Caption
+	}
+	if m.Chat == nil || m.Chat.ID != h.supportGroupID {
+		msg.GroupMessageID = 0
+	}
+
+	if err := h.svc.AddAdminTicketMessage(context.Background(), msg); err != nil {
+		log.Printf("Failed to save reply to ticket %d: %v", ticketID, err)
+	}
+}
+
+// ticketMessageKind тип сообщения для истории тикета
+func ticketMessageKind(m *tele.Message) string {
+	switch {
+	case m.Photo != nil:
+		return "photo"
+	case m.Document != nil:
+		return "document"
+	case m.Voice != nil:
+		return "voice"
+	case m.Video != nil:
+		return "video"
+	case m.Sticker != nil:
+		return "sticker"
+	default:
+		return "text"
+	}
+}
+
 // extractUserIDFromTicket извлекает user ID из текста тикета
 func extractUserIDFromTicket(text string) int64 {
 	// Ищем паттерн #user_123456
@@ -2281,12 +2299,13 @@ func (h *Handler) HandleAdminCloseTicket(c tele.Context) error {
 		return nil
 	}
 
-	// 1. Сбрасываем состояние пользователя
-	SetUserSupportMode(targetUserID, false)
+	// 1. Закрываем тикет (режим поддержки пользователя выключается вместе с ним)
+	if _, err := h.svc.CloseTicket(context.Background(), targetUserID); err != nil {
+		log.Printf("HandleAdminCloseTicket: Failed to close ticket of user %d: %v", targetUserID, err)
+	}
 
-	// 2. Удаляем из трекера и обновляем dashboard
+	// 2. Обновляем dashboard
 	if tracker := GetTracker(); tracker != nil {
-		tracker.RemoveTicket(targetUserID)
 		go tracker.UpdateDashboard()
 	}
 
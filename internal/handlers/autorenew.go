@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// RegisterAutoRenew регистрирует переключатель автопродления подписки
+func (h *Handler) RegisterAutoRenew(b *tele.Bot) {
+	b.Handle(&tele.Btn{Unique: "auto_renew"}, h.HandleAutoRenewToggle)
+}
+
+// subAutoRenewText строка об автопродлении для карточки подписки
+func (h *Handler) subAutoRenewText(lang string, sub *models.Subscription) string {
+	if !sub.AutoRenew {
+		return i18n.T(lang, "sub.autorenew_off")
+	}
+	plan, err := h.svc.AutoRenewPlan(context.Background(), sub)
+	if err != nil {
+		return i18n.T(lang, "sub.autorenew_on")
+	}
+	return i18n.T(lang, "sub.autorenew_plan", planPeriodTitle(lang, plan))
+}
+
+// HandleAutoRenewToggle включает или выключает автопродление и возвращает к карточке подписки
+func (h *Handler) HandleAutoRenewToggle(c tele.Context) error {
+	ctx := context.Background()
+	lang := h.lang(c)
+
+	subID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
+	}
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
+	}
+	sub, err := h.svc.GetSubscriptionByID(ctx, subID)
+	if err != nil || sub.UserID != user.ID {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.sub_not_found")})
+	}
+
+	enabled := !sub.AutoRenew
+	if err := h.svc.SetAutoRenew(ctx, user.ID, subID, enabled); err != nil {
+		if !errors.Is(err, service.ErrAutoRenewUnavailable) {
+			log.Printf("Failed to toggle auto-renew of subscription %d: %v", subID, err)
+		}
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "autorenew.failed")})
+	}
+
+	notice := i18n.T(lang, "autorenew.disabled")
+	if enabled {
+		notice = i18n.N(lang, "autorenew.enabled", int(service.AutoRenewBefore.Hours()))
+	}
+	c.Respond(&tele.CallbackResponse{Text: notice, ShowAlert: enabled})
+	return h.HandleSubDetail(c)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// RegisterCurrency регистрирует выбор валюты отображения сумм пользователем
+func (h *Handler) RegisterCurrency(b *tele.Bot) {
+	b.Handle(&tele.Btn{Unique: "currency"}, h.HandleCurrency)
+	b.Handle(&tele.Btn{Unique: "currency_set"}, h.HandleCurrencySet)
+}
+
+// RegisterCurrencyAdmin регистрирует управление курсами валют
+func (h *Handler) RegisterCurrencyAdmin(adminGroup *tele.Group) {
+	adminGroup.Handle("/rates", h.HandleAdminRates)
+	adminGroup.Handle("/setrate", h.HandleAdminSetRate)
+}
+
+// senderCurrency валюта отображения сумм отправителя
+func (h *Handler) senderCurrency(c tele.Context) *models.Currency {
+	return h.currencyOf(c.Sender().ID)
+}
+
+// currencyOf валюта отображения сумм пользователя по Telegram ID (базовая, если пользователь ещё не создан)
+func (h *Handler) currencyOf(telegramID int64) *models.Currency {
+	ctx := context.Background()
+	user, err := h.svc.GetUserByTelegramID(ctx, telegramID)
+	if err != nil {
+		return models.RUB
+	}
+	return h.svc.UserCurrency(ctx, user)
+}
+
+// HandleCurrency показывает выбор валюты, в которой отображаются цены и баланс
+func (h *Handler) HandleCurrency(c tele.Context) error {
+	ctx := context.Background()
+	lang := h.lang(c)
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.load"))
+	}
+	currencies, err := h.svc.GetCurrencies(ctx)
+	if err != nil {
+		log.Printf("Failed to load currencies: %v", err)
+		return c.Send(i18n.T(lang, "error.retry"))
+	}
+	current := h.svc.UserCurrency(ctx, user)
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	var row tele.Row
+	for _, cur := range currencies {
+		text := cur.Code
+		if cur.Code == current.Code {
+			text = "✅ " + text
+		}
+		row = append(row, menu.Data(text, "currency_set", cur.Code))
+		if len(row) == 3 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "common.back"), "balance")))
+	menu.Inline(rows...)
+
+	return h.editOrResend(c, i18n.T(lang, "currency.text", current.Code), menu)
+}
+
+// HandleCurrencySet меняет валюту отображения пользователя
+func (h *Handler) HandleCurrencySet(c tele.Context) error {
+	ctx := context.Background()
+	lang := h.lang(c)
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
+	}
+
+	cur, err := h.svc.SetUserCurrency(ctx, user.ID, c.Callback().Data)
+	if errors.Is(err, service.ErrCurrencyUnknown) {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "currency.unknown")})
+	}
+	if err != nil {
+		log.Printf("Failed to set currency of user %d: %v", user.ID, err)
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "currency.failed")})
+	}
+
+	c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "currency.set", cur.Code)})
+	return h.HandleBalance(c)
+}
+
+// HandleAdminRates показывает курсы валют: /rates
+func (h *Handler) HandleAdminRates(c tele.Context) error {
+	currencies, err := h.svc.GetCurrencies(context.Background())
+	if err != nil {
+		log.Printf("Failed to load currencies: %v", err)
+		return c.Send("❌ Не удалось загрузить курсы")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("💱 *Курсы валют*\n\n")
+	sb.WriteString(fmt.Sprintf("Цены и балансы хранятся в %s, остальные валюты — только для отображения.\n\n", models.BaseCurrency))
+	for _, cur := range currencies {
+		if cur.IsBase() {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("• *%s*: %s (обновлён %s)\n", cur.Code, models.RUB.Format(cur.Rate), cur.UpdatedAt.Format("02.01.2006 15:04")))
+	}
+	if len(currencies) <= 1 {
+		sb.WriteString("_Курсы не заданы_\n")
+	}
+	sb.WriteString("\nИзменить: `/setrate USD 92.5` — сколько рублей стоит единица валюты")
+	return c.Send(sb.String(), tele.ModeMarkdown)
+}
+
+// HandleAdminSetRate задаёт курс валюты: /setrate <код> <рублей за единицу>
+func (h *Handler) HandleAdminSetRate(c tele.Context) error {
+	args := c.Args()
+	if len(args) < 2 {
+		return c.Send("❌ Использование: /setrate <код> <рублей за единицу>, например /setrate USDT 95.3")
+	}
+
+	rate, err := strconv.ParseFloat(strings.Replace(args[1], ",", ".", 1), 64)
+	if err != nil {
+		return c.Send("❌ Неверный курс")
+	}
+
+	cur, err := h.svc.SetExchangeRate(context.Background(), c.Sender().ID, args[0], rate)
+	if errors.Is(err, service.ErrCurrencyInvalid) {
+		return c.Send("❌ Неверный код валюты или курс. Код — 3–5 латинских букв, курс больше нуля, курс рубля не меняется.")
+	}
+	if err != nil {
+		log.Printf("Failed to set exchange rate: %v", err)
+		return c.Send("❌ Не удалось сохранить курс")
+	}
+
+	return c.Send(fmt.Sprintf("✅ Курс %s: 1 %s = %s\n%s ≈ %s", cur.Code, cur.Code, models.RUB.Format(cur.Rate), models.RUB.Format(1000), cur.Format(1000)))
+}
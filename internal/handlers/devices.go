@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"vpn-telegram-bot/internal/fsm"
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Значения продукта, которые админ вводит на экране устройств
+const (
+	devicesFieldLimit = "limit" // лимит устройств продукта
+	devicesFieldPrice = "price" // цена доп. устройства за месяц
+)
+
+// maxShownConnections сколько подключений показывать в карточке подписки
+const maxShownConnections = 10
+
+// devicesEditData данные ввода лимита устройств или цены устройства продукта
+type devicesEditData struct {
+	ProductID int64  `json:"product_id"`
+	Field     string `json:"field"`
+}
+
+// RegisterDevices регистрирует покупку дополнительных устройств пользователями
+func (h *Handler) RegisterDevices(b *tele.Bot) {
+	b.Handle(&tele.Btn{Unique: "device_slots"}, h.HandleDeviceSlots)
+	b.Handle(&tele.Btn{Unique: "device_buy"}, h.HandleBuyDeviceSlots, h.IdempotencyMiddleware())
+}
+
+// registerDevicesAdmin регистрирует настройку лимитов устройств (часть редактора тарифов)
+func (h *Handler) registerDevicesAdmin(adminGroup *tele.Group) {
+	adminGroup.Handle(&tele.Btn{Unique: "admin_devices"}, h.HandleAdminDevices)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_devices_edit"}, h.HandleAdminDevicesEdit)
+
+	h.fsm.Handle(stateDevicesEdit, h.HandleAdminDevicesInput)
+}
+
+// deviceLimitTitle лимит устройств словами на языке lang: "3 устройства" или "без ограничений"
+func deviceLimitTitle(lang string, limit int) string {
+	if limit == 0 {
+		return i18n.T(lang, "devices.unlimited")
+	}
+	return i18n.N(lang, "devices.count", limit)
+}
+
+// subDevicesText строки об устройствах для карточки подписки: лимит и подключения из VPN панели
+func (h *Handler) subDevicesText(lang string, sub *models.Subscription) string {
+	var sb strings.Builder
+	if sub.DeviceLimit > 0 {
+		sb.WriteString(i18n.T(lang, "sub.devices", sub.DeviceLimitTotal()))
+		if sub.ExtraDevices > 0 {
+			sb.WriteString(i18n.T(lang, "sub.devices_extra", sub.ExtraDevices))
+		}
+	}
+
+	conns, err := h.svc.GetSubscriptionConnections(context.Background(), sub)
+	if errors.Is(err, service.ErrVPNNotSupported) {
+		return sb.String()
+	}
+	if err != nil {
+		log.Printf("Failed to get connections of subscription %d: %v", sub.ID, err)
+		return sb.String()
+	}
+
+	if len(conns) == 0 {
+		sb.WriteString(i18n.T(lang, "sub.connections_none"))
+		return sb.String()
+	}
+	sb.WriteString(i18n.T(lang, "sub.connections", len(conns)))
+	for i, conn := range conns {
+		if i == maxShownConnections {
+			sb.WriteString(i18n.T(lang, "sub.connections_more", len(conns)-maxShownConnections))
+			break
+		}
+		sb.WriteString(fmt.Sprintf("\n  • `%s`", conn.IP))
+		if conn.LastSeen != nil {
+			sb.WriteString(" — " + conn.LastSeen.Format("02.01 15:04"))
+		}
+	}
+	return sb.String()
+}
+
+// canBuyDeviceSlots продаются ли доп. устройства к подписке
+func (h *Handler) canBuyDeviceSlots(sub *models.Subscription) bool {
+	if sub.DeviceLimit == 0 || sub.ExtraDevices >= service.MaxExtraDevices {
+		return false
+	}
+	_, err := h.svc.DeviceSlotPrice(context.Background(), sub, 1)
+	return err == nil
+}
+
+// ================= ПОКУПКА УСТРОЙСТВ =================
+
+// HandleDeviceSlots показывает варианты докупки устройств для подписки
+func (h *Handler) HandleDeviceSlots(c tele.Context) error {
+	ctx := context.Background()
+	lang := h.lang(c)
+
+	subID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
+	}
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.generic"))
+	}
+	sub, err := h.svc.GetSubscriptionByID(ctx, subID)
+	if err != nil || sub.UserID != user.ID {
+		return c.Send(i18n.T(lang, "error.sub_not_found"))
+	}
+
+	id := strconv.FormatInt(sub.ID, 10)
+	cur := h.svc.UserCurrency(ctx, user)
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+
+	text := i18n.T(lang, "devices.text",
+		sub.ID, sub.Product.CountryFlag, sub.Product.Name, deviceLimitTitle(lang, sub.DeviceLimitTotal()),
+		cur.Format(user.Balance), sub.ExpiresAt.Format("02.01.2006"))
+
+	available := service.MaxExtraDevices - sub.ExtraDevices
+	for _, n := range []int{1, 2, 3} {
+		if n > available {
+			break
+		}
+		price, err := h.svc.DeviceSlotPrice(ctx, sub, n)
+		if err != nil {
+			break
+		}
+		btnText := i18n.T(lang, "devices.buy", i18n.N(lang, "devices.count", n), cur.Format(price))
+		rows = append(rows, menu.Row(menu.Data(btnText, "device_buy", fmt.Sprintf("%d:%d", sub.ID, n))))
+	}
+	if len(rows) == 0 {
+		text += i18n.T(lang, "devices.none")
+	}
+	rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "common.back"), "sub", id)))
+	menu.Inline(rows...)
+
+	return h.editOrResend(c, text, menu)
+}
+
+// HandleBuyDeviceSlots докупает устройства с баланса ("subID:count")
+func (h *Handler) HandleBuyDeviceSlots(c tele.Context) error {
+	ctx := context.Background()
+	lang := h.lang(c)
+
+	parts := strings.Split(c.Callback().Data, ":")
+	if len(parts) != 2 {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
+	}
+	subID, err1 := strconv.ParseInt(parts[0], 10, 64)
+	slots, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
+	}
+
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.generic"))
+	}
+
+	cur := h.svc.UserCurrency(ctx, user)
+	back := &tele.ReplyMarkup{}
+	back.Inline(back.Row(back.Data(i18n.T(lang, "common.to_sub"), "sub", parts[0])))
+
+	sub, price, err := h.svc.BuyDeviceSlots(ctx, user.ID, subID, slots)
+	switch {
+	case errors.Is(err, service.ErrInsufficientBalance):
+		text := i18n.T(lang, "devices.insufficient", cur.Format(user.Balance), cur.Format(price))
+
+		menu := &tele.ReplyMarkup{}
+		menu.Inline(
+			menu.Row(menu.Data(i18n.T(lang, "common.topup"), "topup")),
+			menu.Row(menu.Data(i18n.T(lang, "common.back"), "device_slots", parts[0])),
+		)
+		return h.editOrResend(c, text, menu)
+	case errors.Is(err, service.ErrDeviceSlotsUnavailable):
+		return h.editOrResend(c, i18n.T(lang, "devices.unavailable"), back)
+	case err != nil:
+		log.Printf("Failed to buy %d device slots for subscription %d: %v", slots, subID, err)
+		return h.editOrResend(c, i18n.T(lang, "devices.failed"), back)
+	}
+	callbackSucceeded(c)
+
+	text := i18n.T(lang, "devices.done",
+		sub.ID, i18n.N(lang, "devices.count", slots), cur.Format(price), deviceLimitTitle(lang, sub.DeviceLimitTotal()))
+
+	return h.editOrResend(c, text, back)
+}
+
+// ================= АДМИНКА: ЛИМИТ УСТРОЙСТВ =================
+
+// HandleAdminDevices показывает лимит устройств продукта и цену доп. устройства
+func (h *Handler) HandleAdminDevices(c tele.Context) error {
+	productID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	h.resetState(c.Sender().ID)
+	return h.showProductDevices(c, productID)
+}
+
+// showProductDevices экран устройств продукта
+func (h *Handler) showProductDevices(c tele.Context, productID int64) error {
+	product, err := h.svc.GetProductByID(context.Background(), productID)
+	if err != nil {
+		return c.Send("❌ Продукт не найден")
+	}
+
+	slotPrice := "не продаются"
+	if product.DeviceSlotPrice != nil {
+		slotPrice = models.RUB.Format(*product.DeviceSlotPrice) + "/мес"
+	}
+
+	text := fmt.Sprintf(`📱 *Устройства: %s %s*
+
+Лимит: *%s*
+Доп. устройство: *%s*
+
+_Лимит передаётся в панель как ограничение IP (3X-UI). Marzban лимит устройств не поддерживает. Лимит плана, если задан, заменяет лимит продукта. Изменения действуют на новые покупки и продления._`,
+		product.CountryFlag, product.Name, deviceLimitTitle(i18n.Default, product.DeviceLimit), slotPrice)
+
+	id := strconv.FormatInt(productID, 10)
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(
+			menu.Data("📱 Лимит", "admin_devices_edit", id+":"+devicesFieldLimit),
+			menu.Data("💰 Цена устройства", "admin_devices_edit", id+":"+devicesFieldPrice),
+		),
+		menu.Row(menu.Data("🔙 К планам", "admin_plans_product", id)),
+	)
+
+	if c.Callback() != nil {
+		return c.Edit(text, menu, tele.ModeMarkdown)
+	}
+	return c.Send(text, menu, tele.ModeMarkdown)
+}
+
+// HandleAdminDevicesEdit запрашивает лимит устройств или цену устройства ("productID:field")
+func (h *Handler) HandleAdminDevicesEdit(c tele.Context) error {
+	parts := strings.Split(c.Callback().Data, ":")
+	if len(parts) != 2 {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	productID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	var prompt string
+	switch parts[1] {
+	case devicesFieldLimit:
+		prompt = "📱 Введите число одновременных устройств.\n\n`0` — без ограничений."
+	case devicesFieldPrice:
+		prompt = "💰 Введите цену дополнительного устройства за месяц в рублях.\n\n`0` — не продавать."
+	default:
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	h.setState(c.Sender().ID, stateDevicesEdit, devicesEditData{ProductID: productID, Field: parts[1]})
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("❌ Отмена", "admin_devices", parts[0])))
+	return c.Edit(prompt, menu, tele.ModeMarkdown)
+}
+
+// HandleAdminDevicesInput применяет введённый лимит или цену; при ошибке ввода состояние сохраняется
+func (h *Handler) HandleAdminDevicesInput(c tele.Context, s *fsm.Session) error {
+	ctx := context.Background()
+
+	var data devicesEditData
+	if err := s.Decode(&data); err != nil || data.ProductID == 0 {
+		h.resetState(c.Sender().ID)
+		return c.Send("❌ Сессия истекла. Начните заново: /plans")
+	}
+	input := strings.TrimSpace(c.Text())
+
+	product, err := h.svc.GetProductByID(ctx, data.ProductID)
+	if err != nil {
+		h.resetState(c.Sender().ID)
+		return c.Send("❌ Продукт не найден")
+	}
+	limit, slotPrice := product.DeviceLimit, product.DeviceSlotPrice
+
+	switch data.Field {
+	case devicesFieldLimit:
+		limit, err = strconv.Atoi(input)
+		if err != nil {
+			return c.Send("❌ Введите число устройств, например `3`", tele.ModeMarkdown)
+		}
+	case devicesFieldPrice:
+		price, err := strconv.ParseFloat(strings.ReplaceAll(input, ",", "."), 64)
+		if err != nil || price < 0 {
+			return c.Send("❌ Введите цену числом, например `50`", tele.ModeMarkdown)
+		}
+		slotPrice = nil
+		if price > 0 {
+			slotPrice = &price
+		}
+	default:
+		h.resetState(c.Sender().ID)
+		return c.Send("❌ Сессия истекла. Начните заново: /plans")
+	}
+
+	if err := h.svc.UpdateProductDevices(ctx, product.ID, limit, slotPrice); err != nil {
+		if errors.Is(err, service.ErrDevicesInvalid) {
+			return c.Send("❌ Недопустимое значение: лимит 0–100, цена больше нуля. Попробуйте ещё раз.")
+		}
+		log.Printf("Failed to save device settings: %v", err)
+		h.resetState(c.Sender().ID)
+		return c.Send("❌ Не удалось сохранить")
+	}
+
+	h.resetState(c.Sender().ID)
+	return h.showProductDevices(c, product.ID)
+}
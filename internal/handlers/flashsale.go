@@ -2,93 +2,58 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"vpn-telegram-bot/internal/fsm"
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
+
 	tele "gopkg.in/telebot.v3"
 )
 
-// flashSaleState хранит состояние флеш-распродажи
-type flashSaleState struct {
-	mu              sync.RWMutex
-	discountPercent int
-	endTime         time.Time
-}
-
-var flashSale = &flashSaleState{}
-
-// SetFlashSale устанавливает флеш-распродажу
-func (f *flashSaleState) Set(percent int, hours int) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	f.discountPercent = percent
-	f.endTime = time.Now().Add(time.Duration(hours) * time.Hour)
-}
-
-// Clear очищает флеш-распродажу
-func (f *flashSaleState) Clear() {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	f.discountPercent = 0
-	f.endTime = time.Time{}
+// flashSaleData параметры акции, которые настраивает админ
+type flashSaleData struct {
+	Percent   int       `json:"percent"`
+	Hours     int       `json:"hours"`      // 0 = длительность ещё не выбрана
+	StartsAt  time.Time `json:"starts_at"`  // нулевое время = сразу после подтверждения
+	ProductID int64     `json:"product_id"` // 0 = все продукты
+	Months    int       `json:"months"`     // 0 = все сроки
 }
 
-// IsActive проверяет, активна ли распродажа
-func (f *flashSaleState) IsActive() bool {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-	return f.discountPercent > 0 && time.Now().Before(f.endTime)
-}
-
-// GetDiscount возвращает текущую скидку (0 если не активна)
-func (f *flashSaleState) GetDiscount() int {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-	if time.Now().Before(f.endTime) {
-		return f.discountPercent
+// campaign собирает акцию из параметров мастера
+func (d flashSaleData) campaign(adminID int64) *models.Campaign {
+	startsAt := d.StartsAt
+	if startsAt.IsZero() {
+		startsAt = time.Now()
 	}
-	return 0
-}
 
-// GetEndTime возвращает время окончания
-func (f *flashSaleState) GetEndTime() time.Time {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-	return f.endTime
-}
-
-// ApplyDiscount применяет скидку к цене
-func (f *flashSaleState) ApplyDiscount(originalPrice float64) float64 {
-	discount := f.GetDiscount()
-	if discount <= 0 {
-		return originalPrice
+	c := &models.Campaign{
+		DiscountPercent: d.Percent,
+		StartsAt:        startsAt,
+		EndsAt:          startsAt.Add(time.Duration(d.Hours) * time.Hour),
+		CreatedBy:       adminID,
 	}
-	return originalPrice * float64(100-discount) / 100
-}
-
-// GetFlashSale возвращает глобальный объект для использования вне handlers
-func GetFlashSale() *flashSaleState {
-	return flashSale
-}
-
-// flashSaleSession хранит состояние ввода админа
-type flashSaleSession struct {
-	step     int // 1=percent, 2=hours
-	percent  int
-	hours    int
-	photoURL string
+	if d.ProductID > 0 {
+		productID := d.ProductID
+		c.ProductID = &productID
+	}
+	if d.Months > 0 {
+		months := d.Months
+		c.Months = &months
+	}
+	return c
 }
 
-var flashSaleSessions = struct {
-	mu       sync.Mutex
-	sessions map[int64]*flashSaleSession
-}{
-	sessions: make(map[int64]*flashSaleSession),
-}
+// flashStartLayout формат ввода даты начала акции
+const flashStartLayout = "02.01 15:04"
 
 // RegisterFlashSale регистрирует обработчики флеш-распродаж
 func (h *Handler) RegisterFlashSale(b *tele.Bot, adminGroup *tele.Group) {
@@ -99,8 +64,20 @@ func (h *Handler) RegisterFlashSale(b *tele.Bot, adminGroup *tele.Group) {
 	adminGroup.Handle(&tele.Btn{Unique: "flash_stop"}, h.HandleStopSaleCallback)
 	adminGroup.Handle(&tele.Btn{Unique: "flash_percent"}, h.HandleFlashPercent)
 	adminGroup.Handle(&tele.Btn{Unique: "flash_hours"}, h.HandleFlashHours)
+	adminGroup.Handle(&tele.Btn{Unique: "flash_start_at"}, h.HandleFlashStartAt)
+	adminGroup.Handle(&tele.Btn{Unique: "flash_product"}, h.HandleFlashProduct)
+	adminGroup.Handle(&tele.Btn{Unique: "flash_months"}, h.HandleFlashMonths)
 	adminGroup.Handle(&tele.Btn{Unique: "flash_confirm"}, h.HandleFlashConfirm)
 	adminGroup.Handle(&tele.Btn{Unique: "flash_cancel"}, h.HandleFlashCancel)
+	adminGroup.Handle(&tele.Btn{Unique: "flash_campaign_cancel"}, h.HandleFlashCampaignCancel)
+
+	// Дата начала акции вводится текстом
+	h.fsm.Handle(stateFlashSale, h.HandleFlashStartInput)
+
+	// Анонс акции в момент начала (сразу или по расписанию из планировщика)
+	h.svc.OnCampaignStart(func(c *models.Campaign) {
+		go h.broadcastFlashSale(b, c)
+	})
 
 	// Callback для удаления сообщения (доступен всем)
 	b.Handle(&tele.Btn{Unique: "delete_msg"}, h.HandleDeleteMessage)
@@ -115,23 +92,36 @@ func (h *Handler) HandleFlashSaleStart(c tele.Context) error {
 		hours, err2 := strconv.Atoi(args[1])
 		if err1 == nil && err2 == nil && percent > 0 && percent <= 90 && hours > 0 {
 			// Быстрый режим
-			flashSaleSessions.mu.Lock()
-			flashSaleSessions.sessions[c.Sender().ID] = &flashSaleSession{
-				step:    3,
-				percent: percent,
-				hours:   hours,
-			}
-			flashSaleSessions.mu.Unlock()
+			data := flashSaleData{Percent: percent, Hours: hours}
+			h.setState(c.Sender().ID, stateFlashSale, data)
 
-			return h.showFlashConfirm(c, percent, hours)
+			return h.showFlashConfirm(c, data)
 		}
 	}
 
-	// Проверяем, есть ли активная распродажа
+	ctx := context.Background()
+	campaigns, err := h.svc.GetCurrentCampaigns(ctx)
+	if err != nil {
+		log.Printf("Failed to load campaigns: %v", err)
+	}
+
+	// Действующие и запланированные акции
 	var activeText string
-	if flashSale.IsActive() {
-		activeText = fmt.Sprintf("\n\n⚠️ *Активная акция:* -%d%% до %s",
-			flashSale.GetDiscount(), flashSale.GetEndTime().Format("15:04"))
+	if len(campaigns) > 0 {
+		var sb strings.Builder
+		sb.WriteString("\n\n📋 *Акции:*\n")
+		now := time.Now()
+		for i := range campaigns {
+			camp := &campaigns[i]
+			status := "📅"
+			if camp.IsActive(now) {
+				status = "🔥"
+			}
+			sb.WriteString(fmt.Sprintf("%s #%d: -%d%% · %s · %s — %s\n",
+				status, camp.ID, camp.DiscountPercent, h.campaignScope(ctx, i18n.Default, camp),
+				camp.StartsAt.Format("02.01 15:04"), camp.EndsAt.Format("02.01 15:04")))
+		}
+		activeText = sb.String()
 	}
 
 	// Интерактивный режим с быстрыми кнопками
@@ -142,7 +132,7 @@ func (h *Handler) HandleFlashSaleStart(c tele.Context) error {
 🚀 *Быстрый запуск:*`, activeText)
 
 	menu := &tele.ReplyMarkup{}
-	menu.Inline(
+	rows := []tele.Row{
 		menu.Row(
 			menu.Data("🔥 50%% на 6ч", "flash_quick", "50:6"),
 			menu.Data("🔥 50%% на 24ч", "flash_quick", "50:24"),
@@ -152,9 +142,19 @@ func (h *Handler) HandleFlashSaleStart(c tele.Context) error {
 			menu.Data("💥 25%% на 48ч", "flash_quick", "25:48"),
 		),
 		menu.Row(menu.Data("⚙️ Настроить вручную", "flash_manual")),
+	}
+	for i := range campaigns {
+		if i == 5 {
+			break
+		}
+		rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("❌ Отменить акцию #%d", campaigns[i].ID),
+			"flash_campaign_cancel", strconv.FormatInt(campaigns[i].ID, 10))))
+	}
+	rows = append(rows,
 		menu.Row(menu.Data("🛑 Остановить акцию", "flash_stop")),
 		menu.Row(menu.Data("⬅️ Назад", "admin_back")),
 	)
+	menu.Inline(rows...)
 
 	if c.Callback() != nil {
 		return c.Edit(text, menu, tele.ModeMarkdown)
@@ -188,7 +188,12 @@ func (h *Handler) HandleFlashManual(c tele.Context) error {
 
 // HandleStopSaleCallback останавливает распродажу (callback)
 func (h *Handler) HandleStopSaleCallback(c tele.Context) error {
-	if !flashSale.IsActive() {
+	stopped, err := h.svc.StopActiveCampaigns(context.Background())
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Ошибка: %v", err))
+	}
+
+	if stopped == 0 {
 		menu := &tele.ReplyMarkup{}
 		menu.Inline(
 			menu.Row(menu.Data("⬅️ Назад", "flash_start")),
@@ -196,8 +201,7 @@ func (h *Handler) HandleStopSaleCallback(c tele.Context) error {
 		return c.Edit("ℹ️ Сейчас нет активных распродаж.", menu)
 	}
 
-	flashSale.Clear()
-	log.Printf("[FLASH SALE] Admin %d stopped flash sale via button", c.Sender().ID)
+	log.Printf("[FLASH SALE] Admin %d stopped %d campaign(s) via button", c.Sender().ID, stopped)
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
@@ -205,7 +209,28 @@ func (h *Handler) HandleStopSaleCallback(c tele.Context) error {
 		menu.Row(menu.Data("⬅️ В админ-панель", "admin_back")),
 	)
 
-	return c.Edit("✅ *Флеш-распродажа остановлена.*\n\nЦены вернулись к обычным.", menu, tele.ModeMarkdown)
+	return c.Edit("✅ *Флеш-распродажа остановлена.*\n\nЦены вернулись к обычным. Запланированные акции не отменены.", menu, tele.ModeMarkdown)
+}
+
+// HandleFlashCampaignCancel отменяет действующую или запланированную акцию
+func (h *Handler) HandleFlashCampaignCancel(c tele.Context) error {
+	id, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	cancelled, err := h.svc.CancelCampaign(context.Background(), id)
+	switch {
+	case err != nil:
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка: " + err.Error(), ShowAlert: true})
+	case !cancelled:
+		c.Respond(&tele.CallbackResponse{Text: "Акция уже отменена"})
+	default:
+		log.Printf("[FLASH SALE] Admin %d cancelled campaign #%d", c.Sender().ID, id)
+		c.Respond(&tele.CallbackResponse{Text: fmt.Sprintf("✅ Акция #%d отменена", id)})
+	}
+
+	return h.HandleFlashSaleStart(c)
 }
 
 // HandleFlashPercent обрабатывает выбор процента скидки
@@ -215,12 +240,7 @@ func (h *Handler) HandleFlashPercent(c tele.Context) error {
 		return c.Send("❌ Ошибка")
 	}
 
-	flashSaleSessions.mu.Lock()
-	flashSaleSessions.sessions[c.Sender().ID] = &flashSaleSession{
-		step:    2,
-		percent: percent,
-	}
-	flashSaleSessions.mu.Unlock()
+	h.setState(c.Sender().ID, stateFlashSale, flashSaleData{Percent: percent})
 
 	text := fmt.Sprintf(`⚙️ *Ручная настройка*
 
@@ -246,51 +266,245 @@ func (h *Handler) HandleFlashPercent(c tele.Context) error {
 	return c.Edit(text, menu, tele.ModeMarkdown)
 }
 
-// HandleFlashHours обрабатывает выбор длительности
+// HandleFlashHours обрабатывает выбор длительности и предлагает выбрать время начала
 func (h *Handler) HandleFlashHours(c tele.Context) error {
 	hours, err := strconv.Atoi(c.Callback().Data)
 	if err != nil {
 		return c.Send("❌ Ошибка")
 	}
 
-	flashSaleSessions.mu.Lock()
-	session, exists := flashSaleSessions.sessions[c.Sender().ID]
-	if !exists {
-		flashSaleSessions.mu.Unlock()
+	var session flashSaleData
+	if !h.lookupState(c.Sender().ID, stateFlashSale, &session) {
 		return h.HandleFlashSaleStart(c)
 	}
-	session.hours = hours
-	session.step = 3
-	percent := session.percent
-	flashSaleSessions.mu.Unlock()
+	session.Hours = hours
+	h.setState(c.Sender().ID, stateFlashSale, session)
 
-	return h.showFlashConfirm(c, percent, hours)
+	text := fmt.Sprintf(`⚙️ *Ручная настройка*
+
+✅ Скидка: *%d%%*
+✅ Длительность: *%d ч.*
+
+*Когда начать акцию?*
+Выберите вариант или отправьте дату в формате `+"`ДД.ММ ЧЧ:ММ`"+`.
+Анонс будет разослан в момент начала.`, session.Percent, hours)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("▶️ Сейчас", "flash_start_at", "0")),
+		menu.Row(
+			menu.Data("Через 1 ч", "flash_start_at", "60"),
+			menu.Data("Через 3 ч", "flash_start_at", "180"),
+		),
+		menu.Row(
+			menu.Data("Через 12 ч", "flash_start_at", "720"),
+			menu.Data("Через 24 ч", "flash_start_at", "1440"),
+		),
+		menu.Row(menu.Data("❌ Отмена", "flash_cancel")),
+	)
+
+	return c.Edit(text, menu, tele.ModeMarkdown)
+}
+
+// HandleFlashStartAt обрабатывает выбор времени начала (через N минут, 0 = сразу)
+func (h *Handler) HandleFlashStartAt(c tele.Context) error {
+	minutes, err := strconv.Atoi(c.Callback().Data)
+	if err != nil || minutes < 0 {
+		return c.Send("❌ Ошибка")
+	}
+
+	var session flashSaleData
+	if !h.lookupState(c.Sender().ID, stateFlashSale, &session) || session.Hours == 0 {
+		return h.HandleFlashSaleStart(c)
+	}
+
+	session.StartsAt = time.Time{}
+	if minutes > 0 {
+		session.StartsAt = time.Now().Add(time.Duration(minutes) * time.Minute).Truncate(time.Minute)
+	}
+	h.setState(c.Sender().ID, stateFlashSale, session)
+
+	return h.showFlashScope(c, session)
+}
+
+// HandleFlashStartInput принимает дату начала акции текстом (ДД.ММ ЧЧ:ММ)
+func (h *Handler) HandleFlashStartInput(c tele.Context, s *fsm.Session) error {
+	var session flashSaleData
+	if err := s.Decode(&session); err != nil {
+		return err
+	}
+	if session.Hours == 0 {
+		return c.Send("👆 Выберите параметры акции кнопками выше.")
+	}
+
+	now := time.Now()
+	parsed, err := time.ParseInLocation(flashStartLayout, strings.TrimSpace(c.Text()), now.Location())
+	if err != nil {
+		return c.Send("❌ Неверный формат. Отправьте дату в формате `ДД.ММ ЧЧ:ММ`, например `25.12 18:00`", tele.ModeMarkdown)
+	}
+
+	startsAt := time.Date(now.Year(), parsed.Month(), parsed.Day(), parsed.Hour(), parsed.Minute(), 0, 0, now.Location())
+	if startsAt.Before(now.Add(-time.Minute)) {
+		// Дата в прошлом этого года — следующий год (например, в декабре вводят январскую дату)
+		startsAt = startsAt.AddDate(1, 0, 0)
+	}
+	if startsAt.After(now.AddDate(0, 6, 0)) {
+		return c.Send("❌ Акцию можно запланировать не дальше чем на полгода вперёд.")
+	}
+
+	session.StartsAt = startsAt
+	h.setState(c.Sender().ID, stateFlashSale, session)
+
+	return h.showFlashScope(c, session)
+}
+
+// showFlashScope предлагает ограничить акцию продуктом
+func (h *Handler) showFlashScope(c tele.Context, session flashSaleData) error {
+	products, err := h.svc.GetAllProducts(context.Background())
+	if err != nil {
+		return c.Send("❌ Ошибка загрузки продуктов")
+	}
+
+	text := fmt.Sprintf(`⚙️ *Ручная настройка*
+
+✅ Скидка: *%d%%* на *%d ч.*
+✅ Начало: *%s*
+
+*На какие тарифы действует акция?*`, session.Percent, session.Hours, flashStartText(session.StartsAt))
+
+	menu := &tele.ReplyMarkup{}
+	rows := []tele.Row{menu.Row(menu.Data("🌍 Все продукты", "flash_product", "0"))}
+	for _, p := range products {
+		rows = append(rows, menu.Row(menu.Data(fmt.Sprintf("%s %s", p.CountryFlag, p.Name),
+			"flash_product", strconv.FormatInt(p.ID, 10))))
+	}
+	rows = append(rows, menu.Row(menu.Data("❌ Отмена", "flash_cancel")))
+	menu.Inline(rows...)
+
+	return h.editOrResend(c, text, menu)
+}
+
+// HandleFlashProduct обрабатывает выбор продукта и предлагает ограничить акцию сроком
+func (h *Handler) HandleFlashProduct(c tele.Context) error {
+	productID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Send("❌ Ошибка")
+	}
+
+	var session flashSaleData
+	if !h.lookupState(c.Sender().ID, stateFlashSale, &session) || session.Hours == 0 {
+		return h.HandleFlashSaleStart(c)
+	}
+	session.ProductID = productID
+	h.setState(c.Sender().ID, stateFlashSale, session)
+
+	text := `⚙️ *Ручная настройка*
+
+*На какой срок подписки действует скидка?*`
+
+	menu := &tele.ReplyMarkup{}
+	rows := []tele.Row{menu.Row(menu.Data("📅 Все сроки", "flash_months", "0"))}
+	var row tele.Row
+	for _, months := range h.campaignMonthOptions(context.Background(), productID) {
+		row = append(row, menu.Data(fmt.Sprintf("%d мес.", months), "flash_months", strconv.Itoa(months)))
+		if len(row) == 4 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, menu.Row(menu.Data("❌ Отмена", "flash_cancel")))
+	menu.Inline(rows...)
+
+	return c.Edit(text, menu, tele.ModeMarkdown)
+}
+
+// campaignMonthOptions сроки в месяцах из включённых планов продукта (productID = 0 — всех продуктов)
+func (h *Handler) campaignMonthOptions(ctx context.Context, productID int64) []int {
+	products, err := h.svc.GetAllProducts(ctx)
+	if err != nil {
+		log.Printf("Failed to load products: %v", err)
+		return nil
+	}
+
+	seen := make(map[int]bool)
+	var months []int
+	for _, p := range products {
+		if productID != 0 && p.ID != productID {
+			continue
+		}
+		plans, err := h.svc.GetProductPlans(ctx, p.ID)
+		if err != nil {
+			log.Printf("Failed to load plans of product %d: %v", p.ID, err)
+			continue
+		}
+		for _, plan := range plans {
+			if plan.Days == 0 && !seen[plan.Months] {
+				seen[plan.Months] = true
+				months = append(months, plan.Months)
+			}
+		}
+	}
+	sort.Ints(months)
+	return months
+}
+
+// HandleFlashMonths обрабатывает выбор срока и показывает подтверждение
+func (h *Handler) HandleFlashMonths(c tele.Context) error {
+	months, err := strconv.Atoi(c.Callback().Data)
+	if err != nil {
+		return c.Send("❌ Ошибка")
+	}
+
+	var session flashSaleData
+	if !h.lookupState(c.Sender().ID, stateFlashSale, &session) || session.Hours == 0 {
+		return h.HandleFlashSaleStart(c)
+	}
+	session.Months = months
+	h.setState(c.Sender().ID, stateFlashSale, session)
+
+	return h.showFlashConfirm(c, session)
+}
+
+// flashStartText время начала акции для подтверждения
+func flashStartText(startsAt time.Time) string {
+	if startsAt.IsZero() {
+		return "сразу"
+	}
+	return startsAt.Format("02.01 15:04")
 }
 
 // showFlashConfirm показывает подтверждение
-func (h *Handler) showFlashConfirm(c tele.Context, percent, hours int) error {
-	// Рассчитываем цены
-	originalPrice := 450.0
-	newPrice := originalPrice * float64(100-percent) / 100
+func (h *Handler) showFlashConfirm(c tele.Context, session flashSaleData) error {
+	ctx := context.Background()
+	campaign := session.campaign(c.Sender().ID)
 
-	endTime := time.Now().Add(time.Duration(hours) * time.Hour)
+	announceText := "Уведомление будет отправлено всем пользователям."
+	if !session.StartsAt.IsZero() {
+		announceText = "Уведомление будет отправлено всем пользователям в момент начала акции."
+	}
 
 	text := fmt.Sprintf(`🔥 *Подтверждение флеш-распродажи*
 
 📊 *Параметры:*
 • Скидка: *%d%%*
+• Тарифы: *%s*
 • Длительность: *%d ч.*
+• Начало: *%s*
 • Окончание: *%s*
 
 💰 *Цены:*
-• X-RAY MODE: ~%.0f ₽~ → *%.0f ₽*
+%s
 
 📢 *Рассылка:*
-Уведомление будет отправлено всем пользователям.
+%s
 
 *Запустить распродажу?*`,
-		percent, hours, endTime.Format("02.01 15:04"),
-		originalPrice, newPrice)
+		session.Percent, h.campaignScope(ctx, i18n.Default, campaign), session.Hours,
+		flashStartText(session.StartsAt), campaign.EndsAt.Format("02.01 15:04"),
+		h.campaignPriceLines(ctx, i18n.Default, campaign, models.RUB), announceText)
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
@@ -298,108 +512,175 @@ func (h *Handler) showFlashConfirm(c tele.Context, percent, hours int) error {
 		menu.Row(menu.Data("❌ Отмена", "flash_cancel")),
 	)
 
-	if c.Callback() != nil {
-		return c.Edit(text, menu, tele.ModeMarkdown)
-	}
-	return c.Send(text, menu, tele.ModeMarkdown)
+	return h.editOrResend(c, text, menu)
 }
 
-// HandleFlashConfirm подтверждает и запускает флеш-распродажу
+// HandleFlashConfirm подтверждает и создаёт акцию
 func (h *Handler) HandleFlashConfirm(c tele.Context) error {
-	flashSaleSessions.mu.Lock()
-	session, exists := flashSaleSessions.sessions[c.Sender().ID]
-	if !exists || session.step != 3 {
-		flashSaleSessions.mu.Unlock()
+	var session flashSaleData
+	if !h.lookupState(c.Sender().ID, stateFlashSale, &session) || session.Hours == 0 {
 		return c.Send("❌ Сессия истекла. Начните заново: /flashsale")
 	}
-	percent := session.percent
-	hours := session.hours
-	delete(flashSaleSessions.sessions, c.Sender().ID)
-	flashSaleSessions.mu.Unlock()
+	h.resetState(c.Sender().ID)
+
+	if !session.StartsAt.IsZero() && session.StartsAt.Before(time.Now()) {
+		// Подтверждение запоздало — начинаем сразу
+		session.StartsAt = time.Time{}
+	}
+
+	return h.startCampaign(c, session.campaign(c.Sender().ID))
+}
 
-	// Устанавливаем скидку
-	flashSale.Set(percent, hours)
-	endTime := flashSale.GetEndTime()
+// startCampaign сохраняет акцию; если она началась, рассылку запускает обработчик OnCampaignStart
+func (h *Handler) startCampaign(c tele.Context, campaign *models.Campaign) error {
+	created, err := h.svc.CreateCampaign(context.Background(), campaign)
+	if errors.Is(err, service.ErrCampaignInvalid) {
+		return c.Send("❌ Некорректные параметры акции. Начните заново: /flashsale")
+	}
+	if err != nil {
+		log.Printf("[FLASH SALE] Failed to create campaign: %v", err)
+		return c.Send(fmt.Sprintf("❌ Ошибка: %v", err))
+	}
 
-	log.Printf("[FLASH SALE] Admin %d started %d%% sale for %d hours", c.Sender().ID, percent, hours)
+	if created.StartsAt.After(time.Now()) {
+		log.Printf("[FLASH SALE] Admin %d scheduled campaign #%d: %d%% from %s to %s", c.Sender().ID,
+			created.ID, created.DiscountPercent, created.StartsAt.Format("02.01 15:04"), created.EndsAt.Format("02.01 15:04"))
 
-	c.Edit(fmt.Sprintf("✅ *Флеш-распродажа запущена!*\n\nСкидка %d%% активна до %s\n\n📤 Запускаю рассылку...",
-		percent, endTime.Format("02.01 15:04")), tele.ModeMarkdown)
+		return c.Edit(fmt.Sprintf("📅 *Акция #%d запланирована!*\n\nСкидка %d%% с %s до %s\n\n📤 Рассылка начнётся автоматически в момент старта.",
+			created.ID, created.DiscountPercent, created.StartsAt.Format("02.01 15:04"), created.EndsAt.Format("02.01 15:04")), tele.ModeMarkdown)
+	}
 
-	// Запускаем рассылку в горутине
-	go h.broadcastFlashSale(c.Bot(), c.Sender().ID, percent, hours, endTime)
+	log.Printf("[FLASH SALE] Admin %d started campaign #%d: %d%% until %s", c.Sender().ID,
+		created.ID, created.DiscountPercent, created.EndsAt.Format("02.01 15:04"))
 
-	return nil
+	return c.Edit(fmt.Sprintf("✅ *Флеш-распродажа запущена!*\n\n🔥 Скидка: *%d%%*\n⏰ До: *%s*\n\n📤 Запускаю рассылку...",
+		created.DiscountPercent, created.EndsAt.Format("02.01 15:04")), tele.ModeMarkdown)
+}
+
+// campaignScope описание тарифов, на которые действует акция, на языке lang
+func (h *Handler) campaignScope(ctx context.Context, lang string, campaign *models.Campaign) string {
+	scope := i18n.T(lang, "flash.all_plans")
+	if campaign.ProductID != nil {
+		scope = i18n.T(lang, "flash.product", *campaign.ProductID)
+		if product, err := h.svc.GetProductByID(ctx, *campaign.ProductID); err == nil {
+			scope = product.Name
+		}
+	}
+	if campaign.Months != nil {
+		scope += ", " + i18n.T(lang, "period.months_short", *campaign.Months)
+	}
+	return scope
+}
+
+// campaignPriceLines цены продуктов до и после скидки акции на языке lang в валюте cur
+func (h *Handler) campaignPriceLines(ctx context.Context, lang string, campaign *models.Campaign, cur *models.Currency) string {
+	products, err := h.svc.GetAllProducts(ctx)
+	if err != nil {
+		log.Printf("Failed to load products: %v", err)
+		return ""
+	}
+
+	// Для каждого продукта — первый план, на который распространяется акция
+	var lines []string
+	for _, p := range products {
+		plans, err := h.svc.GetProductPlans(ctx, p.ID)
+		if err != nil {
+			log.Printf("Failed to load plans of product %d: %v", p.ID, err)
+			continue
+		}
+		for i := range plans {
+			if !campaign.Covers(&plans[i]) {
+				continue
+			}
+			price := plans[i].CalculatePrice(p.BasePrice)
+			lines = append(lines, i18n.T(lang, "flash.price_line",
+				p.Name, planPeriodTitle(lang, &plans[i]), cur.Format(price), cur.Format(campaign.Apply(price))))
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
 }
 
 // FlashSaleBroadcastImageURL — изображение для рассылки флеш-распродажи
 // TODO: Замените на актуальную ссылку на изображение "СКИДКИ XX%"
 const FlashSaleBroadcastImageURL = "https://drive.google.com/uc?export=view&id=17ZGub9P-QQZ4X8_OTDORSWzuicuE5PD3"
 
-// broadcastFlashSale рассылает уведомление о распродаже с картинкой
-func (h *Handler) broadcastFlashSale(bot *tele.Bot, adminID int64, percent, hours int, endTime time.Time) {
+// broadcastFlashSale рассылает уведомление о начале акции с картинкой; отчёт получает создавший её админ
+func (h *Handler) broadcastFlashSale(bot *tele.Bot, campaign *models.Campaign) {
 	ctx := context.Background()
+	adminID := campaign.CreatedBy
+	notifyAdmin := func(what interface{}, opts ...interface{}) {
+		if adminID != 0 {
+			bot.Send(&tele.User{ID: adminID}, what, opts...)
+		}
+	}
 
-	userIDs, err := h.svc.GetAllUserTelegramIDs(ctx)
+	recipients, err := h.svc.GetBroadcastRecipients(ctx)
 	if err != nil {
 		log.Printf("[FLASH SALE] Failed to get user IDs: %v", err)
-		bot.Send(&tele.User{ID: adminID}, fmt.Sprintf("❌ Ошибка получения списка пользователей: %v", err))
+		notifyAdmin(fmt.Sprintf("❌ Ошибка получения списка пользователей: %v", err))
 		return
 	}
 
-	// Рассчитываем новую цену
-	originalPrice := 450.0
-	newPrice := originalPrice * float64(100-percent) / 100
-
-	// Формируем текст (caption для фото)
-	var hoursText string
-	switch hours {
-	case 1:
-		hoursText = "1 час"
-	case 2, 3, 4:
-		hoursText = fmt.Sprintf("%d часа", hours)
-	default:
-		hoursText = fmt.Sprintf("%d часов", hours)
-	}
-
-	caption := fmt.Sprintf(`🚨 *РАСПРОДАЖА! СКИДКИ -%d%%*
-
-Только ближайшие *%s*!
-Цены на все тарифы снижены. Успей забрать свой VPN за копейки.
-
-💰 X-RAY MODE: ~%.0f ₽~ → *%.0f ₽*
+	hours := int(math.Ceil(time.Until(campaign.EndsAt).Hours()))
+
+	// Текст и цены рассылки — на языке и в валюте получателя; сообщение собирается один раз на пару язык/валюта
+	currencies := make(map[string]*models.Currency)
+	photos := make(map[string]*tele.Photo)
+	menus := make(map[string]*tele.ReplyMarkup)
+	messageFor := func(user *models.User) (*tele.Photo, *tele.ReplyMarkup) {
+		lang := h.svc.UserLanguage(user)
+		cur, ok := currencies[user.Currency]
+		if !ok {
+			cur = h.svc.UserCurrency(ctx, user)
+			currencies[user.Currency] = cur
+		}
 
-⏳ Акция закончится: *%s*`,
-		percent, hoursText,
-		originalPrice, newPrice,
-		endTime.Format("02.01.2006 15:04"))
+		key := lang + ":" + cur.Code
+		if photo, ok := photos[key]; ok {
+			return photo, menus[lang]
+		}
 
-	menu := &tele.ReplyMarkup{}
-	menu.Inline(
-		menu.Row(menu.Data("💎 Выбрать тариф", "tariffs")),
-		menu.Row(menu.Data("⏰ Продлить подписку", "mysubs")),
-		menu.Row(menu.Data("❌ Закрыть", "delete_msg")),
-	)
+		scopeText := i18n.T(lang, "flash.scope_all")
+		if campaign.ProductID != nil || campaign.Months != nil {
+			scopeText = i18n.T(lang, "flash.scope", h.campaignScope(ctx, lang, campaign))
+		}
+		caption := i18n.T(lang, "flash.caption",
+			campaign.DiscountPercent, i18n.N(lang, "flash.hours", hours), scopeText,
+			h.campaignPriceLines(ctx, lang, campaign, cur),
+			campaign.EndsAt.Format("02.01.2006 15:04"))
+		photos[key] = &tele.Photo{
+			File:    tele.FromURL(FlashSaleBroadcastImageURL),
+			Caption: caption,
+		}
 
-	// Создаём фото с caption
-	photo := &tele.Photo{
-		File:    tele.FromURL(FlashSaleBroadcastImageURL),
-		Caption: caption,
+		if _, ok := menus[lang]; !ok {
+			menu := &tele.ReplyMarkup{}
+			menu.Inline(
+				menu.Row(menu.Data(i18n.T(lang, "common.choose_tariff"), "tariffs")),
+				menu.Row(menu.Data(i18n.T(lang, "flash.extend"), "mysubs")),
+				menu.Row(menu.Data(i18n.T(lang, "common.close"), "delete_msg")),
+			)
+			menus[lang] = menu
+		}
+		return photos[key], menus[lang]
 	}
 
-	totalUsers := len(userIDs)
+	totalUsers := len(recipients)
 	var sent, failed int
 	ticker := time.NewTicker(50 * time.Millisecond) // 20 messages per second
 	defer ticker.Stop()
 
-	for _, userID := range userIDs {
+	for i := range recipients {
 		<-ticker.C
 
-		_, err := bot.Send(&tele.User{ID: userID}, photo, menu, tele.ModeMarkdown)
+		user := &recipients[i]
+		photo, menu := messageFor(user)
+		_, err := bot.Send(&tele.User{ID: user.TelegramID}, photo, menu, tele.ModeMarkdown)
 		if err != nil {
 			failed++
 			if !strings.Contains(err.Error(), "blocked") && !strings.Contains(err.Error(), "deactivated") {
-				log.Printf("[FLASH SALE] Failed for user %d: %v", userID, err)
+				log.Printf("[FLASH SALE] Failed for user %d: %v", user.TelegramID, err)
 			}
 		} else {
 			sent++
@@ -407,35 +688,32 @@ func (h *Handler) broadcastFlashSale(bot *tele.Bot, adminID int64, percent, hour
 
 		// Прогресс каждые 100 пользователей
 		if (sent+failed)%100 == 0 && totalUsers > 100 {
-			bot.Send(&tele.User{ID: adminID},
-				fmt.Sprintf("📤 Прогресс рассылки: %d/%d", sent+failed, totalUsers))
+			notifyAdmin(fmt.Sprintf("📤 Прогресс рассылки: %d/%d", sent+failed, totalUsers))
 		}
 	}
 
-	log.Printf("[FLASH SALE] Broadcast finished. Sent: %d, Failed: %d", sent, failed)
+	log.Printf("[FLASH SALE] Campaign #%d broadcast finished. Sent: %d, Failed: %d", campaign.ID, sent, failed)
 
-	bot.Send(&tele.User{ID: adminID},
-		fmt.Sprintf("✅ *Рассылка завершена!*\n\n📤 Отправлено: %d\n❌ Ошибок: %d\n📊 Всего: %d\n\n🔥 Распродажа активна до %s",
-			sent, failed, totalUsers, endTime.Format("02.01 15:04")), tele.ModeMarkdown)
+	notifyAdmin(fmt.Sprintf("✅ *Рассылка завершена!*\n\n📤 Отправлено: %d\n❌ Ошибок: %d\n📊 Всего: %d\n\n🔥 Акция #%d активна до %s",
+		sent, failed, totalUsers, campaign.ID, campaign.EndsAt.Format("02.01 15:04")), tele.ModeMarkdown)
 }
 
 // HandleFlashCancel отменяет создание флеш-распродажи
 func (h *Handler) HandleFlashCancel(c tele.Context) error {
-	flashSaleSessions.mu.Lock()
-	delete(flashSaleSessions.sessions, c.Sender().ID)
-	flashSaleSessions.mu.Unlock()
-
 	return h.HandleAdmin(c)
 }
 
-// HandleStopSale останавливает текущую распродажу
+// HandleStopSale останавливает текущие акции (запланированные остаются)
 func (h *Handler) HandleStopSale(c tele.Context) error {
-	if !flashSale.IsActive() {
+	stopped, err := h.svc.StopActiveCampaigns(context.Background())
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Ошибка: %v", err))
+	}
+	if stopped == 0 {
 		return c.Send("ℹ️ Сейчас нет активных распродаж.")
 	}
 
-	flashSale.Clear()
-	log.Printf("[FLASH SALE] Admin %d stopped flash sale", c.Sender().ID)
+	log.Printf("[FLASH SALE] Admin %d stopped %d campaign(s)", c.Sender().ID, stopped)
 
 	return c.Send("✅ Флеш-распродажа остановлена. Цены вернулись к обычным.")
 }
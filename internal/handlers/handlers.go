@@ -2,12 +2,17 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
 	"strings"
 	"time"
 
+	"vpn-telegram-bot/internal/fsm"
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/payment"
 	"vpn-telegram-bot/internal/service"
 
 	tele "gopkg.in/telebot.v3"
@@ -24,14 +29,22 @@ type Handler struct {
 	svc            *service.Service
 	adminIDs       []int64
 	supportGroupID int64
+	fsm            *fsm.Machine // состояния диалогов (мастера, ожидание ввода)
+
+	sbpDetails    string // реквизиты ручной оплаты СБП (пусто = оплата через поддержку)
+	receiptChatID int64  // чат проверки чеков СБП
+
+	subBaseURL string // внешний адрес для ссылок подписки /sub/{token} (пусто = выдаётся ключ)
 }
 
 // New создаёт новый handler
-func New(svc *service.Service, adminIDs []int64, supportGroupID int64) *Handler {
+func New(svc *service.Service, adminIDs []int64, supportGroupID int64, sessions *fsm.Machine) *Handler {
 	return &Handler{
 		svc:            svc,
 		adminIDs:       adminIDs,
 		supportGroupID: supportGroupID,
+		fsm:            sessions,
+		receiptChatID:  supportGroupID,
 	}
 }
 
@@ -63,6 +76,11 @@ func (h *Handler) Register(b *tele.Bot) {
 	b.Handle(&tele.Btn{Unique: "sub"}, h.HandleSubDetail)
 	b.Handle(&tele.Btn{Unique: "copy_key"}, h.HandleCopyKey)
 	b.Handle(&tele.Btn{Unique: "extend"}, h.HandleExtend)
+	h.RegisterTraffic(b)
+	h.RegisterDevices(b)
+	h.RegisterKeys(b)
+	h.RegisterTrial(b)
+	h.RegisterAutoRenew(b)
 
 	// Instructions
 	b.Handle(&tele.Btn{Unique: "instr_android"}, h.HandleInstrAndroid)
@@ -87,11 +105,15 @@ func (h *Handler) Register(b *tele.Bot) {
 	b.Handle(&tele.Btn{Unique: "topup_amount"}, h.HandleTopUpAmount)
 	b.Handle(&tele.Btn{Unique: "topup_pay_card"}, h.HandleTopUpPayCard)
 	b.Handle(&tele.Btn{Unique: "topup_pay_crypto"}, h.HandleTopUpPayCrypto)
-	b.Handle(&tele.Btn{Unique: "pay_balance"}, h.HandlePayWithBalance)
+	b.Handle(&tele.Btn{Unique: "pay_balance"}, h.HandlePayWithBalance, h.IdempotencyMiddleware())
+	h.RegisterPayments(b)
+	h.RegisterCurrency(b)
+	h.RegisterSettings(b)
 	b.Handle(&tele.Btn{Unique: "promo_enter"}, h.HandlePromoEnter)
+	h.fsm.Handle(stateUserPromo, h.HandleUserPromoInput)
 
 	// Subscription Extension
-	b.Handle(&tele.Btn{Unique: "extend_pay"}, h.HandleExtendPay)
+	b.Handle(&tele.Btn{Unique: "extend_pay"}, h.HandleExtendPay, h.IdempotencyMiddleware())
 
 	// Referral System
 	b.Handle(&tele.Btn{Unique: "ref_system"}, h.HandleRefSystem)
@@ -148,34 +170,32 @@ func (h *Handler) HandleBackToMain(c tele.Context) error {
 
 // showMainMenu отображает главное меню
 func (h *Handler) showMainMenu(c tele.Context, edit bool) error {
-	text := `⚡️ *Система X-RAY VPN активирована.*
-
-Добро пожаловать в цифровую тень. Твой трафик теперь проходит сквозь любые преграды, оставаясь невидимым для посторонних глаз.
-
-*Почему мы?*
-👻 *Абсолютная анонимность:* Мы не ведем логи. В системе сохраняется только твой Telegram ID и факт оплаты для доступа к ключу. Твоя история браузера — только твоя.
-🛡 *Максимальная защита:* Твои данные в броне протокола Reality.
-📱 *Мульти-доступ:* Подключай до 3-х устройств на один ключ (Телефон + ПК + Планшет).
-🚀 *Космическая скорость:* Смотри 4K видео и играй без лагов.
-
-Твой интернет — твои правила. Включай X-RAY.`
+	lang := h.lang(c)
+	text := i18n.T(lang, "menu.text")
 
 	menu := &tele.ReplyMarkup{}
-	btnTariffs := menu.Data("💎 Тарифы", "tariffs")
-	btnMySubs := menu.Data("🔑 Мои подписки", "mysubs")
-	btnBalance := menu.Data("💰 Баланс", "balance")
-	btnPromo := menu.Data("🎟 Промокод", "promo_enter")
-	btnRefSystem := menu.Data("👥 Партнёрка", "ref_system")
-	btnHelp := menu.Data("🛟 Помощь", "help")
-	btnChannel := menu.URL("📢 Канал", "https://t.me/XRAY_MODE")
-	btnChat := menu.URL("💬 Чат", "https://t.me/XRAY_LUV")
+	btnTariffs := menu.Data(i18n.T(lang, "menu.tariffs"), "tariffs")
+	btnMySubs := menu.Data(i18n.T(lang, "common.my_subs"), "mysubs")
+	btnBalance := menu.Data(i18n.T(lang, "menu.balance"), "balance")
+	btnPromo := menu.Data(i18n.T(lang, "menu.promo"), "promo_enter")
+	btnRefSystem := menu.Data(i18n.T(lang, "menu.referral"), "ref_system")
+	btnHelp := menu.Data(i18n.T(lang, "menu.help"), "help")
+	btnChannel := menu.URL(i18n.T(lang, "menu.channel"), "https://t.me/XRAY_MODE")
+	btnChat := menu.URL(i18n.T(lang, "menu.chat"), "https://t.me/XRAY_LUV")
+	btnSettings := menu.Data(i18n.T(lang, "menu.settings"), "settings")
 
-	menu.Inline(
+	var rows []tele.Row
+	if h.trialAvailable(c) {
+		rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "menu.trial"), "trial")))
+	}
+	rows = append(rows,
 		menu.Row(btnTariffs, btnMySubs),
 		menu.Row(btnBalance, btnPromo),
 		menu.Row(btnRefSystem, btnHelp),
+		menu.Row(btnSettings),
 		menu.Row(btnChannel, btnChat),
 	)
+	menu.Inline(rows...)
 
 	if UseBannerImages {
 		photo := &tele.Photo{
@@ -196,36 +216,41 @@ func (h *Handler) showMainMenu(c tele.Context, edit bool) error {
 
 // ================= TARIFFS =================
 
+// xrayModeProductID X-RAY MODE имеет product_id = 1 (Мульти в базе)
+const xrayModeProductID int64 = 1
+
 // HandleTariffs показывает тарифы
 func (h *Handler) HandleTariffs(c tele.Context) error {
-	const basePrice = 450.0
-
-	var text string
-	var btnText string
-
-	// Проверяем активную флеш-распродажу
-	if flashSale.IsActive() {
-		discount := flashSale.GetDiscount()
-		newPrice := flashSale.ApplyDiscount(basePrice)
-		endTime := flashSale.GetEndTime()
-
-		text = fmt.Sprintf(`🔥 *РАСПРОДАЖА -%d%%!*
-⏳ До окончания: *%s*
+	ctx := context.Background()
+	lang := h.lang(c)
 
-🌍 *Выберите тариф:*`, discount, endTime.Format("02.01 15:04"))
+	text := i18n.T(lang, "tariffs.title")
+	btnText := "🌍 X-RAY MODE"
+	cur := h.senderCurrency(c)
 
-		btnText = fmt.Sprintf("🌍 X-RAY MODE — ~%.0f~ %.0f ₽/мес 🔥", basePrice, newPrice)
-	} else {
-		text = `🌍 *Выберите тариф:*`
+	// Цена первого плана (с учётом акции) — на кнопке тарифа
+	if product, err := h.svc.GetProductByID(ctx, xrayModeProductID); err == nil {
+		prices, err := h.svc.GetPlanPrices(ctx, product)
+		if err != nil {
+			log.Printf("Failed to resolve prices: %v", err)
+		}
+		if len(prices) > 0 {
+			price := prices[0]
+			if price.Campaign != nil {
+				text = i18n.T(lang, "tariffs.sale", price.Campaign.DiscountPercent, price.Campaign.EndsAt.Format("02.01 15:04"))
 
-		btnText = "🌍 X-RAY MODE — 450 ₽/мес"
+				btnText = i18n.T(lang, "tariffs.button_sale", cur.Format(price.BasePrice), cur.Format(price.Price), planPeriodTitle(lang, price.Plan))
+			} else {
+				btnText = i18n.T(lang, "tariffs.button", cur.Format(price.Price), planPeriodTitle(lang, price.Plan))
+			}
+		}
 	}
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
 		menu.Row(menu.Data(btnText, "xray_mode")),
-		menu.Row(menu.URL("⭐️ Отзывы (Чат)", "https://t.me/XRAY_LUV")),
-		menu.Row(menu.Data("⬅️ Вернуться", "back_main")),
+		menu.Row(menu.URL(i18n.T(lang, "tariffs.reviews"), "https://t.me/XRAY_LUV")),
+		menu.Row(menu.Data(i18n.T(lang, "common.return"), "back_main")),
 	)
 
 	if UseBannerImages {
@@ -242,79 +267,46 @@ func (h *Handler) HandleTariffs(c tele.Context) error {
 
 // HandleXRayMode показывает описание X-RAY MODE и выбор периода
 func (h *Handler) HandleXRayMode(c tele.Context) error {
-	// X-RAY MODE имеет product_id = 1 (Мульти в базе)
-	const xrayModeProductID int64 = 1
-	const basePrice float64 = 450
-
-	var text string
-
-	// Проверяем флеш-распродажу
-	if flashSale.IsActive() {
-		discount := flashSale.GetDiscount()
-		endTime := flashSale.GetEndTime()
-
-		text = fmt.Sprintf(`🔥 *РАСПРОДАЖА -%d%%!*
-⏳ До: *%s*
+	ctx := context.Background()
+	lang := h.lang(c)
 
-🚀 *X-RAY MODE*
-🇵🇱 Польша (Premium) — Ультра-низкий пинг
-🔜 _Новые страны появятся автоматически!_
+	product, err := h.svc.GetProductByID(ctx, xrayModeProductID)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.product_not_found"))
+	}
 
-🛡 До 3-х устройств | ⚡️ Безлимит | 🔒 VLESS
+	prices, err := h.svc.GetPlanPrices(ctx, product)
+	if err != nil {
+		log.Printf("Failed to resolve prices: %v", err)
+		return c.Send(i18n.T(lang, "error.retry"))
+	}
 
-👇 *Выберите период:*`, discount, endTime.Format("02.01 15:04"))
-	} else {
-		text = `🚀 *X-RAY MODE*
+	cur := h.senderCurrency(c)
 
-🇵🇱 *Польша (Premium)* — Ультра-низкий пинг
-🔜 _Новые страны (🇳🇱 🇩🇪 🇺🇸) появятся автоматически!_
+	// Лучшая акция среди сроков — для заголовка
+	var campaign *models.Campaign
+	for _, p := range prices {
+		if p.Campaign != nil && (campaign == nil || p.Campaign.DiscountPercent > campaign.DiscountPercent) {
+			campaign = p.Campaign
+		}
+	}
 
-🛡 До 3-х устройств | ⚡️ Безлимит | 🔒 VLESS
+	text := i18n.T(lang, "xray.text")
 
-👇 *Выберите период:*`
+	// Проверяем флеш-распродажу
+	if campaign != nil {
+		text = i18n.T(lang, "xray.sale", campaign.DiscountPercent, campaign.EndsAt.Format("02.01 15:04"))
 	}
 
-	plans := h.svc.GetPricingPlans(basePrice)
-
 	menu := &tele.ReplyMarkup{}
 	var rows []tele.Row
 
-	// Применяем флеш-скидку к ценам
-	flashDiscount := flashSale.GetDiscount()
-
-	for _, plan := range plans {
-		var btnText string
-		finalPrice := plan.Price
-
-		// Применяем флеш-скидку
-		if flashDiscount > 0 {
-			finalPrice = plan.Price * float64(100-flashDiscount) / 100
-		}
-
-		if flashDiscount > 0 {
-			// С флеш-скидкой показываем старую и новую цену
-			if plan.Discount > 0 {
-				btnText = fmt.Sprintf("%d мес. ~%d~ %d ₽ 🔥", plan.Months, int(plan.Price), int(finalPrice))
-			} else if plan.Months == 1 {
-				btnText = fmt.Sprintf("1 мес. ~%d~ %d ₽ 🔥", int(plan.Price), int(finalPrice))
-			} else {
-				btnText = fmt.Sprintf("%d мес. ~%d~ %d ₽ 🔥", plan.Months, int(plan.Price), int(finalPrice))
-			}
-		} else {
-			// Обычные цены
-			if plan.Discount > 0 {
-				btnText = fmt.Sprintf("%d месяцев (-%d%%) — %d ₽", plan.Months, plan.Discount, int(plan.Price))
-			} else if plan.Months == 1 {
-				btnText = fmt.Sprintf("1 месяц — %d ₽", int(plan.Price))
-			} else {
-				btnText = fmt.Sprintf("%d месяца — %d ₽", plan.Months, int(plan.Price))
-			}
-		}
-		btn := menu.Data(btnText, "plan", fmt.Sprintf("%d:%d", xrayModeProductID, plan.Months))
+	for _, plan := range prices {
+		btn := menu.Data(planButtonText(plan, planPeriodText(lang, plan.Plan), cur), "plan", strconv.FormatInt(plan.Plan.ID, 10))
 		rows = append(rows, menu.Row(btn))
 	}
 
-	rows = append(rows, menu.Row(menu.Data("⬅️ Назад", "tariffs")))
+	rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "common.back"), "tariffs")))
 	menu.Inline(rows...)
 
 	if UseBannerImages {
@@ -331,43 +323,45 @@ func (h *Handler) HandleXRayMode(c tele.Context) error {
 
 // HandleProductSelect показывает детали продукта
 func (h *Handler) HandleProductSelect(c tele.Context) error {
+	ctx := context.Background()
 	productID, _ := strconv.ParseInt(c.Callback().Data, 10, 64)
 
-	product, err := h.svc.GetProductByID(context.Background(), productID)
+	lang := h.lang(c)
+
+	product, err := h.svc.GetProductByID(ctx, productID)
 	if err != nil {
-		return c.Send("❌ Продукт не найден")
+		return c.Send(i18n.T(lang, "error.product_not_found"))
 	}
+	cur := h.senderCurrency(c)
 
-	plans := h.svc.GetPricingPlans(product.BasePrice)
-
-	text := fmt.Sprintf(`%s *%s*
-
-💰 Базовая цена: %d ₽/мес
-📝 %s
+	plans, err := h.svc.GetPlanPrices(ctx, product)
+	if err != nil {
+		log.Printf("Failed to resolve prices: %v", err)
+		return c.Send(i18n.T(lang, "error.retry"))
+	}
 
-*Скидки:*
-• 6 месяцев — скидка 10%%
-• 12 месяцев — скидка 20%%
+	var discounts []string
+	for _, plan := range plans {
+		if plan.PeriodDiscount > 0 {
+			discounts = append(discounts, i18n.T(lang, "product.discount_line", planPeriodText(lang, plan.Plan), plan.PeriodDiscount))
+		}
+	}
+	var discountText string
+	if len(discounts) > 0 {
+		discountText = "\n" + i18n.T(lang, "product.discounts") + "\n" + strings.Join(discounts, "\n") + "\n"
+	}
 
-Выберите срок подписки:`, product.CountryFlag, product.Name, int(product.BasePrice), product.Description)
+	text := i18n.T(lang, "product.text", product.CountryFlag, product.Name, cur.Format(product.BasePrice), product.Description, discountText)
 
 	menu := &tele.ReplyMarkup{}
 	var rows []tele.Row
 
 	for _, plan := range plans {
-		var btnText string
-		if plan.Discount > 0 {
-			btnText = fmt.Sprintf("%d месяцев (-%d%%) — %d ₽", plan.Months, plan.Discount, int(plan.Price))
-		} else if plan.Months == 1 {
-			btnText = fmt.Sprintf("1 месяц — %d ₽", int(plan.Price))
-		} else {
-			btnText = fmt.Sprintf("%d месяца — %d ₽", plan.Months, int(plan.Price))
-		}
-		btn := menu.Data(btnText, "plan", fmt.Sprintf("%d:%d", productID, plan.Months))
+		btn := menu.Data(planButtonText(plan, planPeriodText(lang, plan.Plan), cur), "plan", strconv.FormatInt(plan.Plan.ID, 10))
 		rows = append(rows, menu.Row(btn))
 	}
 
-	rows = append(rows, menu.Row(menu.Data("⬅️ Вернуться", "tariffs")))
+	rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "common.return"), "tariffs")))
 	menu.Inline(rows...)
 
 	return c.Edit(text, menu, tele.ModeMarkdown)
@@ -375,70 +369,61 @@ func (h *Handler) HandleProductSelect(c tele.Context) error {
 
 // HandlePlanSelect обрабатывает выбор плана
 func (h *Handler) HandlePlanSelect(c tele.Context) error {
-	parts := strings.Split(c.Callback().Data, ":")
-	if len(parts) != 2 {
-		return c.Send("❌ Ошибка")
-	}
-
-	productID, _ := strconv.ParseInt(parts[0], 10, 64)
-	months, _ := strconv.Atoi(parts[1])
-
-	product, err := h.svc.GetProductByID(context.Background(), productID)
+	plan, product, err := h.planFromCallback(c)
 	if err != nil {
-		return c.Send("❌ Продукт не найден")
+		return h.planUnavailable(c, err)
 	}
+	lang := h.lang(c)
 
-	price, discount := h.svc.CalculatePrice(product.BasePrice, months)
-	originalPrice := price
-
-	// Применяем флеш-скидку
-	flashDiscount := flashSale.GetDiscount()
-	if flashDiscount > 0 {
-		price = flashSale.ApplyDiscount(price)
+	planPrice, err := h.svc.ResolvePlanPrice(context.Background(), product, plan)
+	if err != nil {
+		log.Printf("Failed to resolve price: %v", err)
+		return c.Send(i18n.T(lang, "error.retry"))
 	}
+	price := planPrice.Price
 
 	var discountText string
-	if flashDiscount > 0 {
-		discountText = fmt.Sprintf(" 🔥 *АКЦИЯ -%d%%!*", flashDiscount)
-	} else if discount > 0 {
-		discountText = fmt.Sprintf(" (скидка %d%%)", discount)
+	if planPrice.Campaign != nil {
+		discountText = i18n.T(lang, "plan.campaign", planPrice.Campaign.DiscountPercent)
+	} else if planPrice.PeriodDiscount > 0 {
+		discountText = i18n.T(lang, "plan.discount", planPrice.PeriodDiscount)
 	}
 
+	// Счёт выставляется в рублях: в другой валюте показываем и рублёвую сумму
+	cur := h.senderCurrency(c)
 	var priceText string
-	if flashDiscount > 0 {
-		priceText = fmt.Sprintf("~%.0f~ *%.0f* ₽", originalPrice, price)
+	if planPrice.Campaign != nil {
+		priceText = fmt.Sprintf("~%s~ *%s*", cur.Format(planPrice.BasePrice), cur.FormatWithBase(price))
 	} else {
-		priceText = fmt.Sprintf("%d ₽", int(price))
+		priceText = cur.FormatWithBase(price)
 	}
 
-	// Формируем текст срока
-	var periodText string
-	if months == 1 {
-		periodText = "1 месяц"
-	} else if months < 5 {
-		periodText = fmt.Sprintf("%d месяца", months)
-	} else {
-		periodText = fmt.Sprintf("%d месяцев", months)
+	var trafficText string
+	if quota := plan.TrafficQuota(product); quota.LimitGB > 0 {
+		trafficText = i18n.T(lang, "plan.traffic", quota.LimitGB)
+		if quota.Reset == models.TrafficResetMonth {
+			trafficText += i18n.T(lang, "plan.traffic_monthly")
+		}
+	}
+	if devices := plan.Limits(product).Devices; devices > 0 {
+		trafficText += i18n.T(lang, "plan.devices", devices)
 	}
 
-	text := fmt.Sprintf(`💳 *Счёт на оплату*
-—————————————————
-💎 *Тариф:* %s %s (%s)
-💰 *Сумма:* %s%s
-
-🎁 *БОНУС: +7 ДНЕЙ В ПОДАРОК!*
-При оплате *Криптовалютой* (USDT, TON, BTC) срок вашей подписки увеличится автоматически.
-✅ _Бонус начислится сразу после оплаты._
-
-👇 *Выберите способ оплаты:*`, product.CountryFlag, product.Name, periodText, priceText, discountText)
+	text := i18n.T(lang, "plan.invoice", product.CountryFlag, product.Name, planPeriodText(lang, plan), priceText, discountText, trafficText)
 
 	menu := &tele.ReplyMarkup{}
-	menu.Inline(
-		menu.Row(menu.Data("💠 СБП (Быстрый платёж)", "pay_card", c.Callback().Data)),
-		menu.Row(menu.Data("🌑 Криптовалюта (+7 дней 🎁)", "pay_crypto", c.Callback().Data)),
-		menu.Row(menu.Data("💰 С баланса", "pay_balance", c.Callback().Data)),
-		menu.Row(menu.Data("⬅️ Назад", "xray_mode")),
+	rows := []tele.Row{
+		menu.Row(menu.Data(i18n.T(lang, "pay.sbp"), "pay_card", c.Callback().Data)),
+		menu.Row(menu.Data(i18n.T(lang, "pay.crypto"), "pay_crypto", c.Callback().Data)),
+	}
+	if h.svc.StarsEnabled() {
+		rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "pay.stars"), "pay_stars", c.Callback().Data)))
+	}
+	rows = append(rows,
+		menu.Row(menu.Data(i18n.T(lang, "pay.balance"), "pay_balance", c.Callback().Data)),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "xray_mode")),
 	)
+	menu.Inline(rows...)
 
 	if UseBannerImages {
 		photo := &tele.Photo{
@@ -458,45 +443,43 @@ func (h *Handler) HandlePlanSelect(c tele.Context) error {
 func (h *Handler) HandleMySubs(c tele.Context) error {
 	log.Printf("👉 HandleMySubs triggered for User: %d", c.Sender().ID)
 
+	lang := h.lang(c)
 	user, err := h.svc.GetOrCreateUser(context.Background(), c.Sender().ID, c.Sender().Username)
 	if err != nil {
-		return c.Send("❌ Ошибка")
+		return c.Send(i18n.T(lang, "error.generic"))
 	}
 
 	subs, err := h.svc.GetUserSubscriptions(context.Background(), user.ID)
 	if err != nil {
-		return c.Send("❌ Ошибка загрузки подписок")
+		return c.Send(i18n.T(lang, "subs.load_error"))
 	}
 
 	var text string
 	menu := &tele.ReplyMarkup{}
 
 	if len(subs) == 0 {
-		text = `🔑 *Ваши подписки*
-
-У вас пока нет активных подписок.
-Выберите тариф и подключайтесь! 🚀`
+		text = i18n.T(lang, "subs.empty")
 
 		menu.Inline(
-			menu.Row(menu.Data("💎 Выбрать тариф", "tariffs")),
-			menu.Row(menu.Data("⬅️ Назад", "back_main")),
+			menu.Row(menu.Data(i18n.T(lang, "common.choose_tariff"), "tariffs")),
+			menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_main")),
 		)
 	} else {
-		text = "🔑 *Ваши подписки:*\n"
+		text = i18n.T(lang, "subs.title") + "\n"
 
 	var rows []tele.Row
 	for _, sub := range subs {
 		status := ""
 		if sub.ExpiresAt.Before(time.Now()) || !sub.IsActive {
-			status = " [Истёк]"
+			status = i18n.T(lang, "subs.expired_mark")
 		}
 
-		btnText := fmt.Sprintf("%s %s №%d%s", sub.Product.CountryFlag, sub.Product.Name, sub.ID, status)
+		btnText := i18n.T(lang, "subs.button", sub.Product.CountryFlag, sub.Product.Name, sub.ID, status)
 		btn := menu.Data(btnText, "sub", strconv.FormatInt(sub.ID, 10))
 		rows = append(rows, menu.Row(btn))
 	}
 
-		rows = append(rows, menu.Row(menu.Data("⬅️ Назад", "back_main")))
+		rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_main")))
 	menu.Inline(rows...)
 	}
 
@@ -516,39 +499,66 @@ func (h *Handler) HandleMySubs(c tele.Context) error {
 func (h *Handler) HandleSubDetail(c tele.Context) error {
 	log.Printf("👉 HandleSubDetail triggered for User: %d, Data: %s", c.Sender().ID, c.Callback().Data)
 
+	lang := h.lang(c)
 	subID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
 	if err != nil {
 		log.Printf("❌ HandleSubDetail: invalid subID: %v", err)
-		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
 	}
 
 	sub, err := h.svc.GetSubscriptionByID(context.Background(), subID)
 	if err != nil {
 		log.Printf("❌ HandleSubDetail: subscription not found: %v", err)
-		return c.Send("❌ Подписка не найдена")
+		return c.Send(i18n.T(lang, "error.sub_not_found"))
 	}
 
-	status := "✅ Активна"
+	status := i18n.T(lang, "sub.active")
 	if sub.ExpiresAt.Before(time.Now()) || !sub.IsActive {
-		status = "❌ Истекла"
+		status = i18n.T(lang, "sub.expired")
 	}
 
-	text := fmt.Sprintf(`📦 *Подписка №%d* %s %s
+	active := sub.IsActive && sub.ExpiresAt.After(time.Now())
+	var details string
+	if active {
+		details = h.subTrafficText(lang, sub) + h.subDevicesText(lang, sub) + h.subAutoRenewText(lang, sub)
+	}
 
-%s
-📅 До: *%s*
+	keyText := i18n.T(lang, "sub.key")
+	copyText := i18n.T(lang, "sub.copy_key")
+	if subURL := h.subscriptionURL(sub); subURL != "" {
+		keyText = i18n.T(lang, "sub.url", subURL)
+		copyText = i18n.T(lang, "sub.copy_url")
+	}
 
-🔑 *Ключ:* (нажми кнопку ниже)`, sub.ID, sub.Product.CountryFlag, sub.Product.Name, status, sub.ExpiresAt.Format("02.01.2006 15:04"))
+	text := i18n.T(lang, "sub.card", sub.ID, sub.Product.CountryFlag, sub.Product.Name, status, sub.ExpiresAt.Format("02.01.2006 15:04"), details, keyText)
 
+	id := strconv.FormatInt(subID, 10)
 	menu := &tele.ReplyMarkup{}
-	menu.Inline(
-		menu.Row(menu.Data("📋 Скопировать ключ", "copy_key", strconv.FormatInt(subID, 10))),
+	rows := []tele.Row{
+		menu.Row(menu.Data(copyText, "copy_key", id)),
 		menu.Row(
-			menu.Data("🔄 Продлить", "extend", strconv.FormatInt(subID, 10)),
-			menu.Data("📚 Инструкция", "instruction"),
+			menu.Data(i18n.T(lang, "sub.extend"), "extend", id),
+			menu.Data(i18n.T(lang, "common.instruction"), "instruction"),
 		),
-		menu.Row(menu.Data("⬅️ Назад", "mysubs")),
-	)
+	}
+	if active && sub.TrafficLimit > 0 {
+		rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "sub.buy_traffic"), "traffic_packs", id)))
+	}
+	if active && h.canBuyDeviceSlots(sub) {
+		rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "sub.buy_device"), "device_slots", id)))
+	}
+	if active {
+		autoRenewText := i18n.T(lang, "sub.autorenew_enable")
+		if sub.AutoRenew {
+			autoRenewText = i18n.T(lang, "sub.autorenew_disable")
+		}
+		rows = append(rows,
+			menu.Row(menu.Data(autoRenewText, "auto_renew", id)),
+			menu.Row(menu.Data(i18n.T(lang, "sub.rotate_key"), "key_rotate", id)),
+		)
+	}
+	rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "common.back"), "mysubs")))
+	menu.Inline(rows...)
 
 	if UseBannerImages {
 		photo := &tele.Photo{
@@ -566,15 +576,16 @@ func (h *Handler) HandleSubDetail(c tele.Context) error {
 func (h *Handler) HandleCopyKey(c tele.Context) error {
 	subID, _ := strconv.ParseInt(c.Callback().Data, 10, 64)
 
+	lang := h.lang(c)
 	sub, err := h.svc.GetSubscriptionByID(context.Background(), subID)
 	if err != nil {
-		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
 	}
 
-	// Отправляем ключ отдельным сообщением для удобного копирования
-	c.Send(fmt.Sprintf("`%s`", sub.KeyString), tele.ModeMarkdown)
+	// Отправляем ссылку подписки (или ключ) отдельным сообщением для удобного копирования
+	c.Send(fmt.Sprintf("`%s`", h.subKey(sub)), tele.ModeMarkdown)
 
-	return c.Respond(&tele.CallbackResponse{Text: "✅ Ключ отправлен"})
+	return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "sub.key_sent")})
 }
 
 // HandleExtend показывает варианты продления
@@ -582,36 +593,31 @@ func (h *Handler) HandleExtend(c tele.Context) error {
 	log.Printf("👉 HandleExtend triggered for User: %d", c.Sender().ID)
 
 	subID, _ := strconv.ParseInt(c.Callback().Data, 10, 64)
+	lang := h.lang(c)
 
 	sub, err := h.svc.GetSubscriptionByID(context.Background(), subID)
 	if err != nil {
-		return c.Send("❌ Подписка не найдена")
+		return c.Send(i18n.T(lang, "error.sub_not_found"))
 	}
 
-	plans := h.svc.GetPricingPlans(sub.Product.BasePrice)
-
-	text := fmt.Sprintf(`🔄 *Продление подписки №%d*
-
-%s %s
-📅 Текущий срок: до %s
+	plans, err := h.svc.GetPlanPrices(context.Background(), sub.Product)
+	if err != nil {
+		log.Printf("Failed to resolve prices: %v", err)
+		return c.Send(i18n.T(lang, "error.retry"))
+	}
 
-Выберите период (+к текущему сроку):`, sub.ID, sub.Product.CountryFlag, sub.Product.Name, sub.ExpiresAt.Format("02.01.2006"))
+	text := i18n.T(lang, "extend.text", sub.ID, sub.Product.CountryFlag, sub.Product.Name, sub.ExpiresAt.Format("02.01.2006"))
 
+	cur := h.senderCurrency(c)
 	menu := &tele.ReplyMarkup{}
 	var rows []tele.Row
 
 	for _, plan := range plans {
-		var btnText string
-		if plan.Discount > 0 {
-			btnText = fmt.Sprintf("%d мес. (-%d%%) — %d ₽", plan.Months, plan.Discount, int(plan.Price))
-		} else {
-			btnText = fmt.Sprintf("%d мес. — %d ₽", plan.Months, int(plan.Price))
-		}
-		btn := menu.Data(btnText, "extend_pay", fmt.Sprintf("%d:%d", subID, plan.Months))
+		btn := menu.Data(planButtonText(plan, planPeriodTitle(lang, plan.Plan), cur), "extend_pay", fmt.Sprintf("%d:%d", subID, plan.Plan.ID))
 		rows = append(rows, menu.Row(btn))
 	}
 
-	rows = append(rows, menu.Row(menu.Data("⬅️ Назад", "sub", strconv.FormatInt(subID, 10))))
+	rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "common.back"), "sub", strconv.FormatInt(subID, 10))))
 	menu.Inline(rows...)
 
 	if UseBannerImages {
@@ -629,121 +635,79 @@ func (h *Handler) HandleExtend(c tele.Context) error {
 // HandleExtendPay обрабатывает оплату продления подписки
 func (h *Handler) HandleExtendPay(c tele.Context) error {
 	ctx := context.Background()
+	lang := h.lang(c)
 
 	parts := strings.Split(c.Callback().Data, ":")
 	if len(parts) != 2 {
-		return c.Send("❌ Ошибка")
+		return c.Send(i18n.T(lang, "error.generic"))
 	}
 
 	subID, _ := strconv.ParseInt(parts[0], 10, 64)
-	months, _ := strconv.Atoi(parts[1])
-
-	sub, err := h.svc.GetSubscriptionByID(ctx, subID)
-	if err != nil {
-		return c.Send("❌ Подписка не найдена")
-	}
-
-	price, discount := h.svc.CalculatePrice(sub.Product.BasePrice, months)
-
-	// Применяем флеш-скидку
-	if flashSale.IsActive() {
-		price = flashSale.ApplyDiscount(price)
-	}
+	planID, _ := strconv.ParseInt(parts[1], 10, 64)
 
 	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
 	if err != nil {
-		return c.Send("❌ Ошибка")
+		return c.Send(i18n.T(lang, "error.generic"))
 	}
 
-	// Проверяем баланс
-	if user.Balance < price {
+	// Списание и продление — одним заказом: повторное нажатие не спишет деньги второй раз
+	res, err := h.svc.Purchase(ctx, user.ID, service.PlanRef{PlanID: planID, SubscriptionID: subID}, purchaseKey(c))
+	if errors.Is(err, service.ErrInsufficientBalance) {
+		planPrice := res.Price
+		price := planPrice.Price
+		cur := h.svc.UserCurrency(ctx, user)
+
 		var discountText string
-		if flashSale.IsActive() {
-			discountText = fmt.Sprintf(" 🔥 *АКЦИЯ -%d%%!*", flashSale.GetDiscount())
-		} else if discount > 0 {
-			discountText = fmt.Sprintf(" (скидка %d%%)", discount)
+		if planPrice.Campaign != nil {
+			discountText = i18n.T(lang, "plan.campaign", planPrice.Campaign.DiscountPercent)
+		} else if planPrice.PeriodDiscount > 0 {
+			discountText = i18n.T(lang, "plan.discount", planPrice.PeriodDiscount)
 		}
 
-		text := fmt.Sprintf(`❌ *Недостаточно средств для продления*
-
-💰 Ваш баланс: %.0f ₽
-💸 Требуется: %.0f ₽%s
-📉 Не хватает: %.0f ₽
-
-Пополните баланс для продления подписки.`, user.Balance, price, discountText, price-user.Balance)
+		text := i18n.T(lang, "extend.insufficient", cur.Format(user.Balance), cur.Format(price), discountText, cur.Format(price-user.Balance))
 
 		menu := &tele.ReplyMarkup{}
 		menu.Inline(
-			menu.Row(menu.Data("💳 Пополнить баланс", "topup")),
-			menu.Row(menu.Data("⬅️ Назад", "extend", strconv.FormatInt(subID, 10))),
+			menu.Row(menu.Data(i18n.T(lang, "common.topup"), "topup")),
+			menu.Row(menu.Data(i18n.T(lang, "common.back"), "extend", strconv.FormatInt(subID, 10))),
 		)
 
-		return c.Edit(text, menu, tele.ModeMarkdown)
-	}
-
-	// Списываем баланс
-	err = h.svc.DeductBalance(ctx, user.ID, price)
-	if err != nil {
-		return c.Send("❌ Ошибка списания баланса")
+		return h.editOrResend(c, text, menu)
 	}
-
-	// Продлеваем подписку (кумулятивно)
-	err = h.svc.ExtendSubscription(ctx, subID, months)
-	if err != nil {
-		// Возвращаем деньги при ошибке
-		h.svc.AddUserBalance(ctx, user.TelegramID, price)
-		return c.Send("❌ Ошибка продления подписки. Средства возвращены на баланс.")
-	}
-
-	// Получаем обновлённую подписку для отображения новой даты
-	updatedSub, err := h.svc.GetSubscriptionByID(ctx, subID)
 	if err != nil {
-		updatedSub = sub // fallback
+		return h.purchaseFailed(c, err)
 	}
+	callbackSucceeded(c)
+	sub, plan, planPrice := res.Subscription, res.Plan, res.Price
 
 	var discountText string
-	if discount > 0 {
-		discountText = fmt.Sprintf(" (скидка %d%%)", discount)
+	if planPrice.Campaign != nil {
+		discountText = i18n.T(lang, "plan.campaign_short", planPrice.Campaign.DiscountPercent)
+	} else if planPrice.PeriodDiscount > 0 {
+		discountText = i18n.T(lang, "plan.discount", planPrice.PeriodDiscount)
 	}
 
-	text := fmt.Sprintf(`✅ *Подписка продлена!*
-
-%s *%s* №%d
-📅 Добавлено: +%d мес.%s
-⏰ Новый срок: до *%s*
-
-🔑 *Ваш ключ не изменился:*
-`+"`%s`"+`
-
-_(Можете продолжать пользоваться)_`,
+	text := i18n.T(lang, "extend.done",
 		sub.Product.CountryFlag, sub.Product.Name, sub.ID,
-		months, discountText,
-		updatedSub.ExpiresAt.Format("02.01.2006"),
-		sub.KeyString)
+		planPeriodTitle(lang, plan), discountText,
+		sub.ExpiresAt.Format("02.01.2006"),
+		h.subKey(sub))
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.Data("🔑 Мои подписки", "mysubs")),
-		menu.Row(menu.Data("🏠 Главное меню", "back_main")),
+		menu.Row(menu.Data(i18n.T(lang, "common.my_subs"), "mysubs")),
+		menu.Row(menu.Data(i18n.T(lang, "common.main_menu"), "back_main")),
 	)
 
-	return c.Edit(text, menu, tele.ModeMarkdown)
+	return h.editOrResend(c, text, menu)
 }
 
 // ================= INSTRUCTIONS =================
 
 // HandleInstruction показывает выбор устройства
 func (h *Handler) HandleInstruction(c tele.Context) error {
-	text := `📚 *Настройка подключения*
-
-Рекомендуем приложение *Happ* — работает в один клик.
-
-1. Установите Happ (ссылки ниже)
-2. Скопируйте ключ (` + "`vless://...`" + `)
-3. Откройте Happ — он сам добавит ключ
-4. Нажмите *Подключиться*
-
-👇 *Выберите устройство:*`
+	lang := h.lang(c)
+	text := i18n.T(lang, "instr.text")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
@@ -755,7 +719,7 @@ func (h *Handler) HandleInstruction(c tele.Context) error {
 			menu.Data("🍏 iOS", "instr_iphone"),
 			menu.Data("🖥 Mac", "instr_mac"),
 		),
-		menu.Row(menu.Data("Вернуться", "back_main")),
+		menu.Row(menu.Data(i18n.T(lang, "common.return"), "back_main")),
 	)
 
 	if UseBannerImages {
@@ -772,17 +736,13 @@ func (h *Handler) HandleInstruction(c tele.Context) error {
 
 // HandleInstrAndroid инструкция для Android
 func (h *Handler) HandleInstrAndroid(c tele.Context) error {
-	text := `🤖 *Инструкция для Android:*
-
-1. Скачайте приложение [Happ](https://play.google.com/store/apps/details?id=com.happproxy) из Google Play.
-2. Скопируйте ключ подписки в буфер обмена.
-3. Откройте Happ — приложение автоматически предложит добавить ключ из буфера.
-4. Нажмите *Подключиться* — готово!`
+	lang := h.lang(c)
+	text := i18n.T(lang, "instr.android")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.URL("📥 Скачать Happ", "https://play.google.com/store/apps/details?id=com.happproxy")),
-		menu.Row(menu.Data("⬅️ Назад", "instruction")),
+		menu.Row(menu.URL(i18n.T(lang, "instr.download"), "https://play.google.com/store/apps/details?id=com.happproxy")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "instruction")),
 	)
 
 	return c.Edit(text, menu, tele.ModeMarkdown)
@@ -790,17 +750,13 @@ func (h *Handler) HandleInstrAndroid(c tele.Context) error {
 
 // HandleInstrWindows инструкция для Windows
 func (h *Handler) HandleInstrWindows(c tele.Context) error {
-	text := `💻 *Инструкция для Windows:*
-
-1. Скачайте и установите [Happ для Windows](https://github.com/Happ-proxy/happ-desktop/releases/latest/download/setup-Happ.x64.exe).
-2. Скопируйте ключ подписки в буфер обмена.
-3. Откройте Happ — приложение автоматически предложит добавить ключ из буфера.
-4. Нажмите *Подключиться* — готово!`
+	lang := h.lang(c)
+	text := i18n.T(lang, "instr.windows")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.URL("📥 Скачать Happ", "https://github.com/Happ-proxy/happ-desktop/releases/latest/download/setup-Happ.x64.exe")),
-		menu.Row(menu.Data("⬅️ Назад", "instruction")),
+		menu.Row(menu.URL(i18n.T(lang, "instr.download"), "https://github.com/Happ-proxy/happ-desktop/releases/latest/download/setup-Happ.x64.exe")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "instruction")),
 	)
 
 	return c.Edit(text, menu, tele.ModeMarkdown)
@@ -808,17 +764,13 @@ func (h *Handler) HandleInstrWindows(c tele.Context) error {
 
 // HandleInstrIphone инструкция для iPhone
 func (h *Handler) HandleInstrIphone(c tele.Context) error {
-	text := `🍏 *Инструкция для iOS (iPhone / iPad):*
-
-1. Скачайте приложение [Happ](https://apps.apple.com/us/app/happ-proxy-utility/id6504287215) из App Store.
-2. Скопируйте ключ подписки в буфер обмена.
-3. Откройте Happ — приложение автоматически предложит добавить ключ из буфера.
-4. Нажмите *Подключиться* — готово!`
+	lang := h.lang(c)
+	text := i18n.T(lang, "instr.iphone")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.URL("📥 Скачать Happ", "https://apps.apple.com/us/app/happ-proxy-utility/id6504287215")),
-		menu.Row(menu.Data("⬅️ Назад", "instruction")),
+		menu.Row(menu.URL(i18n.T(lang, "instr.download"), "https://apps.apple.com/us/app/happ-proxy-utility/id6504287215")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "instruction")),
 	)
 
 	return c.Edit(text, menu, tele.ModeMarkdown)
@@ -826,17 +778,13 @@ func (h *Handler) HandleInstrIphone(c tele.Context) error {
 
 // HandleInstrMac инструкция для Mac
 func (h *Handler) HandleInstrMac(c tele.Context) error {
-	text := `🖥 *Инструкция для Mac:*
-
-1. Скачайте приложение [Happ](https://apps.apple.com/us/app/happ-proxy-utility/id6504287215) из App Store.
-2. Скопируйте ключ подписки в буфер обмена.
-3. Откройте Happ — приложение автоматически предложит добавить ключ из буфера.
-4. Нажмите *Подключиться* — готово!`
+	lang := h.lang(c)
+	text := i18n.T(lang, "instr.mac")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.URL("📥 Скачать Happ", "https://apps.apple.com/us/app/happ-proxy-utility/id6504287215")),
-		menu.Row(menu.Data("⬅️ Назад", "instruction")),
+		menu.Row(menu.URL(i18n.T(lang, "instr.download"), "https://apps.apple.com/us/app/happ-proxy-utility/id6504287215")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "instruction")),
 	)
 
 	return c.Edit(text, menu, tele.ModeMarkdown)
@@ -848,16 +796,15 @@ func (h *Handler) HandleInstrMac(c tele.Context) error {
 func (h *Handler) HandleHelp(c tele.Context) error {
 	log.Printf("👉 HandleHelp triggered for User: %d", c.Sender().ID)
 
-	text := `🛟 *Помощь*
-
-Выберите интересующий раздел:`
+	lang := h.lang(c)
+	text := i18n.T(lang, "help.text")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.Data("⁉️ Часто задаваемые вопросы", "faq")),
-		menu.Row(menu.Data("🛟 Поддержка", "support")),
-		menu.Row(menu.Data("📄 Пользовательское соглашение", "privacy")),
-		menu.Row(menu.Data("⬅️ Назад", "back_main")),
+		menu.Row(menu.Data(i18n.T(lang, "help.faq"), "faq")),
+		menu.Row(menu.Data(i18n.T(lang, "common.support"), "support")),
+		menu.Row(menu.Data(i18n.T(lang, "help.privacy"), "privacy")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_main")),
 	)
 
 	if UseBannerImages {
@@ -874,26 +821,13 @@ func (h *Handler) HandleHelp(c tele.Context) error {
 
 // HandleFAQ показывает FAQ
 func (h *Handler) HandleFAQ(c tele.Context) error {
-	text := `⁉️ *Часто задаваемые вопросы*
-
-🛠 *Что делать, если VPN не работает?*
-Первым делом попробуйте перезагрузить устройство или переподключиться в приложении. Если проблема осталась — нажмите кнопку *«🛟 Поддержка»* ниже. Мы поможем!
-
-📱 *Сколько устройств можно подключить?*
-Один ключ доступа работает одновременно на *3-х устройствах*. Вы можете защитить телефон, компьютер и планшет одной подпиской.
-
-💳 *Как можно оплатить?*
-Мы принимаем всё: Банковские карты РФ, СБП (Система Быстрых Платежей) и Криптовалюту.
-
-🎁 *Как пользоваться бесплатно?*
-У нас работает щедрая реферальная программа!
-• Вы получаете *25%* на баланс с каждой оплаты приглашенного друга.
-• Пригласи *4-х друзей* — и твой VPN будет оплачиваться их бонусами. Пользуйся бесплатно!`
+	lang := h.lang(c)
+	text := i18n.T(lang, "faq.text")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.Data("🛟 Поддержка", "support")),
-		menu.Row(menu.Data("⬅️ Назад", "help")),
+		menu.Row(menu.Data(i18n.T(lang, "common.support"), "support")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "help")),
 	)
 
 	if UseBannerImages {
@@ -916,20 +850,14 @@ func (h *Handler) HandleSupportHub(c tele.Context) error {
 		c.Respond()
 	}
 
-	text := `🛟 *Поддержка*
-
-Это центр тикетов: создавайте обращения, просматривайте ответы и историю.
-
-• *Создать тикет* — опишите проблему или вопрос.
-• *Мои тикеты* — статус и переписка.
-
-_Старайтесь использовать тикеты — так мы быстрее поможем и ничего не потеряется._`
+	lang := h.lang(c)
+	text := i18n.T(lang, "support.text")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.Data("🎫 Создать тикет", "ticket_create")),
-		menu.Row(menu.Data("📋 Мои тикеты", "ticket_list")),
-		menu.Row(menu.Data("⬅️ Назад", "help")),
+		menu.Row(menu.Data(i18n.T(lang, "support.create"), "ticket_create")),
+		menu.Row(menu.Data(i18n.T(lang, "support.list"), "ticket_list")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "help")),
 	)
 
 	if UseBannerImages {
@@ -956,19 +884,15 @@ func (h *Handler) HandleCreateTicket(c tele.Context) error {
 	}
 
 	// Включаем режим поддержки
-	SetUserSupportMode(c.Sender().ID, true)
+	h.SetUserSupportMode(c.Sender(), true)
 	log.Printf("🎫 Support mode ENABLED for user %d", c.Sender().ID)
 
-	text := `✍️ *Новое обращение*
-
-Пожалуйста, опишите вашу проблему одним сообщением.
-Вы можете прикрепить скриншот или фото чека.
-
-*Оператор ответит вам в этом чате.*`
+	lang := h.lang(c)
+	text := i18n.T(lang, "ticket.new")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.Data("🚫 Отмена", "back_to_support_hub")),
+		menu.Row(menu.Data(i18n.T(lang, "common.cancel"), "back_to_support_hub")),
 	)
 
 	if UseBannerImages {
@@ -1001,7 +925,7 @@ func (h *Handler) HandleBackToSupportHub(c tele.Context) error {
 	}
 
 	// Сбрасываем режим поддержки
-	SetUserSupportMode(c.Sender().ID, false)
+	h.SetUserSupportMode(c.Sender(), false)
 
 	// Возвращаем в центр тикетов
 	return h.HandleSupportHub(c)
@@ -1013,37 +937,54 @@ func (h *Handler) HandleMyTickets(c tele.Context) error {
 		c.Respond()
 	}
 
+	ctx := context.Background()
 	userID := c.Sender().ID
+	lang := h.lang(c)
 	var text string
 	menu := &tele.ReplyMarkup{}
 
-	// Проверяем есть ли активный тикет (пользователь в режиме поддержки)
-	if IsUserInSupportMode(userID) {
-		// Сценарий A: Есть активный диалог
-		text = `📂 *Мои обращения*
+	tickets, err := h.svc.GetUserTickets(ctx, userID, 10)
+	if err != nil {
+		log.Printf("HandleMyTickets: Failed to load tickets of user %d: %v", userID, err)
+	}
 
-🟢 *Активный диалог*
-⚡️ **Статус:** Переписка открыта
+	// История обращений
+	var history strings.Builder
+	var open *models.Ticket
+	for i := range tickets {
+		t := &tickets[i]
+		if t.Status != models.TicketClosed && open == nil {
+			open = t
+		}
+		history.WriteString(i18n.T(lang, "ticket.history_line",
+			ticketStatusEmoji(t.Status), t.ID, t.CreatedAt.Format("02.01.2006"), ticketStatusText(lang, t.Status)) + "\n")
+	}
+
+	if open != nil {
+		// Сценарий A: Есть открытое обращение
+		dialogText := i18n.T(lang, "ticket.dialog_live")
+		if !open.Composing {
+			dialogText = i18n.T(lang, "ticket.dialog_reply")
+		}
 
-Вы можете просто писать сообщения в этот чат — они автоматически попадут в поддержку.`
+		text = i18n.T(lang, "ticket.open", open.ID, ticketStatusText(lang, open.Status), dialogText, history.String())
 
 		menu.Inline(
-			menu.Row(menu.Data("✏️ Написать сообщение", "ticket_reply")),
-			menu.Row(menu.Data("✅ Вопрос решён", "ticket_solve")),
-			menu.Row(menu.Data("⬅️ Назад", "back_to_support_hub")),
+			menu.Row(menu.Data(i18n.T(lang, "ticket.write"), "ticket_reply")),
+			menu.Row(menu.Data(i18n.T(lang, "ticket.solve"), "ticket_solve")),
+			menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_to_support_hub")),
 		)
 	} else {
-		// Сценарий B: Нет активных обращений
-		text = `📂 *Мои обращения*
+		// Сценарий B: Нет открытых обращений
+		text = i18n.T(lang, "ticket.none")
 
-У вас сейчас нет открытых запросов.
-Если возникла проблема — создайте новый тикет.
-
-_Ответы от поддержки приходят прямо в этот чат._`
+		if len(tickets) > 0 {
+			text += "\n\n" + i18n.T(lang, "ticket.history") + "\n" + history.String()
+		}
 
 		menu.Inline(
-			menu.Row(menu.Data("🎫 Создать тикет", "ticket_create")),
-			menu.Row(menu.Data("⬅️ Назад", "back_to_support_hub")),
+			menu.Row(menu.Data(i18n.T(lang, "support.create"), "ticket_create")),
+			menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_to_support_hub")),
 		)
 	}
 
@@ -1064,6 +1005,34 @@ _Ответы от поддержки приходят прямо в этот ч
 	return c.Send(text, menu, tele.ModeMarkdown)
 }
 
+// ticketStatusEmoji эмодзи статуса тикета
+func ticketStatusEmoji(status string) string {
+	switch status {
+	case models.TicketWaiting:
+		return "🔴"
+	case models.TicketReplied:
+		return "🟢"
+	case models.TicketClosed:
+		return "⚪️"
+	default:
+		return "🟡"
+	}
+}
+
+// ticketStatusText название статуса тикета для пользователя
+func ticketStatusText(lang, status string) string {
+	switch status {
+	case models.TicketWaiting:
+		return i18n.T(lang, "ticket.status_waiting")
+	case models.TicketReplied:
+		return i18n.T(lang, "ticket.status_replied")
+	case models.TicketClosed:
+		return i18n.T(lang, "ticket.status_closed")
+	default:
+		return i18n.T(lang, "ticket.status_open")
+	}
+}
+
 // HandleExitSupport выходит из режима поддержки и возвращает в центр тикетов
 func (h *Handler) HandleExitSupport(c tele.Context) error {
 	if c.Callback() != nil {
@@ -1071,7 +1040,7 @@ func (h *Handler) HandleExitSupport(c tele.Context) error {
 	}
 
 	// Выключаем режим поддержки
-	SetUserSupportMode(c.Sender().ID, false)
+	h.SetUserSupportMode(c.Sender(), false)
 
 	// Возвращаем в центр тикетов
 	return h.HandleSupportHub(c)
@@ -1085,18 +1054,16 @@ func (h *Handler) HandleTicketReply(c tele.Context) error {
 	}
 
 	// Включаем режим поддержки для продолжения диалога
-	SetUserSupportMode(c.Sender().ID, true)
+	h.SetUserSupportMode(c.Sender(), true)
 	log.Printf("🎫 Support mode ENABLED for reply, user %d", c.Sender().ID)
 
 	// ВАЖНО: Используем Send, а не Edit — чтобы сохранить историю чата!
-	text := `✍️ *Продолжение диалога*
-
-Напишите ваш ответ оператору.
-Можете прикрепить фото, видео или документ.`
+	lang := h.lang(c)
+	text := i18n.T(lang, "ticket.reply")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.Data("🚫 Отмена", "ticket_cancel_reply")),
+		menu.Row(menu.Data(i18n.T(lang, "common.cancel"), "ticket_cancel_reply")),
 	)
 
 	// Всегда Send — не удаляем сообщение админа
@@ -1112,38 +1079,43 @@ func (h *Handler) HandleTicketSolve(c tele.Context) error {
 	userID := c.Sender().ID
 	username := c.Sender().Username
 
-	// Выключаем режим поддержки
-	SetUserSupportMode(userID, false)
+	// Закрываем тикет (режим поддержки выключается вместе с ним)
+	ticket, err := h.svc.CloseTicket(context.Background(), userID)
+	if err != nil {
+		log.Printf("HandleTicketSolve: Failed to close ticket of user %d: %v", userID, err)
+	}
 
-	// Удаляем из трекера и обновляем dashboard
+	// Обновляем dashboard
 	if tracker := GetTracker(); tracker != nil {
-		tracker.RemoveTicket(userID)
 		go tracker.UpdateDashboard()
 	}
 
 	// Уведомляем админов в группе поддержки
-	usernameStr := "нет"
+	usernameStr := i18n.T(i18n.Default, "ticket.admin_no_username")
 	if username != "" {
 		usernameStr = "@" + username
 	}
 
-	adminNotification := fmt.Sprintf("✅ *Тикет закрыт пользователем*\n\n👤 %s\n🆔 `#user_%d`\n\n_Диалог завершён._", usernameStr, userID)
+	ticketText := ""
+	if ticket != nil {
+		ticketText = fmt.Sprintf(" №%d", ticket.ID)
+	}
+
+	adminNotification := i18n.T(i18n.Default, "ticket.admin_solved", ticketText, usernameStr, userID)
 	supportGroup := &tele.Chat{ID: h.supportGroupID}
-	_, err := c.Bot().Send(supportGroup, adminNotification, tele.ModeMarkdown)
+	_, err = c.Bot().Send(supportGroup, adminNotification, tele.ModeMarkdown)
 	if err != nil {
 		log.Printf("HandleTicketSolve: Failed to notify admin group: %v", err)
 	}
 
 	// Сообщение пользователю
-	text := `✅ *Тикет закрыт*
-
-Спасибо за обращение!
-Если у вас снова возникнут вопросы — мы всегда на связи.`
+	lang := h.lang(c)
+	text := i18n.T(lang, "ticket.closed")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.Data("🏠 Главное меню", "back_main")),
-		menu.Row(menu.Data("🛟 Поддержка", "support")),
+		menu.Row(menu.Data(i18n.T(lang, "common.main_menu"), "back_main")),
+		menu.Row(menu.Data(i18n.T(lang, "common.support"), "support")),
 	)
 
 	if c.Callback() != nil {
@@ -1159,15 +1131,14 @@ func (h *Handler) HandleTicketCancelReply(c tele.Context) error {
 	}
 
 	// Выключаем режим поддержки
-	SetUserSupportMode(c.Sender().ID, false)
-
-	text := `ℹ️ Ответ отменён.
+	h.SetUserSupportMode(c.Sender(), false)
 
-Если вам ответят — вы получите уведомление.`
+	lang := h.lang(c)
+	text := i18n.T(lang, "ticket.reply_cancelled")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.Data("🏠 Главное меню", "back_main")),
+		menu.Row(menu.Data(i18n.T(lang, "common.main_menu"), "back_main")),
 	)
 
 	if c.Callback() != nil {
@@ -1178,14 +1149,13 @@ func (h *Handler) HandleTicketCancelReply(c tele.Context) error {
 
 // HandlePrivacy показывает пользовательское соглашение
 func (h *Handler) HandlePrivacy(c tele.Context) error {
-	text := `📄 *Пользовательское соглашение*
-
-Публичная оферта на заключение лицензионного договора.`
+	lang := h.lang(c)
+	text := i18n.T(lang, "privacy.text")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.URL("📖 Читать соглашение", "https://telegra.ph/Publichnaya-oferta-na-zaklyuchenie-licenzionnogo-dogovora-dlya-ispolzovaniya-VPN-servisa-06-14")),
-		menu.Row(menu.Data("⬅️ Назад", "help")),
+		menu.Row(menu.URL(i18n.T(lang, "privacy.read"), "https://telegra.ph/Publichnaya-oferta-na-zaklyuchenie-licenzionnogo-dogovora-dlya-ispolzovaniya-VPN-servisa-06-14")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "help")),
 	)
 
 	if UseBannerImages {
@@ -1205,22 +1175,20 @@ func (h *Handler) HandlePrivacy(c tele.Context) error {
 // HandleBalance показывает баланс пользователя
 func (h *Handler) HandleBalance(c tele.Context) error {
 	ctx := context.Background()
+	lang := h.lang(c)
 	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
 	if err != nil {
-		return c.Send("❌ Ошибка загрузки данных")
+		return c.Send(i18n.T(lang, "error.load"))
 	}
+	cur := h.svc.UserCurrency(ctx, user)
 
-	text := fmt.Sprintf(`💰 *Ваш кошелёк*
-
-🆔 ID: `+"`%d`"+`
-💵 *Текущий баланс:* *%.0f ₽*
-
-ℹ️ Баланс можно использовать для оплаты подписок и продлений.`, user.TelegramID, user.Balance)
+	text := i18n.T(lang, "balance.text", user.TelegramID, cur.FormatWithBase(user.Balance))
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.Data("💳 Пополнить баланс", "topup")),
-		menu.Row(menu.Data("⬅️ Назад", "back_main")),
+		menu.Row(menu.Data(i18n.T(lang, "common.topup"), "topup")),
+		menu.Row(menu.Data(i18n.T(lang, "settings.currency", cur.Code), "currency")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_main")),
 	)
 
 	if UseBannerImages {
@@ -1238,20 +1206,16 @@ func (h *Handler) HandleBalance(c tele.Context) error {
 // HandlePromoEnter показывает экран ввода промокода
 func (h *Handler) HandlePromoEnter(c tele.Context) error {
 	// Устанавливаем режим ввода промокода
-	SetUserPromoMode(c.Sender().ID, true)
-
-	text := `🎟 *Активация промокода*
-
-Введите ваш промокод в чат, чтобы получить бонус на баланс.
+	h.setState(c.Sender().ID, stateUserPromo, nil)
 
-💡 *Где взять промокод?*
-Мы регулярно публикуем их в нашем *Канале*, *Чате*, а также отправляем активным пользователям прямо здесь, в *боте*.`
+	lang := h.lang(c)
+	text := i18n.T(lang, "promo.text")
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.URL("📢 Наш канал", "https://t.me/XRAY_MODE")),
-		menu.Row(menu.URL("💬 Наш чат", "https://t.me/XRAY_LUV")),
-		menu.Row(menu.Data("⬅️ Назад", "back_main")),
+		menu.Row(menu.URL(i18n.T(lang, "promo.channel"), "https://t.me/XRAY_MODE")),
+		menu.Row(menu.URL(i18n.T(lang, "promo.chat"), "https://t.me/XRAY_LUV")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_main")),
 	)
 
 	if UseBannerImages {
@@ -1268,24 +1232,32 @@ func (h *Handler) HandlePromoEnter(c tele.Context) error {
 
 // HandleTopUp показывает варианты пополнения баланса
 func (h *Handler) HandleTopUp(c tele.Context) error {
-	text := `💳 *Пополнение кошелька*
+	lang := h.lang(c)
+	text := i18n.T(lang, "topup.text")
 
-Выберите сумму пополнения.
-
-Средства зачисляются на ваш внутренний баланс. Вы сможете использовать их для оплаты подписки в любой момент.`
+	// Суммы пополнения совпадают с ценами планов тарифа; зачисляются в рублях по текущему курсу
+	amounts, err := h.svc.GetTopUpAmounts(context.Background(), xrayModeProductID)
+	if err != nil {
+		log.Printf("Failed to load top-up amounts: %v", err)
+	}
+	cur := h.senderCurrency(c)
 
 	menu := &tele.ReplyMarkup{}
-	menu.Inline(
-		menu.Row(
-			menu.Data("450 ₽", "topup_amount", "450"),
-			menu.Data("1350 ₽", "topup_amount", "1350"),
-		),
-		menu.Row(
-			menu.Data("2430 ₽", "topup_amount", "2430"),
-			menu.Data("4320 ₽", "topup_amount", "4320"),
-		),
-		menu.Row(menu.Data("⬅️ Назад", "balance")),
-	)
+	var rows []tele.Row
+	var row tele.Row
+	for _, amount := range amounts {
+		value := fmt.Sprintf("%.0f", amount)
+		row = append(row, menu.Data(cur.Format(amount), "topup_amount", value))
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "common.back"), "balance")))
+	menu.Inline(rows...)
 
 	if UseBannerImages {
 		photo := &tele.Photo{
@@ -1301,28 +1273,24 @@ func (h *Handler) HandleTopUp(c tele.Context) error {
 
 // HandleTopUpAmount обрабатывает выбор суммы пополнения
 func (h *Handler) HandleTopUpAmount(c tele.Context) error {
+	lang := h.lang(c)
 	amount, err := strconv.ParseFloat(c.Callback().Data, 64)
 	if err != nil {
-		return c.Send("❌ Некорректная сумма")
+		return c.Send(i18n.T(lang, "topup.bad_amount"))
 	}
 
-	text := fmt.Sprintf(`💳 *Счёт на оплату*
-—————————————————
-💰 *Назначение:* Пополнение баланса
-💵 *Сумма:* *%.0f ₽*
-
-🎁 *БОНУС: +7 ДНЕЙ В ПОДАРОК!*
-При оплате *Криптовалютой* (USDT, TON, BTC) вы получите бонусные дни при покупке подписки.
-✅ _Бонус начислится сразу после оплаты._
-
-👇 *Выберите способ оплаты:*`, amount)
+	text := i18n.T(lang, "topup.invoice", h.senderCurrency(c).FormatWithBase(amount))
 
 	menu := &tele.ReplyMarkup{}
-	menu.Inline(
-		menu.Row(menu.Data("💠 СБП (Быстрый платёж)", "topup_pay_card", fmt.Sprintf("%.0f", amount))),
-		menu.Row(menu.Data("🌑 Криптовалюта (+7 дней 🎁)", "topup_pay_crypto", fmt.Sprintf("%.0f", amount))),
-		menu.Row(menu.Data("⬅️ Назад", "topup")),
-	)
+	rows := []tele.Row{
+		menu.Row(menu.Data(i18n.T(lang, "pay.sbp"), "topup_pay_card", fmt.Sprintf("%.0f", amount))),
+		menu.Row(menu.Data(i18n.T(lang, "pay.crypto"), "topup_pay_crypto", fmt.Sprintf("%.0f", amount))),
+	}
+	if h.svc.StarsEnabled() {
+		rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "pay.stars"), "topup_pay_stars", fmt.Sprintf("%.0f", amount))))
+	}
+	rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "common.back"), "topup")))
+	menu.Inline(rows...)
 
 	if UseBannerImages {
 		photo := &tele.Photo{
@@ -1340,132 +1308,85 @@ func (h *Handler) HandleTopUpAmount(c tele.Context) error {
 func (h *Handler) HandleTopUpPayCard(c tele.Context) error {
 	amount := c.Callback().Data
 
-	text := fmt.Sprintf(`💠 *Оплата через СБП*
-
-💵 Сумма: *%s ₽*
-
-Для оплаты напишите в поддержку — мы отправим реквизиты для перевода.
-
-После оплаты отправьте чек/скриншот в поддержку, и баланс будет пополнен в течение 15 минут.`, amount)
+	// Если подключён платёжный шлюз — выставляем счёт, баланс пополнится автоматически
+	if h.svc.HasPaymentGateway(payment.MethodCard) {
+		return h.sendTopUpInvoice(c, payment.MethodCard, amount)
+	}
 
-	menu := &tele.ReplyMarkup{}
-	menu.Inline(
-		menu.Row(menu.URL("📥 Написать в поддержку", "https://t.me/XRAY_LUV")),
-		menu.Row(menu.Data("⬅️ Назад", "topup_amount", amount)),
-	)
+	// Реквизиты заданы — пользователь сам присылает чек, админ подтверждает его кнопкой
+	if h.sbpDetails != "" {
+		return h.sendReceiptPayment(c, amount)
+	}
 
-	return c.Edit(text, menu, tele.ModeMarkdown)
+	return h.sendManualPayment(c, payment.MethodCard, amount, "topup_amount", amount)
 }
 
 // HandleTopUpPayCrypto обработка оплаты пополнения криптой
 func (h *Handler) HandleTopUpPayCrypto(c tele.Context) error {
 	amount := c.Callback().Data
 
-	text := fmt.Sprintf(`🌑 *Оплата криптовалютой*
-
-💵 Сумма: *%s ₽*
-🎁 Бонус: *+7 дней* к подписке!
-
-Для оплаты напишите в поддержку — мы отправим адрес кошелька (USDT, TON, BTC).
-
-После оплаты отправьте хэш транзакции в поддержку, и баланс будет пополнен в течение 15 минут.`, amount)
-
-	menu := &tele.ReplyMarkup{}
-	menu.Inline(
-		menu.Row(menu.URL("📥 Написать в поддержку", "https://t.me/XRAY_LUV")),
-		menu.Row(menu.Data("⬅️ Назад", "topup_amount", amount)),
-	)
+	if h.svc.HasPaymentGateway(payment.MethodCrypto) {
+		return h.sendTopUpInvoice(c, payment.MethodCrypto, amount)
+	}
 
-	return c.Edit(text, menu, tele.ModeMarkdown)
+	return h.sendManualPayment(c, payment.MethodCrypto, amount, "topup_amount", amount)
 }
 
 // HandlePayWithBalance оплата с баланса
 func (h *Handler) HandlePayWithBalance(c tele.Context) error {
 	ctx := context.Background()
+	lang := h.lang(c)
 
-	parts := strings.Split(c.Callback().Data, ":")
-	if len(parts) != 2 {
-		return c.Send("❌ Ошибка")
-	}
-
-	productID, _ := strconv.ParseInt(parts[0], 10, 64)
-	months, _ := strconv.Atoi(parts[1])
-
-	product, err := h.svc.GetProductByID(ctx, productID)
+	planID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
 	if err != nil {
-		return c.Send("❌ Продукт не найден")
-	}
-
-	price, _ := h.svc.CalculatePrice(product.BasePrice, months)
-
-	// Применяем флеш-скидку
-	if flashSale.IsActive() {
-		price = flashSale.ApplyDiscount(price)
+		return h.planUnavailable(c, fmt.Errorf("%w: bad callback %q", service.ErrPlanUnavailable, c.Callback().Data))
 	}
 
 	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
 	if err != nil {
-		return c.Send("❌ Ошибка")
+		return c.Send(i18n.T(lang, "error.generic"))
 	}
 
-	// Проверяем баланс
-	if user.Balance < price {
-		text := fmt.Sprintf(`❌ *Недостаточно средств*
-
-💰 Ваш баланс: %.0f ₽
-💸 Требуется: %.0f ₽
-📉 Не хватает: %.0f ₽
-
-Пополните баланс для оформления подписки.`, user.Balance, price, price-user.Balance)
+	// Списание и выдача подписки — одним заказом: повторное нажатие не спишет деньги второй раз
+	res, err := h.svc.Purchase(ctx, user.ID, service.PlanRef{PlanID: planID}, purchaseKey(c))
+	if errors.Is(err, service.ErrInsufficientBalance) {
+		price := res.Price.Price
+		cur := h.svc.UserCurrency(ctx, user)
+		text := i18n.T(lang, "pay.insufficient", cur.Format(user.Balance), cur.Format(price), cur.Format(price-user.Balance))
 
 		menu := &tele.ReplyMarkup{}
 		menu.Inline(
-			menu.Row(menu.Data("💳 Пополнить баланс", "topup")),
-			menu.Row(menu.Data("⬅️ Назад", "tariffs")),
+			menu.Row(menu.Data(i18n.T(lang, "common.topup"), "topup")),
+			menu.Row(menu.Data(i18n.T(lang, "common.back"), "tariffs")),
 		)
 
-		return c.Edit(text, menu, tele.ModeMarkdown)
+		return h.editOrResend(c, text, menu)
 	}
-
-	// Списываем баланс
-	err = h.svc.DeductBalance(ctx, user.ID, price)
 	if err != nil {
-		return c.Send("❌ Ошибка списания баланса")
+		return h.purchaseFailed(c, err)
 	}
+	callbackSucceeded(c)
+	sub, plan, product := res.Subscription, res.Plan, res.Subscription.Product
+	bonusDays := res.Order.BonusDays
 
-	// Создаём подписку
-	expiresAt := time.Now().AddDate(0, months, 0)
-	sub, err := h.svc.CreateSubscriptionSimple(ctx, user.ID, productID, expiresAt)
-	if err != nil {
-		// Возвращаем деньги при ошибке
-		h.svc.AddUserBalance(ctx, user.TelegramID, price)
-		return c.Send("❌ Ошибка создания подписки. Средства возвращены на баланс.")
+	var bonusText string
+	if bonusDays > 0 {
+		bonusText = i18n.N(lang, "pay.bonus_days", bonusDays)
 	}
 
-	text := fmt.Sprintf(`✅ *Подписка активирована!*
-
-%s *%s*
-📅 Срок: %d мес.
-⏰ Действует до: %s
-
-🔑 *Ваш ключ:*
-`+"`%s`"+`
-
-_(Нажмите на ключ, чтобы скопировать)_
-
-Перейдите в раздел «📚 Инструкция» для настройки.`,
-		product.CountryFlag, product.Name, months,
-		expiresAt.Format("02.01.2006"),
-		sub.KeyString)
+	text := i18n.T(lang, "pay.done",
+		product.CountryFlag, product.Name, planPeriodTitle(lang, plan), bonusText,
+		sub.ExpiresAt.Format("02.01.2006"),
+		h.subKey(sub))
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.Data("📚 Инструкция", "instruction")),
-		menu.Row(menu.Data("🔑 Мои подписки", "mysubs")),
-		menu.Row(menu.Data("🏠 Главное меню", "back_main")),
+		menu.Row(menu.Data(i18n.T(lang, "common.instruction"), "instruction")),
+		menu.Row(menu.Data(i18n.T(lang, "common.my_subs"), "mysubs")),
+		menu.Row(menu.Data(i18n.T(lang, "common.main_menu"), "back_main")),
 	)
 
-	return c.Edit(text, menu, tele.ModeMarkdown)
+	return h.editOrResend(c, text, menu)
 }
 
 // ================= REFERRAL SYSTEM =================
@@ -1473,9 +1394,10 @@ _(Нажмите на ключ, чтобы скопировать)_
 // HandleRefSystem показывает партнёрскую программу
 func (h *Handler) HandleRefSystem(c tele.Context) error {
 	ctx := context.Background()
+	lang := h.lang(c)
 	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
 	if err != nil {
-		return c.Send("❌ Ошибка получения данных")
+		return c.Send(i18n.T(lang, "error.load"))
 	}
 
 	// Получаем количество рефералов
@@ -1485,23 +1407,12 @@ func (h *Handler) HandleRefSystem(c tele.Context) error {
 	botUsername := c.Bot().Me.Username
 	refLink := fmt.Sprintf("https://t.me/%s?start=%d", botUsername, c.Sender().ID)
 
-	text := fmt.Sprintf(`👥 *Партнёрская программа*
-
-📊 *Ваша статистика:*
-• Приглашено друзей: *%d*
-• Заработано всего: *%.0f ₽*
-
-💰 *Условия:*
-• Вы получаете *25%%* с каждого пополнения друга сразу на баланс.
-• Друг получает *+3 дня* к подписке при первой покупке.
-
-🔗 *Ваша пригласительная ссылка:*
-`+"`%s`", refCount, user.TotalRefEarnings, refLink)
+	text := i18n.T(lang, "ref.text", refCount, h.svc.UserCurrency(ctx, user).Format(user.TotalRefEarnings), refLink)
 
 	menu := &tele.ReplyMarkup{}
 	menu.Inline(
-		menu.Row(menu.Data("👥 Мои рефералы", "ref_list")),
-		menu.Row(menu.Data("⬅️ Назад", "back_main")),
+		menu.Row(menu.Data(i18n.T(lang, "ref.list"), "ref_list")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_main")),
 	)
 
 	if UseBannerImages {
@@ -1519,6 +1430,7 @@ func (h *Handler) HandleRefSystem(c tele.Context) error {
 // HandleRefList показывает список рефералов с пагинацией
 func (h *Handler) HandleRefList(c tele.Context) error {
 	ctx := context.Background()
+	lang := h.lang(c)
 
 	// Определяем номер страницы
 	page := 1
@@ -1532,20 +1444,16 @@ func (h *Handler) HandleRefList(c tele.Context) error {
 	// Получаем рефералов с пагинацией
 	result, err := h.svc.GetReferralsPaginated(ctx, c.Sender().ID, page)
 	if err != nil {
-		return c.Send("❌ Ошибка загрузки рефералов")
+		return c.Send(i18n.T(lang, "ref.load_error"))
 	}
 
 	// Если рефералов нет
 	if result.TotalCount == 0 {
-		text := `👥 *Ваши рефералы*
-
-У вас пока нет приглашённых друзей.
-
-🔗 Поделитесь своей ссылкой и получайте *25%* с каждого пополнения друга!`
+		text := i18n.T(lang, "ref.empty")
 
 		menu := &tele.ReplyMarkup{}
 		menu.Inline(
-			menu.Row(menu.Data("⬅️ Назад", "ref_system")),
+			menu.Row(menu.Data(i18n.T(lang, "common.back"), "ref_system")),
 		)
 
 		if UseBannerImages {
@@ -1561,8 +1469,9 @@ func (h *Handler) HandleRefList(c tele.Context) error {
 	}
 
 	// Формируем текст со списком рефералов
+	cur := h.senderCurrency(c)
 	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("👥 *Ваши рефералы*\n_Страница %d из %d_\n\n", result.CurrentPage, result.TotalPages))
+	sb.WriteString(i18n.T(lang, "ref.page", result.CurrentPage, result.TotalPages) + "\n\n")
 
 	for i, ref := range result.Referrals {
 		position := (result.CurrentPage-1)*10 + i + 1
@@ -1588,13 +1497,12 @@ func (h *Handler) HandleRefList(c tele.Context) error {
 			username = "@" + username
 		}
 
-		sb.WriteString(fmt.Sprintf("%d. %s*%s* — принёс: *%.0f ₽*\n",
-			position, medal, username, ref.GeneratedRevenue))
-		sb.WriteString(fmt.Sprintf("   _(Регистрация: %s)_\n", ref.JoinedAt.Format("02.01.2006")))
+		sb.WriteString(i18n.T(lang, "ref.line", position, medal, username, cur.Format(ref.GeneratedRevenue)) + "\n")
+		sb.WriteString(i18n.T(lang, "ref.joined", ref.JoinedAt.Format("02.01.2006")) + "\n")
 	}
 
-	sb.WriteString(fmt.Sprintf("\n📊 *Всего рефералов:* %d чел.\n", result.TotalCount))
-	sb.WriteString(fmt.Sprintf("💰 *Общий доход:* %.0f ₽", result.TotalEarnings))
+	sb.WriteString("\n" + i18n.T(lang, "ref.total", result.TotalCount) + "\n")
+	sb.WriteString(i18n.T(lang, "ref.earned", cur.Format(result.TotalEarnings)))
 
 	// Формируем клавиатуру с пагинацией
 	menu := &tele.ReplyMarkup{}
@@ -1602,7 +1510,7 @@ func (h *Handler) HandleRefList(c tele.Context) error {
 
 	// Кнопка "назад" по страницам
 	if result.CurrentPage > 1 {
-		navRow = append(navRow, menu.Data("⬅️ Туда", "ref_list", strconv.Itoa(result.CurrentPage-1)))
+		navRow = append(navRow, menu.Data(i18n.T(lang, "ref.prev"), "ref_list", strconv.Itoa(result.CurrentPage-1)))
 	}
 
 	// Индикатор страницы (пассивная кнопка)
@@ -1612,14 +1520,14 @@ func (h *Handler) HandleRefList(c tele.Context) error {
 
 	// Кнопка "вперёд" по страницам
 	if result.CurrentPage < result.TotalPages {
-		navRow = append(navRow, menu.Data("Сюда ➡️", "ref_list", strconv.Itoa(result.CurrentPage+1)))
+		navRow = append(navRow, menu.Data(i18n.T(lang, "ref.next"), "ref_list", strconv.Itoa(result.CurrentPage+1)))
 	}
 
 	var rows []tele.Row
 	if len(navRow) > 0 {
 		rows = append(rows, menu.Row(navRow...))
 	}
-	rows = append(rows, menu.Row(menu.Data("⬅️ Назад", "ref_system")))
+	rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "common.back"), "ref_system")))
 	menu.Inline(rows...)
 
 	if UseBannerImages {
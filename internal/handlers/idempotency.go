@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// callbackSucceededKey ключ в контексте telebot: обработчик выполнил денежное действие
+const callbackSucceededKey = "callback_succeeded"
+
+// callbackSucceeded отмечает, что денежное действие выполнено: IdempotencyMiddleware сохранит ответ для повторов.
+// Без отметки (ошибка, нехватка средств) обработка снимается, и повтор выполнится заново
+func callbackSucceeded(c tele.Context) {
+	c.Set(callbackSucceededKey, true)
+}
+
+// callbackResult сообщение, которым обработчик ответил на callback (повторяется дубликатам)
+type callbackResult struct {
+	Text      string            `json:"text"`
+	Markup    *tele.ReplyMarkup `json:"markup,omitempty"`
+	ParseMode tele.ParseMode    `json:"parse_mode,omitempty"`
+}
+
+// recordingContext запоминает последнее текстовое сообщение, отправленное или отредактированное обработчиком
+type recordingContext struct {
+	tele.Context
+	result []byte
+}
+
+func (r *recordingContext) Send(what interface{}, opts ...interface{}) error {
+	r.record(what, opts)
+	return r.Context.Send(what, opts...)
+}
+
+func (r *recordingContext) Edit(what interface{}, opts ...interface{}) error {
+	r.record(what, opts)
+	return r.Context.Edit(what, opts...)
+}
+
+// record сохраняет сообщение в JSON до отправки: telebot дописывает callback_data кнопок при отправке
+func (r *recordingContext) record(what interface{}, opts []interface{}) {
+	text, ok := what.(string)
+	if !ok {
+		return
+	}
+	res := callbackResult{Text: text}
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case *tele.ReplyMarkup:
+			res.Markup = o
+		case tele.ParseMode:
+			res.ParseMode = o
+		}
+	}
+	data, err := json.Marshal(res)
+	if err != nil {
+		log.Printf("Failed to record callback result: %v", err)
+		return
+	}
+	r.result = data
+}
+
+// callbackFingerprint отпечаток действия: пользователь, кнопка и состояние сообщения.
+// Повторные нажатия той же кнопки в том же (не изменённом) сообщении дают один отпечаток
+func callbackFingerprint(c tele.Context) string {
+	cb := c.Callback()
+	if cb.Message == nil {
+		return fmt.Sprintf("%d:cb:%s", c.Sender().ID, cb.ID)
+	}
+	return fmt.Sprintf("%d:%d:%d:%s:%s", c.Sender().ID, cb.Message.ID, cb.Message.LastEdit, cb.Unique, cb.Data)
+}
+
+// purchaseKey ключ идемпотентности заказа по нажатию: ID callback-запроса. Ретрай Telegram приносит тот же ID,
+// а каждое новое нажатие — свой, поэтому две осознанные покупки не склеиваются в одну
+// (случайные повторные нажатия отсекает IdempotencyMiddleware)
+func purchaseKey(c tele.Context) string {
+	return "cb:" + c.Callback().ID
+}
+
+// callbackStore учёт обработок денежных callback (service.Service)
+type callbackStore interface {
+	ClaimCallback(ctx context.Context, telegramID int64, callbackID, fingerprint string) (*models.CallbackRequest, bool, error)
+	CompleteCallback(ctx context.Context, id int64, result []byte)
+	ReleaseCallback(ctx context.Context, id int64)
+}
+
+// IdempotencyMiddleware выполняет денежный callback не больше одного раза: повтор (ретрай Telegram
+// или повторное нажатие) в течение service.CallbackDedupWindow получает сохранённый ответ первого вызова.
+// Сохраняется только ответ успешного вызова (см. callbackSucceeded), неудачный можно повторить
+func (h *Handler) IdempotencyMiddleware() tele.MiddlewareFunc {
+	return idempotencyMiddleware(h.svc, h.lang)
+}
+
+// idempotencyMiddleware IdempotencyMiddleware с учётом обработок в store и языком пользователя из lang
+func idempotencyMiddleware(store callbackStore, lang func(tele.Context) string) tele.MiddlewareFunc {
+	return func(next tele.HandlerFunc) tele.HandlerFunc {
+		return func(c tele.Context) error {
+			if c.Callback() == nil {
+				return next(c)
+			}
+			ctx := context.Background()
+
+			req, ok, err := store.ClaimCallback(ctx, c.Sender().ID, c.Callback().ID, callbackFingerprint(c))
+			if err != nil {
+				log.Printf("Failed to claim callback %s of %d: %v", c.Callback().ID, c.Sender().ID, err)
+				return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang(c), "error.retry")})
+			}
+			if !ok {
+				return replayCallback(c, lang(c), req)
+			}
+
+			rc := &recordingContext{Context: c}
+			err = next(rc)
+
+			// Деньги уже списаны: даже если ответ не отправился, повтор не должен выполнить действие ещё раз
+			if succeeded, _ := c.Get(callbackSucceededKey).(bool); succeeded {
+				store.CompleteCallback(ctx, req.ID, rc.result)
+				return err
+			}
+			store.ReleaseCallback(ctx, req.ID)
+			return err
+		}
+	}
+}
+
+// replayCallback отвечает на повтор уже выполненного или выполняющегося callback
+func replayCallback(c tele.Context, lang string, req *models.CallbackRequest) error {
+	log.Printf("🔁 Duplicate callback %s from %d (first: %s, %s)", c.Callback().ID, c.Sender().ID, req.CallbackID, req.Status)
+	if req.Status != models.CallbackDone {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "callback.in_progress")})
+	}
+
+	c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "callback.done")})
+	if len(req.Result) == 0 {
+		return nil
+	}
+	var res callbackResult
+	if err := json.Unmarshal(req.Result, &res); err != nil {
+		log.Printf("Failed to decode result of callback request %d: %v", req.ID, err)
+		return nil
+	}
+	opts := []interface{}{res.ParseMode}
+	if res.Markup != nil {
+		opts = append(opts, res.Markup)
+	}
+	return c.Send(res.Text, opts...)
+}
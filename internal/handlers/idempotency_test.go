@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// fakeCallbackStore учёт обработок callback в памяти с тем же поведением, что и таблица callback_requests:
+// повтор по отпечатку или ID callback получает первую обработку, снятая обработка освобождает отпечаток
+type fakeCallbackStore struct {
+	seq       int64
+	requests  map[string]*models.CallbackRequest // по отпечатку
+	completed []int64
+	released  []int64
+	claimErr  error
+}
+
+func newFakeCallbackStore() *fakeCallbackStore {
+	return &fakeCallbackStore{requests: make(map[string]*models.CallbackRequest)}
+}
+
+func (f *fakeCallbackStore) ClaimCallback(ctx context.Context, telegramID int64, callbackID, fingerprint string) (*models.CallbackRequest, bool, error) {
+	if f.claimErr != nil {
+		return nil, false, f.claimErr
+	}
+	for _, req := range f.requests {
+		if req.Fingerprint == fingerprint || req.CallbackID == callbackID {
+			return req, false, nil
+		}
+	}
+	f.seq++
+	req := &models.CallbackRequest{ID: f.seq, CallbackID: callbackID, TelegramID: telegramID, Fingerprint: fingerprint, Status: models.CallbackProcessing}
+	f.requests[fingerprint] = req
+	return req, true, nil
+}
+
+func (f *fakeCallbackStore) CompleteCallback(ctx context.Context, id int64, result []byte) {
+	for _, req := range f.requests {
+		if req.ID == id {
+			req.Status = models.CallbackDone
+			req.Result = result
+		}
+	}
+	f.completed = append(f.completed, id)
+}
+
+func (f *fakeCallbackStore) ReleaseCallback(ctx context.Context, id int64) {
+	for fp, req := range f.requests {
+		if req.ID == id {
+			delete(f.requests, fp)
+		}
+	}
+	f.released = append(f.released, id)
+}
+
+// fakeCallbackContext нажатие кнопки: запоминает ответы на callback и отправленные сообщения.
+// Остальные методы tele.Context тестам не нужны
+type fakeCallbackContext struct {
+	tele.Context
+	callback  *tele.Callback
+	store     map[string]interface{}
+	responses []string
+	sent      []string
+	editErr   error
+}
+
+func newFakeCallbackContext(id string) *fakeCallbackContext {
+	return &fakeCallbackContext{
+		callback: &tele.Callback{
+			ID:      id,
+			Sender:  &tele.User{ID: 42},
+			Message: &tele.Message{ID: 7},
+			Unique:  "extend_pay",
+			Data:    "1:2",
+		},
+		store: make(map[string]interface{}),
+	}
+}
+
+func (f *fakeCallbackContext) Callback() *tele.Callback { return f.callback }
+func (f *fakeCallbackContext) Sender() *tele.User       { return f.callback.Sender }
+
+func (f *fakeCallbackContext) Get(key string) interface{}      { return f.store[key] }
+func (f *fakeCallbackContext) Set(key string, val interface{}) { f.store[key] = val }
+func (f *fakeCallbackContext) Respond(resp ...*tele.CallbackResponse) error {
+	for _, r := range resp {
+		f.responses = append(f.responses, r.Text)
+	}
+	return nil
+}
+
+func (f *fakeCallbackContext) Send(what interface{}, opts ...interface{}) error {
+	f.sent = append(f.sent, what.(string))
+	return nil
+}
+
+func (f *fakeCallbackContext) Edit(what interface{}, opts ...interface{}) error {
+	if f.editErr != nil {
+		return f.editErr
+	}
+	f.sent = append(f.sent, what.(string))
+	return nil
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	errEdit := errors.New("message can't be edited")
+	lang := func(tele.Context) string { return i18n.RU }
+
+	tests := []struct {
+		name    string
+		next    func(c tele.Context) error
+		editErr error
+		wantErr bool
+		// Итог первого нажатия: обработка сохранена (повтор получит ответ) или снята (повтор выполнится заново)
+		wantCompleted bool
+	}{
+		{
+			name: "success",
+			next: func(c tele.Context) error {
+				callbackSucceeded(c)
+				return c.Edit("done", tele.ModeMarkdown)
+			},
+			wantCompleted: true,
+		},
+		{
+			name: "success but reply failed",
+			next: func(c tele.Context) error {
+				callbackSucceeded(c)
+				return c.Edit("done", tele.ModeMarkdown)
+			},
+			editErr:       errEdit,
+			wantErr:       true,
+			wantCompleted: true,
+		},
+		{
+			name: "handler error",
+			next: func(c tele.Context) error {
+				return errEdit
+			},
+			wantErr: true,
+		},
+		{
+			name: "not marked as success",
+			next: func(c tele.Context) error {
+				return c.Edit("insufficient funds")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeCallbackStore()
+			calls := 0
+			handler := idempotencyMiddleware(store, lang)(func(c tele.Context) error {
+				calls++
+				return tt.next(c)
+			})
+
+			first := newFakeCallbackContext("cb1")
+			first.editErr = tt.editErr
+			if err := handler(first); (err != nil) != tt.wantErr {
+				t.Fatalf("first tap: err = %v, want error %t", err, tt.wantErr)
+			}
+
+			if tt.wantCompleted {
+				if len(store.completed) != 1 || len(store.released) != 0 {
+					t.Fatalf("completed %v, released %v; want the claim completed", store.completed, store.released)
+				}
+			} else if len(store.completed) != 0 || len(store.released) != 1 {
+				t.Fatalf("completed %v, released %v; want the claim released", store.completed, store.released)
+			}
+
+			// Второе нажатие той же кнопки в том же сообщении
+			second := newFakeCallbackContext("cb2")
+			err := handler(second)
+
+			if tt.wantCompleted {
+				if err != nil {
+					t.Fatalf("second tap: %v", err)
+				}
+				if calls != 1 {
+					t.Errorf("handler called %d times, want 1", calls)
+				}
+				want := i18n.T(i18n.RU, "callback.done")
+				if len(second.responses) != 1 || second.responses[0] != want {
+					t.Errorf("second tap responses = %v, want %q", second.responses, want)
+				}
+				if len(second.sent) != 1 || second.sent[0] != "done" {
+					t.Errorf("second tap got %v, want the recorded reply", second.sent)
+				}
+			} else if calls != 2 {
+				t.Errorf("handler called %d times, want 2", calls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyMiddlewareInProgress(t *testing.T) {
+	store := newFakeCallbackStore()
+	lang := func(tele.Context) string { return i18n.RU }
+
+	var nested *fakeCallbackContext
+	var handler tele.HandlerFunc
+	handler = idempotencyMiddleware(store, lang)(func(c tele.Context) error {
+		if nested == nil {
+			// Повторное нажатие, пока первое ещё выполняется
+			nested = newFakeCallbackContext("cb2")
+			if err := handler(nested); err != nil {
+				t.Errorf("nested tap: %v", err)
+			}
+		}
+		callbackSucceeded(c)
+		return nil
+	})
+
+	if err := handler(newFakeCallbackContext("cb1")); err != nil {
+		t.Fatalf("first tap: %v", err)
+	}
+	want := i18n.T(i18n.RU, "callback.in_progress")
+	if len(nested.responses) != 1 || nested.responses[0] != want {
+		t.Errorf("nested tap responses = %v, want %q", nested.responses, want)
+	}
+	if len(store.completed) != 1 {
+		t.Errorf("completed %v, want the first claim completed", store.completed)
+	}
+}
+
+func TestIdempotencyMiddlewareClaimStates(t *testing.T) {
+	lang := func(tele.Context) string { return i18n.RU }
+	result := []byte(`{"text":"paid","parse_mode":"Markdown"}`)
+
+	tests := []struct {
+		name string
+		// Обработка, уже записанная до нажатия (nil — нет)
+		existing      *models.CallbackRequest
+		claimErr      error
+		wantCalled    bool
+		wantResponses []string
+		wantSent      []string
+	}{
+		{
+			name:       "first tap",
+			wantCalled: true,
+		},
+		{
+			name:          "claim failed",
+			claimErr:      errors.New("db is down"),
+			wantResponses: []string{i18n.T(i18n.RU, "error.retry")},
+		},
+		{
+			name:          "first tap still processing",
+			existing:      &models.CallbackRequest{ID: 1, CallbackID: "cb1", Fingerprint: "other", Status: models.CallbackProcessing},
+			wantResponses: []string{i18n.T(i18n.RU, "callback.in_progress")},
+		},
+		{
+			name:          "telegram retry of a done callback",
+			existing:      &models.CallbackRequest{ID: 1, CallbackID: "cb1", Fingerprint: "other", Status: models.CallbackDone, Result: result},
+			wantResponses: []string{i18n.T(i18n.RU, "callback.done")},
+			wantSent:      []string{"paid"},
+		},
+		{
+			name:          "done without recorded reply",
+			existing:      &models.CallbackRequest{ID: 1, CallbackID: "cb1", Fingerprint: "other", Status: models.CallbackDone},
+			wantResponses: []string{i18n.T(i18n.RU, "callback.done")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeCallbackStore()
+			store.claimErr = tt.claimErr
+			if tt.existing != nil {
+				store.seq = tt.existing.ID
+				store.requests[tt.existing.Fingerprint] = tt.existing
+			}
+
+			called := false
+			handler := idempotencyMiddleware(store, lang)(func(c tele.Context) error {
+				called = true
+				callbackSucceeded(c)
+				return nil
+			})
+
+			c := newFakeCallbackContext("cb1")
+			if err := handler(c); err != nil {
+				t.Fatalf("handler: %v", err)
+			}
+			if called != tt.wantCalled {
+				t.Errorf("handler called = %v, want %v", called, tt.wantCalled)
+			}
+			if !slices.Equal(c.responses, tt.wantResponses) {
+				t.Errorf("responses = %v, want %v", c.responses, tt.wantResponses)
+			}
+			if !slices.Equal(c.sent, tt.wantSent) {
+				t.Errorf("sent = %v, want %v", c.sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestIdempotencyMiddlewareSkipsMessages(t *testing.T) {
+	store := newFakeCallbackStore()
+	store.claimErr = errors.New("must not be called")
+
+	called := false
+	handler := idempotencyMiddleware(store, func(tele.Context) string { return i18n.RU })(func(c tele.Context) error {
+		called = true
+		return nil
+	})
+
+	// Обычное сообщение (не нажатие кнопки) идёт мимо учёта обработок
+	c := newFakeCallbackContext("cb1")
+	c.callback = nil
+	if err := handler(c); err != nil || !called {
+		t.Fatalf("handler: called %v, err %v", called, err)
+	}
+}
+
+func TestCallbackFingerprint(t *testing.T) {
+	first := newFakeCallbackContext("cb1")
+	second := newFakeCallbackContext("cb2")
+	if callbackFingerprint(first) != callbackFingerprint(second) {
+		t.Errorf("taps of the same button differ: %q, %q", callbackFingerprint(first), callbackFingerprint(second))
+	}
+
+	// Сообщение изменилось: новое нажатие — новое действие
+	edited := newFakeCallbackContext("cb3")
+	edited.callback.Message.LastEdit = 1700000000
+	if callbackFingerprint(edited) == callbackFingerprint(first) {
+		t.Error("tap in an edited message has the fingerprint of the original message")
+	}
+
+	// Другая кнопка того же сообщения
+	other := newFakeCallbackContext("cb4")
+	other.callback.Data = "1:3"
+	if callbackFingerprint(other) == callbackFingerprint(first) {
+		t.Error("different buttons share a fingerprint")
+	}
+
+	// Inline-сообщение без Message: отпечаток по ID callback
+	inline := newFakeCallbackContext("cb5")
+	inline.callback.Message = nil
+	if got, want := callbackFingerprint(inline), "42:cb:cb5"; got != want {
+		t.Errorf("inline fingerprint = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// RegisterKeys регистрирует перевыпуск ключа подписки пользователем
+func (h *Handler) RegisterKeys(b *tele.Bot) {
+	b.Handle(&tele.Btn{Unique: "key_rotate"}, h.HandleKeyRotate)
+	b.Handle(&tele.Btn{Unique: "key_rotate_confirm"}, h.HandleKeyRotateConfirm)
+}
+
+// RegisterKeysAdmin регистрирует перевыпуск ключа из профиля пользователя в админке
+func (h *Handler) RegisterKeysAdmin(adminGroup *tele.Group) {
+	adminGroup.Handle(&tele.Btn{Unique: "admin_key_rotate"}, h.HandleAdminKeyRotate)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_key_rotate_confirm"}, h.HandleAdminKeyRotateConfirm)
+}
+
+// rotatedKeyText сообщение владельцу о новом ключе на языке lang
+func (h *Handler) rotatedKeyText(lang string, sub *models.Subscription) string {
+	return i18n.T(lang, "key.rotated",
+		sub.ID, sub.Product.CountryFlag, sub.Product.Name, sub.ExpiresAt.Format("02.01.2006"), h.subKey(sub))
+}
+
+// ================= ПОЛЬЗОВАТЕЛЬ =================
+
+// HandleKeyRotate спрашивает подтверждение перевыпуска ключа
+func (h *Handler) HandleKeyRotate(c tele.Context) error {
+	lang := h.lang(c)
+
+	subID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
+	}
+
+	text := i18n.T(lang, "key.rotate_confirm", subID)
+
+	id := strconv.FormatInt(subID, 10)
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data(i18n.T(lang, "key.rotate"), "key_rotate_confirm", id)),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "sub", id)),
+	)
+	return h.editOrResend(c, text, menu)
+}
+
+// HandleKeyRotateConfirm перевыпускает ключ подписки пользователя
+func (h *Handler) HandleKeyRotateConfirm(c tele.Context) error {
+	ctx := context.Background()
+	lang := h.lang(c)
+
+	subID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
+	}
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.generic"))
+	}
+
+	back := &tele.ReplyMarkup{}
+	back.Inline(back.Row(back.Data(i18n.T(lang, "common.to_sub"), "sub", c.Callback().Data)))
+
+	sub, err := h.svc.RotateUserKey(ctx, user, subID)
+	switch {
+	case errors.Is(err, service.ErrKeyRotationCooldown):
+		return h.editOrResend(c, i18n.T(lang, "key.cooldown"), back)
+	case errors.Is(err, service.ErrKeyRotationUnavailable):
+		return h.editOrResend(c, i18n.T(lang, "key.unavailable"), back)
+	case err != nil:
+		log.Printf("Failed to rotate key of subscription %d: %v", subID, err)
+		return h.editOrResend(c, i18n.T(lang, "key.failed"), back)
+	}
+
+	return h.editOrResend(c, h.rotatedKeyText(lang, sub), back)
+}
+
+// ================= АДМИНКА =================
+
+// HandleAdminKeyRotate спрашивает подтверждение перевыпуска ключа подписки пользователя
+func (h *Handler) HandleAdminKeyRotate(c tele.Context) error {
+	subID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	sub, err := h.svc.GetSubscriptionByID(context.Background(), subID)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Подписка не найдена"})
+	}
+
+	text := fmt.Sprintf(`🔄 *Перевыпуск ключа*
+
+📦 Подписка №%d %s %s до %s
+👤 VPN пользователь: `+"`%s`"+`
+
+Старый пользователь будет удалён из панели, новый создан с тем же сроком и лимитами. Пользователь получит новый ключ в боте.`,
+		sub.ID, sub.Product.CountryFlag, sub.Product.Name, sub.ExpiresAt.Format("02.01.2006"), sub.VPNUsername)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("✅ Перевыпустить", "admin_key_rotate_confirm", c.Callback().Data)),
+		menu.Row(menu.Data("❌ Отмена", "admin_back")),
+	)
+	return c.Edit(text, menu, tele.ModeMarkdown)
+}
+
+// HandleAdminKeyRotateConfirm перевыпускает ключ и отправляет его владельцу
+func (h *Handler) HandleAdminKeyRotateConfirm(c tele.Context) error {
+	ctx := context.Background()
+
+	subID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	sub, err := h.svc.AdminRotateKey(ctx, c.Sender().ID, subID)
+	if errors.Is(err, service.ErrKeyRotationUnavailable) {
+		return c.Edit("❌ Перевыпустить ключ нельзя: подписка не активна или изменилась.")
+	}
+	if err != nil {
+		log.Printf("Failed to rotate key of subscription %d: %v", subID, err)
+		return c.Edit(fmt.Sprintf("❌ Не удалось перевыпустить ключ: %v", err))
+	}
+
+	owner, err := h.svc.GetUserByID(ctx, sub.UserID)
+	notified := err == nil
+	if notified {
+		if _, err := c.Bot().Send(&tele.User{ID: owner.TelegramID}, h.rotatedKeyText(h.svc.UserLanguage(owner), sub), tele.ModeMarkdown); err != nil {
+			log.Printf("Failed to notify user %d about key rotation: %v", owner.TelegramID, err)
+			notified = false
+		}
+	}
+
+	text := fmt.Sprintf("✅ Ключ подписки №%d перевыпущен.\n👤 VPN пользователь: `%s`", sub.ID, sub.VPNUsername)
+	if !notified {
+		text += "\n\n⚠️ Не удалось отправить новый ключ пользователю."
+	}
+	return c.Edit(text, tele.ModeMarkdown)
+}
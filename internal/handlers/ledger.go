@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"vpn-telegram-bot/internal/models"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// ledgerDriftLimit сколько расхождений показывать в сообщении (полный список — go run ./cmd/bot -reconcile)
+const ledgerDriftLimit = 20
+
+// HandleAdminReconcile сверяет балансы пользователей с главной книгой: /reconcile
+func (h *Handler) HandleAdminReconcile(c tele.Context) error {
+	report, err := h.svc.ReconcileLedger(context.Background())
+	if err != nil {
+		log.Printf("Failed to reconcile ledger: %v", err)
+		return c.Send("❌ Не удалось выполнить сверку")
+	}
+	if report.OK() {
+		return c.Send("✅ Балансы всех пользователей совпадают с главной книгой")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("⚠️ *Расхождения с главной книгой*\n\n")
+	if report.UnbalancedPostings > 0 {
+		sb.WriteString(fmt.Sprintf("❗ Несбалансированных проводок: %d\n\n", report.UnbalancedPostings))
+	}
+	if len(report.Drift) > 0 {
+		sb.WriteString(fmt.Sprintf("👥 Пользователей с расхождением: %d\n", len(report.Drift)))
+		for i, d := range report.Drift {
+			if i == ledgerDriftLimit {
+				sb.WriteString(fmt.Sprintf("_…и ещё %d_\n", len(report.Drift)-ledgerDriftLimit))
+				break
+			}
+			sb.WriteString(fmt.Sprintf("• `%d`: баланс %s, книга %s (%+.2f)\n",
+				d.TelegramID, models.RUB.Format(models.Rubles(d.Balance)), models.RUB.Format(models.Rubles(d.Ledger)), models.Rubles(d.Balance-d.Ledger)))
+		}
+	}
+	return c.Send(sb.String(), tele.ModeMarkdown)
+}
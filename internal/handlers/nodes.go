@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// RegisterNodes регистрирует обработчики управления нодами
+func (h *Handler) RegisterNodes(adminGroup *tele.Group) {
+	adminGroup.Handle("/nodes", h.HandleAdminNodes)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_nodes"}, h.HandleAdminNodes)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_nodes_reload"}, h.HandleAdminNodesReload)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_node_toggle"}, h.HandleAdminNodeToggle)
+}
+
+// HandleAdminNodes показывает список нод с загрузкой
+func (h *Handler) HandleAdminNodes(c tele.Context) error {
+	ctx := context.Background()
+
+	nodes, err := h.svc.GetNodesStatus(ctx)
+	if err != nil {
+		log.Printf("Error getting nodes: %v", err)
+		return c.Send("❌ Ошибка загрузки нод")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🌐 *Серверы (ноды)*\n\n")
+
+	if len(nodes) == 0 {
+		sb.WriteString("_Ноды не добавлены. Все ключи создаются на панели из config.yaml._\n")
+	}
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+
+	for _, st := range nodes {
+		n := st.Node
+
+		status := "🟢"
+		switch {
+		case !n.IsEnabled:
+			status = "⚪️"
+		case !st.Healthy:
+			status = "🔴"
+		}
+
+		capacity := "∞"
+		if n.Capacity > 0 {
+			capacity = strconv.Itoa(n.Capacity)
+		}
+
+		sb.WriteString(fmt.Sprintf("%s *%s* (#%d, %s)\n", status, n.Name, n.ID, n.PanelType))
+		sb.WriteString(fmt.Sprintf("   👥 %d / %s", n.ActiveSubscriptions, capacity))
+		if n.Capacity > 0 {
+			sb.WriteString(fmt.Sprintf(" (%.0f%%)", n.Load()*100))
+		}
+		sb.WriteString("\n")
+		if st.Error != "" {
+			sb.WriteString(fmt.Sprintf("   ⚠️ `%s`\n", truncateNodeError(st.Error)))
+		}
+		sb.WriteString("\n")
+
+		toggleText := fmt.Sprintf("⏸ Выключить %s", n.Name)
+		if !n.IsEnabled {
+			toggleText = fmt.Sprintf("▶️ Включить %s", n.Name)
+		}
+		rows = append(rows, menu.Row(menu.Data(toggleText, "admin_node_toggle", strconv.FormatInt(n.ID, 10))))
+	}
+
+	sb.WriteString("🟢 работает  🔴 недоступна  ⚪️ выключена")
+
+	rows = append(rows,
+		menu.Row(menu.Data("🔄 Перечитать из БД", "admin_nodes_reload")),
+		menu.Row(menu.Data("🔙 Назад", "admin_back")),
+	)
+	menu.Inline(rows...)
+
+	if c.Callback() != nil {
+		return c.Edit(sb.String(), menu, tele.ModeMarkdown)
+	}
+	return c.Send(sb.String(), menu, tele.ModeMarkdown)
+}
+
+// HandleAdminNodesReload перечитывает ноды из БД
+func (h *Handler) HandleAdminNodesReload(c tele.Context) error {
+	if err := h.svc.ReloadNodes(context.Background()); err != nil {
+		log.Printf("Error reloading nodes: %v", err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка загрузки нод"})
+	}
+
+	c.Respond(&tele.CallbackResponse{Text: "✅ Ноды перезагружены"})
+	return h.HandleAdminNodes(c)
+}
+
+// HandleAdminNodeToggle включает/выключает ноду
+func (h *Handler) HandleAdminNodeToggle(c tele.Context) error {
+	ctx := context.Background()
+
+	nodeID, err := strconv.ParseInt(c.Data(), 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Неверная нода"})
+	}
+
+	nodes, err := h.svc.GetNodesStatus(ctx)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка загрузки нод"})
+	}
+
+	for _, st := range nodes {
+		if st.Node.ID != nodeID {
+			continue
+		}
+		if err := h.svc.SetNodeEnabled(ctx, nodeID, !st.Node.IsEnabled); err != nil {
+			log.Printf("Error toggling node %d: %v", nodeID, err)
+			return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+		}
+		c.Respond()
+		return h.HandleAdminNodes(c)
+	}
+
+	return c.Respond(&tele.CallbackResponse{Text: "❌ Нода не найдена"})
+}
+
+// truncateNodeError обрезает текст ошибки ноды для сообщения
+func truncateNodeError(s string) string {
+	s = strings.ReplaceAll(s, "`", "'")
+	if len([]rune(s)) > 80 {
+		return string([]rune(s)[:80]) + "…"
+	}
+	return s
+}
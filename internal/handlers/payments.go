@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/payment"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// RegisterPayments регистрирует оплату тарифа через шлюзы и уведомления об оплате
+func (h *Handler) RegisterPayments(b *tele.Bot) {
+	b.Handle(&tele.Btn{Unique: "pay_card"}, h.HandlePayCard)
+	b.Handle(&tele.Btn{Unique: "pay_crypto"}, h.HandlePayCrypto)
+	h.RegisterStars(b)
+
+	h.svc.OnPayment(func(res *service.PaymentResult) {
+		h.handlePaymentResult(b, res)
+	})
+}
+
+// PaymentWebhookHandler HTTP обработчик уведомлений платёжных шлюзов: POST /webhooks/payment/{gateway}
+func (h *Handler) PaymentWebhookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gateway := r.PathValue("gateway")
+
+		err := h.svc.ProcessPaymentWebhook(r.Context(), gateway, r)
+		if errors.Is(err, payment.ErrInvalidWebhook) {
+			log.Printf("⚠️ Rejected payment webhook (%s): %v", gateway, err)
+			http.Error(w, "invalid webhook", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			// 5xx: шлюз повторит уведомление позже
+			log.Printf("❌ Payment webhook error (%s): %v", gateway, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// HandlePayCard оплата тарифа через СБП
+func (h *Handler) HandlePayCard(c tele.Context) error {
+	return h.sendPlanInvoice(c, payment.MethodCard)
+}
+
+// HandlePayCrypto оплата тарифа криптовалютой (+7 дней)
+func (h *Handler) HandlePayCrypto(c tele.Context) error {
+	return h.sendPlanInvoice(c, payment.MethodCrypto)
+}
+
+// sendPlanInvoice выставляет счёт на план из callback
+func (h *Handler) sendPlanInvoice(c tele.Context, method string) error {
+	ctx := context.Background()
+	data := c.Callback().Data
+	lang := h.lang(c)
+
+	plan, product, err := h.planFromCallback(c)
+	if err != nil {
+		return h.planUnavailable(c, err)
+	}
+
+	planPrice, err := h.svc.ResolvePlanPrice(ctx, product, plan)
+	if err != nil {
+		log.Printf("Failed to resolve price: %v", err)
+		return c.Send(i18n.T(lang, "error.retry"))
+	}
+	price := planPrice.Price
+
+	// Шлюз не подключён — оплата вручную через поддержку
+	if !h.svc.HasPaymentGateway(method) {
+		return h.sendManualPayment(c, method, fmt.Sprintf("%.0f", price), "plan", data)
+	}
+
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.generic"))
+	}
+
+	inv, err := h.svc.CreatePlanInvoice(ctx, user.ID, method, plan, price)
+	if err != nil {
+		log.Printf("Error creating plan invoice for user %d: %v", user.TelegramID, err)
+		return c.Send(i18n.T(lang, "invoice.create_failed"))
+	}
+
+	var bonusText string
+	if inv.BonusDays > 0 {
+		bonusText = i18n.N(lang, "invoice.bonus_plan", inv.BonusDays)
+	}
+
+	text := i18n.T(lang, "invoice.plan",
+		paymentMethodTitle(lang, method), product.CountryFlag, product.Name, planPeriodTitle(lang, plan), inv.ID,
+		h.svc.UserCurrency(ctx, user).FormatWithBase(inv.Amount), bonusText)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.URL(i18n.T(lang, "invoice.pay"), inv.PaymentURL)),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "plan", data)),
+	)
+
+	return h.editOrResend(c, text, menu)
+}
+
+// sendTopUpInvoice создаёт счёт на пополнение в платёжном шлюзе и показывает ссылку на оплату
+func (h *Handler) sendTopUpInvoice(c tele.Context, method string, amountStr string) error {
+	ctx := context.Background()
+	lang := h.lang(c)
+
+	amount, err := strconv.ParseFloat(amountStr, 64)
+	if err != nil || amount <= 0 {
+		return c.Send(i18n.T(lang, "topup.bad_amount"))
+	}
+
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.generic"))
+	}
+
+	inv, err := h.svc.CreateTopUpInvoice(ctx, user.ID, method, amount)
+	if err != nil {
+		log.Printf("Error creating invoice for user %d: %v", user.TelegramID, err)
+		return c.Send(i18n.T(lang, "invoice.create_failed"))
+	}
+
+	var bonusText string
+	if inv.BonusDays > 0 {
+		bonusText = i18n.N(lang, "invoice.bonus_topup", inv.BonusDays)
+	}
+
+	text := i18n.T(lang, "invoice.topup",
+		paymentMethodTitle(lang, method), inv.ID, h.svc.UserCurrency(ctx, user).FormatWithBase(inv.Amount), bonusText)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.URL(i18n.T(lang, "invoice.pay"), inv.PaymentURL)),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "topup_amount", amountStr)),
+	)
+
+	return h.editOrResend(c, text, menu)
+}
+
+// sendManualPayment инструкция по ручной оплате через поддержку (если шлюз не подключён)
+func (h *Handler) sendManualPayment(c tele.Context, method string, amount string, backUnique string, backData string) error {
+	lang := h.lang(c)
+	value, _ := strconv.ParseFloat(amount, 64)
+	amountText := h.senderCurrency(c).FormatWithBase(value)
+
+	var text string
+	if method == payment.MethodCrypto {
+		text = i18n.T(lang, "manual.crypto", amountText, i18n.N(lang, "period.days", models.CryptoBonusDays))
+	} else {
+		text = i18n.T(lang, "manual.sbp", amountText)
+	}
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.URL(i18n.T(lang, "common.write_support"), "https://t.me/XRAY_LUV")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), backUnique, backData)),
+	)
+
+	return h.editOrResend(c, text, menu)
+}
+
+// handlePaymentResult уведомляет пользователя (и реферера) о зачисленной оплате
+// и об итоге повторной активации оплаченного счёта
+func (h *Handler) handlePaymentResult(b *tele.Bot, res *service.PaymentResult) {
+	if !res.Credited {
+		if res.Invoice.Purpose == models.InvoicePurposeSubscription {
+			h.notifyPlanPaid(b, res)
+		}
+		return
+	}
+
+	if res.Invoice.Purpose == models.InvoicePurposeSubscription {
+		h.notifyPlanPaid(b, res)
+	} else {
+		h.notifyTopUpCredited(b, res.TelegramID, res.Invoice.Amount, res.Invoice.BonusDays)
+	}
+
+	if res.ReferrerTelegramID != nil && res.ReferralBonus > 0 {
+		h.notifyReferralBonus(b, *res.ReferrerTelegramID, res.ReferralBonus)
+	}
+}
+
+// notifyTopUpCredited уведомляет пользователя о зачислении оплаты
+func (h *Handler) notifyTopUpCredited(b *tele.Bot, telegramID int64, amount float64, bonusDays int) {
+	lang := h.langOf(telegramID)
+
+	var bonusText string
+	if bonusDays > 0 {
+		bonusText = i18n.N(lang, "payment.topup_bonus", bonusDays)
+	}
+
+	text := i18n.T(lang, "payment.topup_credited", h.currencyOf(telegramID).FormatWithBase(amount), bonusText)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data(i18n.T(lang, "payment.buy_sub"), "tariffs")),
+		menu.Row(menu.Data(i18n.T(lang, "payment.my_balance"), "balance")),
+	)
+
+	if _, err := b.Send(&tele.User{ID: telegramID}, text, menu, tele.ModeMarkdown); err != nil {
+		log.Printf("Failed to notify user %d about payment: %v", telegramID, err)
+	}
+}
+
+// notifyPlanPaid уведомляет пользователя об оплате тарифа и отправляет ключ
+func (h *Handler) notifyPlanPaid(b *tele.Bot, res *service.PaymentResult) {
+	inv := res.Invoice
+	lang := h.langOf(res.TelegramID)
+
+	if res.ActivationErr == nil && res.Subscription == nil {
+		// Активацию завершил другой вызов, он и уведомил
+		return
+	}
+
+	if res.ActivationErr != nil {
+		key := "payment.activation_failed"
+		var rows []tele.Row
+		menu := &tele.ReplyMarkup{}
+		if res.ActivationRetry {
+			// Подписка ещё будет создана: не предлагаем купить её второй раз
+			key = "payment.activation_retry"
+		} else {
+			rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "menu.tariffs"), "tariffs")))
+		}
+		rows = append(rows, menu.Row(menu.URL(i18n.T(lang, "common.write_support"), "https://t.me/XRAY_LUV")))
+		menu.Inline(rows...)
+
+		text := i18n.T(lang, key, h.currencyOf(res.TelegramID).Format(inv.Amount))
+
+		if _, err := b.Send(&tele.User{ID: res.TelegramID}, text, menu, tele.ModeMarkdown); err != nil {
+			log.Printf("Failed to notify user %d about failed activation: %v", res.TelegramID, err)
+		}
+		return
+	}
+
+	sub := res.Subscription
+
+	var bonusText string
+	if inv.BonusDays > 0 {
+		bonusText = i18n.N(lang, "pay.bonus_days", inv.BonusDays)
+	}
+
+	text := i18n.T(lang, "payment.plan_paid",
+		sub.Product.CountryFlag, sub.Product.Name, invoicePeriodText(lang, inv), bonusText,
+		sub.ExpiresAt.Format("02.01.2006"),
+		h.subKey(sub))
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data(i18n.T(lang, "common.instruction"), "instruction")),
+		menu.Row(menu.Data(i18n.T(lang, "common.my_subs"), "mysubs")),
+	)
+
+	if _, err := b.Send(&tele.User{ID: res.TelegramID}, text, menu, tele.ModeMarkdown); err != nil {
+		log.Printf("Failed to notify user %d about subscription: %v", res.TelegramID, err)
+	}
+}
+
+// notifyReferralBonus уведомляет реферера о начисленном бонусе
+func (h *Handler) notifyReferralBonus(b *tele.Bot, telegramID int64, bonus float64) {
+	text := i18n.T(h.langOf(telegramID), "payment.referral_bonus", h.currencyOf(telegramID).Format(bonus))
+
+	if _, err := b.Send(&tele.User{ID: telegramID}, text, tele.ModeMarkdown); err != nil {
+		log.Printf("Failed to notify referrer %d about bonus: %v", telegramID, err)
+	}
+}
+
+// editOrResend редактирует сообщение; сообщения с баннером (фото) заменяются новым текстовым
+func (h *Handler) editOrResend(c tele.Context, text string, menu *tele.ReplyMarkup) error {
+	if c.Message() != nil && c.Message().Photo != nil {
+		c.Delete()
+		return c.Send(text, menu, tele.ModeMarkdown)
+	}
+	return c.Edit(text, menu, tele.ModeMarkdown)
+}
+
+// paymentMethodTitle заголовок счёта для способа оплаты
+func paymentMethodTitle(lang, method string) string {
+	if method == payment.MethodCrypto {
+		return i18n.T(lang, "invoice.title_crypto")
+	}
+	return i18n.T(lang, "invoice.title_sbp")
+}
@@ -0,0 +1,561 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"vpn-telegram-bot/internal/fsm"
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Поля плана, которые админ редактирует вводом текста
+const (
+	planFieldPeriod   = "period"
+	planFieldPrice    = "price"
+	planFieldDiscount = "discount"
+	planFieldSort     = "sort"
+	planFieldTraffic  = "traffic"
+	planFieldDevices  = "devices"
+)
+
+// planEditData данные ввода поля плана (PlanID = 0 — создание нового плана продукта)
+type planEditData struct {
+	ProductID int64  `json:"product_id"`
+	PlanID    int64  `json:"plan_id"`
+	Field     string `json:"field"`
+}
+
+// planPeriodRe число и единица срока: "3", "3 мес", "7д", "15 дней"
+var planPeriodRe = regexp.MustCompile(`(\d+)\s*([a-zа-яё.]*)`)
+
+// RegisterPlans регистрирует управление тарифными планами продуктов
+func (h *Handler) RegisterPlans(adminGroup *tele.Group) {
+	adminGroup.Handle("/plans", h.HandleAdminPlans)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_plans"}, h.HandleAdminPlans)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_plans_product"}, h.HandleAdminProductPlans)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_plan"}, h.HandleAdminPlan)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_plan_add"}, h.HandleAdminPlanAdd)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_plan_edit"}, h.HandleAdminPlanEdit)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_plan_toggle"}, h.HandleAdminPlanToggle)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_plan_delete"}, h.HandleAdminPlanDelete)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_plan_delete_confirm"}, h.HandleAdminPlanDeleteConfirm)
+
+	h.fsm.Handle(statePlanEdit, h.HandleAdminPlanInput)
+	h.registerTrafficAdmin(adminGroup)
+	h.registerDevicesAdmin(adminGroup)
+}
+
+// ================= ПОКУПКА: ПЛАН ИЗ CALLBACK =================
+
+// planFromCallback загружает продаваемый план и его продукт по ID плана из callback
+func (h *Handler) planFromCallback(c tele.Context) (*models.ProductPlan, *models.Product, error) {
+	planID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: bad callback %q", service.ErrPlanUnavailable, c.Callback().Data)
+	}
+	return h.svc.GetPlanForSale(context.Background(), planID)
+}
+
+// planUnavailable сообщает, что план нельзя купить (скрыт, удалён или устаревшая кнопка)
+func (h *Handler) planUnavailable(c tele.Context, err error) error {
+	lang := h.lang(c)
+	if !errors.Is(err, service.ErrPlanUnavailable) {
+		log.Printf("Failed to load plan: %v", err)
+		return c.Send(i18n.T(lang, "error.retry"))
+	}
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data(i18n.T(lang, "menu.tariffs"), "tariffs")))
+	return c.Send(i18n.T(lang, "plan.unavailable"), menu)
+}
+
+// planButtonText кнопка плана: срок и цена в валюте пользователя, со скидкой плана или зачёркнутой ценой при акции
+func planButtonText(p service.PlanPrice, period string, cur *models.Currency) string {
+	switch {
+	case p.Campaign != nil:
+		return fmt.Sprintf("%s ~%s~ %s 🔥", period, cur.Format(p.BasePrice), cur.Format(p.Price))
+	case p.PeriodDiscount > 0:
+		return fmt.Sprintf("%s (-%d%%) — %s", period, p.PeriodDiscount, cur.Format(p.Price))
+	default:
+		return fmt.Sprintf("%s — %s", period, cur.Format(p.Price))
+	}
+}
+
+// planPeriodText срок плана словами на языке lang: "1 месяц", "6 месяцев", "1 месяц 15 дней"
+func planPeriodText(lang string, plan *models.ProductPlan) string {
+	var parts []string
+	if plan.Months > 0 {
+		parts = append(parts, i18n.N(lang, "period.months", plan.Months))
+	}
+	if plan.Days > 0 {
+		parts = append(parts, i18n.N(lang, "period.days", plan.Days))
+	}
+	return strings.Join(parts, " ")
+}
+
+// planPeriodTitle короткая запись срока плана на языке lang: "3 мес.", "1 мес. 15 дн."
+func planPeriodTitle(lang string, plan *models.ProductPlan) string {
+	return service.PlanPeriodTitle(lang, plan)
+}
+
+// invoicePeriodText срок подписки из счёта на языке lang
+func invoicePeriodText(lang string, inv *models.Invoice) string {
+	return planPeriodTitle(lang, &models.ProductPlan{Months: inv.Months, Days: inv.Days})
+}
+
+// ================= АДМИНКА: ТАРИФНЫЕ ПЛАНЫ =================
+
+// HandleAdminPlans показывает продукты для настройки планов
+func (h *Handler) HandleAdminPlans(c tele.Context) error {
+	ctx := context.Background()
+	h.resetState(c.Sender().ID)
+
+	products, err := h.svc.GetAllProducts(ctx)
+	if err != nil {
+		log.Printf("Error getting products: %v", err)
+		return c.Send("❌ Ошибка загрузки продуктов")
+	}
+
+	text := `💲 *Тарифы*
+
+Планы продукта — сроки подписки и их цены. Из них строятся кнопки покупки, продления и суммы пополнения баланса.
+
+Выберите продукт:`
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for _, p := range products {
+		btnText := fmt.Sprintf("%s %s — %s/мес", p.CountryFlag, p.Name, models.RUB.Format(p.BasePrice))
+		rows = append(rows, menu.Row(menu.Data(btnText, "admin_plans_product", strconv.FormatInt(p.ID, 10))))
+	}
+	rows = append(rows, menu.Row(menu.Data("🔙 Назад", "admin_back")))
+	menu.Inline(rows...)
+
+	if c.Callback() != nil {
+		return c.Edit(text, menu, tele.ModeMarkdown)
+	}
+	return c.Send(text, menu, tele.ModeMarkdown)
+}
+
+// HandleAdminProductPlans показывает планы продукта
+func (h *Handler) HandleAdminProductPlans(c tele.Context) error {
+	productID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	h.resetState(c.Sender().ID)
+	return h.showProductPlans(c, productID)
+}
+
+// showProductPlans список планов продукта, включая скрытые
+func (h *Handler) showProductPlans(c tele.Context, productID int64) error {
+	ctx := context.Background()
+
+	product, err := h.svc.GetProductByID(ctx, productID)
+	if err != nil {
+		return c.Send("❌ Продукт не найден")
+	}
+	plans, err := h.svc.GetAllProductPlans(ctx, productID)
+	if err != nil {
+		log.Printf("Error getting plans of product %d: %v", productID, err)
+		return c.Send("❌ Ошибка загрузки планов")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("💲 *Тарифы: %s %s*\n", product.CountryFlag, product.Name))
+	sb.WriteString(fmt.Sprintf("Базовая цена: *%s/мес*\n", models.RUB.Format(product.BasePrice)))
+	sb.WriteString(fmt.Sprintf("Трафик: *%s*, сброс %s\n", quotaTitle(product.TrafficLimitGB), resetTitle(product.TrafficReset)))
+	sb.WriteString(fmt.Sprintf("Устройства: *%s*\n\n", deviceLimitTitle(i18n.Default, product.DeviceLimit)))
+
+	if len(plans) == 0 {
+		sb.WriteString("_Планов нет — продукт нельзя купить._\n")
+	}
+
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for i := range plans {
+		plan := &plans[i]
+		status := "🟢"
+		if !plan.IsActive {
+			status = "⚪️"
+		}
+		price := plan.CalculatePrice(product.BasePrice)
+		sb.WriteString(fmt.Sprintf("%s *%s* — %s (%s)\n", status, plan.PeriodTitle(), models.RUB.Format(price), planPriceRule(plan)))
+
+		btnText := fmt.Sprintf("%s %s — %s", status, plan.PeriodTitle(), models.RUB.Format(price))
+		rows = append(rows, menu.Row(menu.Data(btnText, "admin_plan", strconv.FormatInt(plan.ID, 10))))
+	}
+	sb.WriteString("\n🟢 продаётся  ⚪️ скрыт")
+
+	rows = append(rows,
+		menu.Row(menu.Data("➕ Добавить план", "admin_plan_add", strconv.FormatInt(productID, 10))),
+		menu.Row(
+			menu.Data("📶 Трафик и пакеты", "admin_traffic", strconv.FormatInt(productID, 10)),
+			menu.Data("📱 Устройства", "admin_devices", strconv.FormatInt(productID, 10)),
+		),
+		menu.Row(menu.Data("🔙 Назад", "admin_plans")),
+	)
+	menu.Inline(rows...)
+
+	if c.Callback() != nil {
+		return c.Edit(sb.String(), menu, tele.ModeMarkdown)
+	}
+	return c.Send(sb.String(), menu, tele.ModeMarkdown)
+}
+
+// planPriceRule как считается цена плана
+func planPriceRule(plan *models.ProductPlan) string {
+	switch {
+	case plan.Price != nil:
+		return "фикс."
+	case plan.DiscountPercent > 0:
+		return fmt.Sprintf("база −%d%%", plan.DiscountPercent)
+	default:
+		return "база"
+	}
+}
+
+// HandleAdminPlan показывает карточку плана
+func (h *Handler) HandleAdminPlan(c tele.Context) error {
+	planID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	h.resetState(c.Sender().ID)
+	return h.showPlan(c, planID)
+}
+
+// showPlan карточка плана с кнопками редактирования
+func (h *Handler) showPlan(c tele.Context, planID int64) error {
+	ctx := context.Background()
+
+	plan, err := h.svc.GetProductPlan(ctx, planID)
+	if err != nil {
+		return c.Send("❌ План не найден")
+	}
+	product, err := h.svc.GetProductByID(ctx, plan.ProductID)
+	if err != nil {
+		return c.Send("❌ Продукт не найден")
+	}
+
+	status := "🟢 продаётся"
+	toggleText := "⏸ Скрыть"
+	if !plan.IsActive {
+		status = "⚪️ скрыт"
+		toggleText = "▶️ Показать"
+	}
+
+	priceRule := fmt.Sprintf("базовая %s/мес × срок", models.RUB.Format(product.BasePrice))
+	if plan.Price != nil {
+		priceRule = "фиксированная"
+	} else if plan.DiscountPercent > 0 {
+		priceRule += fmt.Sprintf(" − %d%%", plan.DiscountPercent)
+	}
+
+	traffic := quotaTitle(plan.TrafficQuota(product).LimitGB)
+	if plan.TrafficLimitGB == nil {
+		traffic += " (как у продукта)"
+	}
+	devices := deviceLimitTitle(i18n.Default, plan.Limits(product).Devices)
+	if plan.DeviceLimit == nil {
+		devices += " (как у продукта)"
+	}
+
+	text := fmt.Sprintf(`💲 *План #%d* — %s %s
+
+📅 Срок: *%s*
+💰 Цена: *%s* (%s)
+📶 Трафик: *%s*
+📱 Устройства: *%s*
+🔢 Порядок: %d
+Статус: %s`,
+		plan.ID, product.CountryFlag, product.Name,
+		planPeriodText(i18n.Default, plan),
+		models.RUB.Format(plan.CalculatePrice(product.BasePrice)), priceRule,
+		traffic,
+		devices,
+		plan.SortOrder,
+		status)
+
+	id := strconv.FormatInt(plan.ID, 10)
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(
+			menu.Data("📅 Срок", "admin_plan_edit", id+":"+planFieldPeriod),
+			menu.Data("💰 Цена", "admin_plan_edit", id+":"+planFieldPrice),
+		),
+		menu.Row(
+			menu.Data("📉 Скидка", "admin_plan_edit", id+":"+planFieldDiscount),
+			menu.Data("🔢 Порядок", "admin_plan_edit", id+":"+planFieldSort),
+		),
+		menu.Row(
+			menu.Data("📶 Трафик", "admin_plan_edit", id+":"+planFieldTraffic),
+			menu.Data("📱 Устройства", "admin_plan_edit", id+":"+planFieldDevices),
+		),
+		menu.Row(
+			menu.Data(toggleText, "admin_plan_toggle", id),
+			menu.Data("🗑 Удалить", "admin_plan_delete", id),
+		),
+		menu.Row(menu.Data("🔙 К планам", "admin_plans_product", strconv.FormatInt(plan.ProductID, 10))),
+	)
+
+	if c.Callback() != nil {
+		return c.Edit(text, menu, tele.ModeMarkdown)
+	}
+	return c.Send(text, menu, tele.ModeMarkdown)
+}
+
+// HandleAdminPlanAdd начинает создание плана: админ вводит срок
+func (h *Handler) HandleAdminPlanAdd(c tele.Context) error {
+	productID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	h.setState(c.Sender().ID, statePlanEdit, planEditData{ProductID: productID, Field: planFieldPeriod})
+
+	text := `➕ *Новый план*
+
+👇 Введите срок подписки: ` + "`1м`, `3 мес`, `7д`, `1м 15д`" + `
+
+Цена нового плана — базовая цена продукта × срок, без скидки. Её можно изменить после создания.`
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("❌ Отмена", "admin_plans_product", strconv.FormatInt(productID, 10))))
+	return c.Edit(text, menu, tele.ModeMarkdown)
+}
+
+// HandleAdminPlanEdit запрашивает новое значение поля плана ("planID:field")
+func (h *Handler) HandleAdminPlanEdit(c tele.Context) error {
+	parts := strings.Split(c.Callback().Data, ":")
+	if len(parts) != 2 {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	planID, _ := strconv.ParseInt(parts[0], 10, 64)
+
+	plan, err := h.svc.GetProductPlan(context.Background(), planID)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ План не найден"})
+	}
+
+	var prompt string
+	switch parts[1] {
+	case planFieldPeriod:
+		prompt = "📅 Введите срок: `1м`, `3 мес`, `7д`, `1м 15д`"
+	case planFieldPrice:
+		prompt = "💰 Введите фиксированную цену в рублях.\n\n`0` — считать цену от базовой цены продукта со скидкой плана."
+	case planFieldDiscount:
+		prompt = "📉 Введите скидку от базовой цены, % (0–99).\n\n_Скидка применяется, только если у плана нет фиксированной цены._"
+	case planFieldSort:
+		prompt = "🔢 Введите порядковый номер (чем меньше, тем выше кнопка)."
+	case planFieldTraffic:
+		prompt = "📶 Введите квоту трафика плана в ГБ.\n\n`0` — безлимит, `-` — как у продукта."
+	case planFieldDevices:
+		prompt = "📱 Введите число одновременных устройств.\n\n`0` — без ограничений, `-` — как у продукта."
+	default:
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	h.setState(c.Sender().ID, statePlanEdit, planEditData{ProductID: plan.ProductID, PlanID: plan.ID, Field: parts[1]})
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("❌ Отмена", "admin_plan", strconv.FormatInt(plan.ID, 10))))
+	return c.Edit(fmt.Sprintf("✏️ *План #%d — %s*\n\n%s", plan.ID, plan.PeriodTitle(), prompt), menu, tele.ModeMarkdown)
+}
+
+// HandleAdminPlanInput применяет введённое значение; при ошибке ввода состояние сохраняется
+func (h *Handler) HandleAdminPlanInput(c tele.Context, s *fsm.Session) error {
+	ctx := context.Background()
+
+	var data planEditData
+	if err := s.Decode(&data); err != nil || data.ProductID == 0 {
+		h.resetState(c.Sender().ID)
+		return c.Send("❌ Сессия истекла. Начните заново: /plans")
+	}
+	input := strings.TrimSpace(c.Text())
+
+	// Новый план: введён срок
+	if data.PlanID == 0 {
+		months, days, err := parsePlanPeriod(input)
+		if err != nil {
+			return c.Send("❌ Не понял срок. Примеры: `1м`, `3 мес`, `7д`, `1м 15д`", tele.ModeMarkdown)
+		}
+		plan, err := h.svc.CreateProductPlan(ctx, &models.ProductPlan{
+			ProductID: data.ProductID,
+			Months:    months,
+			Days:      days,
+			IsActive:  true,
+		})
+		if err != nil {
+			return h.sendPlanError(c, err)
+		}
+		h.resetState(c.Sender().ID)
+		return h.showPlan(c, plan.ID)
+	}
+
+	plan, err := h.svc.GetProductPlan(ctx, data.PlanID)
+	if err != nil {
+		h.resetState(c.Sender().ID)
+		return c.Send("❌ План не найден")
+	}
+
+	switch data.Field {
+	case planFieldPeriod:
+		months, days, err := parsePlanPeriod(input)
+		if err != nil {
+			return c.Send("❌ Не понял срок. Примеры: `1м`, `3 мес`, `7д`, `1м 15д`", tele.ModeMarkdown)
+		}
+		plan.Months, plan.Days = months, days
+	case planFieldPrice:
+		price, err := strconv.ParseFloat(strings.ReplaceAll(input, ",", "."), 64)
+		if err != nil || price < 0 {
+			return c.Send("❌ Введите цену числом, например `990`", tele.ModeMarkdown)
+		}
+		plan.Price = nil
+		if price > 0 {
+			plan.Price = &price
+		}
+	case planFieldDiscount:
+		discount, err := strconv.Atoi(strings.TrimSuffix(input, "%"))
+		if err != nil {
+			return c.Send("❌ Введите скидку числом от 0 до 99")
+		}
+		plan.DiscountPercent = discount
+	case planFieldSort:
+		order, err := strconv.Atoi(input)
+		if err != nil {
+			return c.Send("❌ Введите порядковый номер числом")
+		}
+		plan.SortOrder = order
+	case planFieldTraffic:
+		plan.TrafficLimitGB = nil
+		if input != "-" {
+			limitGB, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(input), "гб"))
+			if err != nil {
+				return c.Send("❌ Введите квоту числом в ГБ или `-`", tele.ModeMarkdown)
+			}
+			plan.TrafficLimitGB = &limitGB
+		}
+	case planFieldDevices:
+		plan.DeviceLimit = nil
+		if input != "-" {
+			limit, err := strconv.Atoi(input)
+			if err != nil {
+				return c.Send("❌ Введите число устройств или `-`", tele.ModeMarkdown)
+			}
+			plan.DeviceLimit = &limit
+		}
+	default:
+		h.resetState(c.Sender().ID)
+		return c.Send("❌ Сессия истекла. Начните заново: /plans")
+	}
+
+	if err := h.svc.UpdateProductPlan(ctx, plan); err != nil {
+		return h.sendPlanError(c, err)
+	}
+	h.resetState(c.Sender().ID)
+	return h.showPlan(c, plan.ID)
+}
+
+// sendPlanError сообщает об ошибке сохранения плана
+func (h *Handler) sendPlanError(c tele.Context, err error) error {
+	if errors.Is(err, service.ErrPlanInvalid) {
+		return c.Send("❌ Недопустимое значение: срок до 120 мес. / 3650 дн., цена больше нуля, скидка 0–99%, трафик до 100000 ГБ, устройств до 100. Попробуйте ещё раз.")
+	}
+	log.Printf("Failed to save plan: %v", err)
+	h.resetState(c.Sender().ID)
+	return c.Send("❌ Не удалось сохранить план")
+}
+
+// parsePlanPeriod разбирает срок: "3" и "3м" — месяцы, "7д" — дни, "1м 15д" — месяцы и дни
+func parsePlanPeriod(input string) (months, days int, err error) {
+	matches := planPeriodRe.FindAllStringSubmatch(strings.ToLower(input), -1)
+	if len(matches) == 0 {
+		return 0, 0, fmt.Errorf("no period in %q", input)
+	}
+	for _, m := range matches {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, 0, err
+		}
+		switch {
+		case m[2] == "" || strings.HasPrefix(m[2], "м") || strings.HasPrefix(m[2], "m"):
+			months += n
+		case strings.HasPrefix(m[2], "д") || strings.HasPrefix(m[2], "d"):
+			days += n
+		default:
+			return 0, 0, fmt.Errorf("unknown unit %q", m[2])
+		}
+	}
+	return months, days, nil
+}
+
+// HandleAdminPlanToggle скрывает план из продажи или возвращает его
+func (h *Handler) HandleAdminPlanToggle(c tele.Context) error {
+	ctx := context.Background()
+	planID, _ := strconv.ParseInt(c.Callback().Data, 10, 64)
+
+	plan, err := h.svc.GetProductPlan(ctx, planID)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ План не найден"})
+	}
+	plan.IsActive = !plan.IsActive
+	if err := h.svc.UpdateProductPlan(ctx, plan); err != nil {
+		log.Printf("Failed to toggle plan %d: %v", planID, err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	respText := "⏸ План скрыт"
+	if plan.IsActive {
+		respText = "▶️ План снова продаётся"
+	}
+	c.Respond(&tele.CallbackResponse{Text: respText})
+	return h.showPlan(c, planID)
+}
+
+// HandleAdminPlanDelete запрашивает подтверждение удаления плана
+func (h *Handler) HandleAdminPlanDelete(c tele.Context) error {
+	planID, _ := strconv.ParseInt(c.Callback().Data, 10, 64)
+
+	plan, err := h.svc.GetProductPlan(context.Background(), planID)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ План не найден"})
+	}
+
+	text := fmt.Sprintf(`🗑 *Удалить план #%d (%s)?*
+
+Кнопки с этим планом у пользователей перестанут работать. Чтобы временно убрать план из продажи, его можно скрыть.`, plan.ID, plan.PeriodTitle())
+
+	id := strconv.FormatInt(plan.ID, 10)
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data("🗑 Да, удалить", "admin_plan_delete_confirm", id)),
+		menu.Row(menu.Data("❌ Отмена", "admin_plan", id)),
+	)
+	return c.Edit(text, menu, tele.ModeMarkdown)
+}
+
+// HandleAdminPlanDeleteConfirm удаляет план
+func (h *Handler) HandleAdminPlanDeleteConfirm(c tele.Context) error {
+	ctx := context.Background()
+	planID, _ := strconv.ParseInt(c.Callback().Data, 10, 64)
+
+	plan, err := h.svc.GetProductPlan(ctx, planID)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ План не найден"})
+	}
+	if err := h.svc.DeleteProductPlan(ctx, planID); err != nil {
+		log.Printf("Failed to delete plan %d: %v", planID, err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка удаления"})
+	}
+
+	c.Respond(&tele.CallbackResponse{Text: "🗑 План удалён"})
+	return h.showProductPlans(c, plan.ProductID)
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// purchaseFailed сообщает об ошибке покупки с баланса (кроме нехватки средств — у неё свой экран)
+func (h *Handler) purchaseFailed(c tele.Context, err error) error {
+	lang := h.lang(c)
+	switch {
+	case errors.Is(err, service.ErrPlanUnavailable):
+		return h.planUnavailable(c, err)
+	case errors.Is(err, service.ErrSubscriptionNotFound):
+		return c.Send(i18n.T(lang, "error.sub_not_found"))
+	case errors.Is(err, service.ErrPurchaseInProgress):
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "purchase.in_progress")})
+	}
+
+	log.Printf("Purchase failed for %d: %v", c.Sender().ID, err)
+	return c.Send(i18n.T(lang, "purchase.failed"))
+}
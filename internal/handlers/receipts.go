@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"vpn-telegram-bot/internal/fsm"
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// receiptData сумма, для которой пользователь отправляет чек
+type receiptData struct {
+	Amount float64 `json:"amount"`
+}
+
+// SetManualSBP настраивает оплату СБП по реквизитам с проверкой чеков (reviewChatID = 0 — группа поддержки)
+func (h *Handler) SetManualSBP(details string, reviewChatID int64) {
+	h.sbpDetails = details
+	h.receiptChatID = reviewChatID
+	if h.receiptChatID == 0 {
+		h.receiptChatID = h.supportGroupID
+	}
+}
+
+// RegisterReceipts регистрирует отправку чеков пользователями и их проверку админами
+func (h *Handler) RegisterReceipts(b *tele.Bot, adminGroup *tele.Group) {
+	b.Handle(&tele.Btn{Unique: "receipt_start"}, h.HandleReceiptStart)
+	b.Handle(&tele.Btn{Unique: "receipt_cancel"}, h.HandleReceiptCancel)
+	h.fsm.HandleAny(stateReceipt, h.HandleReceiptPhoto)
+
+	adminGroup.Handle("/receipts", h.HandleAdminReceipts)
+	adminGroup.Handle(&tele.Btn{Unique: "receipt_approve"}, h.HandleReceiptApprove)
+	adminGroup.Handle(&tele.Btn{Unique: "receipt_reject"}, h.HandleReceiptReject)
+}
+
+// sendReceiptPayment показывает реквизиты СБП и предлагает прислать чек
+func (h *Handler) sendReceiptPayment(c tele.Context, amount string) error {
+	lang := h.lang(c)
+	value, _ := strconv.ParseFloat(amount, 64)
+	text := i18n.T(lang, "receipt.payment", h.senderCurrency(c).FormatWithBase(value), h.sbpDetails)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data(i18n.T(lang, "receipt.send"), "receipt_start", amount)),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "topup_amount", amount)),
+	)
+
+	return h.editOrResend(c, text, menu)
+}
+
+// HandleReceiptStart переводит пользователя в режим отправки чека
+func (h *Handler) HandleReceiptStart(c tele.Context) error {
+	lang := h.lang(c)
+	amountStr := c.Callback().Data
+	amount, err := strconv.ParseFloat(amountStr, 64)
+	if err != nil || amount <= 0 {
+		return c.Send(i18n.T(lang, "topup.bad_amount"))
+	}
+
+	h.setState(c.Sender().ID, stateReceipt, receiptData{Amount: amount})
+
+	text := i18n.T(lang, "receipt.prompt", h.senderCurrency(c).FormatWithBase(amount))
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data(i18n.T(lang, "common.cancel"), "receipt_cancel", amountStr)),
+	)
+
+	return h.editOrResend(c, text, menu)
+}
+
+// HandleReceiptCancel выходит из режима отправки чека
+func (h *Handler) HandleReceiptCancel(c tele.Context) error {
+	h.resetState(c.Sender().ID)
+	return h.sendReceiptPayment(c, c.Callback().Data)
+}
+
+// HandleReceiptPhoto принимает фото чека и отправляет его на проверку
+func (h *Handler) HandleReceiptPhoto(c tele.Context, s *fsm.Session) error {
+	ctx := context.Background()
+	lang := h.lang(c)
+
+	var data receiptData
+	if err := s.Decode(&data); err != nil {
+		return err
+	}
+	amountStr := strconv.FormatFloat(data.Amount, 'f', -1, 64)
+
+	if c.Message().Photo == nil {
+		menu := &tele.ReplyMarkup{}
+		menu.Inline(
+			menu.Row(menu.Data(i18n.T(lang, "common.cancel"), "receipt_cancel", amountStr)),
+		)
+		return c.Send(i18n.T(lang, "receipt.photo_only"), menu, tele.ModeMarkdown)
+	}
+
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.generic"))
+	}
+
+	t, err := h.svc.SubmitReceipt(ctx, user.ID, data.Amount, c.Message().Photo.FileID)
+	if err != nil {
+		log.Printf("Error submitting receipt for user %d: %v", user.TelegramID, err)
+		return c.Send(i18n.T(lang, "receipt.save_failed"))
+	}
+	h.resetState(c.Sender().ID)
+
+	if err := h.sendReceiptForReview(c.Bot(), h.receiptChatID, t, user); err != nil {
+		log.Printf("Failed to send receipt #%d for review: %v", t.ID, err)
+	}
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data(i18n.T(lang, "payment.my_balance"), "balance")),
+	)
+
+	return c.Send(i18n.T(lang, "receipt.sent", t.ID, h.svc.UserCurrency(ctx, user).FormatWithBase(t.Amount)), menu, tele.ModeMarkdown)
+}
+
+// sendReceiptForReview отправляет чек в чат проверки с кнопками «Зачислить» / «Отклонить» (на языке по умолчанию)
+func (h *Handler) sendReceiptForReview(b *tele.Bot, chatID int64, t *models.Transaction, user *models.User) error {
+	username := i18n.T(i18n.Default, "receipt.review_no_username")
+	if user.Username != "" {
+		username = "@" + user.Username
+	}
+
+	caption := i18n.T(i18n.Default, "receipt.review_caption",
+		t.ID, username, user.TelegramID, models.RUB.Format(t.Amount), t.CreatedAt.Format("02.01.2006 15:04"))
+
+	idStr := strconv.FormatInt(t.ID, 10)
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(
+			menu.Data(i18n.T(i18n.Default, "receipt.review_approve"), "receipt_approve", idStr),
+			menu.Data(i18n.T(i18n.Default, "receipt.review_reject"), "receipt_reject", idStr),
+		),
+	)
+
+	photo := &tele.Photo{
+		File:    tele.File{FileID: t.ReceiptFileID},
+		Caption: caption,
+	}
+	_, err := b.Send(&tele.Chat{ID: chatID}, photo, menu)
+	return err
+}
+
+// HandleAdminReceipts показывает очередь непроверенных чеков
+func (h *Handler) HandleAdminReceipts(c tele.Context) error {
+	ctx := context.Background()
+
+	pending, err := h.svc.GetPendingReceipts(ctx, 20)
+	if err != nil {
+		return c.Send(fmt.Sprintf("❌ Ошибка: %v", err))
+	}
+	if len(pending) == 0 {
+		return c.Send("✅ Нет чеков на проверке")
+	}
+
+	c.Send(fmt.Sprintf("🧾 Чеков на проверке: %d", len(pending)))
+	for i := range pending {
+		user, err := h.svc.GetUserByID(ctx, pending[i].UserID)
+		if err != nil {
+			log.Printf("Receipt #%d: failed to load user: %v", pending[i].ID, err)
+			continue
+		}
+		if err := h.sendReceiptForReview(c.Bot(), c.Chat().ID, &pending[i], user); err != nil {
+			log.Printf("Failed to send receipt #%d: %v", pending[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// HandleReceiptApprove зачисляет пополнение по чеку
+func (h *Handler) HandleReceiptApprove(c tele.Context) error {
+	id, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(i18n.Default, "error.generic")})
+	}
+
+	review, err := h.svc.ApproveReceipt(context.Background(), id, c.Sender().ID)
+	if err != nil {
+		return h.respondReceiptError(c, id, err)
+	}
+
+	c.Respond(&tele.CallbackResponse{Text: i18n.T(i18n.Default, "receipt.review_approved", models.RUB.Format(review.Transaction.Amount))})
+	h.markReceiptReviewed(c, i18n.T(i18n.Default, "receipt.verdict_approved"))
+
+	h.notifyTopUpCredited(c.Bot(), review.TelegramID, review.Transaction.Amount, 0)
+	if review.ReferrerTelegramID != nil && review.ReferralBonus > 0 {
+		h.notifyReferralBonus(c.Bot(), *review.ReferrerTelegramID, review.ReferralBonus)
+	}
+	return nil
+}
+
+// HandleReceiptReject отклоняет чек и уведомляет пользователя
+func (h *Handler) HandleReceiptReject(c tele.Context) error {
+	id, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(i18n.Default, "error.generic")})
+	}
+
+	review, err := h.svc.RejectReceipt(context.Background(), id, c.Sender().ID)
+	if err != nil {
+		return h.respondReceiptError(c, id, err)
+	}
+
+	c.Respond(&tele.CallbackResponse{Text: i18n.T(i18n.Default, "receipt.review_rejected")})
+	h.markReceiptReviewed(c, i18n.T(i18n.Default, "receipt.verdict_rejected"))
+
+	lang := h.langOf(review.TelegramID)
+	text := i18n.T(lang, "receipt.rejected", review.Transaction.ID, h.currencyOf(review.TelegramID).FormatWithBase(review.Transaction.Amount))
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.URL(i18n.T(lang, "common.write_support"), "https://t.me/XRAY_LUV")),
+	)
+
+	if _, err := c.Bot().Send(&tele.User{ID: review.TelegramID}, text, menu, tele.ModeMarkdown); err != nil {
+		log.Printf("Failed to notify user %d about rejected receipt: %v", review.TelegramID, err)
+	}
+	return nil
+}
+
+// markReceiptReviewed дописывает решение в подпись чека и убирает кнопки
+func (h *Handler) markReceiptReviewed(c tele.Context, verdict string) {
+	admin := c.Sender().Username
+	if admin == "" {
+		admin = strconv.FormatInt(c.Sender().ID, 10)
+	}
+
+	caption := fmt.Sprintf("%s\n\n%s — %s", c.Message().Caption, verdict, admin)
+	if _, err := c.Bot().EditCaption(c.Message(), caption); err != nil {
+		log.Printf("Failed to update receipt message: %v", err)
+	}
+}
+
+// respondReceiptError отвечает на нажатие кнопки проверки чека при ошибке.
+// Текст ошибки остаётся в логах: в чат проверки уходит только общее сообщение
+func (h *Handler) respondReceiptError(c tele.Context, id int64, err error) error {
+	if errors.Is(err, service.ErrReceiptReviewed) {
+		c.Bot().EditReplyMarkup(c.Message(), nil)
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(i18n.Default, "receipt.review_done"), ShowAlert: true})
+	}
+	log.Printf("Failed to review receipt #%d: %v", id, err)
+	return c.Respond(&tele.CallbackResponse{Text: i18n.T(i18n.Default, "receipt.review_failed"), ShowAlert: true})
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// RegisterRefundsAdmin регистрирует отмену подписки с возвратом из профиля пользователя в админке
+func (h *Handler) RegisterRefundsAdmin(adminGroup *tele.Group) {
+	adminGroup.Handle(&tele.Btn{Unique: "admin_refund"}, h.HandleAdminRefund)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_refund_confirm"}, h.HandleAdminRefundConfirm, h.IdempotencyMiddleware())
+}
+
+// HandleAdminRefund показывает расчёт возврата и способы его выплаты
+func (h *Handler) HandleAdminRefund(c tele.Context) error {
+	subID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	quote, err := h.svc.QuoteRefund(context.Background(), subID)
+	if errors.Is(err, service.ErrRefundUnavailable) {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Подписка не активна"})
+	}
+	if err != nil {
+		log.Printf("Failed to quote refund of subscription %d: %v", subID, err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	sub := quote.Sub
+
+	var sb strings.Builder
+	sb.WriteString("💸 *Отмена подписки с возвратом*\n\n")
+	sb.WriteString(fmt.Sprintf("📦 Подписка №%d %s %s до %s\n", sub.ID, sub.Product.CountryFlag, sub.Product.Name, sub.ExpiresAt.Format("02.01.2006")))
+	sb.WriteString(fmt.Sprintf("📅 Осталось: %s\n", i18n.N(i18n.Default, "period.days", quote.UnusedDays)))
+	if quote.Purchase != nil {
+		sb.WriteString(fmt.Sprintf("🧾 Последняя оплата: %s от %s\n", models.RUB.Format(-quote.Purchase.Amount), quote.Purchase.CreatedAt.Format("02.01.2006")))
+		sb.WriteString(fmt.Sprintf("📊 Стоимость дня: %s, всего оплачено: %s\n", models.RUB.Format(quote.DailyRate), models.RUB.Format(quote.Paid)))
+	} else {
+		sb.WriteString("🧾 _Оплат подписки не найдено (подарок, пробный период или покупка до учёта оплат)_\n")
+	}
+	sb.WriteString(fmt.Sprintf("\n💰 К возврату: *%s*\n\n", models.RUB.Format(quote.Amount)))
+	sb.WriteString("Подписка будет отключена, клиент удалён из панели.")
+
+	id := strconv.FormatInt(sub.ID, 10)
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	if quote.Amount > 0 {
+		rows = append(rows,
+			menu.Row(menu.Data("💰 Вернуть на баланс", "admin_refund_confirm", id, models.RefundToBalance)),
+			menu.Row(menu.Data("🏦 Вернул вне бота", "admin_refund_confirm", id, models.RefundExternal)),
+		)
+	} else {
+		rows = append(rows, menu.Row(menu.Data("⛔ Отменить без возврата", "admin_refund_confirm", id, models.RefundToBalance)))
+	}
+	rows = append(rows, menu.Row(menu.Data("❌ Отмена", "admin_back")))
+	menu.Inline(rows...)
+
+	return c.Edit(sb.String(), menu, tele.ModeMarkdown)
+}
+
+// HandleAdminRefundConfirm отменяет подписку, выполняет возврат и уведомляет владельца
+func (h *Handler) HandleAdminRefundConfirm(c tele.Context) error {
+	ctx := context.Background()
+
+	parts := strings.Split(c.Callback().Data, "|")
+	if len(parts) != 2 {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	subID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	method := parts[1]
+
+	quote, err := h.svc.AdminCancelSubscription(ctx, c.Sender().ID, subID, method)
+	if errors.Is(err, service.ErrRefundUnavailable) {
+		return c.Edit("❌ Отменить подписку нельзя: она уже не активна или изменилась.")
+	}
+	if err != nil {
+		log.Printf("Failed to cancel subscription %d: %v", subID, err)
+		return c.Edit(fmt.Sprintf("❌ Не удалось отменить подписку: %v", err))
+	}
+	callbackSucceeded(c)
+	sub := quote.Sub
+
+	// Пользователю сумма показывается на его языке и в его валюте, админу — по-русски в рублях
+	refundText := func(lang string, cur *models.Currency) string {
+		switch {
+		case quote.Amount == 0:
+			return i18n.T(lang, "refund.none")
+		case method == models.RefundExternal:
+			return i18n.T(lang, "refund.external", cur.Format(quote.Amount))
+		default:
+			return i18n.T(lang, "refund.balance", cur.Format(quote.Amount))
+		}
+	}
+
+	owner, err := h.svc.GetUserByID(ctx, sub.UserID)
+	notified := err == nil
+	if notified {
+		lang := h.svc.UserLanguage(owner)
+		msg := i18n.T(lang, "refund.notice",
+			sub.ID, sub.Product.CountryFlag, sub.Product.Name, refundText(lang, h.svc.UserCurrency(ctx, owner)))
+		if _, err := c.Bot().Send(&tele.User{ID: owner.TelegramID}, msg); err != nil {
+			log.Printf("Failed to notify user %d about subscription cancel: %v", owner.TelegramID, err)
+			notified = false
+		}
+	}
+
+	text := fmt.Sprintf("✅ Подписка №%d отменена, %s.", sub.ID, refundText(i18n.Default, models.RUB))
+	if !notified {
+		text += "\n\n⚠️ Не удалось уведомить пользователя."
+	}
+	return c.Edit(text)
+}
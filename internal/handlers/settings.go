@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"vpn-telegram-bot/internal/i18n"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// langAuto значение кнопки выбора языка: определять по настройкам Telegram
+const langAuto = "auto"
+
+// RegisterSettings регистрирует меню настроек пользователя (язык интерфейса)
+func (h *Handler) RegisterSettings(b *tele.Bot) {
+	b.Handle(&tele.Btn{Unique: "settings"}, h.HandleSettings)
+	b.Handle(&tele.Btn{Unique: "lang_set"}, h.HandleLanguageSet)
+}
+
+// lang язык интерфейса отправителя: выбранный в настройках или по language_code Telegram.
+// Заодно запоминает language_code — по нему выбирается вариант рассылки
+func (h *Handler) lang(c tele.Context) string {
+	ctx := context.Background()
+	user, err := h.svc.GetUserByTelegramID(ctx, c.Sender().ID)
+	if err != nil {
+		return i18n.Detect(c.Sender().LanguageCode)
+	}
+	h.svc.RememberLanguageCode(ctx, user, c.Sender().LanguageCode)
+	return h.svc.UserLanguage(user)
+}
+
+// langOf язык интерфейса пользователя по Telegram ID (по умолчанию, если пользователь ещё не создан)
+func (h *Handler) langOf(telegramID int64) string {
+	user, err := h.svc.GetUserByTelegramID(context.Background(), telegramID)
+	if err != nil {
+		return i18n.Default
+	}
+	return h.svc.UserLanguage(user)
+}
+
+// HandleSettings показывает настройки: язык интерфейса и валюту отображения сумм
+func (h *Handler) HandleSettings(c tele.Context) error {
+	ctx := context.Background()
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(i18n.Detect(c.Sender().LanguageCode), "error.load"))
+	}
+	h.svc.RememberLanguageCode(ctx, user, c.Sender().LanguageCode)
+	lang := h.svc.UserLanguage(user)
+	cur := h.svc.UserCurrency(ctx, user)
+
+	langTitle := i18n.Name(lang)
+	if user.Language == "" {
+		langTitle = i18n.T(lang, "settings.auto_title", langTitle)
+	}
+	text := i18n.T(lang, "settings.text", langTitle, cur.Code)
+
+	menu := &tele.ReplyMarkup{}
+	var row tele.Row
+	for _, l := range i18n.Supported() {
+		btnText := i18n.Name(l)
+		if user.Language == l {
+			btnText = "✅ " + btnText
+		}
+		row = append(row, menu.Data(btnText, "lang_set", l))
+	}
+	autoText := i18n.T(lang, "settings.auto")
+	if user.Language == "" {
+		autoText = "✅ " + autoText
+	}
+	menu.Inline(
+		row,
+		menu.Row(menu.Data(autoText, "lang_set", langAuto)),
+		menu.Row(menu.Data(i18n.T(lang, "settings.currency", cur.Code), "currency")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_main")),
+	)
+
+	return h.editOrResend(c, text, menu)
+}
+
+// HandleLanguageSet меняет язык интерфейса и показывает настройки уже на нём
+func (h *Handler) HandleLanguageSet(c tele.Context) error {
+	ctx := context.Background()
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(h.lang(c), "error.generic")})
+	}
+
+	lang := c.Callback().Data
+	if lang == langAuto {
+		lang = ""
+	}
+	if err := h.svc.SetUserLanguage(ctx, user.ID, lang); err != nil {
+		log.Printf("Failed to set language of user %d: %v", user.ID, err)
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(h.svc.UserLanguage(user), "settings.language_failed")})
+	}
+
+	user.Language = lang
+	current := h.svc.UserLanguage(user)
+	c.Respond(&tele.CallbackResponse{Text: i18n.T(current, "settings.language_set", i18n.Name(current))})
+	return h.HandleSettings(c)
+}
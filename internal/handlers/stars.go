@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// RegisterStars регистрирует оплату Telegram Stars (XTR)
+func (h *Handler) RegisterStars(b *tele.Bot) {
+	b.Handle(&tele.Btn{Unique: "pay_stars"}, h.HandlePayStars)
+	b.Handle(&tele.Btn{Unique: "topup_pay_stars"}, h.HandleTopUpPayStars)
+	b.Handle(tele.OnCheckout, h.HandleStarsCheckout)
+	b.Handle(tele.OnPayment, h.HandleStarsPayment)
+}
+
+// HandlePayStars оплата плана из callback звёздами
+func (h *Handler) HandlePayStars(c tele.Context) error {
+	ctx := context.Background()
+	data := c.Callback().Data
+	lang := h.lang(c)
+
+	plan, product, err := h.planFromCallback(c)
+	if err != nil {
+		return h.planUnavailable(c, err)
+	}
+
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.generic"))
+	}
+
+	price, err := h.svc.ResolvePlanPrice(ctx, product, plan)
+	if err != nil {
+		log.Printf("Failed to resolve price: %v", err)
+		return c.Send(i18n.T(lang, "error.retry"))
+	}
+
+	inv, err := h.svc.CreateStarsPlanInvoice(ctx, user.ID, plan, price.Price)
+	if err != nil {
+		log.Printf("Error creating stars plan invoice for user %d: %v", user.TelegramID, err)
+		return c.Send(i18n.T(lang, "invoice.create_failed"))
+	}
+
+	period := planPeriodTitle(lang, plan)
+	title := i18n.T(lang, "invoice.description_plan", product.Name, period)
+	text := i18n.T(lang, "stars.plan",
+		product.CountryFlag, product.Name, period, inv.ID, inv.AmountStars, h.svc.UserCurrency(ctx, user).Format(inv.Amount))
+
+	return h.sendStarsInvoice(c, lang, inv, title, text, "plan", data)
+}
+
+// HandleTopUpPayStars пополнение баланса звёздами
+func (h *Handler) HandleTopUpPayStars(c tele.Context) error {
+	ctx := context.Background()
+	amountStr := c.Callback().Data
+	lang := h.lang(c)
+
+	amount, err := strconv.ParseFloat(amountStr, 64)
+	if err != nil || amount <= 0 {
+		return c.Send(i18n.T(lang, "topup.bad_amount"))
+	}
+
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.generic"))
+	}
+
+	inv, err := h.svc.CreateStarsTopUpInvoice(ctx, user.ID, amount)
+	if err != nil {
+		log.Printf("Error creating stars invoice for user %d: %v", user.TelegramID, err)
+		return c.Send(i18n.T(lang, "invoice.create_failed"))
+	}
+
+	cur := h.svc.UserCurrency(ctx, user)
+	title := i18n.T(lang, "stars.topup_title", cur.Format(amount))
+	text := i18n.T(lang, "stars.topup", inv.ID, inv.AmountStars, cur.FormatWithBase(inv.Amount))
+
+	return h.sendStarsInvoice(c, lang, inv, title, text, "topup_amount", amountStr)
+}
+
+// sendStarsInvoice показывает описание счёта и отправляет инвойс Telegram в валюте XTR
+func (h *Handler) sendStarsInvoice(c tele.Context, lang string, inv *models.Invoice, title, text, backUnique, backData string) error {
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), backUnique, backData)),
+	)
+
+	if err := h.editOrResend(c, text, menu); err != nil {
+		return err
+	}
+
+	invoice := &tele.Invoice{
+		Title:       title,
+		Description: i18n.T(lang, "invoice.description", title, inv.ID),
+		Payload:     service.StarsPayload(inv.ID),
+		Currency:    service.StarsCurrency,
+		Prices:      []tele.Price{{Label: title, Amount: inv.AmountStars}},
+	}
+	return c.Send(invoice)
+}
+
+// HandleStarsCheckout отвечает на pre_checkout_query: оплата разрешается только по актуальному счёту
+func (h *Handler) HandleStarsCheckout(c tele.Context) error {
+	q := c.PreCheckoutQuery()
+	lang := h.langOf(q.Sender.ID)
+	if q.Currency != service.StarsCurrency {
+		return c.Accept(i18n.T(lang, "stars.unsupported"))
+	}
+
+	_, err := h.svc.ValidateStarsCheckout(context.Background(), q.Sender.ID, q.Payload, q.Total)
+	switch {
+	case err == nil:
+		return c.Accept()
+	case errors.Is(err, service.ErrStarsPriceChanged):
+		log.Printf("⭐️ Checkout rejected for user %d: %v", q.Sender.ID, err)
+		return c.Accept(i18n.T(lang, "stars.price_changed"))
+	case errors.Is(err, service.ErrStarsInvoiceInvalid):
+		log.Printf("⭐️ Checkout rejected for user %d: %v", q.Sender.ID, err)
+		return c.Accept(i18n.T(lang, "stars.invoice_invalid"))
+	default:
+		log.Printf("❌ Checkout error for user %d: %v", q.Sender.ID, err)
+		return c.Accept(i18n.T(lang, "stars.temporary_error"))
+	}
+}
+
+// HandleStarsPayment обрабатывает successful_payment: пополняет баланс или активирует подписку.
+// Уведомление пользователю отправляет обработчик из RegisterPayments
+func (h *Handler) HandleStarsPayment(c tele.Context) error {
+	p := c.Message().Payment
+	if p == nil || p.Currency != service.StarsCurrency {
+		return nil
+	}
+
+	log.Printf("⭐️ Successful payment from user %d: %d stars, charge %s", c.Sender().ID, p.Total, p.TelegramChargeID)
+
+	_, err := h.svc.ProcessStarsPayment(context.Background(), p.Payload, p.TelegramChargeID, p.Total)
+	if err != nil {
+		log.Printf("❌ Failed to process stars payment %s from user %d: %v", p.TelegramChargeID, c.Sender().ID, err)
+		return c.Send(i18n.T(h.lang(c), "stars.not_credited", p.TelegramChargeID), tele.ModeMarkdown)
+	}
+	return nil
+}
+
+// HandleRefundStars возвращает звёзды по ID платежа: /refundstars <telegram_payment_charge_id>
+func (h *Handler) HandleRefundStars(c tele.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return c.Send("❌ Использование: /refundstars <telegram_payment_charge_id>")
+	}
+	chargeID := args[0]
+
+	inv, err := h.svc.RefundStarsPayment(context.Background(), chargeID, func(telegramID int64) error {
+		_, err := c.Bot().Raw("refundStarPayment", map[string]string{
+			"user_id":                    strconv.FormatInt(telegramID, 10),
+			"telegram_payment_charge_id": chargeID,
+		})
+		return err
+	})
+	if inv == nil {
+		return c.Send(fmt.Sprintf("❌ Ошибка возврата: %v", err))
+	}
+
+	text := fmt.Sprintf("✅ Возвращено *%d ⭐️* по счёту №%d", inv.AmountStars, inv.ID)
+	if inv.SubscriptionID != nil {
+		text += fmt.Sprintf("\n🔒 Подписка #%d отключена", *inv.SubscriptionID)
+	} else {
+		text += "\n💰 С баланса списано " + models.RUB.Format(inv.Amount)
+	}
+	if err != nil {
+		text += fmt.Sprintf("\n⚠️ %v", err)
+	}
+
+	return c.Send(text, tele.ModeMarkdown)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Состояния диалогов (internal/fsm). Шаги, ожидающие ввод, регистрирует сценарий, которому они принадлежат
+const (
+	// Пользовательские сценарии
+	stateUserPromo = "user_promo" // ввод промокода
+	stateReceipt   = "receipt"    // ожидание фото чека СБП
+
+	// Админские сценарии
+	stateBroadcastMessage = "broadcast_message" // ожидание сообщения для рассылки
+	stateBroadcastConfirm = "broadcast_confirm" // сообщение получено, ждём подтверждения
+	stateBroadcastVariant = "broadcast_variant" // ожидание варианта рассылки на другом языке
+	stateFindUser         = "admin_find_user"   // ввод ID / @username
+	stateAddBalance       = "admin_addbal"      // ввод суммы пополнения
+	stateIssue            = "issue"             // выбор продукта и срока ключа
+	stateIssueUser        = "issue_user"        // ввод Telegram ID получателя ключа
+	statePromoCode        = "promo_code"        // мастер промокода: код
+	statePromoAmount      = "promo_amount"      // мастер промокода: сумма
+	statePromoActivations = "promo_activations" // мастер промокода: количество активаций
+	statePromoDelete      = "promo_delete"      // ввод кода для удаления
+	stateSupportReply     = "support_reply"     // ответ админа на тикет
+	stateFlashSale        = "flash_sale"        // настройка флеш-распродажи
+	statePlanEdit         = "plan_edit"         // ввод срока / цены / скидки / квоты / устройств плана
+	stateTrafficEdit      = "traffic_edit"      // ввод квоты трафика продукта или нового пакета трафика
+	stateDevicesEdit      = "devices_edit"      // ввод лимита устройств продукта или цены устройства
+)
+
+// targetUserData данные состояний, привязанных к пользователю (пополнение, ответ на тикет)
+type targetUserData struct {
+	UserID int64 `json:"user_id"` // Telegram ID
+}
+
+// setState переводит пользователя в состояние (ошибка хранилища логируется)
+func (h *Handler) setState(userID int64, state string, data any) {
+	if err := h.fsm.Set(context.Background(), userID, state, data); err != nil {
+		log.Printf("⚠️ Failed to set state %s for user %d: %v", state, userID, err)
+	}
+}
+
+// resetState сбрасывает состояние пользователя
+func (h *Handler) resetState(userID int64) {
+	if err := h.fsm.Reset(context.Background(), userID); err != nil {
+		log.Printf("⚠️ Failed to reset state for user %d: %v", userID, err)
+	}
+}
+
+// lookupState проверяет, что пользователь в состоянии state, и распаковывает его данные в v
+func (h *Handler) lookupState(userID int64, state string, v any) bool {
+	ok, err := h.fsm.Lookup(context.Background(), userID, state, v)
+	if err != nil {
+		log.Printf("⚠️ Failed to load state %s for user %d: %v", state, userID, err)
+	}
+	return ok
+}
+
+// dispatchState передаёт сообщение шагу текущего сценария пользователя.
+// Если хранилище недоступно, сообщение обрабатывается дальше как без состояния
+func (h *Handler) dispatchState(c tele.Context) (bool, error) {
+	handled, err := h.fsm.Dispatch(c)
+	if err != nil && !handled {
+		log.Printf("⚠️ %v", err)
+		return false, nil
+	}
+	return handled, err
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
+	"vpn-telegram-bot/internal/subscription"
+)
+
+// subUpdateIntervalHours как часто клиент перезапрашивает ссылку подписки
+const subUpdateIntervalHours = 12
+
+// SetSubscriptionBaseURL включает ссылки подписки: publicURL — внешний адрес HTTP сервера бота
+func (h *Handler) SetSubscriptionBaseURL(publicURL string) {
+	h.subBaseURL = strings.TrimRight(publicURL, "/")
+}
+
+// subscriptionURL ссылка подписки /sub/{token} (пусто — внешний адрес сервера не настроен)
+func (h *Handler) subscriptionURL(sub *models.Subscription) string {
+	if h.subBaseURL == "" || sub.SubToken == "" {
+		return ""
+	}
+	return h.subBaseURL + "/sub/" + sub.SubToken
+}
+
+// subKey что выдавать пользователю для подключения: ссылку подписки или, если она выключена, ключ
+func (h *Handler) subKey(sub *models.Subscription) string {
+	if url := h.subscriptionURL(sub); url != "" {
+		return url
+	}
+	return sub.KeyString
+}
+
+// SubscriptionFeedHandler HTTP обработчик ссылки подписки: GET /sub/{token}.
+// Формат выбирается параметром ?format=base64|clash|singbox или по User-Agent клиента
+func (h *Handler) SubscriptionFeedHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		feed, err := h.svc.GetSubscriptionFeed(r.Context(), r.PathValue("token"))
+		if errors.Is(err, service.ErrSubscriptionNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Subscription feed error: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		format := subscription.DetectFormat(r.URL.Query().Get("format"), r.UserAgent())
+		body, contentType, err := subscription.Render(format, feed.Links)
+		if err != nil {
+			log.Printf("❌ Subscription feed %d (%s): %v", feed.Subscription.ID, format, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		product := feed.Subscription.Product
+		title := fmt.Sprintf("%s %s", product.CountryFlag, product.Name)
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Profile-Title", "base64:"+base64.StdEncoding.EncodeToString([]byte(title)))
+		w.Header().Set("Profile-Update-Interval", fmt.Sprint(subUpdateIntervalHours))
+		w.Header().Set("Subscription-Userinfo", fmt.Sprintf("upload=0; download=%d; total=%d; expire=%d",
+			feed.DataUsed, feed.DataLimit, feed.ExpiresAt.Unix()))
+		w.Write(body)
+	}
+}
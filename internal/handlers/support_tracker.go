@@ -1,50 +1,43 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
-	"sort"
+	"log"
 	"sync"
 	"time"
 
-	tele "gopkg.in/telebot.v3"
-)
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
 
-// TicketStatus статус тикета
-type TicketStatus string
-
-const (
-	StatusWaiting  TicketStatus = "waiting"  // Ожидает ответа админа
-	StatusReplied  TicketStatus = "replied"  // Админ ответил
+	tele "gopkg.in/telebot.v3"
 )
 
-// ActiveTicket информация об активном тикете
-type ActiveTicket struct {
-	UserID           int64
-	Username         string
-	LastMessageTime  time.Time
-	Status           TicketStatus
-	GroupMessageID   int    // ID сообщения в группе (для ссылки)
-	MessageCount     int    // Количество сообщений от пользователя
-}
-
-// SupportTracker трекер активных тикетов
+// SupportTracker dashboard тикетов в группе поддержки (тикеты хранятся в БД)
 type SupportTracker struct {
-	mu               sync.RWMutex
-	tickets          map[int64]*ActiveTicket // userID -> ticket
-	dashboardMsgID   int                     // ID закреплённого сообщения dashboard
-	supportGroupID   int64
-	bot              *tele.Bot
+	mu             sync.RWMutex
+	dashboardMsgID int // ID закреплённого сообщения dashboard
+	supportGroupID int64
+	bot            *tele.Bot
+	svc            *service.Service
 }
 
 var tracker *SupportTracker
 
-// InitSupportTracker инициализирует трекер
-func InitSupportTracker(bot *tele.Bot, supportGroupID int64) {
+// InitSupportTracker инициализирует трекер и восстанавливает сохранённый dashboard
+func InitSupportTracker(bot *tele.Bot, supportGroupID int64, svc *service.Service) {
 	tracker = &SupportTracker{
-		tickets:        make(map[int64]*ActiveTicket),
 		supportGroupID: supportGroupID,
 		bot:            bot,
+		svc:            svc,
 	}
+
+	msgID, err := svc.GetSupportDashboard(context.Background(), supportGroupID)
+	if err != nil {
+		log.Printf("Failed to load support dashboard: %v", err)
+		return
+	}
+	tracker.dashboardMsgID = msgID
 }
 
 // GetTracker возвращает глобальный трекер
@@ -52,11 +45,15 @@ func GetTracker() *SupportTracker {
 	return tracker
 }
 
-// SetDashboardMessageID устанавливает ID сообщения dashboard
+// SetDashboardMessageID устанавливает и сохраняет ID сообщения dashboard
 func (t *SupportTracker) SetDashboardMessageID(msgID int) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
 	t.dashboardMsgID = msgID
+	t.mu.Unlock()
+
+	if err := t.svc.SetSupportDashboard(context.Background(), t.supportGroupID, msgID); err != nil {
+		log.Printf("Failed to save support dashboard: %v", err)
+	}
 }
 
 // GetDashboardMessageID возвращает ID сообщения dashboard
@@ -66,90 +63,26 @@ func (t *SupportTracker) GetDashboardMessageID() int {
 	return t.dashboardMsgID
 }
 
-// AddOrUpdateTicket добавляет или обновляет тикет
-func (t *SupportTracker) AddOrUpdateTicket(userID int64, username string, groupMsgID int) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	if ticket, exists := t.tickets[userID]; exists {
-		ticket.LastMessageTime = time.Now()
-		ticket.Status = StatusWaiting
-		ticket.MessageCount++
-		if groupMsgID > 0 {
-			ticket.GroupMessageID = groupMsgID
-		}
-	} else {
-		t.tickets[userID] = &ActiveTicket{
-			UserID:          userID,
-			Username:        username,
-			LastMessageTime: time.Now(),
-			Status:          StatusWaiting,
-			GroupMessageID:  groupMsgID,
-			MessageCount:    1,
-		}
-	}
-}
-
-// SetTicketReplied помечает тикет как "отвечено"
-func (t *SupportTracker) SetTicketReplied(userID int64) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	if ticket, exists := t.tickets[userID]; exists {
-		ticket.Status = StatusReplied
+// UpdateDashboard обновляет закреплённое сообщение dashboard
+func (t *SupportTracker) UpdateDashboard() {
+	dashboardMsgID := t.GetDashboardMessageID()
+	if t.bot == nil || dashboardMsgID == 0 {
+		return
 	}
-}
 
-// RemoveTicket удаляет тикет (закрыт)
-func (t *SupportTracker) RemoveTicket(userID int64) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	delete(t.tickets, userID)
-}
-
-// GetAllTickets возвращает все активные тикеты
-func (t *SupportTracker) GetAllTickets() []*ActiveTicket {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-
-	tickets := make([]*ActiveTicket, 0, len(t.tickets))
-	for _, ticket := range t.tickets {
-		tickets = append(tickets, ticket)
+	// Сначала ожидающие ответа, затем по времени (старые сверху)
+	tickets, err := t.svc.GetActiveTickets(context.Background())
+	if err != nil {
+		log.Printf("Failed to load tickets for dashboard: %v", err)
+		return
 	}
 
-	// Сортируем: сначала waiting, потом по времени (старые сверху)
-	sort.Slice(tickets, func(i, j int) bool {
-		if tickets[i].Status != tickets[j].Status {
-			return tickets[i].Status == StatusWaiting
+	waitingCount := 0
+	for _, ticket := range tickets {
+		if ticket.Status == models.TicketWaiting {
+			waitingCount++
 		}
-		return tickets[i].LastMessageTime.Before(tickets[j].LastMessageTime)
-	})
-
-	return tickets
-}
-
-// GetWaitingCount возвращает количество ожидающих ответа
-func (t *SupportTracker) GetWaitingCount() int {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-
-	count := 0
-	for _, ticket := range t.tickets {
-		if ticket.Status == StatusWaiting {
-			count++
-		}
-	}
-	return count
-}
-
-// UpdateDashboard обновляет закреплённое сообщение dashboard
-func (t *SupportTracker) UpdateDashboard() {
-	if t.bot == nil || t.dashboardMsgID == 0 {
-		return
 	}
-
-	tickets := t.GetAllTickets()
-	waitingCount := t.GetWaitingCount()
 	totalCount := len(tickets)
 
 	// Формируем текст dashboard
@@ -189,9 +122,9 @@ _Все тикеты обработаны!_`
 			// Статус эмодзи
 			statusEmoji := "🟢"
 			statusText := "✅ Отвечено"
-			if ticket.Status == StatusWaiting {
+			if ticket.Status == models.TicketWaiting {
 				statusEmoji = "🔴"
-				waitTime := time.Since(ticket.LastMessageTime)
+				waitTime := time.Since(ticket.UpdatedAt)
 				if waitTime < time.Minute {
 					statusText = "⏳ Только что"
 				} else if waitTime < time.Hour {
@@ -202,7 +135,7 @@ _Все тикеты обработаны!_`
 			}
 
 			// Username
-			usernameStr := fmt.Sprintf("ID:%d", ticket.UserID)
+			usernameStr := fmt.Sprintf("ID:%d", ticket.TelegramID)
 			if ticket.Username != "" {
 				usernameStr = "@" + ticket.Username
 			}
@@ -213,20 +146,25 @@ _Все тикеты обработаны!_`
 				linkText = fmt.Sprintf(" | [↗️ К диалогу](https://t.me/c/%d/%d)", groupIDForLink, ticket.GroupMessageID)
 			}
 
-			text += fmt.Sprintf("%d. %s *%s*\n   %s%s\n\n", i+1, statusEmoji, usernameStr, statusText, linkText)
+			// Исполнитель
+			assigneeText := ""
+			if ticket.AssigneeID != nil {
+				assigneeText = fmt.Sprintf(" | 👨‍💻 %d", *ticket.AssigneeID)
+			}
+
+			text += fmt.Sprintf("%d. %s *%s* — тикет №%d\n   %s%s%s\n\n", i+1, statusEmoji, usernameStr, ticket.ID, statusText, assigneeText, linkText)
 		}
 	}
 
 	// Обновляем сообщение
 	msg := &tele.Message{
-		ID:   t.dashboardMsgID,
+		ID:   dashboardMsgID,
 		Chat: &tele.Chat{ID: t.supportGroupID},
 	}
 
-	_, err := t.bot.Edit(msg, text, tele.ModeMarkdown, tele.NoPreview)
+	_, err = t.bot.Edit(msg, text, tele.ModeMarkdown, tele.NoPreview)
 	if err != nil {
 		// Если не удалось отредактировать - возможно сообщение удалено
 		// log.Printf("Failed to update dashboard: %v", err)
 	}
 }
-
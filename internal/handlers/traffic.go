@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"vpn-telegram-bot/internal/fsm"
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Значения продукта, которые админ вводит на экране трафика
+const (
+	trafficFieldQuota = "quota" // квота продукта, ГБ
+	trafficFieldPack  = "pack"  // новый пакет: "ГБ цена"
+)
+
+// trafficEditData данные ввода квоты или пакета трафика продукта
+type trafficEditData struct {
+	ProductID int64  `json:"product_id"`
+	Field     string `json:"field"`
+}
+
+// RegisterTraffic регистрирует покупку пакетов трафика пользователями
+func (h *Handler) RegisterTraffic(b *tele.Bot) {
+	b.Handle(&tele.Btn{Unique: "traffic_packs"}, h.HandleTrafficPacks)
+	b.Handle(&tele.Btn{Unique: "traffic_buy"}, h.HandleBuyTrafficPack, h.IdempotencyMiddleware())
+}
+
+// registerTrafficAdmin регистрирует настройку квот и пакетов трафика (часть редактора тарифов)
+func (h *Handler) registerTrafficAdmin(adminGroup *tele.Group) {
+	adminGroup.Handle(&tele.Btn{Unique: "admin_traffic"}, h.HandleAdminTraffic)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_traffic_edit"}, h.HandleAdminTrafficEdit)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_traffic_reset"}, h.HandleAdminTrafficReset)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_traffic_pack_toggle"}, h.HandleAdminTrafficPackToggle)
+	adminGroup.Handle(&tele.Btn{Unique: "admin_traffic_pack_delete"}, h.HandleAdminTrafficPackDelete)
+
+	h.fsm.Handle(stateTrafficEdit, h.HandleAdminTrafficInput)
+}
+
+// formatGB байты в гигабайтах с одним знаком: "12.5"
+func formatGB(bytes int64) string {
+	return strconv.FormatFloat(float64(bytes)/float64(models.BytesPerGB), 'f', 1, 64)
+}
+
+// quotaTitle квота словами: "100 ГБ" или "безлимит"
+func quotaTitle(limitGB int) string {
+	if limitGB == 0 {
+		return "безлимит"
+	}
+	return fmt.Sprintf("%d ГБ", limitGB)
+}
+
+// resetTitle стратегия сброса квоты словами
+func resetTitle(reset string) string {
+	if reset == models.TrafficResetMonth {
+		return "каждый месяц"
+	}
+	return "на весь срок"
+}
+
+// subTrafficText строки о трафике для карточки подписки: расход из VPN панели и квота
+func (h *Handler) subTrafficText(lang string, sub *models.Subscription) string {
+	usage, err := h.svc.GetSubscriptionTraffic(context.Background(), sub)
+	if err != nil {
+		log.Printf("Failed to get traffic of subscription %d: %v", sub.ID, err)
+		if sub.TrafficLimit == 0 {
+			return ""
+		}
+		return i18n.T(lang, "sub.traffic_quota", formatGB(sub.TrafficQuotaBytes()))
+	}
+
+	if sub.TrafficLimit == 0 {
+		return i18n.T(lang, "sub.traffic_unlimited", formatGB(usage.DataUsed))
+	}
+
+	text := i18n.T(lang, "sub.traffic", formatGB(usage.DataUsed), formatGB(sub.TrafficQuotaBytes()))
+	if sub.TrafficExtra > 0 {
+		text += i18n.T(lang, "sub.traffic_extra", formatGB(sub.TrafficExtra))
+	}
+	if sub.TrafficResetAt != nil {
+		text += i18n.T(lang, "sub.traffic_reset", sub.TrafficResetAt.Format("02.01.2006"))
+	}
+	return text
+}
+
+// ================= ПОКУПКА ПАКЕТОВ ТРАФИКА =================
+
+// HandleTrafficPacks показывает пакеты трафика для подписки
+func (h *Handler) HandleTrafficPacks(c tele.Context) error {
+	ctx := context.Background()
+	lang := h.lang(c)
+
+	subID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
+	}
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.generic"))
+	}
+	sub, err := h.svc.GetSubscriptionByID(ctx, subID)
+	if err != nil || sub.UserID != user.ID {
+		return c.Send(i18n.T(lang, "error.sub_not_found"))
+	}
+
+	packs, err := h.svc.GetTrafficPacks(ctx, sub.ProductID)
+	if err != nil {
+		log.Printf("Failed to get traffic packs of product %d: %v", sub.ProductID, err)
+		return c.Send(i18n.T(lang, "error.retry"))
+	}
+
+	id := strconv.FormatInt(sub.ID, 10)
+	cur := h.svc.UserCurrency(ctx, user)
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+
+	text := i18n.T(lang, "traffic.text",
+		sub.ID, sub.Product.CountryFlag, sub.Product.Name, h.subTrafficText(lang, sub), cur.Format(user.Balance))
+
+	if len(packs) == 0 {
+		text += i18n.T(lang, "traffic.no_packs")
+	}
+	for _, p := range packs {
+		btnText := i18n.T(lang, "traffic.pack", p.TrafficGB, cur.Format(p.Price))
+		rows = append(rows, menu.Row(menu.Data(btnText, "traffic_buy", fmt.Sprintf("%d:%d", sub.ID, p.ID))))
+	}
+	rows = append(rows, menu.Row(menu.Data(i18n.T(lang, "common.back"), "sub", id)))
+	menu.Inline(rows...)
+
+	return h.editOrResend(c, text, menu)
+}
+
+// HandleBuyTrafficPack покупает пакет трафика с баланса ("subID:packID")
+func (h *Handler) HandleBuyTrafficPack(c tele.Context) error {
+	ctx := context.Background()
+	lang := h.lang(c)
+
+	parts := strings.Split(c.Callback().Data, ":")
+	if len(parts) != 2 {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
+	}
+	subID, err1 := strconv.ParseInt(parts[0], 10, 64)
+	packID, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "error.generic")})
+	}
+
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.generic"))
+	}
+
+	cur := h.svc.UserCurrency(ctx, user)
+	back := &tele.ReplyMarkup{}
+	back.Inline(back.Row(back.Data(i18n.T(lang, "common.to_sub"), "sub", parts[0])))
+
+	sub, pack, err := h.svc.BuyTrafficPack(ctx, user.ID, subID, packID)
+	switch {
+	case errors.Is(err, service.ErrInsufficientBalance):
+		pack, _ := h.svc.GetTrafficPack(ctx, packID)
+		var price float64
+		if pack != nil {
+			price = pack.Price
+		}
+		text := i18n.T(lang, "traffic.insufficient", cur.Format(user.Balance), cur.Format(price))
+
+		menu := &tele.ReplyMarkup{}
+		menu.Inline(
+			menu.Row(menu.Data(i18n.T(lang, "common.topup"), "topup")),
+			menu.Row(menu.Data(i18n.T(lang, "common.back"), "traffic_packs", parts[0])),
+		)
+		return h.editOrResend(c, text, menu)
+	case errors.Is(err, service.ErrTrafficPackUnavailable), errors.Is(err, service.ErrTrafficNotLimited):
+		return h.editOrResend(c, i18n.T(lang, "traffic.unavailable"), back)
+	case err != nil:
+		log.Printf("Failed to buy traffic pack %d for subscription %d: %v", packID, subID, err)
+		return h.editOrResend(c, i18n.T(lang, "traffic.failed"), back)
+	}
+	callbackSucceeded(c)
+
+	text := i18n.T(lang, "traffic.done", sub.ID, pack.TrafficGB, cur.Format(pack.Price), formatGB(sub.TrafficQuotaBytes()))
+
+	return h.editOrResend(c, text, back)
+}
+
+// ================= АДМИНКА: КВОТЫ И ПАКЕТЫ ТРАФИКА =================
+
+// HandleAdminTraffic показывает квоту и пакеты трафика продукта
+func (h *Handler) HandleAdminTraffic(c tele.Context) error {
+	productID, err := strconv.ParseInt(c.Callback().Data, 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	h.resetState(c.Sender().ID)
+	return h.showProductTraffic(c, productID)
+}
+
+// showProductTraffic экран трафика продукта: квота, сброс, пакеты
+func (h *Handler) showProductTraffic(c tele.Context, productID int64) error {
+	ctx := context.Background()
+
+	product, err := h.svc.GetProductByID(ctx, productID)
+	if err != nil {
+		return c.Send("❌ Продукт не найден")
+	}
+	packs, err := h.svc.GetAllTrafficPacks(ctx, productID)
+	if err != nil {
+		log.Printf("Error getting traffic packs of product %d: %v", productID, err)
+		return c.Send("❌ Ошибка загрузки пакетов")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📶 *Трафик: %s %s*\n\n", product.CountryFlag, product.Name))
+	sb.WriteString(fmt.Sprintf("Квота: *%s*\n", quotaTitle(product.TrafficLimitGB)))
+	sb.WriteString(fmt.Sprintf("Сброс квоты: *%s*\n", resetTitle(product.TrafficReset)))
+	sb.WriteString("_Квота плана, если задана, заменяет квоту продукта. Изменения действуют на новые покупки и продления._\n\n")
+
+	sb.WriteString("*Пакеты трафика:*\n")
+	if len(packs) == 0 {
+		sb.WriteString("_нет_\n")
+	}
+
+	id := strconv.FormatInt(productID, 10)
+	menu := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for _, p := range packs {
+		status := "🟢"
+		if !p.IsActive {
+			status = "⚪️"
+		}
+		sb.WriteString(fmt.Sprintf("%s +%d ГБ — %s\n", status, p.TrafficGB, models.RUB.Format(p.Price)))
+
+		packID := strconv.FormatInt(p.ID, 10)
+		rows = append(rows, menu.Row(
+			menu.Data(fmt.Sprintf("%s +%d ГБ — %s", status, p.TrafficGB, models.RUB.Format(p.Price)), "admin_traffic_pack_toggle", packID),
+			menu.Data("🗑", "admin_traffic_pack_delete", packID),
+		))
+	}
+	sb.WriteString("\n🟢 продаётся  ⚪️ скрыт (нажмите, чтобы переключить)")
+
+	resetBtn := "🔁 Сброс: каждый месяц"
+	if product.TrafficReset != models.TrafficResetMonth {
+		resetBtn = "🔁 Сброс: на весь срок"
+	}
+	rows = append(rows,
+		menu.Row(
+			menu.Data("📶 Квота", "admin_traffic_edit", id+":"+trafficFieldQuota),
+			menu.Data(resetBtn, "admin_traffic_reset", id),
+		),
+		menu.Row(menu.Data("➕ Добавить пакет", "admin_traffic_edit", id+":"+trafficFieldPack)),
+		menu.Row(menu.Data("🔙 К планам", "admin_plans_product", id)),
+	)
+	menu.Inline(rows...)
+
+	if c.Callback() != nil {
+		return c.Edit(sb.String(), menu, tele.ModeMarkdown)
+	}
+	return c.Send(sb.String(), menu, tele.ModeMarkdown)
+}
+
+// HandleAdminTrafficEdit запрашивает квоту продукта или новый пакет ("productID:field")
+func (h *Handler) HandleAdminTrafficEdit(c tele.Context) error {
+	parts := strings.Split(c.Callback().Data, ":")
+	if len(parts) != 2 {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	productID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	var prompt string
+	switch parts[1] {
+	case trafficFieldQuota:
+		prompt = "📶 Введите квоту трафика на период подписки в ГБ.\n\n`0` — безлимит."
+	case trafficFieldPack:
+		prompt = "➕ Введите объём пакета в ГБ и цену в рублях через пробел, например `50 150`."
+	default:
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+	h.setState(c.Sender().ID, stateTrafficEdit, trafficEditData{ProductID: productID, Field: parts[1]})
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(menu.Row(menu.Data("❌ Отмена", "admin_traffic", parts[0])))
+	return c.Edit(prompt, menu, tele.ModeMarkdown)
+}
+
+// HandleAdminTrafficInput применяет введённую квоту или создаёт пакет; при ошибке ввода состояние сохраняется
+func (h *Handler) HandleAdminTrafficInput(c tele.Context, s *fsm.Session) error {
+	ctx := context.Background()
+
+	var data trafficEditData
+	if err := s.Decode(&data); err != nil || data.ProductID == 0 {
+		h.resetState(c.Sender().ID)
+		return c.Send("❌ Сессия истекла. Начните заново: /plans")
+	}
+	input := strings.TrimSpace(c.Text())
+
+	switch data.Field {
+	case trafficFieldQuota:
+		limitGB, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(input), "гб"))
+		if err != nil {
+			return c.Send("❌ Введите квоту числом, например `100`", tele.ModeMarkdown)
+		}
+		product, err := h.svc.GetProductByID(ctx, data.ProductID)
+		if err != nil {
+			h.resetState(c.Sender().ID)
+			return c.Send("❌ Продукт не найден")
+		}
+		if err := h.svc.UpdateProductTraffic(ctx, product.ID, limitGB, product.TrafficReset); err != nil {
+			return h.sendTrafficError(c, err)
+		}
+	case trafficFieldPack:
+		fields := strings.Fields(strings.ReplaceAll(input, ",", "."))
+		if len(fields) != 2 {
+			return c.Send("❌ Введите объём и цену через пробел, например `50 150`", tele.ModeMarkdown)
+		}
+		gb, err1 := strconv.Atoi(fields[0])
+		price, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 != nil || err2 != nil {
+			return c.Send("❌ Введите объём и цену через пробел, например `50 150`", tele.ModeMarkdown)
+		}
+		if _, err := h.svc.CreateTrafficPack(ctx, &models.TrafficPack{ProductID: data.ProductID, TrafficGB: gb, Price: price}); err != nil {
+			return h.sendTrafficError(c, err)
+		}
+	default:
+		h.resetState(c.Sender().ID)
+		return c.Send("❌ Сессия истекла. Начните заново: /plans")
+	}
+
+	h.resetState(c.Sender().ID)
+	return h.showProductTraffic(c, data.ProductID)
+}
+
+// sendTrafficError сообщает об ошибке сохранения квоты или пакета
+func (h *Handler) sendTrafficError(c tele.Context, err error) error {
+	if errors.Is(err, service.ErrTrafficInvalid) {
+		return c.Send("❌ Недопустимое значение: объём до 100000 ГБ, цена больше нуля. Попробуйте ещё раз.")
+	}
+	log.Printf("Failed to save traffic settings: %v", err)
+	h.resetState(c.Sender().ID)
+	return c.Send("❌ Не удалось сохранить")
+}
+
+// HandleAdminTrafficReset переключает сброс квоты продукта: на весь срок / каждый месяц
+func (h *Handler) HandleAdminTrafficReset(c tele.Context) error {
+	ctx := context.Background()
+	productID, _ := strconv.ParseInt(c.Callback().Data, 10, 64)
+
+	product, err := h.svc.GetProductByID(ctx, productID)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Продукт не найден"})
+	}
+	reset := models.TrafficResetMonth
+	if product.TrafficReset == models.TrafficResetMonth {
+		reset = models.TrafficResetNone
+	}
+	if err := h.svc.UpdateProductTraffic(ctx, productID, product.TrafficLimitGB, reset); err != nil {
+		log.Printf("Failed to toggle traffic reset of product %d: %v", productID, err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	c.Respond(&tele.CallbackResponse{Text: "🔁 Сброс квоты: " + resetTitle(reset)})
+	return h.showProductTraffic(c, productID)
+}
+
+// HandleAdminTrafficPackToggle скрывает пакет трафика из продажи или возвращает его
+func (h *Handler) HandleAdminTrafficPackToggle(c tele.Context) error {
+	ctx := context.Background()
+	packID, _ := strconv.ParseInt(c.Callback().Data, 10, 64)
+
+	pack, err := h.svc.GetTrafficPack(ctx, packID)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Пакет не найден"})
+	}
+	if err := h.svc.SetTrafficPackActive(ctx, packID, !pack.IsActive); err != nil {
+		log.Printf("Failed to toggle traffic pack %d: %v", packID, err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка"})
+	}
+
+	respText := "⏸ Пакет скрыт"
+	if !pack.IsActive {
+		respText = "▶️ Пакет снова продаётся"
+	}
+	c.Respond(&tele.CallbackResponse{Text: respText})
+	return h.showProductTraffic(c, pack.ProductID)
+}
+
+// HandleAdminTrafficPackDelete удаляет пакет трафика (уже докупленный трафик сохраняется)
+func (h *Handler) HandleAdminTrafficPackDelete(c tele.Context) error {
+	ctx := context.Background()
+	packID, _ := strconv.ParseInt(c.Callback().Data, 10, 64)
+
+	pack, err := h.svc.GetTrafficPack(ctx, packID)
+	if err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Пакет не найден"})
+	}
+	if err := h.svc.DeleteTrafficPack(ctx, packID); err != nil {
+		log.Printf("Failed to delete traffic pack %d: %v", packID, err)
+		return c.Respond(&tele.CallbackResponse{Text: "❌ Ошибка удаления"})
+	}
+
+	c.Respond(&tele.CallbackResponse{Text: "🗑 Пакет удалён"})
+	return h.showProductTraffic(c, pack.ProductID)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/service"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// RegisterTrial регистрирует получение пробной подписки
+func (h *Handler) RegisterTrial(b *tele.Bot) {
+	b.Handle(&tele.Btn{Unique: "trial"}, h.HandleTrial)
+	b.Handle(&tele.Btn{Unique: "trial_claim"}, h.HandleTrialClaim)
+}
+
+// trialAvailable показывать ли пользователю кнопку пробного периода
+func (h *Handler) trialAvailable(c tele.Context) bool {
+	ctx := context.Background()
+	if h.svc.Trial() == nil {
+		return false
+	}
+	user, err := h.svc.GetUserByTelegramID(ctx, c.Sender().ID)
+	if err != nil {
+		return false
+	}
+	ok, err := h.svc.TrialAvailable(ctx, user)
+	if err != nil {
+		log.Printf("Failed to check trial eligibility for %d: %v", c.Sender().ID, err)
+	}
+	return ok
+}
+
+// HandleTrial показывает условия пробной подписки
+func (h *Handler) HandleTrial(c tele.Context) error {
+	lang := h.lang(c)
+	trial := h.svc.Trial()
+	if trial == nil {
+		return c.Respond(&tele.CallbackResponse{Text: i18n.T(lang, "trial.unavailable")})
+	}
+
+	traffic := i18n.T(lang, "trial.traffic_unlimited")
+	if trial.TrafficGB > 0 {
+		traffic = i18n.T(lang, "trial.traffic", trial.TrafficGB)
+	}
+
+	text := i18n.T(lang, "trial.text", i18n.N(lang, "period.days", trial.Days), traffic)
+
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data(i18n.T(lang, "trial.claim"), "trial_claim")),
+		menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_main")),
+	)
+	return h.editOrResend(c, text, menu)
+}
+
+// HandleTrialClaim выдаёт пробную подписку
+func (h *Handler) HandleTrialClaim(c tele.Context) error {
+	ctx := context.Background()
+	lang := h.lang(c)
+
+	user, err := h.svc.GetOrCreateUser(ctx, c.Sender().ID, c.Sender().Username)
+	if err != nil {
+		return c.Send(i18n.T(lang, "error.generic"))
+	}
+
+	menu := &tele.ReplyMarkup{}
+	sub, err := h.svc.ClaimTrial(ctx, user)
+	if errors.Is(err, service.ErrTrialUnavailable) {
+		menu.Inline(
+			menu.Row(menu.Data(i18n.T(lang, "menu.tariffs"), "tariffs")),
+			menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_main")),
+		)
+		return h.editOrResend(c, i18n.T(lang, "trial.used"), menu)
+	}
+	if err != nil {
+		log.Printf("Failed to claim trial for %d: %v", user.TelegramID, err)
+		menu.Inline(menu.Row(menu.Data(i18n.T(lang, "common.back"), "back_main")))
+		return h.editOrResend(c, i18n.T(lang, "trial.failed"), menu)
+	}
+
+	text := i18n.T(lang, "trial.done",
+		sub.Product.CountryFlag, sub.Product.Name, sub.ExpiresAt.Format("02.01.2006 15:04"), h.subKey(sub))
+
+	menu.Inline(
+		menu.Row(menu.Data(i18n.T(lang, "common.instruction"), "instruction")),
+		menu.Row(menu.Data(i18n.T(lang, "common.my_subs"), "mysubs")),
+		menu.Row(menu.Data(i18n.T(lang, "common.main_menu"), "back_main")),
+	)
+	return h.editOrResend(c, text, menu)
+}
@@ -0,0 +1,202 @@
+package i18n
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Языки интерфейса
+const (
+	RU = "ru"
+	EN = "en"
+
+	// Default язык, в котором есть все сообщения; из него берутся отсутствующие в других каталогах
+	Default = RU
+)
+
+// Категории множественного числа (имена по CLDR)
+const (
+	One   = "one"
+	Few   = "few"
+	Many  = "many"
+	Other = "other"
+)
+
+//go:embed locales/*.yaml
+var localesFS embed.FS
+
+// message сообщение каталога: строка или формы множественного числа
+type message struct {
+	text  string
+	forms map[string]string
+}
+
+// pluralRules категория множественного числа для n
+var pluralRules = map[string]func(n int) string{
+	// 1 месяц, 2 месяца, 5 месяцев, 11 месяцев, 21 месяц
+	RU: func(n int) string {
+		if n < 0 {
+			n = -n
+		}
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return One
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return Few
+		default:
+			return Many
+		}
+	},
+	// 1 month, 2 months
+	EN: func(n int) string {
+		if n == 1 {
+			return One
+		}
+		return Other
+	},
+}
+
+// catalogs сообщения по языкам, загружаются из locales/<язык>.yaml при старте
+var catalogs = loadCatalogs()
+
+// loadCatalogs разбирает встроенные каталоги. Ошибка в каталоге — ошибка сборки бота, поэтому паника
+func loadCatalogs() map[string]map[string]message {
+	catalogs := make(map[string]map[string]message)
+	for lang := range pluralRules {
+		data, err := localesFS.ReadFile(path.Join("locales", lang+".yaml"))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: catalog %s: %v", lang, err))
+		}
+		catalog, err := parseCatalog(lang, data)
+		if err != nil {
+			panic(fmt.Sprintf("i18n: catalog %s: %v", lang, err))
+		}
+		catalogs[lang] = catalog
+	}
+	// Ключ, которого нет в каталоге по умолчанию, — опечатка: T его никогда не запросит
+	for lang, catalog := range catalogs {
+		for key := range catalog {
+			if _, ok := catalogs[Default][key]; !ok {
+				panic(fmt.Sprintf("i18n: catalog %s: key %s is missing in %s", lang, key, Default))
+			}
+		}
+	}
+	return catalogs
+}
+
+// parseCatalog разбирает каталог: ключ — строка или словарь форм множественного числа языка
+func parseCatalog(lang string, data []byte) (map[string]message, error) {
+	var raw map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	required := []string{One, Other}
+	if lang == RU {
+		required = []string{One, Few, Many}
+	}
+
+	catalog := make(map[string]message, len(raw))
+	for key, node := range raw {
+		switch node.Kind {
+		case yaml.ScalarNode:
+			catalog[key] = message{text: node.Value}
+		case yaml.MappingNode:
+			var forms map[string]string
+			if err := node.Decode(&forms); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			for _, form := range required {
+				if _, ok := forms[form]; !ok {
+					return nil, fmt.Errorf("%s: missing plural form %q", key, form)
+				}
+			}
+			catalog[key] = message{forms: forms}
+		default:
+			return nil, fmt.Errorf("%s: expected string or plural forms", key)
+		}
+	}
+	return catalog, nil
+}
+
+// lookup сообщение на языке lang, иначе на языке по умолчанию
+func lookup(lang, key string) (message, string, bool) {
+	if msg, ok := catalogs[lang][key]; ok {
+		return msg, lang, true
+	}
+	msg, ok := catalogs[Default][key]
+	return msg, Default, ok
+}
+
+// T сообщение key на языке lang, отформатированное fmt.Sprintf с args.
+// Если ключа нет ни в одном каталоге, возвращается сам ключ — так пропуск сразу виден в интерфейсе
+func T(lang, key string, args ...interface{}) string {
+	msg, _, ok := lookup(lang, key)
+	if !ok {
+		return key
+	}
+	text := msg.text
+	if msg.forms != nil {
+		text = msg.forms[Other]
+		if text == "" {
+			text = msg.forms[Many]
+		}
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// N сообщение key в форме множественного числа для n: "%d месяц", "%d месяца", "%d месяцев".
+// Первым аргументом форматирования идёт n, за ним args
+func N(lang, key string, n int, args ...interface{}) string {
+	msg, msgLang, ok := lookup(lang, key)
+	if !ok {
+		return key
+	}
+	text := msg.text
+	if msg.forms != nil {
+		text = msg.forms[pluralRules[msgLang](n)]
+	}
+	return fmt.Sprintf(text, append([]interface{}{n}, args...)...)
+}
+
+// Supported языки интерфейса: сначала язык по умолчанию
+func Supported() []string {
+	return []string{RU, EN}
+}
+
+// IsSupported есть ли каталог языка lang
+func IsSupported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Name название языка на нём самом: "🇷🇺 Русский"
+func Name(lang string) string {
+	return T(lang, "lang.name")
+}
+
+// Detect язык интерфейса по language_code из Telegram ("en", "pt-br"). Русский — для русского и
+// близких языков, которые им обычно владеют, английский — для остальных; пустой код — язык по умолчанию
+func Detect(languageCode string) string {
+	code := strings.ToLower(languageCode)
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	switch code {
+	case "":
+		return Default
+	case RU, "uk", "be", "kk":
+		return RU
+	}
+	if IsSupported(code) {
+		return code
+	}
+	return EN
+}
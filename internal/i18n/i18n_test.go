@@ -0,0 +1,124 @@
+package i18n
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+func TestPluralRules(t *testing.T) {
+	tests := []struct {
+		lang string
+		n    int
+		want string
+	}{
+		{RU, 1, One},
+		{RU, 2, Few},
+		{RU, 5, Many},
+		{RU, 11, Many},
+		{RU, 12, Many},
+		{RU, 14, Many},
+		{RU, 21, One},
+		{RU, 22, Few},
+		{RU, 25, Many},
+		{RU, 111, Many},
+		{RU, 0, Many},
+		{EN, 1, One},
+		{EN, 2, Other},
+		{EN, 0, Other},
+	}
+	for _, tt := range tests {
+		if got := pluralRules[tt.lang](tt.n); got != tt.want {
+			t.Errorf("%s plural of %d = %s, want %s", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestN(t *testing.T) {
+	tests := []struct {
+		lang string
+		n    int
+		want string
+	}{
+		{RU, 1, "1 месяц"},
+		{RU, 2, "2 месяца"},
+		{RU, 5, "5 месяцев"},
+		{RU, 11, "11 месяцев"},
+		{RU, 21, "21 месяц"},
+		{RU, 22, "22 месяца"},
+		{RU, 25, "25 месяцев"},
+		{EN, 1, "1 month"},
+		{EN, 21, "21 months"},
+		// Неизвестный язык получает сообщение и правила языка по умолчанию
+		{"de", 22, "22 месяца"},
+	}
+	for _, tt := range tests {
+		if got := N(tt.lang, "period.months", tt.n); got != tt.want {
+			t.Errorf("N(%s, period.months, %d) = %q, want %q", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
+
+// formatVerb глагол fmt в сообщении; %% совпадает целиком, чтобы не принять "%% off" за "% o"
+var formatVerb = regexp.MustCompile(`%%|%[-+# 0]*(\d+|\*)?(\.\d+)?[a-zA-Z]`)
+
+// messageVerbs глаголы fmt сообщения по порядку (без %%); у форм множественного числа — по каждой форме
+func messageVerbs(msg message) map[string][]string {
+	texts := map[string]string{"": msg.text}
+	if msg.forms != nil {
+		texts = msg.forms
+	}
+	verbs := make(map[string][]string, len(texts))
+	for form, text := range texts {
+		verbs[form] = []string{}
+		for _, verb := range formatVerb.FindAllString(text, -1) {
+			if verb != "%%" {
+				verbs[form] = append(verbs[form], verb)
+			}
+		}
+	}
+	return verbs
+}
+
+func TestCatalogsMatchDefault(t *testing.T) {
+	for _, lang := range Supported() {
+		if lang == Default {
+			continue
+		}
+		catalog := catalogs[lang]
+
+		var missing []string
+		for key, def := range catalogs[Default] {
+			msg, ok := catalog[key]
+			if !ok {
+				missing = append(missing, key)
+				continue
+			}
+			if (msg.forms == nil) != (def.forms == nil) {
+				t.Errorf("%s: %s is plural in one catalog only", lang, key)
+				continue
+			}
+
+			// Аргументы передаются одни и те же, поэтому глаголы у всех форм обоих языков совпадают
+			want := messageVerbs(def)[""]
+			if def.forms != nil {
+				want = messageVerbs(def)[Many]
+			}
+			for form, verbs := range messageVerbs(msg) {
+				if !reflect.DeepEqual(verbs, want) {
+					t.Errorf("%s: %s %s has verbs %v, want %v", lang, key, form, verbs, want)
+				}
+			}
+			for form, verbs := range messageVerbs(def) {
+				if !reflect.DeepEqual(verbs, want) {
+					t.Errorf("%s: %s %s has verbs %v, want %v", Default, key, form, verbs, want)
+				}
+			}
+		}
+		sort.Strings(missing)
+		for _, key := range missing {
+			t.Errorf("%s: key %s is missing", lang, key)
+		}
+	}
+}
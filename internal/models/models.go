@@ -1,6 +1,11 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
 
 // User представляет пользователя бота
 type User struct {
@@ -11,6 +16,9 @@ type User struct {
 	ReferrerID      *int64    `db:"referrer_id"`       // Telegram ID того, кто пригласил
 	TotalRefEarnings float64  `db:"total_ref_earnings"` // Всего заработано с рефералов
 	CreatedAt       time.Time `db:"created_at"`
+	Currency        string    `db:"currency"` // валюта отображения сумм
+	Language        string    `db:"language"`      // язык, выбранный в настройках (пусто — по language_code)
+	LanguageCode    string    `db:"language_code"` // language_code клиента Telegram при последнем обращении
 }
 
 // Product представляет VPN продукт/локацию
@@ -22,20 +30,127 @@ type Product struct {
 	MarzbanTag  string  `db:"marzban_tag"`
 	Description string  `db:"description"`
 	SortOrder   int     `db:"sort_order"`
+
+	TrafficLimitGB int    `db:"traffic_limit_gb"` // квота трафика на период, ГБ (0 = безлимит)
+	TrafficReset   string `db:"traffic_reset"`    // TrafficResetNone | TrafficResetMonth
+
+	DeviceLimit     int      `db:"device_limit"`      // одновременных устройств (0 = без ограничения)
+	DeviceSlotPrice *float64 `db:"device_slot_price"` // цена доп. устройства за месяц (nil = не продаются)
+}
+
+// TrafficQuota квота трафика продукта
+func (p *Product) TrafficQuota() TrafficQuota {
+	return TrafficQuota{LimitGB: p.TrafficLimitGB, Reset: p.TrafficReset}
+}
+
+// Limits ограничения подписки на продукт без плана (подарок, выдача админом)
+func (p *Product) Limits() SubscriptionLimits {
+	return SubscriptionLimits{Traffic: p.TrafficQuota(), Devices: p.DeviceLimit}
 }
 
 // Subscription представляет подписку пользователя
 type Subscription struct {
+	ID          int64     `db:"id"`
+	UserID      int64     `db:"user_id"`
+	ProductID   int64     `db:"product_id"`
+	KeyString   string    `db:"key_string"`   // vless:// link
+	VPNUsername string    `db:"vpn_username"` // username в VPN панели
+	NodeID      *int64    `db:"node_id"`      // нода, на которой создан клиент (nil = панель из конфига)
+	ExpiresAt   time.Time `db:"expires_at"`
+	IsActive    bool      `db:"is_active"`
+	CreatedAt   time.Time `db:"created_at"`
+
+	TrafficLimit   int64      `db:"traffic_limit"`    // базовая квота периода, байт (0 = безлимит)
+	TrafficExtra   int64      `db:"traffic_extra"`    // докупленный в текущем периоде трафик, байт
+	TrafficReset   string     `db:"traffic_reset"`    // TrafficResetNone | TrafficResetMonth
+	TrafficResetAt *time.Time `db:"traffic_reset_at"` // следующий ежемесячный сброс квоты
+
+	DeviceLimit  int `db:"device_limit"`  // лимит устройств по плану (0 = без ограничения)
+	ExtraDevices int `db:"extra_devices"` // докупленные в текущем периоде устройства
+
+	SubToken string `db:"sub_token"` // токен ссылки подписки /sub/{token}
+
+	PlanID               *int64     `db:"plan_id"`                 // план последней покупки или продления
+	AutoRenew            bool       `db:"auto_renew"`              // продлевать с баланса перед окончанием
+	AutoRenewAttemptedAt *time.Time `db:"auto_renew_attempted_at"` // последняя попытка автопродления
+
+	// Joined fields
+	Product *Product `db:"-"`
+}
+
+// TrafficQuotaBytes итоговая квота в панели: базовая + докупленная (0 = безлимит)
+func (s *Subscription) TrafficQuotaBytes() int64 {
+	if s.TrafficLimit == 0 {
+		return 0
+	}
+	return s.TrafficLimit + s.TrafficExtra
+}
+
+// DeviceLimitTotal итоговый лимит устройств в панели: по плану + докупленные (0 = без ограничения)
+func (s *Subscription) DeviceLimitTotal() int {
+	if s.DeviceLimit == 0 {
+		return 0
+	}
+	return s.DeviceLimit + s.ExtraDevices
+}
+
+// SubscriptionLimits ограничения, назначаемые подписке при покупке и продлении
+type SubscriptionLimits struct {
+	Traffic TrafficQuota
+	Devices int // одновременных устройств (0 = без ограничения)
+}
+
+// Стратегии сброса квоты трафика
+const (
+	TrafficResetNone  = "no_reset" // квота на весь оплаченный период
+	TrafficResetMonth = "month"    // квота обновляется каждый месяц
+)
+
+// BytesPerGB байт в гигабайте квоты
+const BytesPerGB int64 = 1 << 30
+
+// TrafficQuota квота трафика, назначаемая подписке при покупке и продлении
+type TrafficQuota struct {
+	LimitGB int    // 0 = безлимит
+	Reset   string // TrafficResetNone | TrafficResetMonth
+}
+
+// Bytes квота в байтах
+func (q TrafficQuota) Bytes() int64 {
+	return int64(q.LimitGB) * BytesPerGB
+}
+
+// NextReset дата следующего сброса квоты после from (nil — сброса нет)
+func (q TrafficQuota) NextReset(from time.Time) *time.Time {
+	if q.LimitGB == 0 || q.Reset != TrafficResetMonth {
+		return nil
+	}
+	next := from.AddDate(0, 1, 0)
+	return &next
+}
+
+// TrafficPack пакет дополнительного трафика, покупается с баланса к подписке с квотой
+type TrafficPack struct {
 	ID        int64     `db:"id"`
-	UserID    int64     `db:"user_id"`
 	ProductID int64     `db:"product_id"`
-	KeyString string    `db:"key_string"` // vless:// link
-	ExpiresAt time.Time `db:"expires_at"`
+	TrafficGB int       `db:"traffic_gb"`
+	Price     float64   `db:"price"`
+	SortOrder int       `db:"sort_order"`
 	IsActive  bool      `db:"is_active"`
 	CreatedAt time.Time `db:"created_at"`
+}
 
-	// Joined fields
-	Product *Product `db:"-"`
+// Типы напоминаний об окончании подписки
+const (
+	Reminder3Days   = "3d"
+	Reminder1Day    = "1d"
+	ReminderExpired = "expired"
+)
+
+// ExpiringSubscription подписка для напоминания вместе с Telegram ID владельца
+type ExpiringSubscription struct {
+	Subscription
+	TelegramID int64 `db:"telegram_id"`
 }
 
 // TransactionType тип транзакции
@@ -46,6 +161,9 @@ const (
 	TransactionPurchase      TransactionType = "purchase"
 	TransactionRefund        TransactionType = "refund"
 	TransactionReferralBonus TransactionType = "referral_bonus"
+	TransactionPromoBonus    TransactionType = "promo_bonus"
+	TransactionManualDeposit TransactionType = "manual_deposit"
+	TransactionOpening       TransactionType = "opening_balance" // остаток баланса при переходе на главную книгу (только в ledger_entries)
 )
 
 // TransactionStatus статус транзакции
@@ -66,23 +184,359 @@ type Transaction struct {
 	Type      TransactionType   `db:"type"`
 	Status    TransactionStatus `db:"status"`
 	CreatedAt time.Time         `db:"created_at"`
+
+	// Только для пополнений по чеку СБП (ручная проверка)
+	ReceiptFileID string     `db:"receipt_file_id"`
+	ReviewedBy    *int64     `db:"reviewed_by"` // telegram_id админа
+	ReviewedAt    *time.Time `db:"reviewed_at"`
+
+	// Связи покупок и возвратов с подпиской
+	SubscriptionID *int64 `db:"subscription_id"`
+	RefundOf       *int64 `db:"refund_of"`     // возвращённая покупка
+	RefundMethod   string `db:"refund_method"` // RefundToBalance или RefundExternal
+}
+
+// Способы выплаты возврата
+const (
+	RefundToBalance = "balance"  // зачислен на баланс в боте
+	RefundExternal  = "external" // выплачен вне бота, баланс не меняется
+)
+
+// Счета главной книги. Каждое движение баланса — проводка из двух записей с противоположными суммами:
+// по счёту пользователя и по встречному системному счёту, поэтому сумма всех записей всегда 0
+const (
+	LedgerUser     = "user"     // баланс пользователя (user_id)
+	LedgerPayments = "payments" // деньги, поступившие через платёжные шлюзы и чеки
+	LedgerSales    = "sales"    // выручка от покупок (возвраты на баланс её уменьшают)
+	LedgerReferral = "referral" // расходы на реферальную программу
+	LedgerPromo    = "promo"    // расходы на промокоды
+	LedgerManual   = "manual"   // ручные начисления админом
+	LedgerOpening  = "opening"  // остатки балансов на момент перехода на книгу
+)
+
+// LedgerEntry запись главной книги; суммы в копейках
+type LedgerEntry struct {
+	ID             int64           `db:"id"`
+	PostingID      int64           `db:"posting_id"` // общая у обеих записей проводки
+	Account        string          `db:"account"`
+	UserID         *int64          `db:"user_id"` // только для счёта LedgerUser
+	Amount         int64           `db:"amount"`  // копейки, > 0 — поступление на счёт
+	Reason         TransactionType `db:"reason"`
+	TransactionID  *int64          `db:"transaction_id"`
+	SubscriptionID *int64          `db:"subscription_id"`
+	PromoID        *int64          `db:"promo_id"`
+	InvoiceID      *int64          `db:"invoice_id"`
+	CreatedAt      time.Time       `db:"created_at"`
+}
+
+// LedgerDrift расхождение users.balance с суммой записей книги по счёту пользователя
+type LedgerDrift struct {
+	UserID     int64
+	TelegramID int64
+	Balance    int64 // users.balance, копейки
+	Ledger     int64 // сумма записей книги, копейки
+}
+
+// Kopecks переводит рубли в копейки с округлением
+func Kopecks(rubles float64) int64 {
+	return int64(math.Round(rubles * 100))
 }
 
-// PricingPlan план с расчётом цены
-type PricingPlan struct {
-	Months   int
-	Discount int     // процент скидки
-	Price    float64 // итоговая цена
+// Rubles переводит копейки в рубли
+func Rubles(kopecks int64) float64 {
+	return float64(kopecks) / 100
 }
 
-// CalculatePricingPlans рассчитывает планы с учётом скидок
-func CalculatePricingPlans(basePrice float64) []PricingPlan {
-	return []PricingPlan{
-		{Months: 1, Discount: 0, Price: basePrice},
-		{Months: 3, Discount: 0, Price: basePrice * 3},
-		{Months: 6, Discount: 10, Price: basePrice * 6 * 0.90},
-		{Months: 12, Discount: 20, Price: basePrice * 12 * 0.80},
+// BaseCurrency валюта, в которой хранятся цены, балансы и проводки
+const BaseCurrency = "RUB"
+
+// Currency валюта отображения сумм с курсом пересчёта из базовой
+type Currency struct {
+	Code      string    `db:"code"`
+	Rate      float64   `db:"rate"` // сколько рублей стоит единица валюты
+	UpdatedAt time.Time `db:"updated_at"`
+	UpdatedBy *int64    `db:"updated_by"` // Telegram ID админа, задавшего курс
+}
+
+// RUB базовая валюта
+var RUB = &Currency{Code: BaseCurrency, Rate: 1}
+
+// currencySymbols символы валют; символ из карты ставится перед суммой, код без символа — после
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+}
+
+// IsBase базовая ли валюта (nil — базовая)
+func (c *Currency) IsBase() bool {
+	return c == nil || c.Code == BaseCurrency
+}
+
+// Convert пересчитывает сумму в рублях в валюту
+func (c *Currency) Convert(rub float64) float64 {
+	if c.IsBase() || c.Rate <= 0 {
+		return rub
+	}
+	return rub / c.Rate
+}
+
+// Format форматирует сумму в рублях в валюте: "450 ₽", "$4.95", "4.95 USDT".
+// Все суммы, которые видит пользователь или админ, выводятся через неё
+func (c *Currency) Format(rub float64) string {
+	if c.IsBase() || c.Rate <= 0 {
+		if rub == math.Trunc(rub) {
+			return fmt.Sprintf("%.0f ₽", rub)
+		}
+		return fmt.Sprintf("%.2f ₽", rub)
+	}
+	amount := c.Convert(rub)
+	if symbol, ok := currencySymbols[c.Code]; ok {
+		if amount < 0 {
+			return fmt.Sprintf("-%s%.2f", symbol, -amount)
+		}
+		return fmt.Sprintf("%s%.2f", symbol, amount)
 	}
+	return fmt.Sprintf("%.2f %s", amount, c.Code)
+}
+
+// FormatWithBase сумма в валюте и в рублях, которыми она оплачивается: "$4.95 (450 ₽)"
+func (c *Currency) FormatWithBase(rub float64) string {
+	if c.IsBase() {
+		return c.Format(rub)
+	}
+	return fmt.Sprintf("%s (%s)", c.Format(rub), RUB.Format(rub))
+}
+
+// Виды заказа
+const (
+	OrderNew    = "new"    // новая подписка
+	OrderExtend = "extend" // продление подписки
+)
+
+// Статусы заказа
+const (
+	OrderPending   = "pending"   // деньги списаны, подписка ещё не выдана
+	OrderCompleted = "completed" // подписка выдана или продлена
+	OrderFailed    = "failed"    // покупка не удалась, деньги возвращены на баланс
+)
+
+// Итоги попытки автопродления
+const (
+	AutoRenewCompleted = "completed" // подписка продлена заказом попытки
+	AutoRenewFailed    = "failed"    // не хватило средств, план недоступен или заказ отменён
+)
+
+// Order покупка плана с баланса. Деньги списываются при создании заказа, подписка выдаётся
+// в той же транзакции БД, что завершает заказ; при ошибке или сбое заказ отменяется с возвратом
+type Order struct {
+	ID             int64     `db:"id"`
+	UserID         int64     `db:"user_id"`
+	IdempotencyKey string    `db:"idempotency_key"`
+	Kind           string    `db:"kind"`
+	ProductID      int64     `db:"product_id"`
+	PlanID         *int64    `db:"plan_id"`
+	SubscriptionID *int64    `db:"subscription_id"`
+	Amount         float64   `db:"amount"`
+	BonusDays      int       `db:"bonus_days"`
+	NodeID         *int64    `db:"node_id"`
+	VPNUsername    string    `db:"vpn_username"`
+	PurchaseID     *int64    `db:"purchase_id"`
+	Status         string    `db:"status"`
+	Error          string    `db:"error"`
+	CreatedAt      time.Time `db:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at"`
+}
+
+// Статусы обработки callback
+const (
+	CallbackProcessing = "processing" // обработчик выполняется
+	CallbackDone       = "done"       // обработчик завершён, ответ сохранён
+)
+
+// CallbackRequest обработка денежного callback: повторы с тем же ID или отпечатком не выполняются
+type CallbackRequest struct {
+	ID          int64      `db:"id"`
+	CallbackID  string     `db:"callback_id"`
+	TelegramID  int64      `db:"telegram_id"`
+	Fingerprint string     `db:"fingerprint"`
+	Status      string     `db:"status"`
+	Result      []byte     `db:"result"` // JSON ответа обработчика
+	CreatedAt   time.Time  `db:"created_at"`
+	CompletedAt *time.Time `db:"completed_at"`
+}
+
+// Статусы счёта
+const (
+	InvoicePending  = "pending"
+	InvoicePaid     = "paid"
+	InvoiceCanceled = "canceled"
+	InvoiceRefunded = "refunded"
+)
+
+// Назначения счёта
+const (
+	InvoicePurposeTopUp        = "top_up"
+	InvoicePurposeSubscription = "subscription"
+)
+
+// GatewayStars имя «шлюза» для оплаты Telegram Stars (external_id = telegram_payment_charge_id)
+const GatewayStars = "telegram_stars"
+
+// CryptoBonusDays бонусные дни к подписке при оплате криптовалютой
+const CryptoBonusDays = 7
+
+// Invoice счёт в платёжном шлюзе
+type Invoice struct {
+	ID             int64      `db:"id"`
+	UserID         int64      `db:"user_id"`
+	Gateway        string     `db:"gateway"`
+	ExternalID     string     `db:"external_id"`
+	Purpose        string     `db:"purpose"`
+	Amount         float64    `db:"amount"`
+	AmountStars    int        `db:"amount_stars"` // только для оплаты Telegram Stars
+	Status         string     `db:"status"`
+	PaymentURL     string     `db:"payment_url"`
+	ProductID      *int64     `db:"product_id"` // только для purpose = subscription
+	PlanID         *int64     `db:"plan_id"`    // купленный план (nil у счетов до настраиваемых планов)
+	Months         int        `db:"months"`
+	Days           int        `db:"days"`
+	BonusDays      int        `db:"bonus_days"`
+	SubscriptionID *int64     `db:"subscription_id"` // подписка, созданная по оплаченному счёту
+	CreatedAt      time.Time  `db:"created_at"`
+	PaidAt         *time.Time `db:"paid_at"`
+
+	// Только для счетов на подписку: оплата зачислена, но подписка ещё не создана (активацию повторят)
+	ActivationPending bool   `db:"activation_pending"`
+	ActivationError   string `db:"activation_error"` // причина последней неудачной активации
+}
+
+// DaysPerMonth дней в месяце при расчёте цены плана по базовой цене
+const DaysPerMonth = 30
+
+// ProductPlan тарифный план продукта: срок подписки и цена (фиксированная или скидка от базовой)
+type ProductPlan struct {
+	ID              int64     `db:"id"`
+	ProductID       int64     `db:"product_id"`
+	Months          int       `db:"months"`
+	Days            int       `db:"days"`
+	Price           *float64  `db:"price"`            // фиксированная цена (nil = базовая цена × срок − скидка)
+	DiscountPercent int       `db:"discount_percent"` // скидка от базовой цены (если цена не задана)
+	SortOrder       int       `db:"sort_order"`
+	IsActive        bool      `db:"is_active"`
+	CreatedAt       time.Time `db:"created_at"`
+	UpdatedAt       time.Time `db:"updated_at"`
+	TrafficLimitGB  *int      `db:"traffic_limit_gb"` // квота плана, ГБ (nil = квота продукта, 0 = безлимит)
+	DeviceLimit     *int      `db:"device_limit"`     // лимит устройств плана (nil = лимит продукта, 0 = без ограничения)
+}
+
+// TrafficQuota квота трафика плана: своя или квота продукта
+func (p *ProductPlan) TrafficQuota(product *Product) TrafficQuota {
+	q := product.TrafficQuota()
+	if p.TrafficLimitGB != nil {
+		q.LimitGB = *p.TrafficLimitGB
+	}
+	return q
+}
+
+// Limits ограничения подписки по плану: свои или продукта
+func (p *ProductPlan) Limits(product *Product) SubscriptionLimits {
+	limits := SubscriptionLimits{Traffic: p.TrafficQuota(product), Devices: product.DeviceLimit}
+	if p.DeviceLimit != nil {
+		limits.Devices = *p.DeviceLimit
+	}
+	return limits
+}
+
+// CalculatePrice цена плана без акций
+func (p *ProductPlan) CalculatePrice(basePrice float64) float64 {
+	if p.Price != nil {
+		return *p.Price
+	}
+	price := basePrice * (float64(p.Months) + float64(p.Days)/DaysPerMonth)
+	return price * float64(100-p.DiscountPercent) / 100
+}
+
+// ExpiresFrom дата окончания подписки, купленной по плану в момент from
+func (p *ProductPlan) ExpiresFrom(from time.Time) time.Time {
+	return from.AddDate(0, p.Months, p.Days)
+}
+
+// PeriodTitle короткая запись срока: "3 мес.", "7 дн.", "1 мес. 15 дн."
+func (p *ProductPlan) PeriodTitle() string {
+	var parts []string
+	if p.Months > 0 {
+		parts = append(parts, fmt.Sprintf("%d мес.", p.Months))
+	}
+	if p.Days > 0 {
+		parts = append(parts, fmt.Sprintf("%d дн.", p.Days))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Campaign акция со скидкой (флеш-распродажа). Может быть запланирована заранее
+// и ограничена продуктом и/или сроком подписки
+type Campaign struct {
+	ID              int64      `db:"id"`
+	DiscountPercent int        `db:"discount_percent"`
+	StartsAt        time.Time  `db:"starts_at"`
+	EndsAt          time.Time  `db:"ends_at"`
+	ProductID       *int64     `db:"product_id"` // nil = все продукты
+	Months          *int       `db:"months"`     // nil = все сроки
+	CreatedBy       int64      `db:"created_by"` // Telegram ID админа
+	AnnouncedAt     *time.Time `db:"announced_at"`
+	CancelledAt     *time.Time `db:"cancelled_at"`
+	CreatedAt       time.Time  `db:"created_at"`
+}
+
+// IsActive проверяет, действует ли акция в момент now
+func (c *Campaign) IsActive(now time.Time) bool {
+	return c.CancelledAt == nil && !now.Before(c.StartsAt) && now.Before(c.EndsAt)
+}
+
+// Covers проверяет, распространяется ли акция на план
+func (c *Campaign) Covers(plan *ProductPlan) bool {
+	if c.ProductID != nil && *c.ProductID != plan.ProductID {
+		return false
+	}
+	return c.Months == nil || (*c.Months == plan.Months && plan.Days == 0)
+}
+
+// Apply применяет скидку акции к цене
+func (c *Campaign) Apply(price float64) float64 {
+	return price * float64(100-c.DiscountPercent) / 100
+}
+
+// Node VPN сервер (отдельная панель Marzban / 3X-UI)
+type Node struct {
+	ID         int64     `db:"id"`
+	Name       string    `db:"name"`
+	PanelType  string    `db:"panel_type"` // marzban | 3xui
+	BaseURL    string    `db:"base_url"`
+	Username   string    `db:"username"`
+	Password   string    `db:"password"`
+	InboundID  int       `db:"inbound_id"`
+	PublicHost string    `db:"public_host"`
+	Flow       string    `db:"flow"`
+	Capacity   int       `db:"capacity"` // максимум активных подписок, 0 = без ограничений
+	IsEnabled  bool      `db:"is_enabled"`
+	CreatedAt  time.Time `db:"created_at"`
+
+	// Вычисляемые поля
+	ActiveSubscriptions int `db:"-"`
+}
+
+// Load возвращает загрузку ноды (0..1), для нод без лимита всегда 0
+func (n *Node) Load() float64 {
+	if n.Capacity <= 0 {
+		return 0
+	}
+	return float64(n.ActiveSubscriptions) / float64(n.Capacity)
+}
+
+// IsFull проверяет, достигнут ли лимит подписок
+func (n *Node) IsFull() bool {
+	return n.Capacity > 0 && n.ActiveSubscriptions >= n.Capacity
 }
 
 // AdminStats статистика для админ-панели
@@ -93,6 +547,10 @@ type AdminStats struct {
 	RevenueToday        float64 `db:"revenue_today"`
 	RevenueMonth        float64 `db:"revenue_month"`
 	RevenueAllTime      float64 `db:"revenue_all_time"`
+
+	TrialsClaimed   int64 `db:"trials_claimed"`   // выдано пробных подписок
+	TrialsActive    int64 `db:"trials_active"`    // пробных подписок действует сейчас
+	TrialsConverted int64 `db:"trials_converted"` // взявших пробный период, кто потом оплатил
 }
 
 // TopReferrer информация о топ-рефоводе
@@ -119,6 +577,44 @@ type UserProfile struct {
 	Transactions  []Transaction
 }
 
+// Статусы тикета поддержки
+const (
+	TicketNew     = "new"     // создан, сообщений ещё нет
+	TicketWaiting = "waiting" // ожидает ответа поддержки
+	TicketReplied = "replied" // поддержка ответила
+	TicketClosed  = "closed"
+)
+
+// Ticket обращение в поддержку
+type Ticket struct {
+	ID             int64      `db:"id"`
+	UserID         int64      `db:"user_id"`
+	Status         string     `db:"status"`
+	AssigneeID     *int64     `db:"assignee_id"` // telegram_id админа, который ведёт тикет
+	Composing      bool       `db:"composing"`   // режим поддержки: сообщения пользователя уходят в тикет
+	GroupMessageID int        `db:"group_message_id"`
+	CreatedAt      time.Time  `db:"created_at"`
+	UpdatedAt      time.Time  `db:"updated_at"`
+	ClosedAt       *time.Time `db:"closed_at"`
+
+	// Joined fields
+	TelegramID   int64  `db:"-"`
+	Username     string `db:"-"`
+	MessageCount int    `db:"-"` // сообщений от пользователя
+}
+
+// TicketMessage сообщение в тикете
+type TicketMessage struct {
+	ID             int64     `db:"id"`
+	TicketID       int64     `db:"ticket_id"`
+	FromAdmin      bool      `db:"from_admin"`
+	SenderID       int64     `db:"sender_id"` // telegram_id автора
+	Kind           string    `db:"kind"`      // text | photo | document | voice | video | sticker
+	Text           string    `db:"text"`
+	GroupMessageID int       `db:"group_message_id"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
 // PromoCode представляет промокод
 type PromoCode struct {
 	ID              int64     `db:"id"`
@@ -155,3 +651,35 @@ type ReferralListResult struct {
 	CurrentPage   int
 	TotalPages    int
 }
+
+// FSMSession состояние диалога пользователя (шаг мастера и его данные)
+type FSMSession struct {
+	UserID    int64     `db:"user_id"` // Telegram ID
+	State     string    `db:"state"`
+	Data      []byte    `db:"data"` // JSON
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+// Кто выполнил действие из журнала аудита
+const (
+	AuditActorUser  = "user"
+	AuditActorAdmin = "admin"
+)
+
+// Действия журнала аудита
+const (
+	AuditKeyRotate          = "key_rotate"          // перевыпуск ключа подписки
+	AuditSubscriptionCancel = "subscription_cancel" // досрочная отмена подписки с возвратом
+)
+
+// AuditEntry запись журнала аудита
+type AuditEntry struct {
+	ID             int64     `db:"id"`
+	ActorID        int64     `db:"actor_id"` // Telegram ID
+	ActorRole      string    `db:"actor_role"`
+	Action         string    `db:"action"`
+	UserID         *int64    `db:"user_id"`
+	SubscriptionID *int64    `db:"subscription_id"`
+	Details        string    `db:"details"`
+	CreatedAt      time.Time `db:"created_at"`
+}
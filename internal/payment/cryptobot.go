@@ -0,0 +1,244 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"vpn-telegram-bot/internal/config"
+)
+
+// Адреса Crypto Pay API
+const (
+	cryptoBotAPIURL        = "https://pay.crypt.bot/api"
+	cryptoBotTestnetAPIURL = "https://testnet-pay.crypt.bot/api"
+)
+
+// cryptoBotInvoiceTTL время жизни счёта в CryptoBot
+const cryptoBotInvoiceTTL = 24 * time.Hour
+
+// CryptoBotGateway шлюз Crypto Pay API (@CryptoBot): счета в рублях с оплатой в USDT / TON / BTC.
+// Уведомления подписываются HMAC-SHA256 с ключом SHA256(token).
+type CryptoBotGateway struct {
+	token   string
+	assets  []string
+	baseURL string
+	client  *http.Client
+}
+
+// NewCryptoBotGateway создаёт шлюз CryptoBot
+func NewCryptoBotGateway(cfg config.CryptoBotConfig) *CryptoBotGateway {
+	baseURL := cryptoBotAPIURL
+	if cfg.Testnet {
+		baseURL = cryptoBotTestnetAPIURL
+	}
+	return NewCryptoBotGatewayWithClient(cfg, baseURL, &http.Client{Timeout: 15 * time.Second})
+}
+
+// NewCryptoBotGatewayWithClient создаёт шлюз CryptoBot с указанным адресом API и HTTP клиентом
+func NewCryptoBotGatewayWithClient(cfg config.CryptoBotConfig, baseURL string, client *http.Client) *CryptoBotGateway {
+	assets := cfg.Assets
+	if len(assets) == 0 {
+		assets = []string{"USDT", "TON", "BTC"}
+	}
+	return &CryptoBotGateway{
+		token:   cfg.Token,
+		assets:  assets,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  client,
+	}
+}
+
+// cryptoBotResponse конверт ответов API
+type cryptoBotResponse struct {
+	OK     bool            `json:"ok"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code int    `json:"code"`
+		Name string `json:"name"`
+	} `json:"error"`
+}
+
+// cryptoBotInvoice счёт CryptoBot
+type cryptoBotInvoice struct {
+	InvoiceID     int64  `json:"invoice_id"`
+	Status        string `json:"status"` // active | paid | expired
+	CurrencyType  string `json:"currency_type"`
+	Fiat          string `json:"fiat"`
+	Amount        string `json:"amount"`
+	BotInvoiceURL string `json:"bot_invoice_url"`
+	PayURL        string `json:"pay_url"` // устаревшее поле, оставлено для совместимости
+}
+
+// cryptoBotUpdate тело вебхука
+type cryptoBotUpdate struct {
+	UpdateType string           `json:"update_type"` // invoice_paid
+	Payload    cryptoBotInvoice `json:"payload"`
+}
+
+// Name возвращает имя шлюза
+func (g *CryptoBotGateway) Name() string {
+	return "cryptobot"
+}
+
+// CreateInvoice создаёт фиатный (RUB) счёт с оплатой в криптовалюте
+func (g *CryptoBotGateway) CreateInvoice(ctx context.Context, req InvoiceRequest) (*Invoice, error) {
+	params := map[string]any{
+		"currency_type":   "fiat",
+		"fiat":            "RUB",
+		"accepted_assets": strings.Join(g.assets, ","),
+		"amount":          strconv.FormatFloat(req.Amount, 'f', 2, 64),
+		"description":     req.Description,
+		"payload":         strconv.FormatInt(req.InvoiceID, 10),
+		"expires_in":      int(cryptoBotInvoiceTTL.Seconds()),
+	}
+	if req.ReturnURL != "" {
+		params["paid_btn_name"] = "callback"
+		params["paid_btn_url"] = req.ReturnURL
+	}
+
+	var inv cryptoBotInvoice
+	if err := g.call(ctx, "createInvoice", params, &inv); err != nil {
+		return nil, fmt.Errorf("cryptobot create invoice: %w", err)
+	}
+
+	payURL := inv.BotInvoiceURL
+	if payURL == "" {
+		payURL = inv.PayURL
+	}
+
+	return &Invoice{
+		ExternalID: strconv.FormatInt(inv.InvoiceID, 10),
+		PaymentURL: payURL,
+	}, nil
+}
+
+// ParseWebhook проверяет подпись и разбирает уведомление invoice_paid
+func (g *CryptoBotGateway) ParseWebhook(ctx context.Context, r *http.Request) (*WebhookEvent, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+
+	if !g.validSignature(body, r.Header.Get("Crypto-Pay-Api-Signature")) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidWebhook)
+	}
+
+	var upd cryptoBotUpdate
+	if err := json.Unmarshal(body, &upd); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+	if upd.UpdateType != "invoice_paid" {
+		return nil, fmt.Errorf("%w: unsupported update %q", ErrInvalidWebhook, upd.UpdateType)
+	}
+
+	return cryptoBotEvent(upd.Payload)
+}
+
+// CheckInvoices запрашивает статус счетов (опрос для случаев, когда вебхук не дошёл)
+func (g *CryptoBotGateway) CheckInvoices(ctx context.Context, externalIDs []string) ([]WebhookEvent, error) {
+	if len(externalIDs) == 0 {
+		return nil, nil
+	}
+
+	var result struct {
+		Items []cryptoBotInvoice `json:"items"`
+	}
+	params := map[string]any{
+		"invoice_ids": strings.Join(externalIDs, ","),
+		"count":       len(externalIDs),
+	}
+	if err := g.call(ctx, "getInvoices", params, &result); err != nil {
+		return nil, fmt.Errorf("cryptobot get invoices: %w", err)
+	}
+
+	events := make([]WebhookEvent, 0, len(result.Items))
+	for _, inv := range result.Items {
+		event, err := cryptoBotEvent(inv)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, nil
+}
+
+// validSignature проверяет подпись: hex(HMAC-SHA256(body, SHA256(token)))
+func (g *CryptoBotGateway) validSignature(body []byte, signature string) bool {
+	if signature == "" {
+		return false
+	}
+	secret := sha256.Sum256([]byte(g.token))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
+
+// cryptoBotEvent переводит счёт CryptoBot в событие оплаты
+func cryptoBotEvent(inv cryptoBotInvoice) (*WebhookEvent, error) {
+	if inv.CurrencyType != "" && (inv.CurrencyType != "fiat" || inv.Fiat != "RUB") {
+		return nil, fmt.Errorf("%w: invoice %d is not in RUB", ErrInvalidWebhook, inv.InvoiceID)
+	}
+
+	amount, err := strconv.ParseFloat(inv.Amount, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad amount %q", ErrInvalidWebhook, inv.Amount)
+	}
+
+	event := &WebhookEvent{
+		ExternalID: strconv.FormatInt(inv.InvoiceID, 10),
+		Amount:     amount,
+		Status:     StatusPending,
+	}
+	switch inv.Status {
+	case "paid":
+		event.Status = StatusPaid
+	case "expired":
+		event.Status = StatusCanceled
+	}
+	return event, nil
+}
+
+// call вызывает метод Crypto Pay API (POST с JSON параметрами)
+func (g *CryptoBotGateway) call(ctx context.Context, method string, params map[string]any, out any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.baseURL+"/"+method, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Crypto-Pay-API-Token", g.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope cryptoBotResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&envelope); err != nil {
+		return fmt.Errorf("status %d: decode response: %w", resp.StatusCode, err)
+	}
+	if !envelope.OK {
+		if envelope.Error != nil {
+			return fmt.Errorf("status %d: %s (%d)", resp.StatusCode, envelope.Error.Name, envelope.Error.Code)
+		}
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	return json.Unmarshal(envelope.Result, out)
+}
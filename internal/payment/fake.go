@@ -0,0 +1,82 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FakeGateway тестовый шлюз для локального режима (APP_ENV=local и флаг -fake-payments).
+// Счёт «оплачивается» POST-запросом на вебхук без какой-либо проверки, поэтому один экземпляр
+// обслуживает все способы оплаты, а в продакшене шлюз не подключается:
+//
+//	curl -X POST <public_url>/webhooks/payment/fake -d '{"external_id":"fake_1","status":"paid"}'
+type FakeGateway struct {
+	publicURL string
+
+	mu       sync.Mutex
+	invoices map[string]float64 // external_id -> сумма
+}
+
+// NewFakeGateway создаёт тестовый шлюз
+func NewFakeGateway(publicURL string) *FakeGateway {
+	return &FakeGateway{
+		publicURL: strings.TrimRight(publicURL, "/"),
+		invoices:  make(map[string]float64),
+	}
+}
+
+// fakeWebhook тело вебхука тестового шлюза
+type fakeWebhook struct {
+	ExternalID string `json:"external_id"`
+	Status     string `json:"status"`
+}
+
+// Name возвращает имя шлюза
+func (g *FakeGateway) Name() string {
+	return "fake"
+}
+
+// CreateInvoice запоминает счёт и возвращает фиктивную ссылку
+func (g *FakeGateway) CreateInvoice(ctx context.Context, req InvoiceRequest) (*Invoice, error) {
+	externalID := fmt.Sprintf("fake_%d", req.InvoiceID)
+
+	g.mu.Lock()
+	g.invoices[externalID] = req.Amount
+	g.mu.Unlock()
+
+	return &Invoice{
+		ExternalID: externalID,
+		PaymentURL: fmt.Sprintf("%s/webhooks/payment/fake?external_id=%s", g.publicURL, externalID),
+	}, nil
+}
+
+// ParseWebhook принимает только счета, созданные этим экземпляром; сумма всегда равна сумме счёта
+func (g *FakeGateway) ParseWebhook(ctx context.Context, r *http.Request) (*WebhookEvent, error) {
+	var body fakeWebhook
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+
+	g.mu.Lock()
+	amount, ok := g.invoices[body.ExternalID]
+	g.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown invoice %q", ErrInvalidWebhook, body.ExternalID)
+	}
+
+	status := body.Status
+	if status == "" {
+		status = StatusPaid
+	}
+
+	return &WebhookEvent{
+		ExternalID: body.ExternalID,
+		Status:     status,
+		Amount:     amount,
+	}, nil
+}
@@ -0,0 +1,67 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFakeGatewayWebhook(t *testing.T) {
+	g := NewFakeGateway("http://bot.test/")
+	ctx := context.Background()
+
+	inv, err := g.CreateInvoice(ctx, InvoiceRequest{InvoiceID: 7, Amount: 499})
+	if err != nil {
+		t.Fatalf("CreateInvoice: %v", err)
+	}
+	if inv.ExternalID != "fake_7" || inv.PaymentURL != "http://bot.test/webhooks/payment/fake?external_id=fake_7" {
+		t.Fatalf("unexpected invoice %+v", inv)
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		want    *WebhookEvent
+		wantErr bool
+	}{
+		{
+			name: "paid by default",
+			body: `{"external_id":"fake_7"}`,
+			want: &WebhookEvent{ExternalID: "fake_7", Status: StatusPaid, Amount: 499},
+		},
+		{
+			name: "posted amount is ignored",
+			body: `{"external_id":"fake_7","status":"paid","amount":1000000}`,
+			want: &WebhookEvent{ExternalID: "fake_7", Status: StatusPaid, Amount: 499},
+		},
+		{
+			name: "canceled",
+			body: `{"external_id":"fake_7","status":"canceled"}`,
+			want: &WebhookEvent{ExternalID: "fake_7", Status: StatusCanceled, Amount: 499},
+		},
+		{name: "unknown invoice", body: `{"external_id":"fake_8"}`, wantErr: true},
+		{name: "malformed body", body: `{`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/webhooks/payment/fake", strings.NewReader(tt.body))
+			event, err := g.ParseWebhook(ctx, r)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidWebhook) {
+					t.Fatalf("err = %v, want ErrInvalidWebhook", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseWebhook: %v", err)
+			}
+			if *event != *tt.want {
+				t.Errorf("event = %+v, want %+v", *event, *tt.want)
+			}
+		})
+	}
+}
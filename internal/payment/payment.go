@@ -0,0 +1,59 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Способы оплаты, для которых подключаются шлюзы
+const (
+	MethodCard   = "card"   // СБП / банковская карта
+	MethodCrypto = "crypto" // криптовалюта
+)
+
+// Статусы платежа в событии вебхука
+const (
+	StatusPaid     = "paid"
+	StatusCanceled = "canceled"
+	StatusPending  = "pending"
+)
+
+// ErrInvalidWebhook вебхук не прошёл проверку подлинности или не распознан
+var ErrInvalidWebhook = errors.New("invalid payment webhook")
+
+// InvoiceRequest данные для создания счёта у провайдера
+type InvoiceRequest struct {
+	InvoiceID   int64   // ID счёта в нашей БД
+	Amount      float64 // сумма в рублях
+	Description string
+	ReturnURL   string // куда вернуть пользователя после оплаты
+}
+
+// Invoice созданный у провайдера счёт
+type Invoice struct {
+	ExternalID string // ID платежа у провайдера
+	PaymentURL string // ссылка на оплату для пользователя
+}
+
+// WebhookEvent проверенное событие об оплате
+type WebhookEvent struct {
+	ExternalID string
+	Status     string  // paid | canceled | pending
+	Amount     float64 // фактически оплаченная сумма в рублях
+}
+
+// StatusChecker шлюз, у которого можно запросить статус счетов (опрос вместо / в дополнение к вебхукам)
+type StatusChecker interface {
+	CheckInvoices(ctx context.Context, externalIDs []string) ([]WebhookEvent, error)
+}
+
+// PaymentGateway платёжный шлюз: создание счёта и приём уведомлений об оплате
+type PaymentGateway interface {
+	// Name короткое имя шлюза (используется в URL вебхука и в БД)
+	Name() string
+	// CreateInvoice создаёт платёж у провайдера
+	CreateInvoice(ctx context.Context, req InvoiceRequest) (*Invoice, error)
+	// ParseWebhook проверяет подлинность уведомления и возвращает событие
+	ParseWebhook(ctx context.Context, r *http.Request) (*WebhookEvent, error)
+}
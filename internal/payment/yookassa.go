@@ -0,0 +1,192 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"vpn-telegram-bot/internal/config"
+)
+
+// yookassaAPIURL адрес API ЮKassa
+const yookassaAPIURL = "https://api.yookassa.ru/v3"
+
+// yookassaCurrency валюта платежей: счета бота выставляются в рублях
+const yookassaCurrency = "RUB"
+
+// YooKassaGateway шлюз ЮKassa с оплатой через СБП.
+// Уведомления ЮKassa не подписываются, поэтому статус платежа перепроверяется запросом к API.
+type YooKassaGateway struct {
+	cfg     config.YooKassaConfig
+	baseURL string
+	client  *http.Client
+}
+
+// NewYooKassaGateway создаёт шлюз ЮKassa
+func NewYooKassaGateway(cfg config.YooKassaConfig) *YooKassaGateway {
+	return NewYooKassaGatewayWithClient(cfg, yookassaAPIURL, &http.Client{Timeout: 15 * time.Second})
+}
+
+// NewYooKassaGatewayWithClient создаёт шлюз ЮKassa с указанным адресом API и HTTP клиентом
+func NewYooKassaGatewayWithClient(cfg config.YooKassaConfig, baseURL string, client *http.Client) *YooKassaGateway {
+	return &YooKassaGateway{
+		cfg:     cfg,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  client,
+	}
+}
+
+// yookassaAmount сумма в формате ЮKassa
+type yookassaAmount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+// yookassaPayment объект платежа
+type yookassaPayment struct {
+	ID           string         `json:"id"`
+	Status       string         `json:"status"` // pending | waiting_for_capture | succeeded | canceled
+	Paid         bool           `json:"paid"`
+	Amount       yookassaAmount `json:"amount"`
+	Confirmation struct {
+		Type            string `json:"type"`
+		ConfirmationURL string `json:"confirmation_url"`
+	} `json:"confirmation"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// yookassaNotification тело HTTP уведомления
+type yookassaNotification struct {
+	Type   string          `json:"type"`
+	Event  string          `json:"event"`
+	Object yookassaPayment `json:"object"`
+}
+
+// Name возвращает имя шлюза
+func (g *YooKassaGateway) Name() string {
+	return "yookassa"
+}
+
+// CreateInvoice создаёт платёж через СБП
+func (g *YooKassaGateway) CreateInvoice(ctx context.Context, req InvoiceRequest) (*Invoice, error) {
+	returnURL := req.ReturnURL
+	if returnURL == "" {
+		returnURL = g.cfg.ReturnURL
+	}
+
+	body := map[string]any{
+		"amount": yookassaAmount{
+			Value:    strconv.FormatFloat(req.Amount, 'f', 2, 64),
+			Currency: yookassaCurrency,
+		},
+		"capture": true,
+		"confirmation": map[string]string{
+			"type":       "redirect",
+			"return_url": returnURL,
+		},
+		"payment_method_data": map[string]string{
+			"type": "sbp",
+		},
+		"description": req.Description,
+		"metadata": map[string]string{
+			"invoice_id": strconv.FormatInt(req.InvoiceID, 10),
+		},
+	}
+
+	var p yookassaPayment
+	idempotenceKey := "invoice-" + strconv.FormatInt(req.InvoiceID, 10)
+	if err := g.do(ctx, http.MethodPost, "/payments", idempotenceKey, body, &p); err != nil {
+		return nil, fmt.Errorf("yookassa create payment: %w", err)
+	}
+
+	return &Invoice{
+		ExternalID: p.ID,
+		PaymentURL: p.Confirmation.ConfirmationURL,
+	}, nil
+}
+
+// ParseWebhook разбирает уведомление и перепроверяет платёж через API
+func (g *YooKassaGateway) ParseWebhook(ctx context.Context, r *http.Request) (*WebhookEvent, error) {
+	var n yookassaNotification
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&n); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWebhook, err)
+	}
+	if n.Type != "notification" || n.Object.ID == "" {
+		return nil, ErrInvalidWebhook
+	}
+
+	// Не доверяем телу уведомления: запрашиваем актуальный статус платежа
+	var p yookassaPayment
+	if err := g.do(ctx, http.MethodGet, "/payments/"+url.PathEscape(n.Object.ID), "", nil, &p); err != nil {
+		return nil, fmt.Errorf("yookassa get payment %s: %w", n.Object.ID, err)
+	}
+
+	// Сумма сверяется со счётом только в рублях: платёж в другой валюте не зачисляем
+	if p.Amount.Currency != yookassaCurrency {
+		return nil, fmt.Errorf("%w: payment %s currency %q, expected %s", ErrInvalidWebhook, p.ID, p.Amount.Currency, yookassaCurrency)
+	}
+
+	amount, err := strconv.ParseFloat(p.Amount.Value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("yookassa: bad amount %q: %w", p.Amount.Value, err)
+	}
+
+	event := &WebhookEvent{
+		ExternalID: p.ID,
+		Amount:     amount,
+		Status:     StatusPending,
+	}
+	switch {
+	case p.Status == "succeeded" && p.Paid:
+		event.Status = StatusPaid
+	case p.Status == "canceled":
+		event.Status = StatusCanceled
+	}
+
+	return event, nil
+}
+
+// do выполняет запрос к API ЮKassa
+func (g *YooKassaGateway) do(ctx context.Context, method, path, idempotenceKey string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, g.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(g.cfg.ShopID, g.cfg.SecretKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotenceKey != "" {
+		req.Header.Set("Idempotence-Key", idempotenceKey)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
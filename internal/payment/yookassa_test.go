@@ -0,0 +1,76 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"vpn-telegram-bot/internal/config"
+)
+
+func TestYooKassaWebhookVerifiesPayment(t *testing.T) {
+	// API отвечает платежом pay_1 с суммой и валютой из теста; тело уведомления ему противоречит
+	var status, amount, currency string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/payments/pay_1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"id":"pay_1","status":%q,"paid":%t,"amount":{"value":%q,"currency":%q}}`,
+			status, status == "succeeded", amount, currency)
+	}))
+	t.Cleanup(srv.Close)
+	g := NewYooKassaGatewayWithClient(config.YooKassaConfig{ShopID: "1", SecretKey: "test"}, srv.URL, srv.Client())
+
+	tests := []struct {
+		name     string
+		status   string
+		amount   string
+		currency string
+		want     *WebhookEvent
+		wantErr  bool
+	}{
+		{
+			name: "paid", status: "succeeded", amount: "500.00", currency: "RUB",
+			want: &WebhookEvent{ExternalID: "pay_1", Status: StatusPaid, Amount: 500},
+		},
+		{
+			name: "pending", status: "pending", amount: "500.00", currency: "RUB",
+			want: &WebhookEvent{ExternalID: "pay_1", Status: StatusPending, Amount: 500},
+		},
+		{
+			name: "canceled", status: "canceled", amount: "500.00", currency: "RUB",
+			want: &WebhookEvent{ExternalID: "pay_1", Status: StatusCanceled, Amount: 500},
+		},
+		{name: "other currency", status: "succeeded", amount: "500.00", currency: "USD", wantErr: true},
+		{name: "no currency", status: "succeeded", amount: "500.00", currency: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, amount, currency = tt.status, tt.amount, tt.currency
+
+			body := `{"type":"notification","event":"payment.succeeded","object":{"id":"pay_1","status":"succeeded","paid":true,
+				"amount":{"value":"500.00","currency":"RUB"}}}`
+			r := httptest.NewRequest(http.MethodPost, "/webhooks/payment/yookassa", strings.NewReader(body))
+
+			got, err := g.ParseWebhook(context.Background(), r)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidWebhook) {
+					t.Fatalf("err = %v, want ErrInvalidWebhook", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseWebhook: %v", err)
+			}
+			if *got != *tt.want {
+				t.Errorf("event = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
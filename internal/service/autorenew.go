@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+)
+
+// ErrAutoRenewUnavailable подписка не найдена или принадлежит другому пользователю
+var ErrAutoRenewUnavailable = errors.New("auto-renew unavailable")
+
+// Окно автопродления
+const (
+	AutoRenewBefore = 24 * time.Hour // за сколько до окончания начинать попытки
+	AutoRenewRetry  = 6 * time.Hour  // пауза между попытками (пользователь мог пополнить баланс)
+)
+
+// AutoRenewResult итог попытки автопродления
+type AutoRenewResult struct {
+	Sub       *models.ExpiringSubscription
+	Plan      *models.ProductPlan // nil — продавать нечего (планы продукта скрыты)
+	Price     float64
+	Balance   float64       // баланс на момент попытки
+	ExpiresAt time.Time     // новый срок при успехе
+	Order     *models.Order // заказ, продливший подписку
+	Err       error         // ErrInsufficientBalance — не хватило средств
+}
+
+// SetAutoRenew включает или выключает автопродление подписки пользователя
+func (s *Service) SetAutoRenew(ctx context.Context, userID, subID int64, enabled bool) error {
+	ok, err := s.db.SetSubscriptionAutoRenew(ctx, userID, subID, enabled)
+	if err != nil {
+		return fmt.Errorf("failed to set auto-renew: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: subscription %d", ErrAutoRenewUnavailable, subID)
+	}
+	log.Printf("🔁 Subscription %d auto-renew: %v", subID, enabled)
+	return nil
+}
+
+// AutoRenewPlan план, по которому продлится подписка: последний купленный, а если он скрыт
+// или удалён — первый продаваемый план продукта
+func (s *Service) AutoRenewPlan(ctx context.Context, sub *models.Subscription) (*models.ProductPlan, error) {
+	if sub.PlanID != nil {
+		plan, err := s.db.GetProductPlanByID(ctx, *sub.PlanID)
+		if err == nil && plan.IsActive && plan.ProductID == sub.ProductID {
+			return plan, nil
+		}
+	}
+	plans, err := s.db.GetProductPlans(ctx, sub.ProductID, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plans: %w", err)
+	}
+	if len(plans) == 0 {
+		return nil, fmt.Errorf("%w: product %d has no plans for sale", ErrPlanUnavailable, sub.ProductID)
+	}
+	return &plans[0], nil
+}
+
+// RunAutoRenewals продлевает с баланса подписки с автопродлением, истекающие в ближайшие AutoRenewBefore
+// (вызывается планировщиком). Каждая попытка записывается в auto_renew_attempts вместе с заказом, который её
+// оплатил, или причиной неудачи: деньги движутся только через заказ
+func (s *Service) RunAutoRenewals(ctx context.Context) []AutoRenewResult {
+	now := time.Now()
+	retryAfter := now.Add(-AutoRenewRetry)
+
+	subs, err := s.db.GetSubscriptionsDueAutoRenew(ctx, now.Add(AutoRenewBefore), retryAfter)
+	if err != nil {
+		log.Printf("Scheduler: failed to get subscriptions for auto-renew: %v", err)
+		return nil
+	}
+
+	var results []AutoRenewResult
+	for i := range subs {
+		sub := &subs[i]
+
+		claimed, err := s.db.ClaimAutoRenewAttempt(ctx, sub.ID, retryAfter)
+		if err != nil {
+			log.Printf("Scheduler: failed to claim auto-renew of sub %d: %v", sub.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		res := s.autoRenew(ctx, sub)
+		status, reason := models.AutoRenewCompleted, ""
+		var orderID *int64
+		if res.Order != nil {
+			orderID = &res.Order.ID
+		}
+		if res.Err != nil {
+			status, reason = models.AutoRenewFailed, res.Err.Error()
+			log.Printf("🔁 Auto-renew of subscription %d failed: %v", sub.ID, res.Err)
+		} else {
+			log.Printf("🔁 Subscription %d auto-renewed until %s (%.2f)", sub.ID, res.ExpiresAt.Format("02.01.2006"), res.Price)
+		}
+		if err := s.db.CreateAutoRenewAttempt(ctx, sub.ID, orderID, status, reason); err != nil {
+			log.Printf("Scheduler: failed to log auto-renew of sub %d: %v", sub.ID, err)
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// autoRenew одна попытка: покупка плана с баланса (Purchase); если продлить не удалось, деньги возвращаются
+func (s *Service) autoRenew(ctx context.Context, sub *models.ExpiringSubscription) AutoRenewResult {
+	res := AutoRenewResult{Sub: sub}
+
+	plan, err := s.AutoRenewPlan(ctx, &sub.Subscription)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Plan = plan
+
+	product, err := s.db.GetProductByID(ctx, sub.ProductID)
+	if err != nil {
+		res.Err = fmt.Errorf("product not found: %w", err)
+		return res
+	}
+	planPrice, err := s.ResolvePlanPrice(ctx, product, plan)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Price = planPrice.Price
+
+	user, err := s.db.GetUserByID(ctx, sub.UserID)
+	if err != nil {
+		res.Err = fmt.Errorf("user not found: %w", err)
+		return res
+	}
+	res.Balance = user.Balance
+	if user.Balance < res.Price {
+		res.Err = fmt.Errorf("%w: have %.2f, need %.2f", ErrInsufficientBalance, user.Balance, res.Price)
+		return res
+	}
+
+	// Каждая попытка — отдельный заказ: повторы уже ограничены ClaimAutoRenewAttempt
+	key := fmt.Sprintf("auto_renew:%d:%d", sub.ID, time.Now().Unix())
+	purchase, err := s.Purchase(ctx, user.ID, PlanRef{PlanID: plan.ID, SubscriptionID: sub.ID}, key)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Order = purchase.Order
+	res.Price = purchase.Price.Price
+	res.ExpiresAt = purchase.Subscription.ExpiresAt
+	res.Balance -= res.Price
+	return res
+}
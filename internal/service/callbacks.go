@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+)
+
+// CallbackDedupWindow в течение какого времени повтор денежного callback не выполняется заново
+const CallbackDedupWindow = time.Hour
+
+// ClaimCallback начинает обработку денежного callback. Если за последний CallbackDedupWindow
+// уже была обработка с тем же ID callback или отпечатком действия, возвращает её и false
+func (s *Service) ClaimCallback(ctx context.Context, telegramID int64, callbackID, fingerprint string) (*models.CallbackRequest, bool, error) {
+	return s.db.ClaimCallback(ctx, telegramID, callbackID, fingerprint, time.Now().Add(-CallbackDedupWindow))
+}
+
+// CompleteCallback сохраняет ответ обработчика (JSON) для повторов того же callback
+func (s *Service) CompleteCallback(ctx context.Context, id int64, result []byte) {
+	if err := s.db.CompleteCallback(ctx, id, result); err != nil {
+		log.Printf("Failed to complete callback request %d: %v", id, err)
+	}
+}
+
+// ReleaseCallback снимает обработку callback, которая не удалась: повтор выполнится заново
+func (s *Service) ReleaseCallback(ctx context.Context, id int64) {
+	if err := s.db.DeleteCallback(ctx, id); err != nil {
+		log.Printf("Failed to release callback request %d: %v", id, err)
+	}
+}
+
+// PurgeCallbacks удаляет обработки callback старше CallbackDedupWindow (вызывается планировщиком)
+func (s *Service) PurgeCallbacks(ctx context.Context) {
+	n, err := s.db.DeleteCallbacksBefore(ctx, time.Now().Add(-CallbackDedupWindow))
+	if err != nil {
+		log.Printf("Scheduler: failed to purge callback requests: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Scheduler: purged %d callback requests", n)
+	}
+}
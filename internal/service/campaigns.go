@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+)
+
+// ErrCampaignInvalid некорректные параметры акции
+var ErrCampaignInvalid = errors.New("invalid campaign")
+
+// PlanPrice итоговая цена плана продукта
+type PlanPrice struct {
+	Plan           *models.ProductPlan
+	PeriodDiscount int              // скидка плана от базовой цены, % (0 при фиксированной цене)
+	BasePrice      float64          // цена плана без акции
+	Price          float64          // к оплате
+	Campaign       *models.Campaign // применённая акция (nil — без акции)
+}
+
+// OnCampaignStart устанавливает обработчик начала акции (рассылка-анонс)
+func (s *Service) OnCampaignStart(fn func(*models.Campaign)) {
+	s.campaignHook = fn
+}
+
+// CreateCampaign создаёт акцию. Если она уже началась, анонс отправляется сразу,
+// иначе — планировщиком в момент начала
+func (s *Service) CreateCampaign(ctx context.Context, c *models.Campaign) (*models.Campaign, error) {
+	if c.DiscountPercent <= 0 || c.DiscountPercent > 90 {
+		return nil, fmt.Errorf("%w: discount %d%%", ErrCampaignInvalid, c.DiscountPercent)
+	}
+	if !c.EndsAt.After(c.StartsAt) || !c.EndsAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: period %s — %s", ErrCampaignInvalid, c.StartsAt, c.EndsAt)
+	}
+	if c.ProductID != nil {
+		if _, err := s.db.GetProductByID(ctx, *c.ProductID); err != nil {
+			return nil, fmt.Errorf("%w: product %d not found", ErrCampaignInvalid, *c.ProductID)
+		}
+	}
+
+	created, err := s.db.CreateCampaign(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create campaign: %w", err)
+	}
+	log.Printf("🔥 Campaign #%d created by %d: -%d%% %s — %s",
+		created.ID, created.CreatedBy, created.DiscountPercent,
+		created.StartsAt.Format("02.01 15:04"), created.EndsAt.Format("02.01 15:04"))
+
+	if created.IsActive(time.Now()) {
+		s.announceCampaign(ctx, created)
+	}
+	return created, nil
+}
+
+// GetCurrentCampaigns возвращает действующие и запланированные акции
+func (s *Service) GetCurrentCampaigns(ctx context.Context) ([]models.Campaign, error) {
+	return s.db.GetCurrentCampaigns(ctx, time.Now())
+}
+
+// GetActiveCampaigns возвращает акции, действующие прямо сейчас
+func (s *Service) GetActiveCampaigns(ctx context.Context) ([]models.Campaign, error) {
+	now := time.Now()
+	campaigns, err := s.db.GetCurrentCampaigns(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	active := campaigns[:0]
+	for _, c := range campaigns {
+		if c.IsActive(now) {
+			active = append(active, c)
+		}
+	}
+	return active, nil
+}
+
+// CancelCampaign отменяет акцию (действующую или запланированную)
+func (s *Service) CancelCampaign(ctx context.Context, id int64) (bool, error) {
+	return s.db.CancelCampaign(ctx, id)
+}
+
+// StopActiveCampaigns отменяет все действующие акции
+func (s *Service) StopActiveCampaigns(ctx context.Context) (int64, error) {
+	return s.db.CancelActiveCampaigns(ctx, time.Now())
+}
+
+// AnnounceStartedCampaigns анонсирует начавшиеся акции (вызывается планировщиком)
+func (s *Service) AnnounceStartedCampaigns(ctx context.Context) {
+	campaigns, err := s.db.GetCampaignsToAnnounce(ctx, time.Now())
+	if err != nil {
+		log.Printf("Scheduler: failed to get started campaigns: %v", err)
+		return
+	}
+	for i := range campaigns {
+		s.announceCampaign(ctx, &campaigns[i])
+	}
+}
+
+// announceCampaign отмечает анонс и передаёт акцию обработчику; повторно одна акция не анонсируется
+func (s *Service) announceCampaign(ctx context.Context, c *models.Campaign) {
+	claimed, err := s.db.MarkCampaignAnnounced(ctx, c.ID)
+	if err != nil {
+		log.Printf("Failed to mark campaign #%d announced: %v", c.ID, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	log.Printf("🔥 Campaign #%d started: -%d%% until %s", c.ID, c.DiscountPercent, c.EndsAt.Format("02.01 15:04"))
+	if s.campaignHook != nil {
+		s.campaignHook(c)
+	}
+}
+
+// ResolvePlanPrice рассчитывает цену плана: цена плана и лучшая из действующих акций
+func (s *Service) ResolvePlanPrice(ctx context.Context, product *models.Product, plan *models.ProductPlan) (*PlanPrice, error) {
+	campaigns, err := s.GetActiveCampaigns(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load campaigns: %w", err)
+	}
+	price := resolvePlanPrice(product, plan, campaigns)
+	return &price, nil
+}
+
+// GetPlanPrices возвращает цены включённых планов продукта с учётом действующих акций
+func (s *Service) GetPlanPrices(ctx context.Context, product *models.Product) ([]PlanPrice, error) {
+	plans, err := s.db.GetProductPlans(ctx, product.ID, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plans: %w", err)
+	}
+	campaigns, err := s.GetActiveCampaigns(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load campaigns: %w", err)
+	}
+
+	prices := make([]PlanPrice, 0, len(plans))
+	for i := range plans {
+		prices = append(prices, resolvePlanPrice(product, &plans[i], campaigns))
+	}
+	return prices, nil
+}
+
+// resolvePlanPrice применяет к цене плана лучшую подходящую акцию
+func resolvePlanPrice(product *models.Product, plan *models.ProductPlan, campaigns []models.Campaign) PlanPrice {
+	base := plan.CalculatePrice(product.BasePrice)
+	result := PlanPrice{Plan: plan, BasePrice: base, Price: base}
+	if plan.Price == nil {
+		result.PeriodDiscount = plan.DiscountPercent
+	}
+
+	for i := range campaigns {
+		c := &campaigns[i]
+		if !c.Covers(plan) {
+			continue
+		}
+		if result.Campaign == nil || c.DiscountPercent > result.Campaign.DiscountPercent {
+			result.Campaign = c
+			result.Price = c.Apply(base)
+		}
+	}
+	return result
+}
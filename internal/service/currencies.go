@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"vpn-telegram-bot/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrCurrencyInvalid некорректный код валюты или курс
+	ErrCurrencyInvalid = errors.New("invalid currency")
+	// ErrCurrencyUnknown для валюты не задан курс
+	ErrCurrencyUnknown = errors.New("unknown currency")
+)
+
+// currencyCodeRe код валюты: USD, EUR, USDT
+var currencyCodeRe = regexp.MustCompile(`^[A-Z]{3,5}$`)
+
+// GetCurrencies возвращает валюты, доступные для выбора: базовая и с заданным курсом
+func (s *Service) GetCurrencies(ctx context.Context) ([]models.Currency, error) {
+	return s.db.GetCurrencies(ctx)
+}
+
+// GetCurrency возвращает валюту по коду. Если курс не задан (или БД недоступна), суммы
+// показываются в базовой валюте — неверный пересчёт хуже, чем рубли
+func (s *Service) GetCurrency(ctx context.Context, code string) *models.Currency {
+	if code == "" || code == models.BaseCurrency {
+		return models.RUB
+	}
+	cur, err := s.db.GetCurrency(ctx, code)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Failed to load currency %s: %v", code, err)
+		}
+		return models.RUB
+	}
+	return cur
+}
+
+// UserCurrency валюта, в которой пользователю показываются суммы
+func (s *Service) UserCurrency(ctx context.Context, user *models.User) *models.Currency {
+	if user == nil {
+		return models.RUB
+	}
+	return s.GetCurrency(ctx, user.Currency)
+}
+
+// SetUserCurrency меняет валюту отображения сумм пользователя (только валюты с заданным курсом)
+func (s *Service) SetUserCurrency(ctx context.Context, userID int64, code string) (*models.Currency, error) {
+	cur := models.RUB
+	if code != models.BaseCurrency {
+		var err error
+		cur, err = s.db.GetCurrency(ctx, code)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", ErrCurrencyUnknown, code)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.db.SetUserCurrency(ctx, userID, cur.Code); err != nil {
+		return nil, fmt.Errorf("failed to set currency: %w", err)
+	}
+	return cur, nil
+}
+
+// SetExchangeRate задаёт курс валюты (сколько рублей стоит её единица); валюта появляется в выборе у пользователей
+func (s *Service) SetExchangeRate(ctx context.Context, adminTelegramID int64, code string, rate float64) (*models.Currency, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !currencyCodeRe.MatchString(code) {
+		return nil, fmt.Errorf("%w: bad code %q", ErrCurrencyInvalid, code)
+	}
+	if code == models.BaseCurrency {
+		return nil, fmt.Errorf("%w: rate of the base currency is fixed", ErrCurrencyInvalid)
+	}
+	if rate <= 0 || rate > 1e9 {
+		return nil, fmt.Errorf("%w: bad rate %v", ErrCurrencyInvalid, rate)
+	}
+
+	if err := s.db.SetCurrencyRate(ctx, code, rate, adminTelegramID); err != nil {
+		return nil, fmt.Errorf("failed to set rate: %w", err)
+	}
+	log.Printf("💱 Exchange rate %s set by %d: %.6f RUB", code, adminTelegramID, rate)
+	return s.db.GetCurrency(ctx, code)
+}
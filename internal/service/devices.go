@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrDevicesInvalid некорректный лимит устройств или цена устройства
+	ErrDevicesInvalid = errors.New("invalid device settings")
+	// ErrDeviceSlotsUnavailable докупить устройства нельзя: подписка без лимита, истекла,
+	// чужая, устройства не продаются или докуплен максимум
+	ErrDeviceSlotsUnavailable = errors.New("device slots unavailable")
+)
+
+// Ограничения лимита устройств
+const (
+	maxDeviceLimit  = 100
+	MaxExtraDevices = 10 // докупленных устройств на подписку за период
+)
+
+// UpdateProductDevices задаёт лимит устройств продукта (0 = без ограничения) и цену доп. устройства за месяц
+// (nil — не продаются). Действует на новые покупки и продления
+func (s *Service) UpdateProductDevices(ctx context.Context, productID int64, limit int, slotPrice *float64) error {
+	if limit < 0 || limit > maxDeviceLimit {
+		return fmt.Errorf("%w: limit %d", ErrDevicesInvalid, limit)
+	}
+	if slotPrice != nil && *slotPrice <= 0 {
+		return fmt.Errorf("%w: slot price %.2f", ErrDevicesInvalid, *slotPrice)
+	}
+	if err := s.db.UpdateProductDevices(ctx, productID, limit, slotPrice); err != nil {
+		return fmt.Errorf("failed to update product %d devices: %w", productID, err)
+	}
+	log.Printf("📱 Product %d device limit: %d", productID, limit)
+	return nil
+}
+
+// GetSubscriptionConnections возвращает подключения подписки по данным VPN панели
+// (ErrVPNNotSupported — панель их не сообщает)
+func (s *Service) GetSubscriptionConnections(ctx context.Context, sub *models.Subscription) ([]VPNConnection, error) {
+	if sub.VPNUsername == "" {
+		return nil, fmt.Errorf("subscription %d has no vpn_username", sub.ID)
+	}
+	return s.nodes.Provider(sub.NodeID).GetConnections(ctx, sub.VPNUsername)
+}
+
+// DeviceSlotPrice цена slots доп. устройств до конца оплаченного срока подписки:
+// месячная цена устройства пропорционально оставшимся дням, округлённая вверх до рубля
+func (s *Service) DeviceSlotPrice(ctx context.Context, sub *models.Subscription, slots int) (float64, error) {
+	product, err := s.db.GetProductByID(ctx, sub.ProductID)
+	if err != nil {
+		return 0, fmt.Errorf("product not found: %w", err)
+	}
+	if product.DeviceSlotPrice == nil || sub.DeviceLimit == 0 || slots <= 0 {
+		return 0, fmt.Errorf("%w: subscription %d", ErrDeviceSlotsUnavailable, sub.ID)
+	}
+
+	days := math.Ceil(time.Until(sub.ExpiresAt).Hours() / 24)
+	if days < 1 {
+		days = 1
+	}
+	return math.Ceil(*product.DeviceSlotPrice * float64(slots) * days / models.DaysPerMonth), nil
+}
+
+// BuyDeviceSlots докупает устройства к подписке с баланса и сразу поднимает лимит в VPN панели.
+// Докупленные устройства действуют до продления подписки
+func (s *Service) BuyDeviceSlots(ctx context.Context, userID, subID int64, slots int) (*models.Subscription, float64, error) {
+	sub, err := s.db.GetSubscriptionByID(ctx, subID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && sub.UserID != userID) {
+		return nil, 0, fmt.Errorf("%w: subscription %d", ErrDeviceSlotsUnavailable, subID)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	price, err := s.DeviceSlotPrice(ctx, sub, slots)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if user.Balance < price {
+		return nil, price, fmt.Errorf("%w: have %.2f, need %.2f", ErrInsufficientBalance, user.Balance, price)
+	}
+
+	var updated *models.Subscription
+	ok, err := s.db.PurchaseDeviceSlots(ctx, userID, subID, slots, MaxExtraDevices, price, func(ctx context.Context, u *models.Subscription) error {
+		updated = u
+		return s.applyVPNLimits(ctx, u)
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to buy device slots: %w", err)
+	}
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: subscription %d", ErrDeviceSlotsUnavailable, subID)
+	}
+
+	log.Printf("📱 User %d bought %d device slot(s) for subscription %d (%.2f)", userID, slots, subID, price)
+	updated.Product = sub.Product
+	return updated, price, nil
+}
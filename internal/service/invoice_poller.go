@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// InvoicePollerConfig конфигурация опроса неоплаченных счетов
+type InvoicePollerConfig struct {
+	CheckInterval time.Duration
+	MaxAge        time.Duration // счета старше не опрашиваются и не активируются повторно
+}
+
+// DefaultInvoicePollerConfig возвращает конфигурацию по умолчанию
+func DefaultInvoicePollerConfig() InvoicePollerConfig {
+	return InvoicePollerConfig{
+		CheckInterval: time.Minute,
+		MaxAge:        48 * time.Hour,
+	}
+}
+
+// InvoicePoller периодически проверяет статус неоплаченных счетов (если вебхук не дошёл)
+// и повторяет активацию оплаченных счетов на подписку, по которым подписку создать не удалось
+type InvoicePoller struct {
+	svc    *Service
+	config InvoicePollerConfig
+
+	mu        sync.Mutex
+	isRunning bool
+	stopChan  chan struct{}
+}
+
+// NewInvoicePoller создаёт новый InvoicePoller
+func NewInvoicePoller(svc *Service, config InvoicePollerConfig) *InvoicePoller {
+	return &InvoicePoller{
+		svc:      svc,
+		config:   config,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start запускает опрос
+func (p *InvoicePoller) Start() {
+	p.mu.Lock()
+	if p.isRunning {
+		p.mu.Unlock()
+		return
+	}
+	p.isRunning = true
+	p.mu.Unlock()
+
+	log.Println("🧾 Invoice poller started")
+
+	go p.runLoop()
+}
+
+// Stop останавливает опрос
+func (p *InvoicePoller) Stop() {
+	p.mu.Lock()
+	if !p.isRunning {
+		p.mu.Unlock()
+		return
+	}
+	p.isRunning = false
+	p.mu.Unlock()
+
+	close(p.stopChan)
+	log.Println("🧾 Invoice poller stopped")
+}
+
+func (p *InvoicePoller) runLoop() {
+	ticker := time.NewTicker(p.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			p.svc.PollPendingInvoices(context.Background(), p.config.MaxAge)
+			p.svc.RetryInvoiceActivations(context.Background(), p.config.MaxAge)
+		}
+	}
+}
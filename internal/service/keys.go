@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrKeyRotationUnavailable перевыпустить ключ нельзя: подписка не найдена, чужая, истекла или без клиента в панели
+	ErrKeyRotationUnavailable = errors.New("key rotation unavailable")
+	// ErrKeyRotationCooldown пользователь уже перевыпускал ключ этой подписки недавно
+	ErrKeyRotationCooldown = errors.New("key rotation rate limited")
+)
+
+// KeyRotationCooldown как часто пользователь может сам перевыпускать ключ подписки (на админов не действует)
+const KeyRotationCooldown = 24 * time.Hour
+
+// RotateUserKey перевыпускает ключ подписки по запросу её владельца (не чаще раза в KeyRotationCooldown)
+func (s *Service) RotateUserKey(ctx context.Context, user *models.User, subID int64) (*models.Subscription, error) {
+	sub, err := s.db.GetSubscriptionByID(ctx, subID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && sub.UserID != user.ID) {
+		return nil, fmt.Errorf("%w: subscription %d", ErrKeyRotationUnavailable, subID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	recent, err := s.db.CountAuditEntries(ctx, sub.ID, models.AuditKeyRotate, models.AuditActorUser, time.Now().Add(-KeyRotationCooldown))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check key rotations: %w", err)
+	}
+	if recent > 0 {
+		return nil, fmt.Errorf("%w: subscription %d", ErrKeyRotationCooldown, subID)
+	}
+
+	return s.rotateKey(ctx, sub, user.TelegramID, models.AuditActorUser)
+}
+
+// AdminRotateKey перевыпускает ключ подписки по запросу админа (без ограничения частоты)
+func (s *Service) AdminRotateKey(ctx context.Context, adminTelegramID, subID int64) (*models.Subscription, error) {
+	sub, err := s.db.GetSubscriptionByID(ctx, subID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: subscription %d", ErrKeyRotationUnavailable, subID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.rotateKey(ctx, sub, adminTelegramID, models.AuditActorAdmin)
+}
+
+// rotateKey создаёт нового клиента в панели с тем же сроком и ограничениями, переносит на него подписку
+// и удаляет старого клиента. Ссылка подписки тоже меняется: утёкшая ссылка перестаёт работать.
+// Израсходованный трафик старого клиента не переносится
+func (s *Service) rotateKey(ctx context.Context, sub *models.Subscription, actorID int64, actorRole string) (*models.Subscription, error) {
+	if !sub.IsActive || !sub.ExpiresAt.After(time.Now()) || sub.VPNUsername == "" {
+		return nil, fmt.Errorf("%w: subscription %d is not active", ErrKeyRotationUnavailable, sub.ID)
+	}
+	user, err := s.db.GetUserByID(ctx, sub.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+
+	vpn := s.nodes.Provider(sub.NodeID)
+	vpnUsername := fmt.Sprintf("tg_%d_%d", user.TelegramID, time.Now().UnixMilli())
+	keyString, err := vpn.CreateUser(ctx, vpnUsername, sub.Product.MarzbanTag, sub.ExpiresAt, vpnLimits(sub))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VPN user: %w", err)
+	}
+
+	audit := &models.AuditEntry{
+		ActorID:        actorID,
+		ActorRole:      actorRole,
+		Action:         models.AuditKeyRotate,
+		UserID:         &sub.UserID,
+		SubscriptionID: &sub.ID,
+		Details:        fmt.Sprintf("%s -> %s", sub.VPNUsername, vpnUsername),
+	}
+	updated, err := s.db.RotateSubscriptionKey(ctx, sub.ID, sub.VPNUsername, vpnUsername, keyString, audit, func(ctx context.Context) error {
+		return vpn.DeleteUser(ctx, sub.VPNUsername)
+	})
+	if err != nil {
+		// Подписка осталась на старом клиенте — новый не нужен
+		if delErr := vpn.DeleteUser(ctx, vpnUsername); delErr != nil {
+			log.Printf("⚠️ Failed to delete unused VPN user %s: %v", vpnUsername, delErr)
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: subscription %d was changed concurrently", ErrKeyRotationUnavailable, sub.ID)
+		}
+		return nil, fmt.Errorf("failed to rotate key: %w", err)
+	}
+
+	log.Printf("🔄 Key of subscription %d rotated by %s %d: %s -> %s", sub.ID, actorRole, actorID, sub.VPNUsername, vpnUsername)
+	updated.Product = sub.Product
+	return updated, nil
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+)
+
+// ErrLanguageUnknown для языка нет каталога сообщений
+var ErrLanguageUnknown = errors.New("unknown language")
+
+// UserLanguage язык интерфейса пользователя: выбранный в настройках или определённый по language_code Telegram
+func (s *Service) UserLanguage(user *models.User) string {
+	if user == nil {
+		return i18n.Default
+	}
+	if user.Language != "" && i18n.IsSupported(user.Language) {
+		return user.Language
+	}
+	return i18n.Detect(user.LanguageCode)
+}
+
+// userLanguageByID язык интерфейса пользователя по ID (по умолчанию, если пользователя не удалось загрузить)
+func (s *Service) userLanguageByID(ctx context.Context, userID int64) string {
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return i18n.Default
+	}
+	return s.UserLanguage(user)
+}
+
+// RememberLanguageCode сохраняет language_code клиента Telegram, если он изменился:
+// по нему выбирается вариант рассылки, когда пользователь не задал язык сам
+func (s *Service) RememberLanguageCode(ctx context.Context, user *models.User, code string) {
+	if code == "" || code == user.LanguageCode {
+		return
+	}
+	if err := s.db.SetUserLanguageCode(ctx, user.ID, code); err != nil {
+		log.Printf("Failed to save language code of user %d: %v", user.ID, err)
+		return
+	}
+	user.LanguageCode = code
+}
+
+// SetUserLanguage задаёт язык интерфейса пользователя; пустой lang — снова определять автоматически
+func (s *Service) SetUserLanguage(ctx context.Context, userID int64, lang string) error {
+	if lang != "" && !i18n.IsSupported(lang) {
+		return fmt.Errorf("%w: %s", ErrLanguageUnknown, lang)
+	}
+	if err := s.db.SetUserLanguage(ctx, userID, lang); err != nil {
+		return fmt.Errorf("failed to set language: %w", err)
+	}
+	return nil
+}
+
+// PlanPeriodTitle короткая запись срока плана на языке lang: "3 мес.", "1 мес. 15 дн."
+func PlanPeriodTitle(lang string, plan *models.ProductPlan) string {
+	var parts []string
+	if plan.Months > 0 {
+		parts = append(parts, i18n.T(lang, "period.months_short", plan.Months))
+	}
+	if plan.Days > 0 {
+		parts = append(parts, i18n.T(lang, "period.days_short", plan.Days))
+	}
+	return strings.Join(parts, " ")
+}
+
+// GetBroadcastRecipients возвращает получателей рассылки с языком и валютой (заполнены только ID, язык и валюта)
+func (s *Service) GetBroadcastRecipients(ctx context.Context) ([]models.User, error) {
+	return s.db.GetBroadcastRecipients(ctx)
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"vpn-telegram-bot/internal/models"
+)
+
+// LedgerReport результат сверки балансов с главной книгой
+type LedgerReport struct {
+	Drift              []models.LedgerDrift // пользователи, у которых users.balance ≠ сумме книги
+	UnbalancedPostings int                  // проводки, записи которых в сумме не дают 0
+}
+
+// OK расхождений нет
+func (r *LedgerReport) OK() bool {
+	return len(r.Drift) == 0 && r.UnbalancedPostings == 0
+}
+
+// ReconcileLedger сверяет users.balance с суммой записей книги по каждому пользователю
+// и проверяет, что все проводки сбалансированы. Ничего не исправляет — только отчёт
+func (s *Service) ReconcileLedger(ctx context.Context) (*LedgerReport, error) {
+	drift, err := s.db.GetLedgerDrift(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare balances with ledger: %w", err)
+	}
+	unbalanced, err := s.db.CountUnbalancedPostings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check ledger postings: %w", err)
+	}
+	return &LedgerReport{Drift: drift, UnbalancedPostings: unbalanced}, nil
+}
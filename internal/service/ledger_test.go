@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+)
+
+// flakyVPN мок-панель, которая по флагу fail отказывает в создании и продлении клиентов
+type flakyVPN struct {
+	*MockVPNProvider
+	fail    atomic.Bool
+	deleted atomic.Int32 // удалённые клиенты (уборка после отменённых заказов)
+	resets  atomic.Int32 // сбросы трафика
+}
+
+var errPanelDown = errors.New("panel is down")
+
+func newFlakyVPN() *flakyVPN {
+	return &flakyVPN{MockVPNProvider: NewMockVPNProvider()}
+}
+
+func (f *flakyVPN) CreateUser(ctx context.Context, username, tag string, expiresAt time.Time, limits UserLimits) (string, error) {
+	if f.fail.Load() {
+		return "", errPanelDown
+	}
+	return f.MockVPNProvider.CreateUser(ctx, username, tag, expiresAt, limits)
+}
+
+func (f *flakyVPN) ExtendUser(ctx context.Context, username string, expiresAt time.Time, limits UserLimits) error {
+	if f.fail.Load() {
+		return errPanelDown
+	}
+	return f.MockVPNProvider.ExtendUser(ctx, username, expiresAt, limits)
+}
+
+func (f *flakyVPN) ResetTraffic(ctx context.Context, username string) error {
+	f.resets.Add(1)
+	return f.MockVPNProvider.ResetTraffic(ctx, username)
+}
+
+func (f *flakyVPN) DeleteUser(ctx context.Context, username string) error {
+	f.deleted.Add(1)
+	return f.MockVPNProvider.DeleteUser(ctx, username)
+}
+
+func TestLedgerBalancedAcrossOperations(t *testing.T) {
+	vpn := newFlakyVPN()
+	svc := newTestService(t, vpn)
+	plan := newTestPlan(t, svc, 300)
+	ctx := context.Background()
+
+	user := newTestUser(t, svc, 2001, 500)
+	assertLedgerBalanced(t, svc)
+
+	// Покупка
+	res, err := svc.Purchase(ctx, user.ID, PlanRef{PlanID: plan.ID}, "ledger:1")
+	if err != nil {
+		t.Fatalf("Purchase: %v", err)
+	}
+	if got := userBalance(t, svc, user.ID); got != 200 {
+		t.Fatalf("balance after purchase = %.2f, want 200", got)
+	}
+	assertLedgerBalanced(t, svc)
+
+	// Панель недоступна: заказ отменяется с возвратом
+	vpn.fail.Store(true)
+	if _, err := svc.Purchase(ctx, user.ID, PlanRef{PlanID: plan.ID, SubscriptionID: res.Subscription.ID}, "ledger:2"); err == nil {
+		t.Fatal("extension with the panel down succeeded")
+	}
+	vpn.fail.Store(false)
+	if got := userBalance(t, svc, user.ID); got != 200 {
+		t.Fatalf("balance after failed extension = %.2f, want 200", got)
+	}
+	assertLedgerBalanced(t, svc)
+
+	// Не хватает денег: ничего не списывается
+	if _, err := svc.Purchase(ctx, user.ID, PlanRef{PlanID: plan.ID}, "ledger:3"); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("err = %v, want ErrInsufficientBalance", err)
+	}
+	assertLedgerBalanced(t, svc)
+
+	// Отмена админом с возвратом на баланс
+	quote, err := svc.AdminCancelSubscription(ctx, 1, res.Subscription.ID, models.RefundToBalance)
+	if err != nil {
+		t.Fatalf("AdminCancelSubscription: %v", err)
+	}
+	if quote.Amount <= 0 || quote.Amount > 300 {
+		t.Fatalf("refund amount = %.2f, want (0, 300]", quote.Amount)
+	}
+	if got := userBalance(t, svc, user.ID); got != 200+quote.Amount {
+		t.Fatalf("balance after refund = %.2f, want %.2f", got, 200+quote.Amount)
+	}
+	assertLedgerBalanced(t, svc)
+}
+
+func TestReconcileLedgerReportsDrift(t *testing.T) {
+	svc := newTestService(t, nil)
+	ctx := context.Background()
+	user := newTestUser(t, svc, 2002, 100)
+
+	// Баланс изменён мимо книги
+	if _, err := svc.db.Pool.Exec(ctx, `UPDATE users SET balance = balance + 1 WHERE id = $1`, user.ID); err != nil {
+		t.Fatalf("tamper balance: %v", err)
+	}
+
+	report, err := svc.ReconcileLedger(ctx)
+	if err != nil {
+		t.Fatalf("ReconcileLedger: %v", err)
+	}
+	if report.OK() || len(report.Drift) != 1 || report.Drift[0].UserID != user.ID {
+		t.Fatalf("drift = %+v, want user %d", report.Drift, user.ID)
+	}
+	if d := report.Drift[0]; d.Balance-d.Ledger != 100 {
+		t.Errorf("drift = %d kopecks, want 100", d.Balance-d.Ledger)
+	}
+}
@@ -0,0 +1,416 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"vpn-telegram-bot/internal/config"
+)
+
+// ErrVPNUserNotFound пользователь не найден в VPN панели
+var ErrVPNUserNotFound = errors.New("vpn user not found")
+
+// MarzbanError ошибка ответа Marzban API
+type MarzbanError struct {
+	StatusCode int
+	Detail     string
+}
+
+func (e *MarzbanError) Error() string {
+	return fmt.Sprintf("marzban: status %d: %s", e.StatusCode, e.Detail)
+}
+
+// MarzbanProvider реализация VPNProvider для Marzban
+type MarzbanProvider struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+// NewMarzbanProvider создаёт новый Marzban провайдер
+func NewMarzbanProvider(cfg config.MarzbanConfig) *MarzbanProvider {
+	return NewMarzbanProviderWithClient(cfg, &http.Client{Timeout: 15 * time.Second})
+}
+
+// NewMarzbanProviderWithClient создаёт Marzban провайдер с указанным HTTP клиентом
+// (например, для работы с httptest сервером)
+func NewMarzbanProviderWithClient(cfg config.MarzbanConfig, client *http.Client) *MarzbanProvider {
+	return &MarzbanProvider{
+		baseURL:  strings.TrimRight(cfg.BaseURL, "/"),
+		username: cfg.Username,
+		password: cfg.Password,
+		client:   client,
+	}
+}
+
+// marzbanUser пользователь в ответах Marzban API
+type marzbanUser struct {
+	Username        string   `json:"username"`
+	Status          string   `json:"status"`
+	UsedTraffic     int64    `json:"used_traffic"`
+	DataLimit       *int64   `json:"data_limit"`
+	Expire          *int64   `json:"expire"`
+	Links           []string `json:"links"`
+	SubscriptionURL string   `json:"subscription_url"`
+}
+
+// marzbanUserCreate тело запроса POST /api/user
+type marzbanUserCreate struct {
+	Username               string                    `json:"username"`
+	Proxies                map[string]map[string]any `json:"proxies"`
+	Inbounds               map[string][]string       `json:"inbounds,omitempty"`
+	Expire                 int64                     `json:"expire"`
+	DataLimit              int64                     `json:"data_limit"`
+	DataLimitResetStrategy string                    `json:"data_limit_reset_strategy"`
+	Status                 string                    `json:"status"`
+}
+
+// marzbanUserModify тело запроса PUT /api/user/{username}
+type marzbanUserModify struct {
+	Expire    int64  `json:"expire,omitempty"`
+	DataLimit *int64 `json:"data_limit,omitempty"` // nil = не менять, 0 = безлимит
+	Status    string `json:"status"`
+}
+
+// marzbanUsersResponse ответ GET /api/users
+type marzbanUsersResponse struct {
+	Users []marzbanUser `json:"users"`
+	Total int           `json:"total"`
+}
+
+// marzbanSystemStats ответ GET /api/system
+type marzbanSystemStats struct {
+	MemTotal               int64   `json:"mem_total"`
+	MemUsed                int64   `json:"mem_used"`
+	CPUUsage               float64 `json:"cpu_usage"`
+	TotalUser              int     `json:"total_user"`
+	UsersActive            int     `json:"users_active"`
+	IncomingBandwidthSpeed int64   `json:"incoming_bandwidth_speed"`
+	OutgoingBandwidthSpeed int64   `json:"outgoing_bandwidth_speed"`
+}
+
+// CreateUser создаёт пользователя в Marzban и возвращает ключ подключения.
+// Лимит устройств Marzban не поддерживает и не применяется.
+func (m *MarzbanProvider) CreateUser(ctx context.Context, username string, tag string, expiresAt time.Time, limits UserLimits) (string, error) {
+	// Ежемесячный сброс квоты выполняет планировщик бота, чтобы вместе с ним сгорал докупленный трафик
+	req := marzbanUserCreate{
+		Username:               username,
+		Proxies:                map[string]map[string]any{"vless": {}},
+		Expire:                 expiresAt.Unix(),
+		DataLimit:              limits.DataLimit,
+		DataLimitResetStrategy: "no_reset",
+		Status:                 "active",
+	}
+	if tag != "" {
+		req.Inbounds = map[string][]string{"vless": {tag}}
+	}
+
+	var user marzbanUser
+	if err := m.do(ctx, http.MethodPost, "/api/user", req, &user); err != nil {
+		return "", fmt.Errorf("create user %s: %w", username, err)
+	}
+
+	return m.pickKey(&user), nil
+}
+
+// GetSubscription получает информацию о подписке
+func (m *MarzbanProvider) GetSubscription(ctx context.Context, username string) (*VPNSubscription, error) {
+	var user marzbanUser
+	if err := m.do(ctx, http.MethodGet, "/api/user/"+url.PathEscape(username), nil, &user); err != nil {
+		return nil, fmt.Errorf("get user %s: %w", username, err)
+	}
+
+	sub := &VPNSubscription{
+		Username:  user.Username,
+		KeyString: m.pickKey(&user),
+		Links:     user.Links,
+		IsActive:  user.Status == "active",
+		DataUsed:  user.UsedTraffic,
+	}
+	if user.Expire != nil && *user.Expire > 0 {
+		sub.ExpiresAt = time.Unix(*user.Expire, 0)
+	}
+	if user.DataLimit != nil {
+		sub.DataLimit = *user.DataLimit
+	}
+
+	return sub, nil
+}
+
+// ExtendUser продлевает подписку пользователя и обновляет квоту трафика
+func (m *MarzbanProvider) ExtendUser(ctx context.Context, username string, newExpiresAt time.Time, limits UserLimits) error {
+	req := marzbanUserModify{
+		Expire:    newExpiresAt.Unix(),
+		DataLimit: &limits.DataLimit,
+		Status:    "active",
+	}
+	if err := m.do(ctx, http.MethodPut, "/api/user/"+url.PathEscape(username), req, nil); err != nil {
+		return fmt.Errorf("extend user %s: %w", username, err)
+	}
+	return nil
+}
+
+// ResetTraffic обнуляет израсходованный трафик пользователя
+func (m *MarzbanProvider) ResetTraffic(ctx context.Context, username string) error {
+	if err := m.do(ctx, http.MethodPost, "/api/user/"+url.PathEscape(username)+"/reset", nil, nil); err != nil {
+		return fmt.Errorf("reset traffic %s: %w", username, err)
+	}
+	return nil
+}
+
+// GetConnections не поддерживается: Marzban не отдаёт IP подключений через API
+func (m *MarzbanProvider) GetConnections(ctx context.Context, username string) ([]VPNConnection, error) {
+	return nil, ErrVPNNotSupported
+}
+
+// DisableUser отключает пользователя (ключ перестаёт работать, но не удаляется)
+func (m *MarzbanProvider) DisableUser(ctx context.Context, username string) error {
+	req := marzbanUserModify{Status: "disabled"}
+	if err := m.do(ctx, http.MethodPut, "/api/user/"+url.PathEscape(username), req, nil); err != nil {
+		return fmt.Errorf("disable user %s: %w", username, err)
+	}
+	return nil
+}
+
+// DeleteUser удаляет пользователя
+func (m *MarzbanProvider) DeleteUser(ctx context.Context, username string) error {
+	if err := m.do(ctx, http.MethodDelete, "/api/user/"+url.PathEscape(username), nil, nil); err != nil {
+		return fmt.Errorf("delete user %s: %w", username, err)
+	}
+	return nil
+}
+
+// GetAllUsers получает список всех пользователей (постранично)
+func (m *MarzbanProvider) GetAllUsers(ctx context.Context) ([]VPNUser, error) {
+	const pageSize = 500
+
+	var users []VPNUser
+	for offset := 0; ; offset += pageSize {
+		path := fmt.Sprintf("/api/users?offset=%d&limit=%d", offset, pageSize)
+
+		var resp marzbanUsersResponse
+		if err := m.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+			return nil, fmt.Errorf("list users: %w", err)
+		}
+
+		for _, u := range resp.Users {
+			vu := VPNUser{
+				Username:    u.Username,
+				UsedTraffic: u.UsedTraffic,
+				IsActive:    u.Status == "active",
+			}
+			if u.DataLimit != nil {
+				vu.DataLimit = *u.DataLimit
+			}
+			if u.Expire != nil && *u.Expire > 0 {
+				vu.ExpiresAt = time.Unix(*u.Expire, 0)
+			}
+			users = append(users, vu)
+		}
+
+		if len(resp.Users) < pageSize || len(users) >= resp.Total {
+			break
+		}
+	}
+
+	return users, nil
+}
+
+// GetSystemStats получает системную статистику
+func (m *MarzbanProvider) GetSystemStats(ctx context.Context) (*SystemStats, error) {
+	var sys marzbanSystemStats
+	if err := m.do(ctx, http.MethodGet, "/api/system", nil, &sys); err != nil {
+		return nil, fmt.Errorf("system stats: %w", err)
+	}
+
+	stats := &SystemStats{
+		CPUPercent:    sys.CPUUsage,
+		NetworkRxMbps: bytesPerSecToMbps(sys.IncomingBandwidthSpeed),
+		NetworkTxMbps: bytesPerSecToMbps(sys.OutgoingBandwidthSpeed),
+		TotalUsers:    sys.TotalUser,
+		ActiveUsers:   sys.UsersActive,
+	}
+	if sys.MemTotal > 0 {
+		stats.MemoryPercent = float64(sys.MemUsed) / float64(sys.MemTotal) * 100
+	}
+
+	return stats, nil
+}
+
+// pickKey выбирает ключ подключения из ответа Marzban (vless:// в приоритете)
+func (m *MarzbanProvider) pickKey(user *marzbanUser) string {
+	for _, link := range user.Links {
+		if strings.HasPrefix(link, "vless://") {
+			return link
+		}
+	}
+	if len(user.Links) > 0 {
+		return user.Links[0]
+	}
+	if strings.HasPrefix(user.SubscriptionURL, "/") {
+		return m.baseURL + user.SubscriptionURL
+	}
+	return user.SubscriptionURL
+}
+
+// login получает access token администратора
+func (m *MarzbanProvider) login(ctx context.Context) (string, error) {
+	form := url.Values{}
+	form.Set("username", m.username)
+	form.Set("password", m.password)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/api/admin/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("marzban login: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("marzban login: %w", readMarzbanError(resp))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("marzban login: decode token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("marzban login: empty access token")
+	}
+
+	return token.AccessToken, nil
+}
+
+// getToken возвращает закэшированный токен или логинится заново
+func (m *MarzbanProvider) getToken(ctx context.Context, refresh bool) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" && !refresh {
+		return m.token, nil
+	}
+
+	token, err := m.login(ctx)
+	if err != nil {
+		m.token = ""
+		return "", err
+	}
+	m.token = token
+	return token, nil
+}
+
+// do выполняет запрос к API с авторизацией; при 401 перелогинивается и повторяет запрос один раз
+func (m *MarzbanProvider) do(ctx context.Context, method, path string, body any, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		token, err := m.getToken(ctx, attempt > 0)
+		if err != nil {
+			return err
+		}
+
+		var reader io.Reader
+		if payload != nil {
+			reader = bytes.NewReader(payload)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, m.baseURL+path, reader)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/json")
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := m.client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			// Токен истёк — логинимся заново и повторяем
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			continue
+		}
+
+		err = decodeMarzbanResponse(resp, out)
+		resp.Body.Close()
+		return err
+	}
+
+	return &MarzbanError{StatusCode: http.StatusUnauthorized, Detail: "unauthorized after re-login"}
+}
+
+// decodeMarzbanResponse разбирает ответ API
+func decodeMarzbanResponse(resp *http.Response, out any) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", ErrVPNUserNotFound, readMarzbanError(resp))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return readMarzbanError(resp)
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// readMarzbanError извлекает detail из тела ошибки
+func readMarzbanError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	var body struct {
+		Detail json.RawMessage `json:"detail"`
+	}
+	detail := strings.TrimSpace(string(data))
+	if json.Unmarshal(data, &body) == nil && len(body.Detail) > 0 {
+		var s string
+		if json.Unmarshal(body.Detail, &s) == nil {
+			detail = s
+		} else {
+			detail = string(body.Detail)
+		}
+	}
+
+	return &MarzbanError{StatusCode: resp.StatusCode, Detail: detail}
+}
+
+// bytesPerSecToMbps переводит байты/сек в мегабиты/сек
+func bytesPerSecToMbps(v int64) float64 {
+	return float64(v) * 8 / 1_000_000
+}
@@ -0,0 +1,240 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"vpn-telegram-bot/internal/config"
+)
+
+// fakeMarzban минимальная замена Marzban API: выдаёт токены, хранит пользователей и считает запросы
+type fakeMarzban struct {
+	t *testing.T
+
+	mu     sync.Mutex
+	logins int
+	token  string // действующий токен; пустой — все токены отозваны
+	users  []marzbanUser
+	pages  []string // offset&limit запросов /api/users
+}
+
+func newFakeMarzban(t *testing.T) (*fakeMarzban, *MarzbanProvider) {
+	t.Helper()
+
+	f := &fakeMarzban{t: t}
+	srv := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(srv.Close)
+
+	m := NewMarzbanProviderWithClient(config.MarzbanConfig{
+		BaseURL:  srv.URL + "/",
+		Username: "admin",
+		Password: "secret",
+	}, srv.Client())
+	return f, m
+}
+
+// expireToken отзывает выданный токен, как это делает Marzban по истечении срока
+func (f *fakeMarzban) expireToken() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.token = ""
+}
+
+func (f *fakeMarzban) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/api/admin/token" {
+		if r.Method != http.MethodPost || r.FormValue("username") != "admin" || r.FormValue("password") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"detail":"Incorrect username or password"}`)
+			return
+		}
+		f.logins++
+		f.token = "token-" + strconv.Itoa(f.logins)
+		fmt.Fprintf(w, `{"access_token":%q,"token_type":"bearer"}`, f.token)
+		return
+	}
+
+	if f.token == "" || r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"detail":"Could not validate credentials"}`)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/users":
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		f.pages = append(f.pages, fmt.Sprintf("%d&%d", offset, limit))
+
+		end := min(offset+limit, len(f.users))
+		page := []marzbanUser{}
+		if offset < end {
+			page = f.users[offset:end]
+		}
+		json.NewEncoder(w).Encode(marzbanUsersResponse{Users: page, Total: len(f.users)})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/user/"):
+		name := strings.TrimPrefix(r.URL.Path, "/api/user/")
+		for _, u := range f.users {
+			if u.Username == name {
+				json.NewEncoder(w).Encode(u)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"detail":"User not found"}`)
+
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestMarzbanReloginOnUnauthorized(t *testing.T) {
+	f, m := newFakeMarzban(t)
+	expire := time.Now().Add(24 * time.Hour).Unix()
+	f.users = []marzbanUser{{
+		Username: "tg_1",
+		Status:   "active",
+		Expire:   &expire,
+		Links:    []string{"vmess://other", "vless://key"},
+	}}
+	ctx := context.Background()
+
+	sub, err := m.GetSubscription(ctx, "tg_1")
+	if err != nil {
+		t.Fatalf("GetSubscription: %v", err)
+	}
+	if sub.KeyString != "vless://key" || !sub.IsActive || sub.ExpiresAt.Unix() != expire {
+		t.Errorf("unexpected subscription %+v", sub)
+	}
+
+	// Токен из кэша переиспользуется без повторного логина
+	if _, err := m.GetSubscription(ctx, "tg_1"); err != nil {
+		t.Fatalf("GetSubscription with cached token: %v", err)
+	}
+	if f.logins != 1 {
+		t.Fatalf("logins = %d, want 1", f.logins)
+	}
+
+	// Истёкший токен: 401, перелогин и повтор того же запроса
+	f.expireToken()
+	if _, err := m.GetSubscription(ctx, "tg_1"); err != nil {
+		t.Fatalf("GetSubscription after token expiry: %v", err)
+	}
+	if f.logins != 2 {
+		t.Errorf("logins = %d, want 2", f.logins)
+	}
+}
+
+func TestMarzbanLoginFailure(t *testing.T) {
+	_, m := newFakeMarzban(t)
+	m.password = "wrong"
+
+	_, err := m.GetSubscription(context.Background(), "tg_1")
+	var apiErr *MarzbanError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("err = %v, want MarzbanError 401", err)
+	}
+	if apiErr.Detail != "Incorrect username or password" {
+		t.Errorf("detail = %q", apiErr.Detail)
+	}
+}
+
+func TestMarzbanUserNotFound(t *testing.T) {
+	_, m := newFakeMarzban(t)
+
+	_, err := m.GetSubscription(context.Background(), "missing")
+	if !errors.Is(err, ErrVPNUserNotFound) {
+		t.Fatalf("err = %v, want ErrVPNUserNotFound", err)
+	}
+}
+
+func TestMarzbanGetAllUsersPaged(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int
+		pages []string
+	}{
+		{name: "empty", total: 0, pages: []string{"0&500"}},
+		{name: "single page", total: 499, pages: []string{"0&500"}},
+		{name: "exact pages", total: 1000, pages: []string{"0&500", "500&500"}},
+		{name: "partial last page", total: 1201, pages: []string{"0&500", "500&500", "1000&500"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, m := newFakeMarzban(t)
+			limit := int64(10 << 30)
+			for i := 0; i < tt.total; i++ {
+				f.users = append(f.users, marzbanUser{
+					Username:    fmt.Sprintf("tg_%d", i),
+					Status:      "active",
+					UsedTraffic: int64(i),
+					DataLimit:   &limit,
+				})
+			}
+
+			users, err := m.GetAllUsers(context.Background())
+			if err != nil {
+				t.Fatalf("GetAllUsers: %v", err)
+			}
+			if len(users) != tt.total {
+				t.Fatalf("got %d users, want %d", len(users), tt.total)
+			}
+			for i, u := range users {
+				if u.Username != fmt.Sprintf("tg_%d", i) || u.UsedTraffic != int64(i) || u.DataLimit != limit || !u.IsActive {
+					t.Fatalf("user %d = %+v", i, u)
+				}
+			}
+			if strings.Join(f.pages, ",") != strings.Join(tt.pages, ",") {
+				t.Errorf("pages = %v, want %v", f.pages, tt.pages)
+			}
+		})
+	}
+}
+
+func TestMarzbanGetAllUsersReloginBetweenPages(t *testing.T) {
+	f, m := newFakeMarzban(t)
+	for i := 0; i < 700; i++ {
+		f.users = append(f.users, marzbanUser{Username: fmt.Sprintf("tg_%d", i), Status: "active"})
+	}
+
+	// Токен истекает после первой страницы: вторая запрашивается повторно с новым токеном
+	m.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err == nil && r.URL.Query().Get("offset") == "0" {
+			f.expireToken()
+		}
+		return resp, err
+	})
+
+	users, err := m.GetAllUsers(context.Background())
+	if err != nil {
+		t.Fatalf("GetAllUsers: %v", err)
+	}
+	if len(users) != 700 {
+		t.Errorf("got %d users, want 700", len(users))
+	}
+	if f.logins != 2 {
+		t.Errorf("logins = %d, want 2", f.logins)
+	}
+}
+
+// roundTripFunc транспорт из функции
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"vpn-telegram-bot/internal/config"
+	"vpn-telegram-bot/internal/database"
+	"vpn-telegram-bot/internal/models"
+)
+
+// ErrNoAvailableNodes у продукта есть ноды, но все заполнены или недоступны
+var ErrNoAvailableNodes = errors.New("no available nodes")
+
+// nodeHealthTTL сколько кэшируется результат проверки ноды
+const nodeHealthTTL = time.Minute
+
+// nodeHealthTimeout таймаут проверки ноды
+const nodeHealthTimeout = 5 * time.Second
+
+// nodeHealth результат последней проверки ноды
+type nodeHealth struct {
+	ok        bool
+	err       error
+	checkedAt time.Time
+}
+
+// NodeStatus состояние ноды для админки
+type NodeStatus struct {
+	Node    models.Node
+	Healthy bool
+	Error   string
+}
+
+// DefaultPanelName имя панели из конфига в алертах Watchdog
+const DefaultPanelName = "default"
+
+// Panel панель VPN под наблюдением Watchdog
+type Panel struct {
+	Name string
+	VPN  VPNProvider
+}
+
+// NodeManager держит по одному VPNProvider на каждую ноду из БД.
+// Подписки без ноды (и продукты без привязанных нод) обслуживает fallback провайдер из конфига.
+type NodeManager struct {
+	db       *database.DB
+	fallback VPNProvider
+	mock     bool
+
+	mu        sync.RWMutex
+	nodes     []models.Node // ноды на момент последнего Reload
+	providers map[int64]VPNProvider
+	health    map[int64]nodeHealth
+}
+
+// NewNodeManager создаёт менеджер нод. В mock режиме для всех нод используется MockVPNProvider
+func NewNodeManager(db *database.DB, fallback VPNProvider, mock bool) *NodeManager {
+	return &NodeManager{
+		db:        db,
+		fallback:  fallback,
+		mock:      mock,
+		providers: make(map[int64]VPNProvider),
+		health:    make(map[int64]nodeHealth),
+	}
+}
+
+// Reload перечитывает ноды из БД и пересоздаёт провайдеры
+func (m *NodeManager) Reload(ctx context.Context) error {
+	nodes, err := m.db.GetNodes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load nodes: %w", err)
+	}
+
+	providers := make(map[int64]VPNProvider, len(nodes))
+	for _, n := range nodes {
+		providers[n.ID] = m.newProvider(n)
+	}
+
+	m.mu.Lock()
+	m.nodes = nodes
+	m.providers = providers
+	m.health = make(map[int64]nodeHealth)
+	m.mu.Unlock()
+
+	log.Printf("🌐 Loaded %d VPN nodes", len(nodes))
+	return nil
+}
+
+// Default возвращает провайдер панели из конфига
+func (m *NodeManager) Default() VPNProvider {
+	return m.fallback
+}
+
+// Panels возвращает панели для мониторинга: панель из конфига и включённые ноды
+func (m *NodeManager) Panels() []Panel {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	panels := []Panel{{Name: DefaultPanelName, VPN: m.fallback}}
+	for _, n := range m.nodes {
+		if p, ok := m.providers[n.ID]; ok && n.IsEnabled {
+			panels = append(panels, Panel{Name: n.Name, VPN: p})
+		}
+	}
+	return panels
+}
+
+// Provider возвращает провайдер ноды (nil или неизвестная нода = fallback)
+func (m *NodeManager) Provider(nodeID *int64) VPNProvider {
+	if nodeID == nil {
+		return m.fallback
+	}
+
+	m.mu.RLock()
+	p, ok := m.providers[*nodeID]
+	m.mu.RUnlock()
+
+	if !ok {
+		log.Printf("⚠️ Node %d is not loaded, using default VPN panel", *nodeID)
+		return m.fallback
+	}
+	return p
+}
+
+// PickNode выбирает наименее загруженную исправную ноду продукта.
+// Если к продукту не привязано ни одной ноды, возвращает nil и fallback провайдер.
+func (m *NodeManager) PickNode(ctx context.Context, productID int64) (*models.Node, VPNProvider, error) {
+	nodes, err := m.db.GetProductNodes(ctx, productID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load product nodes: %w", err)
+	}
+	if len(nodes) == 0 {
+		return nil, m.fallback, nil
+	}
+
+	var candidates []models.Node
+	for _, n := range nodes {
+		if n.IsFull() || !m.isHealthy(ctx, n.ID) {
+			continue
+		}
+		candidates = append(candidates, n)
+	}
+	if len(candidates) == 0 {
+		return nil, nil, fmt.Errorf("product %d: %w", productID, ErrNoAvailableNodes)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Load() != candidates[j].Load() {
+			return candidates[i].Load() < candidates[j].Load()
+		}
+		return candidates[i].ActiveSubscriptions < candidates[j].ActiveSubscriptions
+	})
+
+	node := candidates[0]
+	return &node, m.Provider(&node.ID), nil
+}
+
+// Status возвращает все ноды с их загрузкой и состоянием
+func (m *NodeManager) Status(ctx context.Context) ([]NodeStatus, error) {
+	nodes, err := m.db.GetNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]NodeStatus, 0, len(nodes))
+	for _, n := range nodes {
+		st := NodeStatus{Node: n}
+		if n.IsEnabled {
+			st.Healthy = m.isHealthy(ctx, n.ID)
+			if h, ok := m.cachedHealth(n.ID); ok && h.err != nil {
+				st.Error = h.err.Error()
+			}
+		}
+		result = append(result, st)
+	}
+	return result, nil
+}
+
+// isHealthy проверяет ноду через GetSystemStats (с кэшем)
+func (m *NodeManager) isHealthy(ctx context.Context, nodeID int64) bool {
+	if h, ok := m.cachedHealth(nodeID); ok && time.Since(h.checkedAt) < nodeHealthTTL {
+		return h.ok
+	}
+
+	m.mu.RLock()
+	p, loaded := m.providers[nodeID]
+	m.mu.RUnlock()
+
+	h := nodeHealth{checkedAt: time.Now()}
+	if !loaded {
+		h.err = errors.New("node is not loaded")
+	} else {
+		checkCtx, cancel := context.WithTimeout(ctx, nodeHealthTimeout)
+		_, h.err = p.GetSystemStats(checkCtx)
+		cancel()
+	}
+	h.ok = h.err == nil
+
+	if h.err != nil {
+		log.Printf("⚠️ Node %d health check failed: %v", nodeID, h.err)
+	}
+
+	m.mu.Lock()
+	m.health[nodeID] = h
+	m.mu.Unlock()
+
+	return h.ok
+}
+
+func (m *NodeManager) cachedHealth(nodeID int64) (nodeHealth, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	h, ok := m.health[nodeID]
+	return h, ok
+}
+
+// newProvider создаёт провайдер для ноды по типу панели
+func (m *NodeManager) newProvider(n models.Node) VPNProvider {
+	if m.mock {
+		return NewMockVPNProvider()
+	}
+
+	switch n.PanelType {
+	case config.PanelXUI:
+		return NewXUIProvider(config.XUIConfig{
+			BaseURL:   n.BaseURL,
+			Username:  n.Username,
+			Password:  n.Password,
+			InboundID: n.InboundID,
+			Host:      n.PublicHost,
+			Flow:      n.Flow,
+		})
+	default:
+		return NewMarzbanProvider(config.MarzbanConfig{
+			BaseURL:  n.BaseURL,
+			Username: n.Username,
+			Password: n.Password,
+		})
+	}
+}
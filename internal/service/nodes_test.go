@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+
+	"vpn-telegram-bot/internal/models"
+)
+
+func TestNodeManagerPanels(t *testing.T) {
+	fallback := NewMockVPNProvider()
+	m := NewNodeManager(nil, fallback, true)
+
+	// Без нод под наблюдением только панель из конфига
+	if panels := m.Panels(); len(panels) != 1 || panels[0].Name != DefaultPanelName || panels[0].VPN != fallback {
+		t.Fatalf("panels without nodes = %+v, want only the default panel", panels)
+	}
+
+	de, nl := NewMockVPNProvider(), NewMockVPNProvider()
+	m.nodes = []models.Node{
+		{ID: 1, Name: "de-1", IsEnabled: true},
+		{ID: 2, Name: "nl-1", IsEnabled: true},
+		{ID: 3, Name: "fi-1", IsEnabled: false},
+	}
+	m.providers = map[int64]VPNProvider{1: de, 2: nl, 3: NewMockVPNProvider()}
+
+	panels := m.Panels()
+	want := []Panel{{DefaultPanelName, fallback}, {"de-1", de}, {"nl-1", nl}}
+	if len(panels) != len(want) {
+		t.Fatalf("panels = %+v, want %d panels", panels, len(want))
+	}
+	for i := range want {
+		if panels[i].Name != want[i].Name || panels[i].VPN != want[i].VPN {
+			t.Errorf("panel %d = %s, want %s", i, panels[i].Name, want[i].Name)
+		}
+	}
+}
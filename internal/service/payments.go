@@ -0,0 +1,349 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/payment"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PaymentResult результат обработки оплаты
+type PaymentResult struct {
+	Invoice            *models.Invoice
+	TelegramID         int64 // владелец счёта
+	Credited           bool  // баланс пополнен этим вызовом (false = уже был зачислен или не оплачен)
+	ReferrerTelegramID *int64
+	ReferralBonus      float64
+
+	// Только для счетов на подписку. Если активацию завершил другой вызов, Subscription и ActivationErr пусты
+	Subscription    *models.Subscription
+	ActivationErr   error // подписку создать не удалось, деньги остались на балансе
+	ActivationRetry bool  // ошибка временная: активацию повторят InvoicePoller или повторный вебхук
+}
+
+// SetPaymentGateway подключает шлюз для способа оплаты (payment.MethodCard / payment.MethodCrypto)
+func (s *Service) SetPaymentGateway(method string, gw payment.PaymentGateway) {
+	s.gateways[method] = gw
+	log.Printf("💳 Payment gateway for %s: %s", method, gw.Name())
+}
+
+// HasPaymentGateway проверяет, подключён ли шлюз для способа оплаты
+func (s *Service) HasPaymentGateway(method string) bool {
+	return s.gateways[method] != nil
+}
+
+// OnPayment устанавливает обработчик зачисленных оплат (уведомления пользователей)
+func (s *Service) OnPayment(fn func(*PaymentResult)) {
+	s.paymentHook = fn
+}
+
+// CreateTopUpInvoice создаёт счёт на пополнение баланса и возвращает его со ссылкой на оплату
+func (s *Service) CreateTopUpInvoice(ctx context.Context, userID int64, method string, amount float64) (*models.Invoice, error) {
+	inv := &models.Invoice{
+		UserID:    userID,
+		Purpose:   models.InvoicePurposeTopUp,
+		Amount:    amount,
+		BonusDays: methodBonusDays(method),
+	}
+	lang := s.userLanguageByID(ctx, userID)
+	return s.createInvoice(ctx, method, inv, lang, i18n.T(lang, "invoice.description_topup"))
+}
+
+// CreatePlanInvoice создаёт счёт на покупку подписки: после оплаты подписка активируется автоматически
+func (s *Service) CreatePlanInvoice(ctx context.Context, userID int64, method string, plan *models.ProductPlan, price float64) (*models.Invoice, error) {
+	product, err := s.db.GetProductByID(ctx, plan.ProductID)
+	if err != nil {
+		return nil, fmt.Errorf("product not found: %w", err)
+	}
+
+	inv := planInvoice(userID, plan, price)
+	inv.BonusDays = methodBonusDays(method)
+	lang := s.userLanguageByID(ctx, userID)
+	return s.createInvoice(ctx, method, inv, lang, i18n.T(lang, "invoice.description_plan", product.Name, PlanPeriodTitle(lang, plan)))
+}
+
+// planInvoice заготовка счёта на покупку подписки по плану
+func planInvoice(userID int64, plan *models.ProductPlan, price float64) *models.Invoice {
+	return &models.Invoice{
+		UserID:    userID,
+		Purpose:   models.InvoicePurposeSubscription,
+		Amount:    price,
+		ProductID: &plan.ProductID,
+		PlanID:    &plan.ID,
+		Months:    plan.Months,
+		Days:      plan.Days,
+	}
+}
+
+// createInvoice сохраняет счёт и создаёт платёж в шлюзе; описание платежа на языке владельца lang
+func (s *Service) createInvoice(ctx context.Context, method string, inv *models.Invoice, lang, description string) (*models.Invoice, error) {
+	gw := s.gateways[method]
+	if gw == nil {
+		return nil, fmt.Errorf("payment method %s is not configured", method)
+	}
+
+	inv.Gateway = gw.Name()
+	created, err := s.db.CreateInvoice(ctx, inv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invoice: %w", err)
+	}
+
+	ext, err := gw.CreateInvoice(ctx, payment.InvoiceRequest{
+		InvoiceID:   created.ID,
+		Amount:      created.Amount,
+		Description: i18n.T(lang, "invoice.description", description, created.ID),
+	})
+	if err != nil {
+		s.db.CancelInvoice(ctx, created.ID)
+		return nil, err
+	}
+
+	if err := s.db.SetInvoicePayment(ctx, created.ID, ext.ExternalID, ext.PaymentURL); err != nil {
+		return nil, fmt.Errorf("failed to save invoice payment: %w", err)
+	}
+
+	created.ExternalID = ext.ExternalID
+	created.PaymentURL = ext.PaymentURL
+	return created, nil
+}
+
+// ProcessPaymentWebhook проверяет уведомление шлюза и зачисляет оплату (ровно один раз)
+func (s *Service) ProcessPaymentWebhook(ctx context.Context, gatewayName string, r *http.Request) error {
+	gw := s.gatewayByName(gatewayName)
+	if gw == nil {
+		return fmt.Errorf("%w: unknown gateway %q", payment.ErrInvalidWebhook, gatewayName)
+	}
+
+	event, err := gw.ParseWebhook(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.applyPaymentEvent(ctx, gw.Name(), event)
+	return err
+}
+
+// PollPendingInvoices опрашивает шлюзы, поддерживающие проверку статуса, по неоплаченным счетам
+func (s *Service) PollPendingInvoices(ctx context.Context, maxAge time.Duration) {
+	const batchSize = 100
+
+	for _, gw := range s.gateways {
+		checker, ok := gw.(payment.StatusChecker)
+		if !ok {
+			continue
+		}
+
+		invoices, err := s.db.GetPendingInvoices(ctx, gw.Name(), time.Now().Add(-maxAge))
+		if err != nil {
+			log.Printf("Payments: failed to load pending invoices (%s): %v", gw.Name(), err)
+			continue
+		}
+
+		for start := 0; start < len(invoices); start += batchSize {
+			end := min(start+batchSize, len(invoices))
+
+			ids := make([]string, 0, end-start)
+			for _, inv := range invoices[start:end] {
+				ids = append(ids, inv.ExternalID)
+			}
+
+			events, err := checker.CheckInvoices(ctx, ids)
+			if err != nil {
+				log.Printf("Payments: failed to check invoices (%s): %v", gw.Name(), err)
+				break
+			}
+
+			for i := range events {
+				if events[i].Status == payment.StatusPending {
+					continue
+				}
+				if _, err := s.applyPaymentEvent(ctx, gw.Name(), &events[i]); err != nil {
+					log.Printf("Payments: failed to apply %s invoice %s: %v", gw.Name(), events[i].ExternalID, err)
+				}
+			}
+		}
+	}
+}
+
+// applyPaymentEvent применяет проверенное событие оплаты к счёту
+func (s *Service) applyPaymentEvent(ctx context.Context, gateway string, event *payment.WebhookEvent) (*PaymentResult, error) {
+	inv, err := s.db.GetInvoiceByExternalID(ctx, gateway, event.ExternalID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: invoice %s/%s not found", payment.ErrInvalidWebhook, gateway, event.ExternalID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PaymentResult{Invoice: inv}
+
+	switch event.Status {
+	case payment.StatusPaid:
+		// Копейки на округлении допускаем, недоплату — нет
+		if event.Amount+0.01 < inv.Amount {
+			return nil, fmt.Errorf("%w: invoice %d paid %.2f, expected %.2f",
+				payment.ErrInvalidWebhook, inv.ID, event.Amount, inv.Amount)
+		}
+
+		result.Credited, result.ReferrerTelegramID, result.ReferralBonus, err = s.db.PayInvoice(ctx, inv.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pay invoice %d: %w", inv.ID, err)
+		}
+		if !result.Credited {
+			// Повторное уведомление об уже зачисленном счёте: заодно повторяем несостоявшуюся активацию
+			if inv.Status == models.InvoicePaid && inv.ActivationPending {
+				s.retryActivation(ctx, inv)
+			}
+			return result, nil
+		}
+		inv.Status = models.InvoicePaid
+		inv.ActivationPending = inv.Purpose == models.InvoicePurposeSubscription
+		log.Printf("💰 Invoice %d paid via %s: %.2f ₽ (user %d)", inv.ID, gateway, inv.Amount, inv.UserID)
+
+	case payment.StatusCanceled:
+		return result, s.db.CancelInvoice(ctx, inv.ID)
+
+	default:
+		return result, nil
+	}
+
+	user, err := s.db.GetUserByID(ctx, inv.UserID)
+	if err != nil {
+		return nil, err
+	}
+	result.TelegramID = user.TelegramID
+
+	if inv.Purpose == models.InvoicePurposeSubscription {
+		s.activateInvoice(ctx, result)
+	}
+
+	if s.paymentHook != nil {
+		s.paymentHook(result)
+	}
+
+	return result, nil
+}
+
+// RetryInvoiceActivations повторяет активацию счетов на подписку, оплаченных после maxAge назад,
+// по которым подписку создать не удалось (панель или БД были недоступны, процесс упал)
+func (s *Service) RetryInvoiceActivations(ctx context.Context, maxAge time.Duration) {
+	invoices, err := s.db.GetInvoicesAwaitingActivation(ctx, time.Now().Add(-maxAge))
+	if err != nil {
+		log.Printf("Payments: failed to load invoices awaiting activation: %v", err)
+		return
+	}
+	for i := range invoices {
+		s.retryActivation(ctx, &invoices[i])
+	}
+}
+
+// retryActivation повторная активация уже зачисленного счёта. Пользователь узнаёт только об итоге:
+// подписка создана или активацию больше не повторяют
+func (s *Service) retryActivation(ctx context.Context, inv *models.Invoice) {
+	user, err := s.db.GetUserByID(ctx, inv.UserID)
+	if err != nil {
+		log.Printf("Payments: invoice %d: failed to load user %d: %v", inv.ID, inv.UserID, err)
+		return
+	}
+
+	result := &PaymentResult{Invoice: inv, TelegramID: user.TelegramID}
+	s.activateInvoice(ctx, result)
+	if result.Subscription == nil && (result.ActivationErr == nil || result.ActivationRetry) {
+		return
+	}
+	if s.paymentHook != nil {
+		s.paymentHook(result)
+	}
+}
+
+// activateInvoice создаёт подписку по оплаченному счёту и запоминает итог в счёте: при временной ошибке
+// счёт остаётся ожидающим активации, при постоянной (план удалён, деньги с баланса уже потрачены) — нет
+func (s *Service) activateInvoice(ctx context.Context, result *PaymentResult) {
+	inv := result.Invoice
+
+	sub, err := s.activatePaidPlan(ctx, inv)
+	if err != nil {
+		retry := activationRetryable(err)
+		log.Printf("❌ Invoice %d: failed to activate plan (retry: %v): %v", inv.ID, retry, err)
+
+		ok, saveErr := s.db.FailInvoiceActivation(ctx, inv.ID, err.Error(), !retry)
+		if saveErr != nil {
+			log.Printf("⚠️ Invoice %d: failed to save activation error: %v", inv.ID, saveErr)
+		}
+		if ok || saveErr != nil {
+			result.ActivationErr = err
+			result.ActivationRetry = retry
+		}
+		return
+	}
+
+	ok, err := s.db.CompleteInvoiceActivation(ctx, inv.ID, sub.ID)
+	if err != nil {
+		// Счёт останется ожидающим: повтор найдёт завершённый заказ по ключу счёта и попробует снова
+		log.Printf("⚠️ Invoice %d: failed to link subscription %d: %v", inv.ID, sub.ID, err)
+	}
+	if ok || err != nil {
+		inv.SubscriptionID = &sub.ID
+		inv.ActivationPending = false
+		result.Subscription = sub
+	}
+}
+
+// activationRetryable можно ли повторить активацию: план, продукт и потраченные деньги сами не появятся
+func activationRetryable(err error) bool {
+	return !errors.Is(err, pgx.ErrNoRows) &&
+		!errors.Is(err, ErrPlanUnavailable) &&
+		!errors.Is(err, ErrInsufficientBalance) &&
+		!errors.Is(err, ErrSubscriptionNotFound) &&
+		!errors.Is(err, ErrPurchaseFailed)
+}
+
+// activatePaidPlan покупает оплаченную подписку с баланса (оплата уже зачислена на баланс) заказом с ключом счёта:
+// план продаётся по цене счёта, даже если его уже скрыли или акция закончилась. Если покупка не удалась,
+// деньги остаются на балансе. Повторный вызов после успешной покупки возвращает ту же подписку
+func (s *Service) activatePaidPlan(ctx context.Context, inv *models.Invoice) (*models.Subscription, error) {
+	if inv.PlanID == nil {
+		return nil, fmt.Errorf("%w: invoice %d has no plan", ErrPlanUnavailable, inv.ID)
+	}
+	plan, err := s.db.GetProductPlanByID(ctx, *inv.PlanID)
+	if err != nil {
+		return nil, fmt.Errorf("plan %d of invoice %d not found: %w", *inv.PlanID, inv.ID, err)
+	}
+	product, err := s.db.GetProductByID(ctx, plan.ProductID)
+	if err != nil {
+		return nil, fmt.Errorf("product not found: %w", err)
+	}
+
+	price := &PlanPrice{Plan: plan, BasePrice: inv.Amount, Price: inv.Amount}
+	res, err := s.purchase(ctx, inv.UserID, 0, product, plan, price, inv.BonusDays, fmt.Sprintf("invoice:%d", inv.ID))
+	if err != nil {
+		return nil, err
+	}
+	return res.Subscription, nil
+}
+
+// gatewayByName ищет подключённый шлюз по имени
+func (s *Service) gatewayByName(name string) payment.PaymentGateway {
+	for _, gw := range s.gateways {
+		if gw.Name() == name {
+			return gw
+		}
+	}
+	return nil
+}
+
+// methodBonusDays бонусные дни за способ оплаты
+func methodBonusDays(method string) int {
+	if method == payment.MethodCrypto {
+		return models.CryptoBonusDays
+	}
+	return 0
+}
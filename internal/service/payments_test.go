@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"vpn-telegram-bot/internal/config"
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/payment"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// fakeWebhookRequest вебхук тестового шлюза об оплате счёта
+func fakeWebhookRequest(externalID string) *http.Request {
+	body := fmt.Sprintf(`{"external_id":%q,"status":"paid"}`, externalID)
+	return httptest.NewRequest(http.MethodPost, "/webhooks/payment/fake", strings.NewReader(body))
+}
+
+func TestFakeWebhookCreditsOnce(t *testing.T) {
+	svc := newTestService(t, nil)
+	fake := payment.NewFakeGateway("http://bot.test")
+	svc.SetPaymentGateway(payment.MethodCard, fake)
+	svc.SetPaymentGateway(payment.MethodCrypto, fake)
+
+	var hooks atomic.Int32
+	svc.OnPayment(func(res *PaymentResult) {
+		if !res.Credited {
+			t.Errorf("payment hook for not credited invoice %d", res.Invoice.ID)
+		}
+		hooks.Add(1)
+	})
+
+	ctx := context.Background()
+	user := newTestUser(t, svc, 1001, 0)
+
+	card, err := svc.CreateTopUpInvoice(ctx, user.ID, payment.MethodCard, 500)
+	if err != nil {
+		t.Fatalf("CreateTopUpInvoice card: %v", err)
+	}
+	crypto, err := svc.CreateTopUpInvoice(ctx, user.ID, payment.MethodCrypto, 300)
+	if err != nil {
+		t.Fatalf("CreateTopUpInvoice crypto: %v", err)
+	}
+
+	if err := svc.ProcessPaymentWebhook(ctx, fake.Name(), fakeWebhookRequest(card.ExternalID)); err != nil {
+		t.Fatalf("card webhook: %v", err)
+	}
+	if got := userBalance(t, svc, user.ID); got != 500 {
+		t.Fatalf("balance after card webhook = %.2f, want 500", got)
+	}
+
+	// Повторный вебхук — без повторного зачисления
+	if err := svc.ProcessPaymentWebhook(ctx, fake.Name(), fakeWebhookRequest(card.ExternalID)); err != nil {
+		t.Fatalf("repeated card webhook: %v", err)
+	}
+	if got := userBalance(t, svc, user.ID); got != 500 {
+		t.Fatalf("balance after repeated webhook = %.2f, want 500", got)
+	}
+
+	// Счёт второго способа оплаты приходит на тот же вебхук и находится тем же шлюзом
+	if err := svc.ProcessPaymentWebhook(ctx, fake.Name(), fakeWebhookRequest(crypto.ExternalID)); err != nil {
+		t.Fatalf("crypto webhook: %v", err)
+	}
+	if got := userBalance(t, svc, user.ID); got != 800 {
+		t.Fatalf("balance after crypto webhook = %.2f, want 800", got)
+	}
+
+	if got := hooks.Load(); got != 2 {
+		t.Errorf("payment hook called %d times, want 2", got)
+	}
+	assertLedgerBalanced(t, svc)
+}
+
+func TestConcurrentWebhooksCreditOnce(t *testing.T) {
+	svc := newTestService(t, nil)
+	fake := payment.NewFakeGateway("http://bot.test")
+	svc.SetPaymentGateway(payment.MethodCard, fake)
+
+	ctx := context.Background()
+	user := newTestUser(t, svc, 1002, 0)
+	inv, err := svc.CreateTopUpInvoice(ctx, user.ID, payment.MethodCard, 250)
+	if err != nil {
+		t.Fatalf("CreateTopUpInvoice: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := svc.ProcessPaymentWebhook(ctx, fake.Name(), fakeWebhookRequest(inv.ExternalID)); err != nil {
+				t.Errorf("webhook: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := userBalance(t, svc, user.ID); got != 250 {
+		t.Errorf("balance = %.2f, want 250", got)
+	}
+	assertLedgerBalanced(t, svc)
+}
+
+func TestWebhookForUnknownGateway(t *testing.T) {
+	svc := newTestService(t, nil)
+	svc.SetPaymentGateway(payment.MethodCard, payment.NewFakeGateway("http://bot.test"))
+
+	err := svc.ProcessPaymentWebhook(context.Background(), "yookassa", fakeWebhookRequest("fake_1"))
+	if !errors.Is(err, payment.ErrInvalidWebhook) {
+		t.Fatalf("err = %v, want ErrInvalidWebhook", err)
+	}
+}
+
+func TestActivationRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"panel down", fmt.Errorf("failed to create client: %w", errPanelDown), true},
+		{"purchase in progress", fmt.Errorf("%w: order 1", ErrPurchaseInProgress), true},
+		{"plan deleted", fmt.Errorf("%w: invoice 1 has no plan", ErrPlanUnavailable), false},
+		{"plan not found", fmt.Errorf("plan 1 of invoice 1 not found: %w", pgx.ErrNoRows), false},
+		{"balance spent", fmt.Errorf("%w: have 0.00, need 300.00", ErrInsufficientBalance), false},
+		{"completed order without subscription", fmt.Errorf("%w: order 1", ErrPurchaseFailed), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := activationRetryable(tt.err); got != tt.want {
+				t.Errorf("activationRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeYooKassa API ЮKassa: создаёт платёж pay_1 и отдаёт его статус (или ошибку)
+type fakeYooKassa struct {
+	mu       sync.Mutex
+	status   string // pending | succeeded | canceled; пусто — API отвечает 500
+	amount   string
+	currency string
+}
+
+func (f *fakeYooKassa) set(status, amount, currency string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status, f.amount, f.currency = status, amount, currency
+}
+
+func (f *fakeYooKassa) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/payments":
+		fmt.Fprint(w, `{"id":"pay_1","status":"pending","paid":false,"amount":{"value":"500.00","currency":"RUB"},
+			"confirmation":{"type":"redirect","confirmation_url":"https://yoomoney.test/pay_1"}}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/payments/pay_1":
+		if f.status == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"type":"error","code":"internal_server_error"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"id":"pay_1","status":%q,"paid":%t,"amount":{"value":%q,"currency":%q}}`,
+			f.status, f.status == "succeeded", f.amount, f.currency)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestWebhookRefetchFailureDoesNotCredit(t *testing.T) {
+	svc := newTestService(t, nil)
+	api := &fakeYooKassa{}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	gw := payment.NewYooKassaGatewayWithClient(config.YooKassaConfig{ShopID: "1", SecretKey: "test"}, srv.URL, srv.Client())
+	svc.SetPaymentGateway(payment.MethodCard, gw)
+
+	ctx := context.Background()
+	user := newTestUser(t, svc, 1003, 0)
+	inv, err := svc.CreateTopUpInvoice(ctx, user.ID, payment.MethodCard, 500)
+	if err != nil {
+		t.Fatalf("CreateTopUpInvoice: %v", err)
+	}
+
+	// Тело уведомления утверждает, что платёж прошёл; шлюз верит только ответу API
+	webhook := func() error {
+		body := `{"type":"notification","event":"payment.succeeded","object":{"id":"pay_1","status":"succeeded","paid":true,
+			"amount":{"value":"500.00","currency":"RUB"}}}`
+		r := httptest.NewRequest(http.MethodPost, "/webhooks/payment/yookassa", strings.NewReader(body))
+		return svc.ProcessPaymentWebhook(ctx, gw.Name(), r)
+	}
+
+	steps := []struct {
+		name     string
+		status   string
+		amount   string
+		currency string
+		wantErr  bool
+		balance  float64
+	}{
+		{name: "api error", status: "", wantErr: true, balance: 0},
+		{name: "still pending", status: "pending", amount: "500.00", currency: "RUB", balance: 0},
+		{name: "underpaid", status: "succeeded", amount: "100.00", currency: "RUB", wantErr: true, balance: 0},
+		{name: "other currency", status: "succeeded", amount: "500.00", currency: "USD", wantErr: true, balance: 0},
+		{name: "paid", status: "succeeded", amount: "500.00", currency: "RUB", balance: 500},
+		{name: "repeated", status: "succeeded", amount: "500.00", currency: "RUB", balance: 500},
+	}
+	for _, step := range steps {
+		api.set(step.status, step.amount, step.currency)
+		err := webhook()
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: err = %v, want error %t", step.name, err, step.wantErr)
+		}
+		if got := userBalance(t, svc, user.ID); got != step.balance {
+			t.Fatalf("%s: balance = %.2f, want %.2f", step.name, got, step.balance)
+		}
+	}
+
+	paid, err := svc.db.GetInvoiceByID(ctx, inv.ID)
+	if err != nil {
+		t.Fatalf("GetInvoiceByID: %v", err)
+	}
+	if paid.Status != models.InvoicePaid {
+		t.Errorf("invoice status = %s, want %s", paid.Status, models.InvoicePaid)
+	}
+	assertLedgerBalanced(t, svc)
+}
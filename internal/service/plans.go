@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"vpn-telegram-bot/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrPlanInvalid некорректные параметры плана
+	ErrPlanInvalid = errors.New("invalid plan")
+	// ErrPlanUnavailable план не найден или скрыт админом
+	ErrPlanUnavailable = errors.New("plan unavailable")
+)
+
+// Ограничения срока плана
+const (
+	maxPlanMonths = 120
+	maxPlanDays   = 3650
+)
+
+// GetProductPlans возвращает включённые планы продукта в порядке отображения
+func (s *Service) GetProductPlans(ctx context.Context, productID int64) ([]models.ProductPlan, error) {
+	return s.db.GetProductPlans(ctx, productID, true)
+}
+
+// GetAllProductPlans возвращает все планы продукта, включая скрытые (для админки)
+func (s *Service) GetAllProductPlans(ctx context.Context, productID int64) ([]models.ProductPlan, error) {
+	return s.db.GetProductPlans(ctx, productID, false)
+}
+
+// GetProductPlan возвращает план по ID
+func (s *Service) GetProductPlan(ctx context.Context, id int64) (*models.ProductPlan, error) {
+	return s.db.GetProductPlanByID(ctx, id)
+}
+
+// GetPlanForSale возвращает включённый план вместе с его продуктом
+func (s *Service) GetPlanForSale(ctx context.Context, planID int64) (*models.ProductPlan, *models.Product, error) {
+	plan, err := s.db.GetProductPlanByID(ctx, planID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !plan.IsActive) {
+		return nil, nil, fmt.Errorf("%w: plan %d", ErrPlanUnavailable, planID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	product, err := s.db.GetProductByID(ctx, plan.ProductID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("product not found: %w", err)
+	}
+	return plan, product, nil
+}
+
+// CreateProductPlan добавляет план в конец списка планов продукта
+func (s *Service) CreateProductPlan(ctx context.Context, plan *models.ProductPlan) (*models.ProductPlan, error) {
+	if err := validatePlan(plan); err != nil {
+		return nil, err
+	}
+	if _, err := s.db.GetProductByID(ctx, plan.ProductID); err != nil {
+		return nil, fmt.Errorf("%w: product %d not found", ErrPlanInvalid, plan.ProductID)
+	}
+
+	created, err := s.db.CreateProductPlan(ctx, plan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create plan: %w", err)
+	}
+	log.Printf("💲 Plan #%d created for product %d: %s", created.ID, created.ProductID, created.PeriodTitle())
+	return created, nil
+}
+
+// UpdateProductPlan сохраняет изменения плана
+func (s *Service) UpdateProductPlan(ctx context.Context, plan *models.ProductPlan) error {
+	if err := validatePlan(plan); err != nil {
+		return err
+	}
+	if err := s.db.UpdateProductPlan(ctx, plan); err != nil {
+		return fmt.Errorf("failed to update plan %d: %w", plan.ID, err)
+	}
+	log.Printf("💲 Plan #%d updated: %s, active=%v", plan.ID, plan.PeriodTitle(), plan.IsActive)
+	return nil
+}
+
+// DeleteProductPlan удаляет план
+func (s *Service) DeleteProductPlan(ctx context.Context, id int64) error {
+	if err := s.db.DeleteProductPlan(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete plan %d: %w", id, err)
+	}
+	log.Printf("💲 Plan #%d deleted", id)
+	return nil
+}
+
+// GetTopUpAmounts суммы пополнения баланса: цены включённых планов продукта без акций
+func (s *Service) GetTopUpAmounts(ctx context.Context, productID int64) ([]float64, error) {
+	product, err := s.db.GetProductByID(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("product not found: %w", err)
+	}
+	plans, err := s.db.GetProductPlans(ctx, productID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	amounts := make([]float64, 0, len(plans))
+	for i := range plans {
+		amounts = append(amounts, plans[i].CalculatePrice(product.BasePrice))
+	}
+	return amounts, nil
+}
+
+// validatePlan проверяет срок, цену, скидку, квоту и лимит устройств плана
+func validatePlan(p *models.ProductPlan) error {
+	switch {
+	case p.Months < 0 || p.Days < 0 || p.Months+p.Days == 0:
+		return fmt.Errorf("%w: empty period", ErrPlanInvalid)
+	case p.Months > maxPlanMonths || p.Days > maxPlanDays:
+		return fmt.Errorf("%w: period %s is too long", ErrPlanInvalid, p.PeriodTitle())
+	case p.Price != nil && *p.Price <= 0:
+		return fmt.Errorf("%w: price %.2f", ErrPlanInvalid, *p.Price)
+	case p.DiscountPercent < 0 || p.DiscountPercent >= 100:
+		return fmt.Errorf("%w: discount %d%%", ErrPlanInvalid, p.DiscountPercent)
+	case p.TrafficLimitGB != nil && (*p.TrafficLimitGB < 0 || *p.TrafficLimitGB > maxTrafficGB):
+		return fmt.Errorf("%w: traffic %d GB", ErrPlanInvalid, *p.TrafficLimitGB)
+	case p.DeviceLimit != nil && (*p.DeviceLimit < 0 || *p.DeviceLimit > maxDeviceLimit):
+		return fmt.Errorf("%w: device limit %d", ErrPlanInvalid, *p.DeviceLimit)
+	}
+	return nil
+}
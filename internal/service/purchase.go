@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrPurchaseInProgress заказ с тем же ключом идемпотентности ещё выполняется
+	ErrPurchaseInProgress = errors.New("purchase in progress")
+	// ErrPurchaseFailed заказ с тем же ключом завершён, но подписки у него нет
+	ErrPurchaseFailed = errors.New("purchase failed")
+)
+
+// OrderRecoveryAfter через сколько ожидающий заказ считается брошенным (процесс упал во время покупки)
+const OrderRecoveryAfter = 5 * time.Minute
+
+// PlanRef что покупается: план продукта для новой подписки или для продления SubscriptionID
+type PlanRef struct {
+	PlanID         int64
+	SubscriptionID int64 // 0 — новая подписка
+}
+
+// PurchaseResult итог покупки
+type PurchaseResult struct {
+	Order        *models.Order
+	Subscription *models.Subscription // выданная или продлённая подписка (с продуктом)
+	Plan         *models.ProductPlan
+	Price        *PlanPrice
+	Duplicate    bool // повторный запрос с тем же ключом: покупка уже была выполнена
+}
+
+// Purchase покупает план с баланса пользователя. Деньги списываются вместе с созданием заказа,
+// подписка выдаётся (продлевается) в той же транзакции БД, что завершает заказ. Если панель или БД вернули ошибку,
+// заказ отменяется с возвратом денег, а созданный в панели клиент удаляется. Заказы, брошенные из-за падения
+// процесса, разбирает RecoverOrders. Повторный вызов с тем же idempotencyKey ничего не списывает,
+// пока заказ не отменён: отменённый заказ освобождает ключ.
+// При ErrInsufficientBalance возвращается и результат с планом и ценой — для экрана пополнения
+func (s *Service) Purchase(ctx context.Context, userID int64, ref PlanRef, idempotencyKey string) (*PurchaseResult, error) {
+	plan, product, err := s.GetPlanForSale(ctx, ref.PlanID)
+	if err != nil {
+		return nil, err
+	}
+	price, err := s.ResolvePlanPrice(ctx, product, plan)
+	if err != nil {
+		return nil, err
+	}
+	return s.purchase(ctx, userID, ref.SubscriptionID, product, plan, price, 0, idempotencyKey)
+}
+
+// purchase покупка плана по уже рассчитанной цене; bonusDays добавляются к сроку новой подписки
+func (s *Service) purchase(ctx context.Context, userID, subID int64, product *models.Product, plan *models.ProductPlan, price *PlanPrice, bonusDays int, idempotencyKey string) (*PurchaseResult, error) {
+	var sub *models.Subscription
+	if subID != 0 {
+		var err error
+		sub, err = s.db.GetSubscriptionByID(ctx, subID)
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && sub.UserID != userID) {
+			return nil, fmt.Errorf("%w: subscription %d", ErrSubscriptionNotFound, subID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if plan.ProductID != sub.ProductID {
+			return nil, fmt.Errorf("%w: plan %d is not for product %d", ErrPlanUnavailable, plan.ID, sub.ProductID)
+		}
+	}
+
+	res := &PurchaseResult{Plan: plan, Price: price}
+
+	// Повторное нажатие: отдаём результат первого заказа
+	if existing, err := s.db.GetOrderByKey(ctx, userID, idempotencyKey); err == nil {
+		return s.duplicatePurchase(ctx, res, existing, product)
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	if user.Balance < price.Price {
+		return res, fmt.Errorf("%w: have %.2f, need %.2f", ErrInsufficientBalance, user.Balance, price.Price)
+	}
+
+	order := &models.Order{
+		UserID:         userID,
+		IdempotencyKey: idempotencyKey,
+		ProductID:      product.ID,
+		PlanID:         &plan.ID,
+		Amount:         price.Price,
+		BonusDays:      bonusDays,
+	}
+	var vpn VPNProvider
+	if sub != nil {
+		order.Kind = models.OrderExtend
+		order.SubscriptionID = &sub.ID
+	} else {
+		// Нода и имя клиента сохраняются в заказе до обращения к панели, чтобы брошенный заказ можно было убрать
+		var node *models.Node
+		node, vpn, err = s.nodes.PickNode(ctx, product.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pick node: %w", err)
+		}
+		if node != nil {
+			order.NodeID = &node.ID
+		}
+		order.Kind = models.OrderNew
+		order.VPNUsername = fmt.Sprintf("tg_%d_%d", user.TelegramID, time.Now().Unix())
+	}
+
+	created, ok, err := s.db.CreateOrder(ctx, order)
+	if err != nil {
+		// Баланс мог уменьшиться параллельной покупкой
+		if user, userErr := s.db.GetUserByID(ctx, userID); userErr == nil && user.Balance < price.Price {
+			return res, fmt.Errorf("%w: have %.2f, need %.2f", ErrInsufficientBalance, user.Balance, price.Price)
+		}
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+	if !ok {
+		existing, err := s.db.GetOrderByKey(ctx, userID, idempotencyKey)
+		if err != nil {
+			return nil, err
+		}
+		return s.duplicatePurchase(ctx, res, existing, product)
+	}
+	res.Order = created
+
+	if sub != nil {
+		res.Subscription, err = s.fulfilExtension(ctx, created, sub, product, plan)
+	} else {
+		res.Subscription, err = s.fulfilNewSubscription(ctx, created, vpn, product, plan)
+	}
+	if err != nil {
+		s.failOrder(ctx, created, err)
+		return nil, err
+	}
+
+	created.Status = models.OrderCompleted
+	created.SubscriptionID = &res.Subscription.ID
+	log.Printf("🛒 Order %d completed: user %d, %s subscription %d, %.2f ₽", created.ID, userID, created.Kind, res.Subscription.ID, price.Price)
+	return res, nil
+}
+
+// duplicatePurchase результат повторного запроса по уже существующему заказу
+func (s *Service) duplicatePurchase(ctx context.Context, res *PurchaseResult, order *models.Order, product *models.Product) (*PurchaseResult, error) {
+	if order.Status == models.OrderPending {
+		return nil, fmt.Errorf("%w: order %d", ErrPurchaseInProgress, order.ID)
+	}
+	if order.SubscriptionID == nil {
+		return nil, fmt.Errorf("%w: order %d has no subscription", ErrPurchaseFailed, order.ID)
+	}
+
+	sub, err := s.db.GetSubscriptionByID(ctx, *order.SubscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load subscription of order %d: %w", order.ID, err)
+	}
+	sub.Product = product
+	res.Order = order
+	res.Subscription = sub
+	res.Duplicate = true
+	return res, nil
+}
+
+// fulfilNewSubscription создаёт клиента в панели и сохраняет подписку, завершая заказ
+func (s *Service) fulfilNewSubscription(ctx context.Context, order *models.Order, vpn VPNProvider, product *models.Product, plan *models.ProductPlan) (*models.Subscription, error) {
+	expiresAt := plan.ExpiresFrom(time.Now()).AddDate(0, 0, order.BonusDays)
+	sub := newVPNSubscription(order.UserID, product, order.NodeID, &plan.ID, plan.Limits(product), order.VPNUsername, expiresAt)
+	if err := createVPNUser(ctx, vpn, sub, product); err != nil {
+		return nil, err
+	}
+
+	saved, err := s.db.CompleteOrderSubscription(ctx, order.ID, sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save subscription: %w", err)
+	}
+	saved.Product = product
+	return saved, nil
+}
+
+// fulfilExtension продлевает подписку в БД и панели, завершая заказ. Трафик сбрасывается только после
+// завершения заказа: отменённый заказ восстанавливает срок и лимиты, но израсходованный трафик уже не вернуть
+func (s *Service) fulfilExtension(ctx context.Context, order *models.Order, sub *models.Subscription, product *models.Product, plan *models.ProductPlan) (*models.Subscription, error) {
+	extended, extendVPN := s.planExtension(sub, product, plan)
+	if err := s.db.CompleteOrderExtension(ctx, order.ID, extended, extendVPN); err != nil {
+		return nil, fmt.Errorf("failed to extend subscription %d: %w", sub.ID, err)
+	}
+	s.resetPlanTraffic(ctx, extended)
+	extended.Product = product
+	return extended, nil
+}
+
+// failOrder отменяет заказ с возвратом денег и убирает следы покупки в панели.
+// Если заказ уже отменил RecoverOrders, деньги второй раз не возвращаются
+func (s *Service) failOrder(ctx context.Context, order *models.Order, cause error) {
+	failed, err := s.db.FailOrder(ctx, order.ID, cause.Error())
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Заказ уже отменил RecoverOrders, пока панель отвечала: убираем то, что успели создать после этого
+		log.Printf("⚠️ Order %d was recovered while in progress: %v", order.ID, cause)
+		s.compensateOrder(ctx, order)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Order %d: failed to refund %.2f to user %d: %v", order.ID, order.Amount, order.UserID, err)
+		return
+	}
+	log.Printf("↩️ Order %d failed, %.2f refunded to user %d: %v", order.ID, failed.Amount, failed.UserID, cause)
+	s.compensateOrder(ctx, failed)
+}
+
+// compensateOrder возвращает панель к состоянию БД после отмены заказа: удаляет клиента
+// несостоявшейся новой подписки или восстанавливает срок и лимиты продлеваемой
+func (s *Service) compensateOrder(ctx context.Context, order *models.Order) {
+	switch order.Kind {
+	case models.OrderNew:
+		if order.VPNUsername == "" {
+			return
+		}
+		err := s.nodes.Provider(order.NodeID).DeleteUser(ctx, order.VPNUsername)
+		if err != nil && !errors.Is(err, ErrVPNUserNotFound) {
+			log.Printf("⚠️ Order %d: failed to delete VPN user %s: %v", order.ID, order.VPNUsername, err)
+		}
+	case models.OrderExtend:
+		if order.SubscriptionID == nil {
+			return
+		}
+		sub, err := s.db.GetSubscriptionByID(ctx, *order.SubscriptionID)
+		if err != nil {
+			log.Printf("⚠️ Order %d: failed to load subscription %d: %v", order.ID, *order.SubscriptionID, err)
+			return
+		}
+		if sub.VPNUsername == "" {
+			return
+		}
+		if err := s.applyVPNLimits(ctx, sub); err != nil {
+			log.Printf("⚠️ Order %d: failed to restore VPN user of subscription %d: %v", order.ID, sub.ID, err)
+		}
+	}
+}
+
+// RecoverOrders отменяет с возвратом денег заказы, брошенные дольше OrderRecoveryAfter назад
+// (процесс упал между списанием и выдачей подписки). Возвращает отменённые заказы
+func (s *Service) RecoverOrders(ctx context.Context) []models.Order {
+	orders, err := s.db.GetStalePendingOrders(ctx, time.Now().Add(-OrderRecoveryAfter))
+	if err != nil {
+		log.Printf("Scheduler: failed to get pending orders: %v", err)
+		return nil
+	}
+
+	var recovered []models.Order
+	for _, order := range orders {
+		failed, err := s.db.FailOrder(ctx, order.ID, "abandoned: recovered after restart")
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Scheduler: failed to recover order %d: %v", order.ID, err)
+			continue
+		}
+		log.Printf("↩️ Abandoned order %d recovered, %.2f refunded to user %d", failed.ID, failed.Amount, failed.UserID)
+		s.compensateOrder(ctx, failed)
+		recovered = append(recovered, *failed)
+	}
+	return recovered
+}
@@ -0,0 +1,413 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/payment"
+)
+
+func TestConcurrentPurchasesDoNotOverspend(t *testing.T) {
+	svc := newTestService(t, nil)
+	plan := newTestPlan(t, svc, 300)
+	user := newTestUser(t, svc, 3001, 300)
+	ctx := context.Background()
+
+	// Разные ключи — разные нажатия: денег хватает только на одну покупку
+	var ok, insufficient atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := svc.Purchase(ctx, user.ID, PlanRef{PlanID: plan.ID}, fmt.Sprintf("cb:%d", i))
+			switch {
+			case err == nil:
+				ok.Add(1)
+			case errors.Is(err, ErrInsufficientBalance):
+				insufficient.Add(1)
+			default:
+				t.Errorf("Purchase %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if ok.Load() != 1 || insufficient.Load() != 9 {
+		t.Errorf("succeeded %d, insufficient %d; want 1 and 9", ok.Load(), insufficient.Load())
+	}
+	if got := userBalance(t, svc, user.ID); got != 0 {
+		t.Errorf("balance = %.2f, want 0", got)
+	}
+	assertLedgerBalanced(t, svc)
+}
+
+func TestPurchaseSameKeyChargesOnce(t *testing.T) {
+	svc := newTestService(t, nil)
+	plan := newTestPlan(t, svc, 100)
+	user := newTestUser(t, svc, 3002, 1000)
+	ctx := context.Background()
+
+	// Ретраи одного нажатия: параллельно и после завершения
+	var mu sync.Mutex
+	subs := make(map[int64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := svc.Purchase(ctx, user.ID, PlanRef{PlanID: plan.ID}, "cb:same")
+			if errors.Is(err, ErrPurchaseInProgress) {
+				return
+			}
+			if err != nil {
+				t.Errorf("Purchase: %v", err)
+				return
+			}
+			mu.Lock()
+			subs[res.Subscription.ID] = true
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	res, err := svc.Purchase(ctx, user.ID, PlanRef{PlanID: plan.ID}, "cb:same")
+	if err != nil {
+		t.Fatalf("repeated Purchase: %v", err)
+	}
+	if !res.Duplicate {
+		t.Error("repeated purchase is not marked as duplicate")
+	}
+	subs[res.Subscription.ID] = true
+
+	if len(subs) != 1 {
+		t.Errorf("got %d subscriptions, want 1", len(subs))
+	}
+	if got := userBalance(t, svc, user.ID); got != 900 {
+		t.Errorf("balance = %.2f, want 900", got)
+	}
+	assertLedgerBalanced(t, svc)
+}
+
+func TestFailedOrderReleasesKey(t *testing.T) {
+	vpn := newFlakyVPN()
+	svc := newTestService(t, vpn)
+	plan := newTestPlan(t, svc, 100)
+	user := newTestUser(t, svc, 3003, 100)
+	ctx := context.Background()
+
+	vpn.fail.Store(true)
+	if _, err := svc.Purchase(ctx, user.ID, PlanRef{PlanID: plan.ID}, "cb:retry"); !errors.Is(err, errPanelDown) {
+		t.Fatalf("err = %v, want panel error", err)
+	}
+	if got := userBalance(t, svc, user.ID); got != 100 {
+		t.Fatalf("balance after failed purchase = %.2f, want 100", got)
+	}
+
+	// Отменённый заказ не держит ключ: ретрай того же нажатия покупает заново
+	vpn.fail.Store(false)
+	res, err := svc.Purchase(ctx, user.ID, PlanRef{PlanID: plan.ID}, "cb:retry")
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if res.Duplicate || res.Order.Status != models.OrderCompleted {
+		t.Errorf("retry result: duplicate %v, order %+v", res.Duplicate, res.Order)
+	}
+	if got := userBalance(t, svc, user.ID); got != 0 {
+		t.Errorf("balance after retry = %.2f, want 0", got)
+	}
+	assertLedgerBalanced(t, svc)
+}
+
+func TestRecoverOrdersRefundsStuckOrder(t *testing.T) {
+	vpn := newFlakyVPN()
+	svc := newTestService(t, vpn)
+	plan := newTestPlan(t, svc, 150)
+	user := newTestUser(t, svc, 3004, 200)
+	ctx := context.Background()
+
+	// Процесс упал после списания: заказ остался ожидающим
+	order, ok, err := svc.db.CreateOrder(ctx, &models.Order{
+		UserID:         user.ID,
+		IdempotencyKey: "cb:crashed",
+		Kind:           models.OrderNew,
+		ProductID:      plan.ProductID,
+		PlanID:         &plan.ID,
+		Amount:         150,
+		VPNUsername:    "tg_3004_crashed",
+	})
+	if err != nil || !ok {
+		t.Fatalf("CreateOrder: ok %v, err %v", ok, err)
+	}
+	if got := userBalance(t, svc, user.ID); got != 50 {
+		t.Fatalf("balance after charge = %.2f, want 50", got)
+	}
+
+	// Свежий заказ ещё может завершиться — не трогаем
+	if recovered := svc.RecoverOrders(ctx); len(recovered) != 0 {
+		t.Fatalf("recovered fresh orders: %+v", recovered)
+	}
+
+	_, err = svc.db.Pool.Exec(ctx, `UPDATE orders SET created_at = $2 WHERE id = $1`,
+		order.ID, time.Now().Add(-2*OrderRecoveryAfter))
+	if err != nil {
+		t.Fatalf("backdate order: %v", err)
+	}
+	recovered := svc.RecoverOrders(ctx)
+	if len(recovered) != 1 || recovered[0].ID != order.ID || recovered[0].Status != models.OrderFailed {
+		t.Fatalf("recovered = %+v, want order %d", recovered, order.ID)
+	}
+	if got := userBalance(t, svc, user.ID); got != 200 {
+		t.Errorf("balance after recovery = %.2f, want 200", got)
+	}
+	if vpn.deleted.Load() != 1 {
+		t.Errorf("deleted %d panel clients, want 1", vpn.deleted.Load())
+	}
+
+	// Повторный проход ничего не возвращает второй раз
+	if recovered := svc.RecoverOrders(ctx); len(recovered) != 0 {
+		t.Errorf("recovered again: %+v", recovered)
+	}
+	if got := userBalance(t, svc, user.ID); got != 200 {
+		t.Errorf("balance after second recovery = %.2f, want 200", got)
+	}
+	assertLedgerBalanced(t, svc)
+}
+
+func TestPaidPlanInvoiceActivatesOnce(t *testing.T) {
+	svc := newTestService(t, nil)
+	fake := payment.NewFakeGateway("http://bot.test")
+	svc.SetPaymentGateway(payment.MethodCrypto, fake)
+	plan := newTestPlan(t, svc, 300)
+	user := newTestUser(t, svc, 3005, 0)
+	ctx := context.Background()
+
+	var activated []*PaymentResult
+	svc.OnPayment(func(res *PaymentResult) { activated = append(activated, res) })
+
+	inv, err := svc.CreatePlanInvoice(ctx, user.ID, payment.MethodCrypto, plan, 300)
+	if err != nil {
+		t.Fatalf("CreatePlanInvoice: %v", err)
+	}
+	// Цена плана выросла после выставления счёта: подписка выдаётся по цене счёта
+	price := 500.0
+	plan.Price = &price
+	if err := svc.UpdateProductPlan(ctx, plan); err != nil {
+		t.Fatalf("UpdateProductPlan: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := svc.ProcessPaymentWebhook(ctx, fake.Name(), fakeWebhookRequest(inv.ExternalID)); err != nil {
+			t.Fatalf("webhook %d: %v", i, err)
+		}
+	}
+
+	if len(activated) != 1 {
+		t.Fatalf("payment hook called %d times, want 1", len(activated))
+	}
+	res := activated[0]
+	if res.ActivationErr != nil || res.Subscription == nil {
+		t.Fatalf("activation failed: %v", res.ActivationErr)
+	}
+	if got := userBalance(t, svc, user.ID); got != 0 {
+		t.Errorf("balance = %.2f, want 0", got)
+	}
+
+	// Бонусные дни за оплату криптовалютой добавлены к сроку
+	want := plan.ExpiresFrom(time.Now()).AddDate(0, 0, models.CryptoBonusDays)
+	if d := res.Subscription.ExpiresAt.Sub(want); d < -time.Minute || d > time.Minute {
+		t.Errorf("expires at %s, want about %s", res.Subscription.ExpiresAt, want)
+	}
+
+	paid, err := svc.db.GetInvoiceByID(ctx, inv.ID)
+	if err != nil {
+		t.Fatalf("GetInvoiceByID: %v", err)
+	}
+	if paid.SubscriptionID == nil || *paid.SubscriptionID != res.Subscription.ID {
+		t.Errorf("invoice subscription = %v, want %d", paid.SubscriptionID, res.Subscription.ID)
+	}
+	assertLedgerBalanced(t, svc)
+}
+
+func TestPaidPlanInvoiceActivationRetried(t *testing.T) {
+	vpn := newFlakyVPN()
+	svc := newTestService(t, vpn)
+	fake := payment.NewFakeGateway("http://bot.test")
+	svc.SetPaymentGateway(payment.MethodCard, fake)
+	plan := newTestPlan(t, svc, 300)
+	user := newTestUser(t, svc, 3006, 0)
+	ctx := context.Background()
+
+	var results []*PaymentResult
+	svc.OnPayment(func(res *PaymentResult) { results = append(results, res) })
+
+	inv, err := svc.CreatePlanInvoice(ctx, user.ID, payment.MethodCard, plan, 300)
+	if err != nil {
+		t.Fatalf("CreatePlanInvoice: %v", err)
+	}
+
+	// Панель недоступна: оплата зачислена, подписка не создана, счёт ждёт активации
+	vpn.fail.Store(true)
+	if err := svc.ProcessPaymentWebhook(ctx, fake.Name(), fakeWebhookRequest(inv.ExternalID)); err != nil {
+		t.Fatalf("webhook: %v", err)
+	}
+	if len(results) != 1 || !results[0].Credited || !errors.Is(results[0].ActivationErr, errPanelDown) || !results[0].ActivationRetry {
+		t.Fatalf("first result = %+v, want credited with retryable activation error", results)
+	}
+	pending, err := svc.db.GetInvoiceByID(ctx, inv.ID)
+	if err != nil {
+		t.Fatalf("GetInvoiceByID: %v", err)
+	}
+	if !pending.ActivationPending || pending.SubscriptionID != nil || pending.ActivationError == "" {
+		t.Fatalf("invoice after failed activation: %+v", pending)
+	}
+	if got := userBalance(t, svc, user.ID); got != 300 {
+		t.Fatalf("balance after failed activation = %.2f, want 300", got)
+	}
+
+	// Повтор при ещё недоступной панели молчит
+	svc.RetryInvoiceActivations(ctx, time.Hour)
+	if len(results) != 1 {
+		t.Fatalf("payment hook called %d times while the panel is down, want 1", len(results))
+	}
+
+	// Панель поднялась: повторный вебхук создаёт подписку, опрос после этого ничего не делает
+	vpn.fail.Store(false)
+	if err := svc.ProcessPaymentWebhook(ctx, fake.Name(), fakeWebhookRequest(inv.ExternalID)); err != nil {
+		t.Fatalf("repeated webhook: %v", err)
+	}
+	svc.RetryInvoiceActivations(ctx, time.Hour)
+
+	if len(results) != 2 {
+		t.Fatalf("payment hook called %d times, want 2", len(results))
+	}
+	res := results[1]
+	if res.Credited || res.ActivationErr != nil || res.Subscription == nil {
+		t.Fatalf("retry result = %+v, want activated subscription", res)
+	}
+	if got := userBalance(t, svc, user.ID); got != 0 {
+		t.Errorf("balance after activation = %.2f, want 0", got)
+	}
+
+	activated, err := svc.db.GetInvoiceByID(ctx, inv.ID)
+	if err != nil {
+		t.Fatalf("GetInvoiceByID: %v", err)
+	}
+	if activated.ActivationPending || activated.SubscriptionID == nil || *activated.SubscriptionID != res.Subscription.ID {
+		t.Errorf("invoice after activation: %+v", activated)
+	}
+	assertLedgerBalanced(t, svc)
+}
+
+func TestPaidPlanInvoiceActivationGivesUp(t *testing.T) {
+	svc := newTestService(t, nil)
+	fake := payment.NewFakeGateway("http://bot.test")
+	svc.SetPaymentGateway(payment.MethodCard, fake)
+	plan := newTestPlan(t, svc, 300)
+	user := newTestUser(t, svc, 3007, 0)
+	ctx := context.Background()
+
+	var results []*PaymentResult
+	svc.OnPayment(func(res *PaymentResult) { results = append(results, res) })
+
+	inv, err := svc.CreatePlanInvoice(ctx, user.ID, payment.MethodCard, plan, 300)
+	if err != nil {
+		t.Fatalf("CreatePlanInvoice: %v", err)
+	}
+	// План удалили после выставления счёта: повторять активацию бессмысленно
+	if err := svc.DeleteProductPlan(ctx, plan.ID); err != nil {
+		t.Fatalf("DeleteProductPlan: %v", err)
+	}
+
+	if err := svc.ProcessPaymentWebhook(ctx, fake.Name(), fakeWebhookRequest(inv.ExternalID)); err != nil {
+		t.Fatalf("webhook: %v", err)
+	}
+	if len(results) != 1 || results[0].ActivationErr == nil || results[0].ActivationRetry {
+		t.Fatalf("result = %+v, want final activation error", results)
+	}
+
+	failed, err := svc.db.GetInvoiceByID(ctx, inv.ID)
+	if err != nil {
+		t.Fatalf("GetInvoiceByID: %v", err)
+	}
+	if failed.ActivationPending || failed.ActivationError == "" {
+		t.Errorf("invoice after final failure: %+v", failed)
+	}
+	if got := userBalance(t, svc, user.ID); got != 300 {
+		t.Errorf("balance = %.2f, want 300 left on balance", got)
+	}
+}
+
+func TestPlanExtensionResetsTrafficAfterCommit(t *testing.T) {
+	vpn := newFlakyVPN()
+	svc := New(nil, NewNodeManager(nil, vpn, true))
+	ctx := context.Background()
+
+	limitGB := 100
+	product := &models.Product{ID: 1}
+	plan := &models.ProductPlan{ID: 2, ProductID: 1, Months: 1, TrafficLimitGB: &limitGB}
+	sub := &models.Subscription{ID: 3, ProductID: 1, VPNUsername: "tg_1", ExpiresAt: time.Now().Add(24 * time.Hour)}
+
+	extended, extendVPN := svc.planExtension(sub, product, plan)
+	if extended.TrafficLimit <= 0 {
+		t.Fatalf("traffic limit = %d, want the plan quota", extended.TrafficLimit)
+	}
+
+	// Продление в панели выполняется в транзакции заказа и может откатиться: трафик оно не трогает
+	if err := extendVPN(ctx); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	if got := vpn.resets.Load(); got != 0 {
+		t.Fatalf("traffic reset %d times before the order is completed, want 0", got)
+	}
+
+	svc.resetPlanTraffic(ctx, extended)
+	if got := vpn.resets.Load(); got != 1 {
+		t.Errorf("traffic reset %d times after completion, want 1", got)
+	}
+
+	// Безлимитный план: сбрасывать нечего
+	unlimited := 0
+	plan.TrafficLimitGB = &unlimited
+	extended, _ = svc.planExtension(sub, product, plan)
+	svc.resetPlanTraffic(ctx, extended)
+	if got := vpn.resets.Load(); got != 1 {
+		t.Errorf("traffic reset %d times for an unlimited plan, want 1", got)
+	}
+}
+
+func TestCompensateOrderWithoutDB(t *testing.T) {
+	subID := int64(5)
+	tests := []struct {
+		name        string
+		order       models.Order
+		wantDeleted int32
+	}{
+		// Клиент новой подписки мог быть создан в панели до отмены заказа
+		{name: "new subscription", order: models.Order{ID: 1, Kind: models.OrderNew, VPNUsername: "tg_1_1"}, wantDeleted: 1},
+		// Процесс упал до выбора имени клиента: в панели ничего нет
+		{name: "new subscription without client", order: models.Order{ID: 2, Kind: models.OrderNew}},
+		// Продлевать было нечего: ни панель, ни БД не трогаются
+		{name: "extension without subscription", order: models.Order{ID: 3, Kind: models.OrderExtend}},
+		// Заказ неизвестного вида компенсировать нечем: клиент не удаляется
+		{name: "unknown kind", order: models.Order{ID: 4, Kind: "gift", SubscriptionID: &subID, VPNUsername: "tg_1_4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vpn := newFlakyVPN()
+			svc := New(nil, NewNodeManager(nil, vpn, true))
+
+			svc.compensateOrder(context.Background(), &tt.order)
+			if got := vpn.deleted.Load(); got != tt.wantDeleted {
+				t.Errorf("deleted %d panel clients, want %d", got, tt.wantDeleted)
+			}
+		})
+	}
+}
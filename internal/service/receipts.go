@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"vpn-telegram-bot/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrReceiptReviewed чек уже рассмотрен (или не найден)
+var ErrReceiptReviewed = errors.New("receipt already reviewed")
+
+// ReceiptReview результат проверки чека админом
+type ReceiptReview struct {
+	Transaction        *models.Transaction
+	TelegramID         int64 // владелец чека
+	ReferrerTelegramID *int64
+	ReferralBonus      float64
+}
+
+// SubmitReceipt сохраняет чек СБП как пополнение в статусе pending
+func (s *Service) SubmitReceipt(ctx context.Context, userID int64, amount float64, receiptFileID string) (*models.Transaction, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("invalid amount %.2f", amount)
+	}
+	t, err := s.db.CreateReceiptTransaction(ctx, userID, amount, receiptFileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save receipt: %w", err)
+	}
+	log.Printf("🧾 Receipt #%d submitted: %.2f ₽ (user %d)", t.ID, amount, userID)
+	return t, nil
+}
+
+// GetPendingReceipts возвращает очередь чеков на проверку
+func (s *Service) GetPendingReceipts(ctx context.Context, limit int) ([]models.Transaction, error) {
+	return s.db.GetPendingReceipts(ctx, limit)
+}
+
+// ApproveReceipt подтверждает чек и пополняет баланс пользователя (ровно один раз)
+func (s *Service) ApproveReceipt(ctx context.Context, id, reviewerTelegramID int64) (*ReceiptReview, error) {
+	t, referrerTelegramID, referralBonus, err := s.db.ApproveReceipt(ctx, id, reviewerTelegramID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrReceiptReviewed
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("✅ Receipt #%d approved by %d: %.2f ₽ (user %d)", t.ID, reviewerTelegramID, t.Amount, t.UserID)
+
+	return s.receiptReview(ctx, t, referrerTelegramID, referralBonus)
+}
+
+// RejectReceipt отклоняет чек без пополнения баланса
+func (s *Service) RejectReceipt(ctx context.Context, id, reviewerTelegramID int64) (*ReceiptReview, error) {
+	t, err := s.db.RejectReceipt(ctx, id, reviewerTelegramID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrReceiptReviewed
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("❌ Receipt #%d rejected by %d (user %d)", t.ID, reviewerTelegramID, t.UserID)
+
+	return s.receiptReview(ctx, t, nil, 0)
+}
+
+// receiptReview дополняет результат telegram_id владельца чека
+func (s *Service) receiptReview(ctx context.Context, t *models.Transaction, referrerTelegramID *int64, referralBonus float64) (*ReceiptReview, error) {
+	user, err := s.db.GetUserByID(ctx, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &ReceiptReview{
+		Transaction:        t,
+		TelegramID:         user.TelegramID,
+		ReferrerTelegramID: referrerTelegramID,
+		ReferralBonus:      referralBonus,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrRefundUnavailable отменить подписку нельзя: она не найдена, уже отключена, истекла или изменилась
+var ErrRefundUnavailable = errors.New("refund unavailable")
+
+// RefundQuote расчёт возврата за неиспользованные дни подписки
+type RefundQuote struct {
+	Sub        *models.Subscription
+	Purchase   *models.Transaction // последняя оплата подписки (nil — подарок, пробный период или покупка до учёта оплат)
+	Paid       float64             // сумма невозвращённых оплат подписки
+	DailyRate  float64             // стоимость дня по последней оплате
+	UnusedDays int
+	Amount     float64 // к возврату: неиспользованные дни по DailyRate, не больше Paid
+}
+
+// QuoteRefund рассчитывает возврат за неиспользованные полные дни активной подписки
+func (s *Service) QuoteRefund(ctx context.Context, subID int64) (*RefundQuote, error) {
+	sub, err := s.db.GetSubscriptionByID(ctx, subID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: subscription %d", ErrRefundUnavailable, subID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !sub.IsActive || !sub.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: subscription %d is not active", ErrRefundUnavailable, subID)
+	}
+
+	quote := &RefundQuote{
+		Sub:        sub,
+		UnusedDays: int(time.Until(sub.ExpiresAt).Hours() / 24),
+	}
+
+	purchases, err := s.db.GetSubscriptionPurchases(ctx, sub.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load purchases: %w", err)
+	}
+	if len(purchases) == 0 {
+		return quote, nil
+	}
+	quote.Purchase = &purchases[0]
+	for _, p := range purchases {
+		quote.Paid += -p.Amount
+	}
+
+	quote.DailyRate = s.purchaseDailyRate(ctx, sub, quote.Purchase)
+	quote.Amount = math.Floor(math.Min(quote.DailyRate*float64(quote.UnusedDays), quote.Paid))
+	return quote, nil
+}
+
+// purchaseDailyRate стоимость дня подписки по оплате: сумма, делённая на срок купленного плана.
+// Если план удалён, считается по базовой цене продукта за месяц
+func (s *Service) purchaseDailyRate(ctx context.Context, sub *models.Subscription, purchase *models.Transaction) float64 {
+	if sub.PlanID != nil {
+		if plan, err := s.db.GetProductPlanByID(ctx, *sub.PlanID); err == nil {
+			days := plan.ExpiresFrom(purchase.CreatedAt).Sub(purchase.CreatedAt).Hours() / 24
+			if days >= 1 {
+				return -purchase.Amount / days
+			}
+		}
+	}
+	if sub.Product != nil {
+		return sub.Product.BasePrice / models.DaysPerMonth
+	}
+	return 0
+}
+
+// AdminCancelSubscription досрочно отменяет подписку по решению админа: возвращает стоимость
+// неиспользованных дней на баланс (models.RefundToBalance) или отмечает возврат вне бота (models.RefundExternal),
+// отключает подписку и удаляет клиента из VPN панели. Всё, кроме удаления в панели, — одной транзакцией БД
+func (s *Service) AdminCancelSubscription(ctx context.Context, adminTelegramID, subID int64, method string) (*RefundQuote, error) {
+	if method != models.RefundToBalance && method != models.RefundExternal {
+		return nil, fmt.Errorf("unknown refund method %q", method)
+	}
+
+	quote, err := s.QuoteRefund(ctx, subID)
+	if err != nil {
+		return nil, err
+	}
+	sub := quote.Sub
+
+	var refund *models.Transaction
+	if quote.Amount > 0 {
+		refund = &models.Transaction{
+			UserID:       sub.UserID,
+			Amount:       quote.Amount,
+			RefundOf:     &quote.Purchase.ID,
+			RefundMethod: method,
+		}
+	}
+
+	details := fmt.Sprintf("%d unused days, no refund", quote.UnusedDays)
+	if refund != nil {
+		details = fmt.Sprintf("%d unused days, refund %.2f to %s (purchase %d)", quote.UnusedDays, quote.Amount, method, quote.Purchase.ID)
+	}
+	audit := &models.AuditEntry{
+		ActorID:        adminTelegramID,
+		ActorRole:      models.AuditActorAdmin,
+		Action:         models.AuditSubscriptionCancel,
+		UserID:         &sub.UserID,
+		SubscriptionID: &sub.ID,
+		Details:        details,
+	}
+
+	cancelled, err := s.db.CancelSubscription(ctx, sub.ID, sub.ExpiresAt, refund, audit, func(ctx context.Context) error {
+		if sub.VPNUsername == "" {
+			return nil
+		}
+		err := s.nodes.Provider(sub.NodeID).DeleteUser(ctx, sub.VPNUsername)
+		if err != nil && !errors.Is(err, ErrVPNUserNotFound) {
+			return fmt.Errorf("failed to delete VPN user %s: %w", sub.VPNUsername, err)
+		}
+		return nil
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: subscription %d was changed concurrently", ErrRefundUnavailable, sub.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel subscription: %w", err)
+	}
+
+	log.Printf("💸 Subscription %d cancelled by admin %d: %s", sub.ID, adminTelegramID, details)
+	cancelled.Product = sub.Product
+	quote.Sub = cancelled
+	return quote, nil
+}
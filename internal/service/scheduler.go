@@ -0,0 +1,285 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"vpn-telegram-bot/internal/i18n"
+	"vpn-telegram-bot/internal/models"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// SchedulerConfig конфигурация планировщика подписок
+type SchedulerConfig struct {
+	CheckInterval         time.Duration
+	CampaignCheckInterval time.Duration // как часто проверять начало запланированных акций
+}
+
+// DefaultSchedulerConfig возвращает конфигурацию по умолчанию
+func DefaultSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		CheckInterval:         10 * time.Minute,
+		CampaignCheckInterval: time.Minute,
+	}
+}
+
+// Scheduler фоновый сервис: автопродление и напоминания об окончании подписок, их отключение,
+// ежемесячный сброс квот трафика и анонс начавшихся акций
+type Scheduler struct {
+	bot    *tele.Bot
+	svc    *Service
+	config SchedulerConfig
+
+	mu        sync.Mutex
+	isRunning bool
+	stopChan  chan struct{}
+}
+
+// NewScheduler создаёт новый Scheduler
+func NewScheduler(bot *tele.Bot, svc *Service, config SchedulerConfig) *Scheduler {
+	return &Scheduler{
+		bot:      bot,
+		svc:      svc,
+		config:   config,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start запускает планировщик
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	if s.isRunning {
+		s.mu.Unlock()
+		return
+	}
+	s.isRunning = true
+	s.mu.Unlock()
+
+	log.Println("⏰ Scheduler started")
+
+	go s.runLoop()
+}
+
+// Stop останавливает планировщик
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if !s.isRunning {
+		s.mu.Unlock()
+		return
+	}
+	s.isRunning = false
+	s.mu.Unlock()
+
+	close(s.stopChan)
+	log.Println("⏰ Scheduler stopped")
+}
+
+func (s *Scheduler) runLoop() {
+	ticker := time.NewTicker(s.config.CheckInterval)
+	defer ticker.Stop()
+	campaignTicker := time.NewTicker(s.config.CampaignCheckInterval)
+	defer campaignTicker.Stop()
+
+	// Первая проверка сразу после старта
+	s.checkSubscriptions()
+	s.svc.AnnounceStartedCampaigns(context.Background())
+
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+			s.checkSubscriptions()
+		case <-campaignTicker.C:
+			s.svc.AnnounceStartedCampaigns(context.Background())
+		}
+	}
+}
+
+func (s *Scheduler) checkSubscriptions() {
+	ctx := context.Background()
+	now := time.Now()
+
+	// Сначала возврат денег по брошенным заказам, затем автопродление, истёкшие и напоминания
+	s.processAbandonedOrders(ctx)
+	s.processAutoRenewals(ctx)
+	s.processExpired(ctx, now)
+	s.processReminders(ctx, models.Reminder1Day, now, 0, 24*time.Hour)
+	s.processReminders(ctx, models.Reminder3Days, now, 24*time.Hour, 72*time.Hour)
+
+	s.svc.ResetDueTraffic(ctx)
+	s.svc.PurgeCallbacks(ctx)
+}
+
+// processReminders отправляет напоминания по подпискам, истекающим в (now+from, now+to].
+// Подписки не длиннее to не напоминаются: они попали бы в окно сразу после выдачи
+func (s *Scheduler) processReminders(ctx context.Context, kind string, now time.Time, from, to time.Duration) {
+	subs, err := s.svc.GetSubscriptionsExpiringBetween(ctx, now.Add(from), now.Add(to), kind, to)
+	if err != nil {
+		log.Printf("Scheduler: failed to get expiring subscriptions (%s): %v", kind, err)
+		return
+	}
+
+	for i := range subs {
+		sub := &subs[i]
+
+		// Сначала фиксируем напоминание, чтобы после рестарта не было дублей
+		claimed, err := s.svc.ClaimReminder(ctx, sub.ID, kind, sub.ExpiresAt)
+		if err != nil {
+			log.Printf("Scheduler: failed to record reminder %s for sub %d: %v", kind, sub.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		lang, _ := s.userLocale(ctx, sub.UserID)
+		s.notify(lang, sub.TelegramID, s.formatReminder(lang, sub), sub.ID)
+	}
+}
+
+// processExpired отключает истёкшие подписки и уведомляет владельцев
+func (s *Scheduler) processExpired(ctx context.Context, now time.Time) {
+	subs, err := s.svc.GetSubscriptionsExpiringBetween(ctx, time.Time{}, now, models.ReminderExpired, 0)
+	if err != nil {
+		log.Printf("Scheduler: failed to get expired subscriptions: %v", err)
+		return
+	}
+
+	for i := range subs {
+		sub := &subs[i]
+
+		// Отключаем в панели и в БД; при ошибке панели повторим на следующем тике
+		if err := s.svc.DeactivateSubscription(ctx, &sub.Subscription); err != nil {
+			log.Printf("Scheduler: failed to deactivate sub %d: %v", sub.ID, err)
+			continue
+		}
+
+		claimed, err := s.svc.ClaimReminder(ctx, sub.ID, models.ReminderExpired, sub.ExpiresAt)
+		if err != nil {
+			log.Printf("Scheduler: failed to record expiry notice for sub %d: %v", sub.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		lang, _ := s.userLocale(ctx, sub.UserID)
+		s.notify(lang, sub.TelegramID, s.formatExpired(lang, sub), sub.ID)
+	}
+
+	if len(subs) > 0 {
+		log.Printf("⏰ Scheduler: processed %d expired subscriptions", len(subs))
+	}
+}
+
+// processAutoRenewals продлевает подписки с автопродлением и сообщает владельцам об успехе
+// или о нехватке средств (остальные ошибки только логируются — попытка повторится позже)
+func (s *Scheduler) processAutoRenewals(ctx context.Context) {
+	for _, res := range s.svc.RunAutoRenewals(ctx) {
+		sub := res.Sub
+		lang, cur := s.userLocale(ctx, sub.UserID)
+		switch {
+		case res.Err == nil:
+			s.notify(lang, sub.TelegramID, s.formatAutoRenewed(lang, &res, cur), sub.ID)
+		case errors.Is(res.Err, ErrInsufficientBalance):
+			menu := &tele.ReplyMarkup{}
+			menu.Inline(
+				menu.Row(menu.Data(i18n.T(lang, "common.topup"), "topup")),
+				menu.Row(menu.Data(i18n.T(lang, "sub.extend"), "extend", strconv.FormatInt(sub.ID, 10))),
+			)
+			if _, err := s.bot.Send(&tele.User{ID: sub.TelegramID}, s.formatAutoRenewFailed(lang, &res, cur), menu, tele.ModeMarkdown); err != nil {
+				log.Printf("Scheduler: failed to notify user %d about auto-renew of sub %d: %v", sub.TelegramID, sub.ID, err)
+			}
+		}
+	}
+}
+
+// processAbandonedOrders отменяет покупки, прерванные падением бота, и сообщает пользователям о возврате
+func (s *Scheduler) processAbandonedOrders(ctx context.Context) {
+	for _, order := range s.svc.RecoverOrders(ctx) {
+		user, err := s.svc.GetUserByID(ctx, order.UserID)
+		if err != nil {
+			log.Printf("Scheduler: failed to get user %d of order %d: %v", order.UserID, order.ID, err)
+			continue
+		}
+		text := i18n.T(s.svc.UserLanguage(user), "order.abandoned", s.svc.UserCurrency(ctx, user).Format(order.Amount))
+		if _, err := s.bot.Send(&tele.User{ID: user.TelegramID}, text, tele.ModeMarkdown); err != nil {
+			log.Printf("Scheduler: failed to notify user %d about order %d: %v", user.TelegramID, order.ID, err)
+		}
+	}
+}
+
+// userLocale язык и валюта владельца подписки (по умолчанию, если пользователя не удалось загрузить)
+func (s *Scheduler) userLocale(ctx context.Context, userID int64) (string, *models.Currency) {
+	user, err := s.svc.GetUserByID(ctx, userID)
+	if err != nil {
+		log.Printf("Scheduler: failed to get user %d: %v", userID, err)
+		return i18n.Default, models.RUB
+	}
+	return s.svc.UserLanguage(user), s.svc.UserCurrency(ctx, user)
+}
+
+// notify отправляет пользователю сообщение с кнопкой продления
+func (s *Scheduler) notify(lang string, telegramID int64, text string, subID int64) {
+	menu := &tele.ReplyMarkup{}
+	menu.Inline(
+		menu.Row(menu.Data(i18n.T(lang, "sub.extend"), "extend", strconv.FormatInt(subID, 10))),
+	)
+
+	if _, err := s.bot.Send(&tele.User{ID: telegramID}, text, menu, tele.ModeMarkdown); err != nil {
+		log.Printf("Scheduler: failed to notify user %d about sub %d: %v", telegramID, subID, err)
+	}
+}
+
+func (s *Scheduler) formatReminder(lang string, sub *models.ExpiringSubscription) string {
+	return i18n.T(lang, "reminder.expiring",
+		sub.Product.CountryFlag, sub.Product.Name, sub.ID,
+		sub.ExpiresAt.Format("02.01.2006 15:04"),
+		formatTimeLeft(lang, time.Until(sub.ExpiresAt)))
+}
+
+func (s *Scheduler) formatExpired(lang string, sub *models.ExpiringSubscription) string {
+	return i18n.T(lang, "reminder.expired",
+		sub.Product.CountryFlag, sub.Product.Name, sub.ID,
+		sub.ExpiresAt.Format("02.01.2006 15:04"))
+}
+
+func (s *Scheduler) formatAutoRenewed(lang string, res *AutoRenewResult, cur *models.Currency) string {
+	sub := res.Sub
+	return i18n.T(lang, "autorenew.done",
+		sub.Product.CountryFlag, sub.Product.Name, sub.ID,
+		PlanPeriodTitle(lang, res.Plan), res.ExpiresAt.Format("02.01.2006"),
+		cur.Format(res.Price), cur.Format(res.Balance))
+}
+
+func (s *Scheduler) formatAutoRenewFailed(lang string, res *AutoRenewResult, cur *models.Currency) string {
+	sub := res.Sub
+	return i18n.T(lang, "autorenew.insufficient",
+		sub.Product.CountryFlag, sub.Product.Name, sub.ID,
+		sub.ExpiresAt.Format("02.01.2006 15:04"),
+		cur.Format(res.Balance), PlanPeriodTitle(lang, res.Plan), cur.Format(res.Price))
+}
+
+// formatTimeLeft форматирует оставшееся время на языке lang: "2 дня 5 часов" / "7 часов"
+func formatTimeLeft(lang string, d time.Duration) string {
+	if d < time.Hour {
+		return i18n.T(lang, "time.less_hour")
+	}
+
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+
+	if days == 0 {
+		return i18n.N(lang, "time.hours", hours)
+	}
+	if hours == 0 {
+		return i18n.N(lang, "period.days", days)
+	}
+	return i18n.N(lang, "period.days", days) + " " + i18n.N(lang, "time.hours", hours)
+}
@@ -2,24 +2,37 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"vpn-telegram-bot/internal/database"
 	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/payment"
 )
 
+// ErrInsufficientBalance на балансе недостаточно средств
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
 // Service бизнес-логика приложения
 type Service struct {
-	db  *database.DB
-	vpn VPNProvider
+	db        *database.DB
+	nodes     *NodeManager
+	gateways  map[string]payment.PaymentGateway // способ оплаты -> шлюз
+	starsRate float64                           // ₽ за одну звезду, 0 = оплата Stars выключена
+	trial     *TrialSettings                    // nil = пробный период выключен
+
+	paymentHook  func(*PaymentResult)
+	campaignHook func(*models.Campaign)
 }
 
 // New создаёт новый сервис
-func New(db *database.DB, vpn VPNProvider) *Service {
+func New(db *database.DB, nodes *NodeManager) *Service {
 	return &Service{
-		db:  db,
-		vpn: vpn,
+		db:       db,
+		nodes:    nodes,
+		gateways: make(map[string]payment.PaymentGateway),
 	}
 }
 
@@ -48,52 +61,42 @@ func (s *Service) GetSubscriptionByID(ctx context.Context, id int64) (*models.Su
 	return s.db.GetSubscriptionByID(ctx, id)
 }
 
-// CalculatePrice рассчитывает цену с учётом скидки
-func (s *Service) CalculatePrice(basePrice float64, months int) (float64, int) {
-	var discount int
-	switch months {
-	case 6:
-		discount = 10
-	case 12:
-		discount = 20
-	default:
-		discount = 0
-	}
-
-	price := basePrice * float64(months)
-	if discount > 0 {
-		price = price * (1 - float64(discount)/100)
-	}
-
-	return price, discount
-}
-
-// GetPricingPlans возвращает все планы с ценами
-func (s *Service) GetPricingPlans(basePrice float64) []models.PricingPlan {
-	return models.CalculatePricingPlans(basePrice)
-}
-
-// CreateSubscription создаёт новую подписку
-func (s *Service) CreateSubscription(ctx context.Context, user *models.User, productID int64, months int) (*models.Subscription, error) {
-	product, err := s.db.GetProductByID(ctx, productID)
+// CreateSubscription создаёт новую подписку по плану
+func (s *Service) CreateSubscription(ctx context.Context, user *models.User, plan *models.ProductPlan) (*models.Subscription, error) {
+	product, err := s.db.GetProductByID(ctx, plan.ProductID)
 	if err != nil {
 		return nil, fmt.Errorf("product not found: %w", err)
 	}
 
 	// Рассчитываем дату истечения
-	expiresAt := time.Now().AddDate(0, months, 0)
+	expiresAt := plan.ExpiresFrom(time.Now())
 
 	// Генерируем username для VPN
 	vpnUsername := fmt.Sprintf("tg_%d_%d", user.TelegramID, time.Now().Unix())
 
-	// Создаём пользователя в VPN панели
-	keyString, err := s.vpn.CreateUser(ctx, vpnUsername, product.MarzbanTag, expiresAt)
+	return s.createVPNSubscription(ctx, user.ID, product, &plan.ID, plan.Limits(product), vpnUsername, expiresAt)
+}
+
+// createVPNSubscription создаёт клиента с ограничениями плана на наименее загруженной ноде продукта и сохраняет подписку.
+// planID — купленный план (nil для подарков и пробного периода), по нему работает автопродление
+func (s *Service) createVPNSubscription(ctx context.Context, userID int64, product *models.Product, planID *int64, limits models.SubscriptionLimits, vpnUsername string, expiresAt time.Time) (*models.Subscription, error) {
+	node, vpn, err := s.nodes.PickNode(ctx, product.ID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create VPN user: %w", err)
+		return nil, fmt.Errorf("failed to pick node: %w", err)
+	}
+
+	var nodeID *int64
+	if node != nil {
+		nodeID = &node.ID
+	}
+
+	sub := newVPNSubscription(userID, product, nodeID, planID, limits, vpnUsername, expiresAt)
+	if err := createVPNUser(ctx, vpn, sub, product); err != nil {
+		return nil, err
 	}
 
 	// Сохраняем подписку в БД
-	sub, err := s.db.CreateSubscription(ctx, user.ID, productID, keyString, expiresAt)
+	sub, err = s.db.CreateSubscription(ctx, sub)
 	if err != nil {
 		return nil, fmt.Errorf("failed to save subscription: %w", err)
 	}
@@ -102,26 +105,123 @@ func (s *Service) CreateSubscription(ctx context.Context, user *models.User, pro
 	return sub, nil
 }
 
-// ExtendSubscription продлевает подписку
-func (s *Service) ExtendSubscription(ctx context.Context, subID int64, months int) error {
-	sub, err := s.db.GetSubscriptionByID(ctx, subID)
+// newVPNSubscription подписка на ноде nodeID (nil — панель по умолчанию), ещё не созданная ни в панели, ни в БД
+func newVPNSubscription(userID int64, product *models.Product, nodeID *int64, planID *int64, limits models.SubscriptionLimits, vpnUsername string, expiresAt time.Time) *models.Subscription {
+	return &models.Subscription{
+		UserID:         userID,
+		ProductID:      product.ID,
+		NodeID:         nodeID,
+		PlanID:         planID,
+		VPNUsername:    vpnUsername,
+		ExpiresAt:      expiresAt,
+		TrafficLimit:   limits.Traffic.Bytes(),
+		TrafficReset:   limits.Traffic.Reset,
+		TrafficResetAt: limits.Traffic.NextReset(time.Now()),
+		DeviceLimit:    limits.Devices,
+	}
+}
+
+// createVPNUser создаёт пользователя подписки в VPN панели и запоминает его ключ
+func createVPNUser(ctx context.Context, vpn VPNProvider, sub *models.Subscription, product *models.Product) error {
+	keyString, err := vpn.CreateUser(ctx, sub.VPNUsername, product.MarzbanTag, sub.ExpiresAt, vpnLimits(sub))
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create VPN user: %w", err)
 	}
+	sub.KeyString = keyString
+	return nil
+}
 
+// planExtension подписка после продления по плану и продление клиента в VPN панели (nil — у подписки нет клиента).
+// Квота трафика назначается по плану и начинается заново: докупленный трафик обнуляется, а израсходованный
+// сбрасывается в панели отдельно (resetPlanTraffic) — уже после сохранения продления, потому что сброс не откатить
+func (s *Service) planExtension(sub *models.Subscription, product *models.Product, plan *models.ProductPlan) (*models.Subscription, func(ctx context.Context) error) {
 	// Если подписка истекла, продлеваем от текущей даты
 	baseTime := sub.ExpiresAt
 	if baseTime.Before(time.Now()) {
 		baseTime = time.Now()
 	}
 
-	newExpiresAt := baseTime.AddDate(0, months, 0)
+	limits := plan.Limits(product)
+	extended := *sub
+	extended.ExpiresAt = plan.ExpiresFrom(baseTime)
+	extended.TrafficLimit = limits.Traffic.Bytes()
+	extended.TrafficExtra = 0
+	extended.TrafficReset = limits.Traffic.Reset
+	extended.TrafficResetAt = limits.Traffic.NextReset(time.Now())
+	extended.DeviceLimit = limits.Devices
+	extended.ExtraDevices = 0
+	extended.PlanID = &plan.ID
+
+	// Старые подписки могут быть без username (не удалось восстановить из ключа)
+	if sub.VPNUsername == "" {
+		log.Printf("⚠️ Subscription %d has no vpn_username, extending only in DB", sub.ID)
+		return &extended, nil
+	}
+
+	return &extended, func(ctx context.Context) error {
+		vpn := s.nodes.Provider(sub.NodeID)
+		if err := vpn.ExtendUser(ctx, sub.VPNUsername, extended.ExpiresAt, vpnLimits(&extended)); err != nil {
+			return fmt.Errorf("failed to extend VPN user %s: %w", sub.VPNUsername, err)
+		}
+		return nil
+	}
+}
+
+// resetPlanTraffic сбрасывает израсходованный трафик продлённой подписки с квотой.
+// Продление уже сохранено, поэтому ошибка только логируется: квоту обнулит следующий плановый сброс
+func (s *Service) resetPlanTraffic(ctx context.Context, sub *models.Subscription) {
+	if sub.TrafficLimit <= 0 || sub.VPNUsername == "" {
+		return
+	}
+	if err := s.nodes.Provider(sub.NodeID).ResetTraffic(ctx, sub.VPNUsername); err != nil {
+		log.Printf("⚠️ Subscription %d: failed to reset traffic of VPN user %s: %v", sub.ID, sub.VPNUsername, err)
+	}
+}
+
+// vpnLimits ограничения подписки для VPN панели с учётом докупленных трафика и устройств
+func vpnLimits(sub *models.Subscription) UserLimits {
+	return UserLimits{DataLimit: sub.TrafficQuotaBytes(), DeviceLimit: sub.DeviceLimitTotal()}
+}
+
+// applyVPNLimits применяет текущие ограничения подписки в VPN панели, не меняя срок
+func (s *Service) applyVPNLimits(ctx context.Context, sub *models.Subscription) error {
+	if sub.VPNUsername == "" {
+		return fmt.Errorf("subscription %d has no vpn_username", sub.ID)
+	}
+	if err := s.nodes.Provider(sub.NodeID).ExtendUser(ctx, sub.VPNUsername, sub.ExpiresAt, vpnLimits(sub)); err != nil {
+		return fmt.Errorf("failed to update VPN user %s limits: %w", sub.VPNUsername, err)
+	}
+	return nil
+}
+
+// GetSubscriptionTraffic возвращает расход трафика подписки по данным VPN панели
+func (s *Service) GetSubscriptionTraffic(ctx context.Context, sub *models.Subscription) (*VPNSubscription, error) {
+	if sub.VPNUsername == "" {
+		return nil, fmt.Errorf("subscription %d has no vpn_username", sub.ID)
+	}
+	return s.nodes.Provider(sub.NodeID).GetSubscription(ctx, sub.VPNUsername)
+}
 
-	// Продлеваем в VPN панели
-	// vpnUsername можно извлечь из key_string или хранить отдельно
-	// TODO: implement proper username extraction
+// GetSubscriptionsExpiringBetween возвращает активные подписки длиннее minLength с окончанием в (from, to] без напоминания kind
+func (s *Service) GetSubscriptionsExpiringBetween(ctx context.Context, from, to time.Time, kind string, minLength time.Duration) ([]models.ExpiringSubscription, error) {
+	return s.db.GetActiveSubscriptionsExpiringBetween(ctx, from, to, kind, minLength)
+}
+
+// ClaimReminder отмечает напоминание отправленным (false = уже было)
+func (s *Service) ClaimReminder(ctx context.Context, subID int64, kind string, expiresAt time.Time) (bool, error) {
+	return s.db.ClaimReminder(ctx, subID, kind, expiresAt)
+}
+
+// DeactivateSubscription отключает истёкшую подписку в VPN панели и в БД
+func (s *Service) DeactivateSubscription(ctx context.Context, sub *models.Subscription) error {
+	if sub.VPNUsername != "" {
+		err := s.nodes.Provider(sub.NodeID).DisableUser(ctx, sub.VPNUsername)
+		if err != nil && !errors.Is(err, ErrVPNUserNotFound) {
+			return fmt.Errorf("failed to disable VPN user %s: %w", sub.VPNUsername, err)
+		}
+	}
 
-	return s.db.ExtendSubscription(ctx, subID, newExpiresAt)
+	return s.db.DeactivateSubscription(ctx, sub.ID, sub.ExpiresAt)
 }
 
 // === Admin Methods ===
@@ -196,20 +296,7 @@ func (s *Service) GiftSubscription(ctx context.Context, telegramID int64, produc
 	// Генерируем username для VPN
 	vpnUsername := fmt.Sprintf("gift_tg_%d_%d", user.TelegramID, time.Now().Unix())
 
-	// Создаём пользователя в VPN панели
-	keyString, err := s.vpn.CreateUser(ctx, vpnUsername, product.MarzbanTag, expiresAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create VPN user: %w", err)
-	}
-
-	// Сохраняем подписку в БД
-	sub, err := s.db.CreateSubscription(ctx, user.ID, productID, keyString, expiresAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to save subscription: %w", err)
-	}
-
-	sub.Product = product
-	return sub, nil
+	return s.createVPNSubscription(ctx, user.ID, product, nil, product.Limits(), vpnUsername, expiresAt)
 }
 
 // GetAllUserTelegramIDs возвращает все telegram_id для рассылки
@@ -222,6 +309,11 @@ func (s *Service) GetUserByTelegramID(ctx context.Context, telegramID int64) (*m
 	return s.db.GetUserByTelegramID(ctx, telegramID)
 }
 
+// GetUserByID получает пользователя по внутреннему ID
+func (s *Service) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	return s.db.GetUserByID(ctx, id)
+}
+
 // === Referral System ===
 
 // UserExists проверяет существует ли пользователь
@@ -259,42 +351,6 @@ func (s *Service) TopUpBalanceWithReferral(ctx context.Context, userID int64, am
 	return s.db.TopUpBalanceWithReferral(ctx, userID, amount)
 }
 
-// DeductBalance списывает баланс пользователя
-func (s *Service) DeductBalance(ctx context.Context, userID int64, amount float64) error {
-	return s.db.DeductBalance(ctx, userID, amount)
-}
-
-// CreateSubscription создаёт новую подписку (упрощённая версия для оплаты с баланса)
-func (s *Service) CreateSubscriptionSimple(ctx context.Context, userID int64, productID int64, expiresAt time.Time) (*models.Subscription, error) {
-	product, err := s.db.GetProductByID(ctx, productID)
-	if err != nil {
-		return nil, fmt.Errorf("product not found: %w", err)
-	}
-
-	user, err := s.db.GetUserByID(ctx, userID)
-	if err != nil {
-		return nil, fmt.Errorf("user not found: %w", err)
-	}
-
-	// Генерируем username для VPN
-	vpnUsername := fmt.Sprintf("tg_%d_%d", user.TelegramID, time.Now().Unix())
-
-	// Создаём пользователя в VPN панели
-	keyString, err := s.vpn.CreateUser(ctx, vpnUsername, product.MarzbanTag, expiresAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create VPN user: %w", err)
-	}
-
-	// Сохраняем подписку в БД
-	sub, err := s.db.CreateSubscription(ctx, userID, productID, keyString, expiresAt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to save subscription: %w", err)
-	}
-
-	sub.Product = product
-	return sub, nil
-}
-
 // parseIntSafe безопасно парсит int64
 func parseIntSafe(s string) (int64, error) {
 	var result int64
@@ -366,6 +422,23 @@ func (s *Service) GetTopReferrers(ctx context.Context) ([]*models.TopReferrer, e
 	return s.db.GetTopReferrers(ctx, 10)
 }
 
+// ================= NODES =================
+
+// GetNodesStatus возвращает ноды с загрузкой и состоянием
+func (s *Service) GetNodesStatus(ctx context.Context) ([]NodeStatus, error) {
+	return s.nodes.Status(ctx)
+}
+
+// ReloadNodes перечитывает ноды из БД
+func (s *Service) ReloadNodes(ctx context.Context) error {
+	return s.nodes.Reload(ctx)
+}
+
+// SetNodeEnabled включает или выключает ноду
+func (s *Service) SetNodeEnabled(ctx context.Context, nodeID int64, enabled bool) error {
+	return s.db.SetNodeEnabled(ctx, nodeID, enabled)
+}
+
 // GetPromoStats возвращает статистику по промокодам
 func (s *Service) GetPromoStats(ctx context.Context) ([]*models.PromoStats, error) {
 	return s.db.GetPromoStats(ctx)
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"vpn-telegram-bot/internal/models"
+	"vpn-telegram-bot/internal/payment"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// StarsCurrency валюта Telegram Stars
+const StarsCurrency = "XTR"
+
+// Ошибки проверки pre_checkout_query
+var (
+	ErrStarsInvoiceInvalid = errors.New("stars invoice is not payable")
+	ErrStarsPriceChanged   = errors.New("stars price has changed")
+)
+
+// EnableStars включает оплату Telegram Stars с курсом rubPerStar (₽ за одну звезду)
+func (s *Service) EnableStars(rubPerStar float64) {
+	s.starsRate = rubPerStar
+	log.Printf("⭐️ Telegram Stars payments enabled: %.2f ₽ per star", rubPerStar)
+}
+
+// StarsEnabled проверяет, включена ли оплата Telegram Stars
+func (s *Service) StarsEnabled() bool {
+	return s.starsRate > 0
+}
+
+// RubToStars пересчитывает цену в рублях в звёзды (с округлением вверх)
+func (s *Service) RubToStars(amount float64) int {
+	return max(1, int(math.Ceil(amount/s.starsRate-0.0001)))
+}
+
+// StarsPayload payload счёта Telegram по ID счёта в БД
+func StarsPayload(invoiceID int64) string {
+	return fmt.Sprintf("inv:%d", invoiceID)
+}
+
+// parseStarsPayload извлекает ID счёта из payload
+func parseStarsPayload(payload string) (int64, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(payload, "inv:"), 10, 64)
+	if err != nil || !strings.HasPrefix(payload, "inv:") {
+		return 0, fmt.Errorf("%w: bad payload %q", ErrStarsInvoiceInvalid, payload)
+	}
+	return id, nil
+}
+
+// CreateStarsTopUpInvoice создаёт счёт на пополнение баланса в Telegram Stars
+func (s *Service) CreateStarsTopUpInvoice(ctx context.Context, userID int64, amount float64) (*models.Invoice, error) {
+	return s.createStarsInvoice(ctx, &models.Invoice{
+		UserID:  userID,
+		Purpose: models.InvoicePurposeTopUp,
+		Amount:  amount,
+	})
+}
+
+// CreateStarsPlanInvoice создаёт счёт на покупку подписки в Telegram Stars
+func (s *Service) CreateStarsPlanInvoice(ctx context.Context, userID int64, plan *models.ProductPlan, price float64) (*models.Invoice, error) {
+	if _, err := s.db.GetProductByID(ctx, plan.ProductID); err != nil {
+		return nil, fmt.Errorf("product not found: %w", err)
+	}
+
+	return s.createStarsInvoice(ctx, planInvoice(userID, plan, price))
+}
+
+// createStarsInvoice сохраняет счёт Stars: ID платежа (telegram_payment_charge_id) появится после оплаты
+func (s *Service) createStarsInvoice(ctx context.Context, inv *models.Invoice) (*models.Invoice, error) {
+	if !s.StarsEnabled() {
+		return nil, errors.New("telegram stars payments are not enabled")
+	}
+
+	inv.Gateway = models.GatewayStars
+	inv.AmountStars = s.RubToStars(inv.Amount)
+	created, err := s.db.CreateInvoice(ctx, inv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invoice: %w", err)
+	}
+	return created, nil
+}
+
+// ValidateStarsCheckout проверяет pre_checkout_query: счёт не оплачен, принадлежит пользователю,
+// сумма совпадает, а для подписки план по-прежнему продаётся и его цена (с учётом действующих акций) не изменилась
+func (s *Service) ValidateStarsCheckout(ctx context.Context, telegramID int64, payload string, totalStars int) (*models.Invoice, error) {
+	invoiceID, err := parseStarsPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := s.db.GetInvoiceByID(ctx, invoiceID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: invoice %d not found", ErrStarsInvoiceInvalid, invoiceID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if inv.Gateway != models.GatewayStars || inv.Status != models.InvoicePending || inv.ExternalID != "" {
+		return nil, fmt.Errorf("%w: invoice %d is %s", ErrStarsInvoiceInvalid, inv.ID, inv.Status)
+	}
+	if totalStars != inv.AmountStars {
+		return nil, fmt.Errorf("%w: invoice %d total %d, expected %d", ErrStarsInvoiceInvalid, inv.ID, totalStars, inv.AmountStars)
+	}
+
+	user, err := s.db.GetUserByID(ctx, inv.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TelegramID != telegramID {
+		return nil, fmt.Errorf("%w: invoice %d belongs to another user", ErrStarsInvoiceInvalid, inv.ID)
+	}
+
+	if inv.Purpose != models.InvoicePurposeSubscription {
+		return inv, nil
+	}
+
+	if inv.PlanID == nil {
+		return nil, fmt.Errorf("%w: invoice %d has no plan", ErrStarsInvoiceInvalid, inv.ID)
+	}
+	plan, product, err := s.GetPlanForSale(ctx, *inv.PlanID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invoice %d: %v", ErrStarsInvoiceInvalid, inv.ID, err)
+	}
+	if plan.Months != inv.Months || plan.Days != inv.Days {
+		return nil, fmt.Errorf("%w: invoice %d: plan %d period changed", ErrStarsInvoiceInvalid, inv.ID, plan.ID)
+	}
+
+	price, err := s.ResolvePlanPrice(ctx, product, plan)
+	if err != nil {
+		return nil, err
+	}
+	if s.RubToStars(price.Price) != inv.AmountStars {
+		return nil, fmt.Errorf("%w: invoice %d", ErrStarsPriceChanged, inv.ID)
+	}
+
+	return inv, nil
+}
+
+// ProcessStarsPayment зачисляет successful_payment. telegram_payment_charge_id сохраняется как ID платежа счёта,
+// поэтому повторное обновление с тем же платежом ничего не зачисляет
+func (s *Service) ProcessStarsPayment(ctx context.Context, payload, chargeID string, totalStars int) (*PaymentResult, error) {
+	invoiceID, err := parseStarsPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := s.db.GetInvoiceByID(ctx, invoiceID)
+	if err != nil {
+		return nil, fmt.Errorf("invoice %d: %w", invoiceID, err)
+	}
+	if totalStars < inv.AmountStars {
+		return nil, fmt.Errorf("%w: invoice %d paid %d stars, expected %d",
+			payment.ErrInvalidWebhook, inv.ID, totalStars, inv.AmountStars)
+	}
+
+	_, err = s.db.GetInvoiceByExternalID(ctx, models.GatewayStars, chargeID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if err := s.attachStarsCharge(ctx, inv, chargeID, totalStars); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return s.applyPaymentEvent(ctx, models.GatewayStars, &payment.WebhookEvent{
+		ExternalID: chargeID,
+		Status:     payment.StatusPaid,
+		Amount:     inv.Amount,
+	})
+}
+
+// attachStarsCharge привязывает платёж к счёту. Если счёт уже оплачен другим платежом
+// (повторная оплата того же инвойса), деньги зачисляются на баланс отдельным счётом
+func (s *Service) attachStarsCharge(ctx context.Context, inv *models.Invoice, chargeID string, totalStars int) error {
+	attached, err := s.db.AttachInvoiceExternalID(ctx, inv.ID, chargeID)
+	if err != nil {
+		return fmt.Errorf("failed to save charge %s: %w", chargeID, err)
+	}
+	if attached {
+		return nil
+	}
+
+	// Платёж мог быть привязан параллельным обновлением
+	current, err := s.db.GetInvoiceByID(ctx, inv.ID)
+	if err != nil {
+		return err
+	}
+	if current.ExternalID == chargeID {
+		return nil
+	}
+
+	log.Printf("⚠️ Invoice %d paid twice via Stars, crediting charge %s to balance", inv.ID, chargeID)
+	extra, err := s.db.CreateInvoice(ctx, &models.Invoice{
+		UserID:      inv.UserID,
+		Gateway:     models.GatewayStars,
+		Purpose:     models.InvoicePurposeTopUp,
+		Amount:      inv.Amount,
+		AmountStars: totalStars,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create invoice for charge %s: %w", chargeID, err)
+	}
+	if _, err := s.db.AttachInvoiceExternalID(ctx, extra.ID, chargeID); err != nil {
+		return fmt.Errorf("failed to save charge %s: %w", chargeID, err)
+	}
+	return nil
+}
+
+// RefundStarsPayment возвращает звёзды по telegram_payment_charge_id.
+// refund выполняет возврат в Telegram; если он не удался, счёт и баланс не меняются.
+// Подписка, купленная по счёту, деактивируется; иначе сумма списывается с баланса
+func (s *Service) RefundStarsPayment(ctx context.Context, chargeID string, refund func(telegramID int64) error) (*models.Invoice, error) {
+	inv, err := s.db.RefundInvoice(ctx, models.GatewayStars, chargeID, func(ctx context.Context, inv *models.Invoice) error {
+		user, err := s.db.GetUserByID(ctx, inv.UserID)
+		if err != nil {
+			return err
+		}
+		return refund(user.TelegramID)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("paid stars payment %s not found", chargeID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("↩️ Invoice %d refunded: %d stars (user %d)", inv.ID, inv.AmountStars, inv.UserID)
+
+	if inv.SubscriptionID == nil {
+		return inv, nil
+	}
+
+	sub, err := s.db.GetSubscriptionByID(ctx, *inv.SubscriptionID)
+	if err != nil {
+		return inv, fmt.Errorf("refunded, but failed to load subscription %d: %w", *inv.SubscriptionID, err)
+	}
+	if sub.IsActive {
+		if err := s.DeactivateSubscription(ctx, sub); err != nil {
+			return inv, fmt.Errorf("refunded, but failed to deactivate subscription %d: %w", sub.ID, err)
+		}
+	}
+	return inv, nil
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrSubscriptionNotFound подписки с таким токеном нет
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// SubscriptionFeed содержимое ссылки подписки /sub/{token}
+type SubscriptionFeed struct {
+	Subscription *models.Subscription
+	Links        []string // ссылки подключения; пусто — подписка истекла или отключена
+	DataUsed     int64    // израсходовано, байт
+	DataLimit    int64    // квота, байт (0 = безлимит)
+	ExpiresAt    time.Time
+}
+
+// GetSubscriptionFeed собирает ссылку подписки по токену. Ссылки берутся из VPN панели,
+// поэтому смена ноды или ключа подхватывается клиентом без переимпорта; если панель недоступна —
+// отдаётся сохранённый ключ
+func (s *Service) GetSubscriptionFeed(ctx context.Context, token string) (*SubscriptionFeed, error) {
+	if token == "" {
+		return nil, ErrSubscriptionNotFound
+	}
+	sub, err := s.db.GetSubscriptionByToken(ctx, token)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription by token: %w", err)
+	}
+
+	feed := &SubscriptionFeed{
+		Subscription: sub,
+		DataLimit:    sub.TrafficQuotaBytes(),
+		ExpiresAt:    sub.ExpiresAt,
+	}
+	if !sub.IsActive || !sub.ExpiresAt.After(time.Now()) {
+		return feed, nil
+	}
+
+	if sub.VPNUsername != "" {
+		vpnSub, err := s.nodes.Provider(sub.NodeID).GetSubscription(ctx, sub.VPNUsername)
+		if err != nil {
+			log.Printf("⚠️ Subscription feed %d: panel unavailable, serving stored key: %v", sub.ID, err)
+		} else {
+			feed.Links = proxyLinks(vpnSub.Links)
+			feed.DataUsed = vpnSub.DataUsed
+		}
+	}
+	if len(feed.Links) == 0 {
+		feed.Links = proxyLinks([]string{sub.KeyString})
+	}
+	return feed, nil
+}
+
+// proxyLinks оставляет только ссылки подключения (vless://, vmess://, ...), без http(s) ссылок панели
+func proxyLinks(links []string) []string {
+	var result []string
+	for _, link := range links {
+		scheme, _, ok := strings.Cut(link, "://")
+		if !ok || scheme == "http" || scheme == "https" {
+			continue
+		}
+		result = append(result, link)
+	}
+	return result
+}
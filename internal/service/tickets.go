@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"vpn-telegram-bot/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// StartSupport включает режим поддержки: продолжает открытый тикет пользователя или создаёт новый
+func (s *Service) StartSupport(ctx context.Context, telegramID int64, username string) (*models.Ticket, error) {
+	user, err := s.db.GetOrCreateUser(ctx, telegramID, username)
+	if err != nil {
+		return nil, err
+	}
+	return s.db.OpenTicket(ctx, user.ID)
+}
+
+// StopSupport выключает режим поддержки (тикет остаётся открытым, пустой тикет удаляется)
+func (s *Service) StopSupport(ctx context.Context, telegramID int64) error {
+	return s.db.StopTicketComposing(ctx, telegramID)
+}
+
+// IsInSupportMode проверяет, уходят ли сообщения пользователя в поддержку
+func (s *Service) IsInSupportMode(ctx context.Context, telegramID int64) (bool, error) {
+	return s.db.IsTicketComposing(ctx, telegramID)
+}
+
+// AddUserTicketMessage сохраняет сообщение пользователя в его открытый тикет
+func (s *Service) AddUserTicketMessage(ctx context.Context, userID int64, msg *models.TicketMessage) (*models.Ticket, error) {
+	msg.FromAdmin = false
+	return s.db.AddUserTicketMessage(ctx, userID, msg)
+}
+
+// AddAdminTicketMessage сохраняет ответ поддержки в тикет
+func (s *Service) AddAdminTicketMessage(ctx context.Context, msg *models.TicketMessage) error {
+	msg.FromAdmin = true
+	return s.db.AddAdminTicketMessage(ctx, msg)
+}
+
+// GetOpenTicket возвращает открытый тикет пользователя (nil, если его нет)
+func (s *Service) GetOpenTicket(ctx context.Context, telegramID int64) (*models.Ticket, error) {
+	return noTicket(s.db.GetOpenTicketByTelegramID(ctx, telegramID))
+}
+
+// FindTicketByGroupMessage находит тикет по сообщению в группе поддержки (nil, если не найден)
+func (s *Service) FindTicketByGroupMessage(ctx context.Context, groupMessageID int) (*models.Ticket, error) {
+	return noTicket(s.db.GetTicketByGroupMessage(ctx, groupMessageID))
+}
+
+// CloseTicket закрывает открытый тикет пользователя (nil, если открытого тикета нет)
+func (s *Service) CloseTicket(ctx context.Context, telegramID int64) (*models.Ticket, error) {
+	return noTicket(s.db.CloseTicket(ctx, telegramID))
+}
+
+// GetActiveTickets возвращает тикеты для dashboard поддержки
+func (s *Service) GetActiveTickets(ctx context.Context) ([]models.Ticket, error) {
+	return s.db.GetActiveTickets(ctx)
+}
+
+// GetUserTickets возвращает историю обращений пользователя
+func (s *Service) GetUserTickets(ctx context.Context, telegramID int64, limit int) ([]models.Ticket, error) {
+	return s.db.GetUserTickets(ctx, telegramID, limit)
+}
+
+// GetSupportDashboard возвращает ID сообщения dashboard в группе поддержки
+func (s *Service) GetSupportDashboard(ctx context.Context, chatID int64) (int, error) {
+	return s.db.GetSupportDashboard(ctx, chatID)
+}
+
+// SetSupportDashboard сохраняет ID сообщения dashboard в группе поддержки
+func (s *Service) SetSupportDashboard(ctx context.Context, chatID int64, msgID int) error {
+	return s.db.SetSupportDashboard(ctx, chatID, msgID)
+}
+
+// noTicket превращает "не найдено" в nil без ошибки
+func noTicket(t *models.Ticket, err error) (*models.Ticket, error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return t, err
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrTrafficInvalid некорректные параметры квоты или пакета трафика
+	ErrTrafficInvalid = errors.New("invalid traffic settings")
+	// ErrTrafficPackUnavailable пакет не найден, скрыт или не подходит к подписке
+	ErrTrafficPackUnavailable = errors.New("traffic pack unavailable")
+	// ErrTrafficNotLimited подписка безлимитная, истекла или принадлежит другому пользователю
+	ErrTrafficNotLimited = errors.New("subscription has no traffic quota")
+)
+
+// maxTrafficGB ограничение квоты и пакета трафика
+const maxTrafficGB = 100_000
+
+// UpdateProductTraffic задаёт квоту продукта (0 = безлимит) и стратегию её сброса.
+// Действует на новые покупки и продления
+func (s *Service) UpdateProductTraffic(ctx context.Context, productID int64, limitGB int, reset string) error {
+	if limitGB < 0 || limitGB > maxTrafficGB {
+		return fmt.Errorf("%w: limit %d GB", ErrTrafficInvalid, limitGB)
+	}
+	if reset != models.TrafficResetNone && reset != models.TrafficResetMonth {
+		return fmt.Errorf("%w: reset strategy %q", ErrTrafficInvalid, reset)
+	}
+	if err := s.db.UpdateProductTraffic(ctx, productID, limitGB, reset); err != nil {
+		return fmt.Errorf("failed to update product %d traffic: %w", productID, err)
+	}
+	log.Printf("📶 Product %d traffic quota: %d GB, reset=%s", productID, limitGB, reset)
+	return nil
+}
+
+// GetTrafficPacks возвращает включённые пакеты трафика продукта
+func (s *Service) GetTrafficPacks(ctx context.Context, productID int64) ([]models.TrafficPack, error) {
+	return s.db.GetTrafficPacks(ctx, productID, true)
+}
+
+// GetAllTrafficPacks возвращает все пакеты трафика продукта, включая скрытые (для админки)
+func (s *Service) GetAllTrafficPacks(ctx context.Context, productID int64) ([]models.TrafficPack, error) {
+	return s.db.GetTrafficPacks(ctx, productID, false)
+}
+
+// GetTrafficPack возвращает пакет трафика по ID
+func (s *Service) GetTrafficPack(ctx context.Context, id int64) (*models.TrafficPack, error) {
+	return s.db.GetTrafficPackByID(ctx, id)
+}
+
+// CreateTrafficPack добавляет пакет трафика в конец списка пакетов продукта
+func (s *Service) CreateTrafficPack(ctx context.Context, pack *models.TrafficPack) (*models.TrafficPack, error) {
+	if pack.TrafficGB <= 0 || pack.TrafficGB > maxTrafficGB {
+		return nil, fmt.Errorf("%w: pack %d GB", ErrTrafficInvalid, pack.TrafficGB)
+	}
+	if pack.Price <= 0 {
+		return nil, fmt.Errorf("%w: price %.2f", ErrTrafficInvalid, pack.Price)
+	}
+	if _, err := s.db.GetProductByID(ctx, pack.ProductID); err != nil {
+		return nil, fmt.Errorf("%w: product %d not found", ErrTrafficInvalid, pack.ProductID)
+	}
+
+	created, err := s.db.CreateTrafficPack(ctx, pack)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create traffic pack: %w", err)
+	}
+	log.Printf("📶 Traffic pack #%d created for product %d: %d GB for %.2f", created.ID, created.ProductID, created.TrafficGB, created.Price)
+	return created, nil
+}
+
+// SetTrafficPackActive включает или скрывает пакет трафика
+func (s *Service) SetTrafficPackActive(ctx context.Context, id int64, active bool) error {
+	if err := s.db.SetTrafficPackActive(ctx, id, active); err != nil {
+		return fmt.Errorf("failed to update traffic pack %d: %w", id, err)
+	}
+	return nil
+}
+
+// DeleteTrafficPack удаляет пакет трафика
+func (s *Service) DeleteTrafficPack(ctx context.Context, id int64) error {
+	if err := s.db.DeleteTrafficPack(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete traffic pack %d: %w", id, err)
+	}
+	log.Printf("📶 Traffic pack #%d deleted", id)
+	return nil
+}
+
+// BuyTrafficPack покупает пакет трафика с баланса и сразу увеличивает квоту в VPN панели.
+// Докупленный трафик действует до конца текущего периода квоты (продления или ежемесячного сброса)
+func (s *Service) BuyTrafficPack(ctx context.Context, userID, subID, packID int64) (*models.Subscription, *models.TrafficPack, error) {
+	sub, err := s.db.GetSubscriptionByID(ctx, subID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && sub.UserID != userID) {
+		return nil, nil, fmt.Errorf("%w: subscription %d", ErrTrafficNotLimited, subID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pack, err := s.db.GetTrafficPackByID(ctx, packID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && (!pack.IsActive || pack.ProductID != sub.ProductID)) {
+		return nil, nil, fmt.Errorf("%w: pack %d", ErrTrafficPackUnavailable, packID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.Balance < pack.Price {
+		return nil, nil, fmt.Errorf("%w: have %.2f, need %.2f", ErrInsufficientBalance, user.Balance, pack.Price)
+	}
+
+	extra := int64(pack.TrafficGB) * models.BytesPerGB
+	var updated *models.Subscription
+	ok, err := s.db.PurchaseTrafficPack(ctx, userID, subID, extra, pack.Price, func(ctx context.Context, u *models.Subscription) error {
+		updated = u
+		return s.applyVPNLimits(ctx, u)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to buy traffic pack: %w", err)
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: subscription %d", ErrTrafficNotLimited, subID)
+	}
+
+	log.Printf("📶 User %d bought %d GB for subscription %d (%.2f)", userID, pack.TrafficGB, subID, pack.Price)
+	updated.Product = sub.Product
+	return updated, pack, nil
+}
+
+// ResetDueTraffic начинает новый месяц квоты у подписок с ежемесячным сбросом (вызывается планировщиком):
+// израсходованный трафик обнуляется, квота возвращается к базовой
+func (s *Service) ResetDueTraffic(ctx context.Context) {
+	now := time.Now()
+	subs, err := s.db.GetSubscriptionsDueTrafficReset(ctx, now)
+	if err != nil {
+		log.Printf("Scheduler: failed to get subscriptions for traffic reset: %v", err)
+		return
+	}
+
+	for i := range subs {
+		sub := &subs[i]
+		if sub.TrafficResetAt == nil {
+			continue
+		}
+
+		// Пропущенные из-за простоя месяцы не копятся: следующий сброс всегда в будущем
+		next := *sub.TrafficResetAt
+		for !next.After(now) {
+			next = next.AddDate(0, 1, 0)
+		}
+
+		_, err := s.db.ResetSubscriptionTraffic(ctx, sub.ID, *sub.TrafficResetAt, next, func(ctx context.Context) error {
+			if sub.VPNUsername == "" {
+				return nil
+			}
+			vpn := s.nodes.Provider(sub.NodeID)
+			if err := vpn.ResetTraffic(ctx, sub.VPNUsername); err != nil {
+				return err
+			}
+			if sub.TrafficExtra > 0 {
+				reset := *sub
+				reset.TrafficExtra = 0
+				return vpn.ExtendUser(ctx, sub.VPNUsername, sub.ExpiresAt, vpnLimits(&reset))
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("Scheduler: failed to reset traffic of subscription %d: %v", sub.ID, err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"vpn-telegram-bot/internal/models"
+)
+
+// ErrTrialUnavailable пробный период выключен или пользователь уже брал его либо покупал подписку
+var ErrTrialUnavailable = errors.New("trial unavailable")
+
+// TrialSettings параметры пробной подписки
+type TrialSettings struct {
+	ProductID int64
+	Days      int
+	TrafficGB int // 0 = без ограничения
+}
+
+// EnableTrial включает бесплатную пробную подписку
+func (s *Service) EnableTrial(settings TrialSettings) {
+	s.trial = &settings
+	log.Printf("🎁 Free trial enabled: product %d, %d days, %d GB", settings.ProductID, settings.Days, settings.TrafficGB)
+}
+
+// Trial параметры пробной подписки (nil — пробный период выключен)
+func (s *Service) Trial() *TrialSettings {
+	return s.trial
+}
+
+// TrialAvailable может ли пользователь взять пробную подписку
+func (s *Service) TrialAvailable(ctx context.Context, user *models.User) (bool, error) {
+	if s.trial == nil {
+		return false, nil
+	}
+	return s.db.IsTrialEligible(ctx, user.ID)
+}
+
+// ClaimTrial выдаёт пользователю пробную подписку. Заявка резервируется до создания ключа,
+// поэтому повторные нажатия не выдадут второй ключ; если ключ создать не удалось, резерв снимается
+func (s *Service) ClaimTrial(ctx context.Context, user *models.User) (*models.Subscription, error) {
+	if s.trial == nil {
+		return nil, fmt.Errorf("%w: disabled", ErrTrialUnavailable)
+	}
+	product, err := s.db.GetProductByID(ctx, s.trial.ProductID)
+	if err != nil {
+		return nil, fmt.Errorf("trial product not found: %w", err)
+	}
+
+	claimID, ok, err := s.db.CreateTrialClaim(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim trial: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: user %d", ErrTrialUnavailable, user.ID)
+	}
+
+	limits := models.SubscriptionLimits{
+		Traffic: models.TrafficQuota{LimitGB: s.trial.TrafficGB, Reset: models.TrafficResetNone},
+		Devices: product.DeviceLimit,
+	}
+	vpnUsername := fmt.Sprintf("trial_tg_%d_%d", user.TelegramID, time.Now().Unix())
+	expiresAt := time.Now().AddDate(0, 0, s.trial.Days)
+
+	sub, err := s.createVPNSubscription(ctx, user.ID, product, nil, limits, vpnUsername, expiresAt)
+	if err != nil {
+		if delErr := s.db.DeleteTrialClaim(ctx, claimID); delErr != nil {
+			log.Printf("⚠️ Failed to release trial claim %d: %v", claimID, delErr)
+		}
+		return nil, err
+	}
+	if err := s.db.SetTrialSubscription(ctx, claimID, sub.ID); err != nil {
+		log.Printf("⚠️ Failed to link trial claim %d to subscription %d: %v", claimID, sub.ID, err)
+	}
+
+	log.Printf("🎁 User %d claimed trial: subscription %d until %s", user.TelegramID, sub.ID, expiresAt.Format("02.01.2006"))
+	return sub, nil
+}
@@ -2,22 +2,39 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
-
-	"vpn-telegram-bot/internal/config"
 )
 
+// ErrVPNNotSupported возможность не поддерживается VPN панелью
+var ErrVPNNotSupported = errors.New("not supported by vpn panel")
+
 // VPNProvider интерфейс для работы с VPN панелью
 type VPNProvider interface {
-	CreateUser(ctx context.Context, username string, tag string, expiresAt time.Time) (string, error)
+	CreateUser(ctx context.Context, username string, tag string, expiresAt time.Time, limits UserLimits) (string, error)
 	GetSubscription(ctx context.Context, username string) (*VPNSubscription, error)
-	ExtendUser(ctx context.Context, username string, newExpiresAt time.Time) error
+	ExtendUser(ctx context.Context, username string, newExpiresAt time.Time, limits UserLimits) error
+	ResetTraffic(ctx context.Context, username string) error
+	GetConnections(ctx context.Context, username string) ([]VPNConnection, error)
+	DisableUser(ctx context.Context, username string) error
 	DeleteUser(ctx context.Context, username string) error
 	GetAllUsers(ctx context.Context) ([]VPNUser, error)
 	GetSystemStats(ctx context.Context) (*SystemStats, error)
 }
 
+// UserLimits ограничения пользователя в панели
+type UserLimits struct {
+	DataLimit   int64 // квота трафика, байт (0 = безлимит)
+	DeviceLimit int   // одновременных IP (0 = без ограничения)
+}
+
+// VPNConnection активное подключение пользователя по данным панели
+type VPNConnection struct {
+	IP       string
+	LastSeen *time.Time // nil — панель не сообщает время
+}
+
 // VPNUser информация о пользователе VPN
 type VPNUser struct {
 	Username    string
@@ -41,98 +58,13 @@ type SystemStats struct {
 type VPNSubscription struct {
 	Username  string
 	KeyString string
+	Links     []string // все ссылки подключения (для ссылки подписки)
 	ExpiresAt time.Time
 	IsActive  bool
 	DataLimit int64 // bytes
 	DataUsed  int64 // bytes
 }
 
-// MarzbanProvider реализация VPNProvider для Marzban
-type MarzbanProvider struct {
-	baseURL  string
-	username string
-	password string
-	token    string
-}
-
-// NewMarzbanProvider создаёт новый Marzban провайдер
-func NewMarzbanProvider(cfg config.MarzbanConfig) *MarzbanProvider {
-	return &MarzbanProvider{
-		baseURL:  cfg.BaseURL,
-		username: cfg.Username,
-		password: cfg.Password,
-	}
-}
-
-// CreateUser создаёт пользователя в Marzban
-// TODO: Implement real Marzban API integration
-func (m *MarzbanProvider) CreateUser(ctx context.Context, username string, tag string, expiresAt time.Time) (string, error) {
-	// Mock implementation - в реальности здесь будет HTTP запрос к Marzban API
-	// POST /api/user с телом запроса
-
-	// Генерируем mock vless ключ
-	mockKey := fmt.Sprintf(
-		"vless://%s@your-server.com:443?type=tcp&security=reality&pbk=mock&fp=chrome&sni=www.google.com&sid=mock&spx=%%2F#%s",
-		username,
-		tag,
-	)
-
-	return mockKey, nil
-}
-
-// GetSubscription получает информацию о подписке
-// TODO: Implement real Marzban API integration
-func (m *MarzbanProvider) GetSubscription(ctx context.Context, username string) (*VPNSubscription, error) {
-	// Mock implementation
-	return &VPNSubscription{
-		Username:  username,
-		KeyString: "vless://mock-key",
-		ExpiresAt: time.Now().Add(30 * 24 * time.Hour),
-		IsActive:  true,
-		DataLimit: 0,
-		DataUsed:  0,
-	}, nil
-}
-
-// ExtendUser продлевает подписку пользователя
-// TODO: Implement real Marzban API integration
-func (m *MarzbanProvider) ExtendUser(ctx context.Context, username string, newExpiresAt time.Time) error {
-	// Mock implementation
-	// PUT /api/user/{username}
-	return nil
-}
-
-// DeleteUser удаляет пользователя
-// TODO: Implement real Marzban API integration
-func (m *MarzbanProvider) DeleteUser(ctx context.Context, username string) error {
-	// Mock implementation
-	// DELETE /api/user/{username}
-	return nil
-}
-
-// GetAllUsers получает список всех пользователей
-// TODO: Implement real Marzban API integration
-func (m *MarzbanProvider) GetAllUsers(ctx context.Context) ([]VPNUser, error) {
-	// Mock implementation
-	// GET /api/users
-	return []VPNUser{}, nil
-}
-
-// GetSystemStats получает системную статистику
-// TODO: Implement real Marzban API integration
-func (m *MarzbanProvider) GetSystemStats(ctx context.Context) (*SystemStats, error) {
-	// Mock implementation
-	// GET /api/system
-	return &SystemStats{
-		CPUPercent:    25.0,
-		MemoryPercent: 45.0,
-		NetworkRxMbps: 50.0,
-		NetworkTxMbps: 30.0,
-		TotalUsers:    100,
-		ActiveUsers:   25,
-	}, nil
-}
-
 // MockVPNProvider мок-провайдер для локальной разработки
 type MockVPNProvider struct{}
 
@@ -140,7 +72,7 @@ func NewMockVPNProvider() *MockVPNProvider {
 	return &MockVPNProvider{}
 }
 
-func (m *MockVPNProvider) CreateUser(ctx context.Context, username string, tag string, expiresAt time.Time) (string, error) {
+func (m *MockVPNProvider) CreateUser(ctx context.Context, username string, tag string, expiresAt time.Time, limits UserLimits) (string, error) {
 	// Генерируем реалистичный mock VLESS ключ
 	mockUUID := fmt.Sprintf("mock-%s-%d", username, time.Now().Unix())
 	mockKey := fmt.Sprintf(
@@ -153,9 +85,11 @@ func (m *MockVPNProvider) CreateUser(ctx context.Context, username string, tag s
 
 func (m *MockVPNProvider) GetSubscription(ctx context.Context, username string) (*VPNSubscription, error) {
 	mockUUID := fmt.Sprintf("mock-%s", username)
+	key := fmt.Sprintf("vless://%s@pl1.xray-vpn.com:443?type=tcp&security=reality#XRAY-%s", mockUUID, username)
 	return &VPNSubscription{
 		Username:  username,
-		KeyString: fmt.Sprintf("vless://%s@pl1.xray-vpn.com:443?type=tcp&security=reality#XRAY-%s", mockUUID, username),
+		KeyString: key,
+		Links:     []string{key},
 		ExpiresAt: time.Now().Add(30 * 24 * time.Hour),
 		IsActive:  true,
 		DataLimit: 0,              // Unlimited
@@ -163,7 +97,23 @@ func (m *MockVPNProvider) GetSubscription(ctx context.Context, username string)
 	}, nil
 }
 
-func (m *MockVPNProvider) ExtendUser(ctx context.Context, username string, newExpiresAt time.Time) error {
+func (m *MockVPNProvider) ExtendUser(ctx context.Context, username string, newExpiresAt time.Time, limits UserLimits) error {
+	// Mock: просто возвращаем успех
+	return nil
+}
+
+func (m *MockVPNProvider) ResetTraffic(ctx context.Context, username string) error {
+	// Mock: просто возвращаем успех
+	return nil
+}
+
+func (m *MockVPNProvider) GetConnections(ctx context.Context, username string) ([]VPNConnection, error) {
+	// Mock: одно подключение "прямо сейчас"
+	now := time.Now()
+	return []VPNConnection{{IP: "203.0.113.10", LastSeen: &now}}, nil
+}
+
+func (m *MockVPNProvider) DisableUser(ctx context.Context, username string) error {
 	// Mock: просто возвращаем успех
 	return nil
 }
@@ -195,12 +145,3 @@ func (m *MockVPNProvider) GetSystemStats(ctx context.Context) (*SystemStats, err
 		ActiveUsers:   12,
 	}, nil
 }
-
-
-
-
-
-
-
-
-
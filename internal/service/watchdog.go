@@ -29,27 +29,28 @@ func DefaultWatchdogConfig() WatchdogConfig {
 	}
 }
 
-// Watchdog сервис мониторинга нагрузки
+// Watchdog сервис мониторинга нагрузки всех панелей: из конфига и включённых нод
 type Watchdog struct {
 	bot      *tele.Bot
 	adminIDs []int64
-	vpn      VPNProvider
+	nodes    *NodeManager
 	config   WatchdogConfig
 
 	mu            sync.Mutex
-	lastAlertTime time.Time
+	lastAlertTime map[string]time.Time // по имени панели: у каждой свой cooldown
 	isRunning     bool
 	stopChan      chan struct{}
 }
 
 // NewWatchdog создаёт новый Watchdog
-func NewWatchdog(bot *tele.Bot, adminIDs []int64, vpn VPNProvider, config WatchdogConfig) *Watchdog {
+func NewWatchdog(bot *tele.Bot, adminIDs []int64, nodes *NodeManager, config WatchdogConfig) *Watchdog {
 	return &Watchdog{
-		bot:      bot,
-		adminIDs: adminIDs,
-		vpn:      vpn,
-		config:   config,
-		stopChan: make(chan struct{}),
+		bot:           bot,
+		adminIDs:      adminIDs,
+		nodes:         nodes,
+		config:        config,
+		lastAlertTime: make(map[string]time.Time),
+		stopChan:      make(chan struct{}),
 	}
 }
 
@@ -99,9 +100,15 @@ func (w *Watchdog) runLoop() {
 func (w *Watchdog) checkSystem() {
 	ctx := context.Background()
 
-	stats, err := w.vpn.GetSystemStats(ctx)
+	for _, panel := range w.nodes.Panels() {
+		w.checkPanel(ctx, panel)
+	}
+}
+
+func (w *Watchdog) checkPanel(ctx context.Context, panel Panel) {
+	stats, err := panel.VPN.GetSystemStats(ctx)
 	if err != nil {
-		log.Printf("Watchdog: failed to get system stats: %v", err)
+		log.Printf("Watchdog: failed to get system stats of panel %s: %v", panel.Name, err)
 		return
 	}
 
@@ -115,26 +122,26 @@ func (w *Watchdog) checkSystem() {
 
 	// Проверяем cooldown
 	w.mu.Lock()
-	if time.Since(w.lastAlertTime) < w.config.AlertCooldown {
+	if time.Since(w.lastAlertTime[panel.Name]) < w.config.AlertCooldown {
 		w.mu.Unlock()
 		return
 	}
-	w.lastAlertTime = time.Now()
+	w.lastAlertTime[panel.Name] = time.Now()
 	w.mu.Unlock()
 
 	// Отправляем алерт
-	w.sendAlert(ctx, stats, cpuAlert, networkAlert)
+	w.sendAlert(ctx, panel, stats, cpuAlert, networkAlert)
 }
 
-func (w *Watchdog) sendAlert(ctx context.Context, stats *SystemStats, cpuAlert, networkAlert bool) {
+func (w *Watchdog) sendAlert(ctx context.Context, panel Panel, stats *SystemStats, cpuAlert, networkAlert bool) {
 	// Получаем топ пользователей
-	topUsers, err := w.getTopUsers(ctx, 3)
+	topUsers, err := w.getTopUsers(ctx, panel.VPN, 3)
 	if err != nil {
-		log.Printf("Watchdog: failed to get top users: %v", err)
+		log.Printf("Watchdog: failed to get top users of panel %s: %v", panel.Name, err)
 	}
 
 	// Формируем сообщение
-	message := w.formatAlertMessage(stats, cpuAlert, networkAlert, topUsers)
+	message := w.formatAlertMessage(panel.Name, stats, cpuAlert, networkAlert, topUsers)
 
 	// Отправляем всем админам
 	for _, adminID := range w.adminIDs {
@@ -144,11 +151,11 @@ func (w *Watchdog) sendAlert(ctx context.Context, stats *SystemStats, cpuAlert,
 		}
 	}
 
-	log.Printf("🚨 Watchdog alert sent: CPU=%.1f%%, Network=%.1f Mbps", stats.CPUPercent, stats.NetworkRxMbps)
+	log.Printf("🚨 Watchdog alert sent: panel %s, CPU=%.1f%%, Network=%.1f Mbps", panel.Name, stats.CPUPercent, stats.NetworkRxMbps)
 }
 
-func (w *Watchdog) getTopUsers(ctx context.Context, limit int) ([]VPNUser, error) {
-	users, err := w.vpn.GetAllUsers(ctx)
+func (w *Watchdog) getTopUsers(ctx context.Context, vpn VPNProvider, limit int) ([]VPNUser, error) {
+	users, err := vpn.GetAllUsers(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -173,7 +180,7 @@ func (w *Watchdog) getTopUsers(ctx context.Context, limit int) ([]VPNUser, error
 	return activeUsers, nil
 }
 
-func (w *Watchdog) formatAlertMessage(stats *SystemStats, cpuAlert, networkAlert bool, topUsers []VPNUser) string {
+func (w *Watchdog) formatAlertMessage(panelName string, stats *SystemStats, cpuAlert, networkAlert bool, topUsers []VPNUser) string {
 	// CPU статус
 	cpuStatus := "🟢"
 	if stats.CPUPercent >= 90 {
@@ -191,11 +198,13 @@ func (w *Watchdog) formatAlertMessage(stats *SystemStats, cpuAlert, networkAlert
 	msg := fmt.Sprintf(`☠️ *DDoS / HIGH LOAD ALERT*
 
 ⚠️ *Anomaly Detected!*
+🖥 *Panel:* %s
 📉 *CPU:* %.1f%% %s
 📶 *Network RX:* %.0f Mbps %s
 💾 *Memory:* %.1f%%
 
 👥 *Active Users:* %d / %d`,
+		panelName,
 		stats.CPUPercent, cpuStatus,
 		stats.NetworkRxMbps, networkStatus,
 		stats.MemoryPercent,
@@ -211,7 +220,7 @@ func (w *Watchdog) formatAlertMessage(stats *SystemStats, cpuAlert, networkAlert
 		}
 	}
 
-	msg += "\n\n_Check Marzban Panel immediately._"
+	msg += "\n\n_Check the panel immediately._"
 
 	return msg
 }
@@ -221,9 +230,10 @@ func (w *Watchdog) ForceCheck() {
 	w.checkSystem()
 }
 
-// TestAlert отправляет тестовый алерт (для админа)
+// TestAlert отправляет тестовый алерт по панели из конфига (для админа)
 func (w *Watchdog) TestAlert() {
 	ctx := context.Background()
+	panel := w.nodes.Panels()[0]
 
 	// Создаём тестовые данные с высокой нагрузкой
 	stats := &SystemStats{
@@ -235,8 +245,8 @@ func (w *Watchdog) TestAlert() {
 		ActiveUsers:   45,
 	}
 
-	topUsers, _ := w.getTopUsers(ctx, 3)
-	message := w.formatAlertMessage(stats, true, true, topUsers)
+	topUsers, _ := w.getTopUsers(ctx, panel.VPN, 3)
+	message := w.formatAlertMessage(panel.Name, stats, true, true, topUsers)
 
 	for _, adminID := range w.adminIDs {
 		w.bot.Send(&tele.User{ID: adminID}, "🧪 *TEST ALERT* (симуляция)\n\n"+message, tele.ModeMarkdown)
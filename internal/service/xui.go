@@ -0,0 +1,713 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vpn-telegram-bot/internal/config"
+)
+
+// errXUIUnauthorized сессия в панели истекла
+var errXUIUnauthorized = errors.New("3x-ui: unauthorized")
+
+// XUIProvider реализация VPNProvider для панелей 3X-UI / X-UI.
+// Все клиенты создаются внутри одного inbound из конфига.
+type XUIProvider struct {
+	baseURL   string
+	username  string
+	password  string
+	inboundID int
+	host      string
+	flow      string
+	client    *http.Client
+
+	mu       sync.Mutex
+	loggedIn bool
+}
+
+// NewXUIProvider создаёт новый 3X-UI провайдер
+func NewXUIProvider(cfg config.XUIConfig) *XUIProvider {
+	return NewXUIProviderWithClient(cfg, &http.Client{Timeout: 15 * time.Second})
+}
+
+// NewXUIProviderWithClient создаёт 3X-UI провайдер с указанным HTTP клиентом.
+// Клиенту назначается cookie jar для хранения сессии, редиректы не выполняются.
+func NewXUIProviderWithClient(cfg config.XUIConfig, client *http.Client) *XUIProvider {
+	jar, _ := cookiejar.New(nil)
+	c := *client
+	c.Jar = jar
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+	host := cfg.Host
+	if host == "" {
+		if u, err := url.Parse(baseURL); err == nil {
+			host = u.Hostname()
+		}
+	}
+
+	return &XUIProvider{
+		baseURL:   baseURL,
+		username:  cfg.Username,
+		password:  cfg.Password,
+		inboundID: cfg.InboundID,
+		host:      host,
+		flow:      cfg.Flow,
+		client:    &c,
+	}
+}
+
+// xuiResponse общий конверт ответов панели
+type xuiResponse struct {
+	Success bool            `json:"success"`
+	Msg     string          `json:"msg"`
+	Obj     json.RawMessage `json:"obj"`
+}
+
+// xuiInbound inbound панели
+type xuiInbound struct {
+	ID             int                `json:"id"`
+	Remark         string             `json:"remark"`
+	Port           int                `json:"port"`
+	Protocol       string             `json:"protocol"`
+	Settings       string             `json:"settings"`
+	StreamSettings string             `json:"streamSettings"`
+	ClientStats    []xuiClientTraffic `json:"clientStats"`
+}
+
+// xuiInboundSettings настройки inbound (клиенты)
+type xuiInboundSettings struct {
+	Clients []xuiClient `json:"clients"`
+}
+
+// xuiClient клиент внутри inbound
+type xuiClient struct {
+	ID         string `json:"id"`
+	Flow       string `json:"flow"`
+	Email      string `json:"email"`
+	LimitIP    int    `json:"limitIp"`
+	TotalGB    int64  `json:"totalGB"` // лимит трафика в байтах
+	ExpiryTime int64  `json:"expiryTime"`
+	Enable     bool   `json:"enable"`
+	SubID      string `json:"subId"`
+	Reset      int    `json:"reset"`
+}
+
+// xuiClientTraffic статистика трафика клиента
+type xuiClientTraffic struct {
+	InboundID  int    `json:"inboundId"`
+	Enable     bool   `json:"enable"`
+	Email      string `json:"email"`
+	Up         int64  `json:"up"`
+	Down       int64  `json:"down"`
+	ExpiryTime int64  `json:"expiryTime"`
+	Total      int64  `json:"total"`
+}
+
+// xuiStreamSettings транспорт и безопасность inbound
+type xuiStreamSettings struct {
+	Network         string `json:"network"`
+	Security        string `json:"security"`
+	RealitySettings struct {
+		ServerNames []string `json:"serverNames"`
+		ShortIDs    []string `json:"shortIds"`
+		Settings    struct {
+			PublicKey   string `json:"publicKey"`
+			Fingerprint string `json:"fingerprint"`
+			SpiderX     string `json:"spiderX"`
+		} `json:"settings"`
+	} `json:"realitySettings"`
+	TLSSettings struct {
+		ServerName string   `json:"serverName"`
+		ALPN       []string `json:"alpn"`
+		Settings   struct {
+			Fingerprint string `json:"fingerprint"`
+		} `json:"settings"`
+	} `json:"tlsSettings"`
+	WSSettings struct {
+		Path    string            `json:"path"`
+		Host    string            `json:"host"`
+		Headers map[string]string `json:"headers"`
+	} `json:"wsSettings"`
+	GRPCSettings struct {
+		ServiceName string `json:"serviceName"`
+	} `json:"grpcSettings"`
+	TCPSettings struct {
+		Header struct {
+			Type string `json:"type"`
+		} `json:"header"`
+	} `json:"tcpSettings"`
+}
+
+// xuiServerStatus ответ /server/status
+type xuiServerStatus struct {
+	CPU float64 `json:"cpu"`
+	Mem struct {
+		Current int64 `json:"current"`
+		Total   int64 `json:"total"`
+	} `json:"mem"`
+	NetIO struct {
+		Up   int64 `json:"up"`
+		Down int64 `json:"down"`
+	} `json:"netIO"`
+}
+
+// CreateUser добавляет клиента в inbound и возвращает ссылку подключения.
+// tag не используется: inbound задаётся в конфиге.
+func (x *XUIProvider) CreateUser(ctx context.Context, username string, tag string, expiresAt time.Time, limits UserLimits) (string, error) {
+	inbound, err := x.getInbound(ctx)
+	if err != nil {
+		return "", fmt.Errorf("create user %s: %w", username, err)
+	}
+
+	client := xuiClient{
+		ID:         generateUUID(),
+		Email:      username,
+		LimitIP:    limits.DeviceLimit,
+		TotalGB:    limits.DataLimit,
+		ExpiryTime: expiresAt.UnixMilli(),
+		Enable:     true,
+		SubID:      randomHex(8),
+	}
+	if inbound.Protocol == "vless" {
+		client.Flow = x.flow
+	}
+
+	if err := x.postClient(ctx, "/panel/api/inbounds/addClient", client); err != nil {
+		return "", fmt.Errorf("create user %s: %w", username, err)
+	}
+
+	return x.buildLink(inbound, client)
+}
+
+// GetSubscription получает информацию о клиенте
+func (x *XUIProvider) GetSubscription(ctx context.Context, username string) (*VPNSubscription, error) {
+	inbound, err := x.getInbound(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get user %s: %w", username, err)
+	}
+
+	client, err := findXUIClient(inbound, username)
+	if err != nil {
+		return nil, fmt.Errorf("get user %s: %w", username, err)
+	}
+
+	key, err := x.buildLink(inbound, *client)
+	if err != nil {
+		return nil, fmt.Errorf("get user %s: %w", username, err)
+	}
+
+	sub := &VPNSubscription{
+		Username:  username,
+		KeyString: key,
+		Links:     []string{key},
+		IsActive:  client.Enable,
+		DataLimit: client.TotalGB,
+	}
+	if client.ExpiryTime > 0 {
+		sub.ExpiresAt = time.UnixMilli(client.ExpiryTime)
+	}
+
+	var traffic *xuiClientTraffic
+	if err := x.do(ctx, http.MethodGet, "/panel/api/inbounds/getClientTraffics/"+url.PathEscape(username), nil, &traffic); err != nil {
+		return nil, fmt.Errorf("get traffic %s: %w", username, err)
+	}
+	if traffic != nil {
+		sub.DataUsed = traffic.Up + traffic.Down
+		sub.IsActive = sub.IsActive && traffic.Enable
+	}
+
+	return sub, nil
+}
+
+// ExtendUser продлевает клиента, обновляет квоту трафика и лимит IP и включает его
+func (x *XUIProvider) ExtendUser(ctx context.Context, username string, newExpiresAt time.Time, limits UserLimits) error {
+	inbound, err := x.getInbound(ctx)
+	if err != nil {
+		return fmt.Errorf("extend user %s: %w", username, err)
+	}
+
+	client, err := findXUIClient(inbound, username)
+	if err != nil {
+		return fmt.Errorf("extend user %s: %w", username, err)
+	}
+
+	client.ExpiryTime = newExpiresAt.UnixMilli()
+	client.TotalGB = limits.DataLimit
+	client.LimitIP = limits.DeviceLimit
+	client.Enable = true
+
+	if err := x.postClient(ctx, "/panel/api/inbounds/updateClient/"+url.PathEscape(client.ID), *client); err != nil {
+		return fmt.Errorf("extend user %s: %w", username, err)
+	}
+	return nil
+}
+
+// ResetTraffic обнуляет счётчики трафика клиента
+func (x *XUIProvider) ResetTraffic(ctx context.Context, username string) error {
+	path := fmt.Sprintf("/panel/api/inbounds/%d/resetClientTraffic/%s", x.inboundID, url.PathEscape(username))
+	if err := x.do(ctx, http.MethodPost, path, nil, nil); err != nil {
+		return fmt.Errorf("reset traffic %s: %w", username, err)
+	}
+	return nil
+}
+
+// GetConnections возвращает IP, с которых подключался клиент (панель ведёт их учёт при включённом лимите IP)
+func (x *XUIProvider) GetConnections(ctx context.Context, username string) ([]VPNConnection, error) {
+	var raw json.RawMessage
+	if err := x.do(ctx, http.MethodPost, "/panel/api/inbounds/clientIps/"+url.PathEscape(username), nil, &raw); err != nil {
+		return nil, fmt.Errorf("client ips %s: %w", username, err)
+	}
+	return parseXUIClientIPs(raw)
+}
+
+// parseXUIClientIPs разбирает ответ clientIps: строка "No IP Record", JSON-массив
+// или строка с JSON-массивом; элементы — "1.2.3.4" или "1.2.3.4 (2006-01-02 15:04:05)"
+func parseXUIClientIPs(raw json.RawMessage) ([]VPNConnection, error) {
+	var ips []string
+	if err := json.Unmarshal(raw, &ips); err != nil {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("parse client ips: %w", err)
+		}
+		if !strings.HasPrefix(strings.TrimSpace(s), "[") {
+			return nil, nil // "No IP Record"
+		}
+		if err := json.Unmarshal([]byte(s), &ips); err != nil {
+			return nil, fmt.Errorf("parse client ips: %w", err)
+		}
+	}
+
+	conns := make([]VPNConnection, 0, len(ips))
+	for _, item := range ips {
+		conn := VPNConnection{IP: strings.TrimSpace(item)}
+		if ip, seen, ok := strings.Cut(conn.IP, " ("); ok {
+			conn.IP = ip
+			if t, err := time.ParseInLocation("2006-01-02 15:04:05", strings.TrimSuffix(seen, ")"), time.Local); err == nil {
+				conn.LastSeen = &t
+			}
+		}
+		if conn.IP != "" {
+			conns = append(conns, conn)
+		}
+	}
+	return conns, nil
+}
+
+// DisableUser выключает клиента (enable=false)
+func (x *XUIProvider) DisableUser(ctx context.Context, username string) error {
+	inbound, err := x.getInbound(ctx)
+	if err != nil {
+		return fmt.Errorf("disable user %s: %w", username, err)
+	}
+
+	client, err := findXUIClient(inbound, username)
+	if err != nil {
+		return fmt.Errorf("disable user %s: %w", username, err)
+	}
+
+	client.Enable = false
+
+	if err := x.postClient(ctx, "/panel/api/inbounds/updateClient/"+url.PathEscape(client.ID), *client); err != nil {
+		return fmt.Errorf("disable user %s: %w", username, err)
+	}
+	return nil
+}
+
+// DeleteUser удаляет клиента из inbound
+func (x *XUIProvider) DeleteUser(ctx context.Context, username string) error {
+	inbound, err := x.getInbound(ctx)
+	if err != nil {
+		return fmt.Errorf("delete user %s: %w", username, err)
+	}
+
+	client, err := findXUIClient(inbound, username)
+	if err != nil {
+		return fmt.Errorf("delete user %s: %w", username, err)
+	}
+
+	path := fmt.Sprintf("/panel/api/inbounds/%d/delClient/%s", x.inboundID, url.PathEscape(client.ID))
+	if err := x.do(ctx, http.MethodPost, path, nil, nil); err != nil {
+		return fmt.Errorf("delete user %s: %w", username, err)
+	}
+	return nil
+}
+
+// GetAllUsers возвращает всех клиентов inbound со статистикой трафика
+func (x *XUIProvider) GetAllUsers(ctx context.Context) ([]VPNUser, error) {
+	inbound, err := x.getInbound(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+
+	users := make([]VPNUser, 0, len(inbound.ClientStats))
+	for _, st := range inbound.ClientStats {
+		u := VPNUser{
+			Username:    st.Email,
+			UsedTraffic: st.Up + st.Down,
+			DataLimit:   st.Total,
+			IsActive:    st.Enable,
+		}
+		if st.ExpiryTime > 0 {
+			u.ExpiresAt = time.UnixMilli(st.ExpiryTime)
+		}
+		users = append(users, u)
+	}
+
+	return users, nil
+}
+
+// GetSystemStats получает нагрузку сервера и количество клиентов
+func (x *XUIProvider) GetSystemStats(ctx context.Context) (*SystemStats, error) {
+	var status xuiServerStatus
+	if err := x.do(ctx, http.MethodPost, "/server/status", nil, &status); err != nil {
+		return nil, fmt.Errorf("server status: %w", err)
+	}
+
+	stats := &SystemStats{
+		CPUPercent:    status.CPU,
+		NetworkRxMbps: bytesPerSecToMbps(status.NetIO.Down),
+		NetworkTxMbps: bytesPerSecToMbps(status.NetIO.Up),
+	}
+	if status.Mem.Total > 0 {
+		stats.MemoryPercent = float64(status.Mem.Current) / float64(status.Mem.Total) * 100
+	}
+
+	if inbound, err := x.getInbound(ctx); err == nil {
+		stats.TotalUsers = len(inbound.ClientStats)
+		for _, st := range inbound.ClientStats {
+			if st.Enable {
+				stats.ActiveUsers++
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// getInbound загружает настроенный inbound
+func (x *XUIProvider) getInbound(ctx context.Context) (*xuiInbound, error) {
+	var inbound xuiInbound
+	path := fmt.Sprintf("/panel/api/inbounds/get/%d", x.inboundID)
+	if err := x.do(ctx, http.MethodGet, path, nil, &inbound); err != nil {
+		return nil, err
+	}
+	return &inbound, nil
+}
+
+// postClient отправляет addClient / updateClient для одного клиента
+func (x *XUIProvider) postClient(ctx context.Context, path string, client xuiClient) error {
+	settings, err := json.Marshal(xuiInboundSettings{Clients: []xuiClient{client}})
+	if err != nil {
+		return err
+	}
+
+	body := map[string]any{
+		"id":       x.inboundID,
+		"settings": string(settings),
+	}
+	return x.do(ctx, http.MethodPost, path, body, nil)
+}
+
+// findXUIClient ищет клиента в настройках inbound по email
+func findXUIClient(inbound *xuiInbound, email string) (*xuiClient, error) {
+	var settings xuiInboundSettings
+	if err := json.Unmarshal([]byte(inbound.Settings), &settings); err != nil {
+		return nil, fmt.Errorf("parse inbound settings: %w", err)
+	}
+
+	for i := range settings.Clients {
+		if settings.Clients[i].Email == email {
+			return &settings.Clients[i], nil
+		}
+	}
+	return nil, ErrVPNUserNotFound
+}
+
+// buildLink собирает VLESS / VMess ссылку из настроек inbound
+func (x *XUIProvider) buildLink(inbound *xuiInbound, client xuiClient) (string, error) {
+	var stream xuiStreamSettings
+	if inbound.StreamSettings != "" {
+		if err := json.Unmarshal([]byte(inbound.StreamSettings), &stream); err != nil {
+			return "", fmt.Errorf("parse stream settings: %w", err)
+		}
+	}
+	if stream.Network == "" {
+		stream.Network = "tcp"
+	}
+
+	remark := client.Email
+	if inbound.Remark != "" {
+		remark = inbound.Remark + "-" + client.Email
+	}
+
+	switch inbound.Protocol {
+	case "vless":
+		return x.buildVLESSLink(inbound, client, &stream, remark), nil
+	case "vmess":
+		return x.buildVMessLink(inbound, client, &stream, remark)
+	default:
+		return "", fmt.Errorf("unsupported inbound protocol %q", inbound.Protocol)
+	}
+}
+
+// buildVLESSLink собирает vless:// ссылку
+func (x *XUIProvider) buildVLESSLink(inbound *xuiInbound, client xuiClient, stream *xuiStreamSettings, remark string) string {
+	q := url.Values{}
+	q.Set("type", stream.Network)
+	q.Set("encryption", "none")
+
+	switch stream.Network {
+	case "ws":
+		q.Set("path", stream.WSSettings.Path)
+		if host := wsHost(stream); host != "" {
+			q.Set("host", host)
+		}
+	case "grpc":
+		q.Set("serviceName", stream.GRPCSettings.ServiceName)
+	case "tcp":
+		if stream.TCPSettings.Header.Type == "http" {
+			q.Set("headerType", "http")
+		}
+	}
+
+	switch stream.Security {
+	case "reality":
+		r := stream.RealitySettings
+		q.Set("security", "reality")
+		q.Set("pbk", r.Settings.PublicKey)
+		q.Set("fp", r.Settings.Fingerprint)
+		if len(r.ServerNames) > 0 {
+			q.Set("sni", r.ServerNames[0])
+		}
+		if len(r.ShortIDs) > 0 {
+			q.Set("sid", r.ShortIDs[0])
+		}
+		if r.Settings.SpiderX != "" {
+			q.Set("spx", r.Settings.SpiderX)
+		}
+	case "tls":
+		q.Set("security", "tls")
+		if stream.TLSSettings.ServerName != "" {
+			q.Set("sni", stream.TLSSettings.ServerName)
+		}
+		if stream.TLSSettings.Settings.Fingerprint != "" {
+			q.Set("fp", stream.TLSSettings.Settings.Fingerprint)
+		}
+		if len(stream.TLSSettings.ALPN) > 0 {
+			q.Set("alpn", strings.Join(stream.TLSSettings.ALPN, ","))
+		}
+	default:
+		q.Set("security", "none")
+	}
+
+	if client.Flow != "" {
+		q.Set("flow", client.Flow)
+	}
+
+	return fmt.Sprintf("vless://%s@%s?%s#%s",
+		client.ID,
+		net.JoinHostPort(x.host, strconv.Itoa(inbound.Port)),
+		q.Encode(),
+		url.PathEscape(remark))
+}
+
+// buildVMessLink собирает vmess:// ссылку (base64 JSON)
+func (x *XUIProvider) buildVMessLink(inbound *xuiInbound, client xuiClient, stream *xuiStreamSettings, remark string) (string, error) {
+	cfg := map[string]string{
+		"v":    "2",
+		"ps":   remark,
+		"add":  x.host,
+		"port": strconv.Itoa(inbound.Port),
+		"id":   client.ID,
+		"aid":  "0",
+		"scy":  "auto",
+		"net":  stream.Network,
+		"type": "none",
+		"tls":  "",
+	}
+
+	switch stream.Network {
+	case "ws":
+		cfg["path"] = stream.WSSettings.Path
+		cfg["host"] = wsHost(stream)
+	case "grpc":
+		cfg["path"] = stream.GRPCSettings.ServiceName
+	case "tcp":
+		if stream.TCPSettings.Header.Type == "http" {
+			cfg["type"] = "http"
+		}
+	}
+
+	if stream.Security == "tls" {
+		cfg["tls"] = "tls"
+		cfg["sni"] = stream.TLSSettings.ServerName
+		cfg["fp"] = stream.TLSSettings.Settings.Fingerprint
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	return "vmess://" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// wsHost возвращает Host заголовок для WebSocket транспорта
+func wsHost(stream *xuiStreamSettings) string {
+	if stream.WSSettings.Host != "" {
+		return stream.WSSettings.Host
+	}
+	return stream.WSSettings.Headers["Host"]
+}
+
+// login открывает сессию в панели (cookie сохраняется в jar)
+func (x *XUIProvider) login(ctx context.Context) error {
+	form := url.Values{}
+	form.Set("username", x.username)
+	form.Set("password", x.password)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, x.baseURL+"/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("3x-ui login: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body xuiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("3x-ui login: status %d: %w", resp.StatusCode, err)
+	}
+	if !body.Success {
+		return fmt.Errorf("3x-ui login: %s", body.Msg)
+	}
+	return nil
+}
+
+// ensureLogin логинится, если сессии ещё нет (или refresh=true)
+func (x *XUIProvider) ensureLogin(ctx context.Context, refresh bool) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.loggedIn && !refresh {
+		return nil
+	}
+
+	if err := x.login(ctx); err != nil {
+		x.loggedIn = false
+		return err
+	}
+	x.loggedIn = true
+	return nil
+}
+
+// do выполняет запрос к панели; при истёкшей сессии перелогинивается и повторяет один раз
+func (x *XUIProvider) do(ctx context.Context, method, path string, body any, out any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := x.ensureLogin(ctx, attempt > 0); err != nil {
+			return err
+		}
+
+		err := x.doOnce(ctx, method, path, payload, out)
+		if errors.Is(err, errXUIUnauthorized) && attempt == 0 {
+			continue
+		}
+		return err
+	}
+
+	return errXUIUnauthorized
+}
+
+func (x *XUIProvider) doOnce(ctx context.Context, method, path string, payload []byte, out any) error {
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, x.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := x.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Без сессии панель отвечает редиректом на логин или 401/404
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusNotFound ||
+		(resp.StatusCode >= 300 && resp.StatusCode < 400) {
+		io.Copy(io.Discard, resp.Body)
+		return errXUIUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("3x-ui: status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	var envelope xuiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("3x-ui: decode response: %w", err)
+	}
+	if !envelope.Success {
+		return fmt.Errorf("3x-ui: %s", envelope.Msg)
+	}
+
+	if out != nil && len(envelope.Obj) > 0 && string(envelope.Obj) != "null" {
+		if err := json.Unmarshal(envelope.Obj, out); err != nil {
+			return fmt.Errorf("3x-ui: decode obj: %w", err)
+		}
+	}
+	return nil
+}
+
+// generateUUID генерирует случайный UUID v4
+func generateUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// randomHex возвращает случайную hex строку из n байт
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"vpn-telegram-bot/internal/config"
+)
+
+const (
+	xuiTestBasePath = "/secret-path"
+	xuiTestInbound  = 3
+	xuiTestCookie   = "3x-ui"
+)
+
+// fakeXUI минимальная замена 3X-UI: сессия в cookie, один inbound с клиентами и статистикой трафика
+type fakeXUI struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	logins   int
+	session  string // действующая сессия; пустая — сессия истекла
+	clients  []xuiClient
+	traffic  map[string]xuiClientTraffic
+	requests []string // "METHOD path" запросов к API (без логина)
+}
+
+func newFakeXUI(t *testing.T) (*fakeXUI, *XUIProvider) {
+	t.Helper()
+
+	f := &fakeXUI{t: t, traffic: make(map[string]xuiClientTraffic)}
+	srv := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(srv.Close)
+
+	x := NewXUIProviderWithClient(config.XUIConfig{
+		BaseURL:   srv.URL + xuiTestBasePath + "/",
+		Username:  "admin",
+		Password:  "secret",
+		InboundID: xuiTestInbound,
+		Host:      "vpn.example.com",
+		Flow:      "xtls-rprx-vision",
+	}, srv.Client())
+	return f, x
+}
+
+// expireSession завершает сессию на стороне панели
+func (f *fakeXUI) expireSession() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.session = ""
+}
+
+func (f *fakeXUI) writeObj(w http.ResponseWriter, obj any) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		f.t.Fatalf("marshal obj: %v", err)
+	}
+	json.NewEncoder(w).Encode(xuiResponse{Success: true, Obj: data})
+}
+
+func (f *fakeXUI) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path, ok := strings.CutPrefix(r.URL.Path, xuiTestBasePath)
+	if !ok {
+		f.t.Errorf("request outside of web base path: %s", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if path == "/login" {
+		if r.FormValue("username") != "admin" || r.FormValue("password") != "secret" {
+			json.NewEncoder(w).Encode(xuiResponse{Success: false, Msg: "Invalid username or password"})
+			return
+		}
+		f.logins++
+		f.session = "session-" + strconv.Itoa(f.logins)
+		http.SetCookie(w, &http.Cookie{Name: xuiTestCookie, Value: f.session, Path: "/"})
+		json.NewEncoder(w).Encode(xuiResponse{Success: true, Msg: "Login Successfully"})
+		return
+	}
+
+	// Без сессии API панели отвечает 404
+	if cookie, err := r.Cookie(xuiTestCookie); err != nil || f.session == "" || cookie.Value != f.session {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.requests = append(f.requests, r.Method+" "+path)
+
+	switch {
+	case r.Method == http.MethodGet && path == fmt.Sprintf("/panel/api/inbounds/get/%d", xuiTestInbound):
+		settings, _ := json.Marshal(xuiInboundSettings{Clients: f.clients})
+		f.writeObj(w, xuiInbound{
+			ID:       xuiTestInbound,
+			Remark:   "PL",
+			Port:     443,
+			Protocol: "vless",
+			Settings: string(settings),
+			StreamSettings: `{"network":"tcp","security":"reality","realitySettings":{"serverNames":["www.google.com"],` +
+				`"shortIds":["ab12"],"settings":{"publicKey":"PBK","fingerprint":"chrome"}}}`,
+		})
+
+	case r.Method == http.MethodPost && path == "/panel/api/inbounds/addClient":
+		client := f.decodeClient(r)
+		f.clients = append(f.clients, client)
+		json.NewEncoder(w).Encode(xuiResponse{Success: true})
+
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/panel/api/inbounds/updateClient/"):
+		id := strings.TrimPrefix(path, "/panel/api/inbounds/updateClient/")
+		client := f.decodeClient(r)
+		for i := range f.clients {
+			if f.clients[i].ID == id {
+				f.clients[i] = client
+				json.NewEncoder(w).Encode(xuiResponse{Success: true})
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(xuiResponse{Success: false, Msg: "client not found"})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/panel/api/inbounds/getClientTraffics/"):
+		email := strings.TrimPrefix(path, "/panel/api/inbounds/getClientTraffics/")
+		traffic, ok := f.traffic[email]
+		if !ok {
+			json.NewEncoder(w).Encode(xuiResponse{Success: true, Obj: json.RawMessage("null")})
+			return
+		}
+		f.writeObj(w, traffic)
+
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// decodeClient разбирает тело addClient / updateClient: inbound и settings с одним клиентом
+func (f *fakeXUI) decodeClient(r *http.Request) xuiClient {
+	var body struct {
+		ID       int    `json:"id"`
+		Settings string `json:"settings"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		f.t.Fatalf("decode client request: %v", err)
+	}
+	if body.ID != xuiTestInbound {
+		f.t.Errorf("inbound id = %d, want %d", body.ID, xuiTestInbound)
+	}
+
+	var settings xuiInboundSettings
+	if err := json.Unmarshal([]byte(body.Settings), &settings); err != nil {
+		f.t.Fatalf("decode client settings: %v", err)
+	}
+	if len(settings.Clients) != 1 {
+		f.t.Fatalf("got %d clients in request, want 1", len(settings.Clients))
+	}
+	return settings.Clients[0]
+}
+
+func TestXUICreateUser(t *testing.T) {
+	f, x := newFakeXUI(t)
+	expiresAt := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Millisecond)
+
+	key, err := x.CreateUser(context.Background(), "tg_1", "", expiresAt, UserLimits{DataLimit: 50 << 30, DeviceLimit: 3})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if f.logins != 1 {
+		t.Errorf("logins = %d, want 1", f.logins)
+	}
+	if len(f.clients) != 1 {
+		t.Fatalf("got %d clients, want 1", len(f.clients))
+	}
+	c := f.clients[0]
+	if c.Email != "tg_1" || c.TotalGB != 50<<30 || c.LimitIP != 3 || c.ExpiryTime != expiresAt.UnixMilli() ||
+		!c.Enable || c.Flow != "xtls-rprx-vision" || c.ID == "" || c.SubID == "" {
+		t.Errorf("unexpected client %+v", c)
+	}
+
+	link, err := url.Parse(key)
+	if err != nil {
+		t.Fatalf("parse key %q: %v", key, err)
+	}
+	q := link.Query()
+	if link.Scheme != "vless" || link.User.Username() != c.ID || link.Host != "vpn.example.com:443" || link.Fragment != "PL-tg_1" {
+		t.Errorf("unexpected key %s", key)
+	}
+	if q.Get("security") != "reality" || q.Get("pbk") != "PBK" || q.Get("sni") != "www.google.com" ||
+		q.Get("sid") != "ab12" || q.Get("flow") != "xtls-rprx-vision" {
+		t.Errorf("unexpected key params %v", q)
+	}
+}
+
+func TestXUIExtendUser(t *testing.T) {
+	f, x := newFakeXUI(t)
+	f.clients = []xuiClient{
+		{ID: "uuid-1", Email: "tg_1", TotalGB: 10 << 30, LimitIP: 1, ExpiryTime: 1000, Enable: false, SubID: "sub1"},
+		{ID: "uuid-2", Email: "tg_2", Enable: true},
+	}
+	expiresAt := time.Now().Add(60 * 24 * time.Hour)
+
+	if err := x.ExtendUser(context.Background(), "tg_1", expiresAt, UserLimits{DataLimit: 100 << 30, DeviceLimit: 5}); err != nil {
+		t.Fatalf("ExtendUser: %v", err)
+	}
+
+	want := []string{
+		fmt.Sprintf("GET /panel/api/inbounds/get/%d", xuiTestInbound),
+		"POST /panel/api/inbounds/updateClient/uuid-1",
+	}
+	if strings.Join(f.requests, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", f.requests, want)
+	}
+
+	c := f.clients[0]
+	if c.ExpiryTime != expiresAt.UnixMilli() || c.TotalGB != 100<<30 || c.LimitIP != 5 || !c.Enable {
+		t.Errorf("client not extended: %+v", c)
+	}
+	if c.ID != "uuid-1" || c.SubID != "sub1" {
+		t.Errorf("client identity changed: %+v", c)
+	}
+	if f.clients[1].Email != "tg_2" || !f.clients[1].Enable {
+		t.Errorf("other client changed: %+v", f.clients[1])
+	}
+}
+
+func TestXUIGetSubscriptionTraffic(t *testing.T) {
+	f, x := newFakeXUI(t)
+	f.clients = []xuiClient{{ID: "uuid-1", Email: "tg_1", TotalGB: 10 << 30, ExpiryTime: 1700000000000, Enable: true}}
+	f.traffic["tg_1"] = xuiClientTraffic{InboundID: xuiTestInbound, Email: "tg_1", Enable: true, Up: 100, Down: 250}
+
+	sub, err := x.GetSubscription(context.Background(), "tg_1")
+	if err != nil {
+		t.Fatalf("GetSubscription: %v", err)
+	}
+	if sub.DataUsed != 350 || sub.DataLimit != 10<<30 || !sub.IsActive || !sub.ExpiresAt.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("unexpected subscription %+v", sub)
+	}
+	if !strings.HasPrefix(sub.KeyString, "vless://uuid-1@") {
+		t.Errorf("unexpected key %s", sub.KeyString)
+	}
+
+	// Клиент исчерпал квоту: панель выключает его в статистике трафика
+	f.traffic["tg_1"] = xuiClientTraffic{Email: "tg_1", Enable: false, Up: 10 << 30}
+	sub, err = x.GetSubscription(context.Background(), "tg_1")
+	if err != nil {
+		t.Fatalf("GetSubscription: %v", err)
+	}
+	if sub.IsActive {
+		t.Error("subscription with disabled traffic stats is active")
+	}
+
+	if _, err := x.GetSubscription(context.Background(), "missing"); err == nil {
+		t.Error("GetSubscription of missing client succeeded")
+	}
+}
+
+func TestXUIReloginOnExpiredSession(t *testing.T) {
+	f, x := newFakeXUI(t)
+	f.clients = []xuiClient{{ID: "uuid-1", Email: "tg_1", Enable: true}}
+	ctx := context.Background()
+
+	if _, err := x.GetSubscription(ctx, "tg_1"); err != nil {
+		t.Fatalf("GetSubscription: %v", err)
+	}
+	if f.logins != 1 {
+		t.Fatalf("logins = %d, want 1", f.logins)
+	}
+
+	f.expireSession()
+	if err := x.ExtendUser(ctx, "tg_1", time.Now().Add(time.Hour), UserLimits{}); err != nil {
+		t.Fatalf("ExtendUser after session expiry: %v", err)
+	}
+	if f.logins != 2 {
+		t.Errorf("logins = %d, want 2", f.logins)
+	}
+}
+
+func TestXUILoginFailure(t *testing.T) {
+	f, x := newFakeXUI(t)
+	x.password = "wrong"
+
+	err := x.ExtendUser(context.Background(), "tg_1", time.Now(), UserLimits{})
+	if err == nil || !strings.Contains(err.Error(), "Invalid username or password") {
+		t.Fatalf("err = %v, want login error", err)
+	}
+	if len(f.requests) != 0 {
+		t.Errorf("API requests without session: %v", f.requests)
+	}
+}
@@ -0,0 +1,343 @@
+package subscription
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Форматы ответа ссылки подписки
+const (
+	FormatBase64  = "base64"  // список ссылок в base64: v2rayN, v2rayNG, Happ, Streisand, Hiddify
+	FormatClash   = "clash"   // YAML конфиг Clash / Mihomo / Stash
+	FormatSingBox = "singbox" // JSON конфиг sing-box (SFA, SFI, SFM)
+)
+
+// selectorTag имя группы выбора сервера в Clash и sing-box
+const selectorTag = "PROXY"
+
+// DetectFormat выбирает формат: явный параметр ?format= важнее User-Agent клиента
+func DetectFormat(format, userAgent string) string {
+	switch strings.ToLower(format) {
+	case "clash", "mihomo", "stash":
+		return FormatClash
+	case "singbox", "sing-box":
+		return FormatSingBox
+	case "base64", "v2ray", "v2rayn":
+		return FormatBase64
+	}
+
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "clash"), strings.Contains(ua, "mihomo"), strings.Contains(ua, "stash"):
+		return FormatClash
+	case strings.Contains(ua, "sing-box"), strings.HasPrefix(ua, "sfa/"), strings.HasPrefix(ua, "sfi/"), strings.HasPrefix(ua, "sfm/"):
+		return FormatSingBox
+	}
+	return FormatBase64
+}
+
+// Render формирует тело ответа в формате format и его Content-Type
+func Render(format string, links []string) ([]byte, string, error) {
+	switch format {
+	case FormatClash:
+		body, err := renderClash(parseLinks(links))
+		return body, "text/yaml; charset=utf-8", err
+	case FormatSingBox:
+		body, err := renderSingBox(parseLinks(links))
+		return body, "application/json; charset=utf-8", err
+	}
+	return renderBase64(links), "text/plain; charset=utf-8", nil
+}
+
+// renderBase64 список ссылок по одной на строку, целиком в base64
+func renderBase64(links []string) []byte {
+	return []byte(base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n"))))
+}
+
+// parseLinks разбирает ссылки, пропуская неподдерживаемые; имена делаются уникальными
+func parseLinks(links []string) []*Proxy {
+	var proxies []*Proxy
+	seen := make(map[string]int)
+	for _, link := range links {
+		p, err := ParseLink(link)
+		if err != nil {
+			log.Printf("⚠️ Subscription: skipped link: %v", err)
+			continue
+		}
+		seen[p.Name]++
+		if n := seen[p.Name]; n > 1 {
+			p.Name = fmt.Sprintf("%s %d", p.Name, n)
+		}
+		proxies = append(proxies, p)
+	}
+	return proxies
+}
+
+// ================= CLASH =================
+
+type clashConfig struct {
+	MixedPort   int          `yaml:"mixed-port"`
+	Mode        string       `yaml:"mode"`
+	LogLevel    string       `yaml:"log-level"`
+	Proxies     []clashProxy `yaml:"proxies"`
+	ProxyGroups []clashGroup `yaml:"proxy-groups"`
+	Rules       []string     `yaml:"rules"`
+}
+
+type clashGroup struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Proxies []string `yaml:"proxies"`
+}
+
+type clashProxy struct {
+	Name              string            `yaml:"name"`
+	Type              string            `yaml:"type"`
+	Server            string            `yaml:"server"`
+	Port              int               `yaml:"port"`
+	UUID              string            `yaml:"uuid,omitempty"`
+	AlterID           *int              `yaml:"alterId,omitempty"`
+	Cipher            string            `yaml:"cipher,omitempty"`
+	Password          string            `yaml:"password,omitempty"`
+	UDP               bool              `yaml:"udp"`
+	Flow              string            `yaml:"flow,omitempty"`
+	Network           string            `yaml:"network,omitempty"`
+	TLS               bool              `yaml:"tls,omitempty"`
+	ServerName        string            `yaml:"servername,omitempty"`
+	SNI               string            `yaml:"sni,omitempty"`
+	ALPN              []string          `yaml:"alpn,omitempty"`
+	SkipCertVerify    bool              `yaml:"skip-cert-verify,omitempty"`
+	ClientFingerprint string            `yaml:"client-fingerprint,omitempty"`
+	RealityOpts       *clashRealityOpts `yaml:"reality-opts,omitempty"`
+	WSOpts            *clashWSOpts      `yaml:"ws-opts,omitempty"`
+	GRPCOpts          *clashGRPCOpts    `yaml:"grpc-opts,omitempty"`
+	H2Opts            *clashH2Opts      `yaml:"h2-opts,omitempty"`
+}
+
+type clashRealityOpts struct {
+	PublicKey string `yaml:"public-key"`
+	ShortID   string `yaml:"short-id,omitempty"`
+}
+
+type clashWSOpts struct {
+	Path    string            `yaml:"path,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+type clashGRPCOpts struct {
+	ServiceName string `yaml:"grpc-service-name"`
+}
+
+type clashH2Opts struct {
+	Host []string `yaml:"host,omitempty"`
+	Path string   `yaml:"path,omitempty"`
+}
+
+// renderClash конфиг Mihomo: все серверы в группе выбора, весь трафик через неё
+func renderClash(proxies []*Proxy) ([]byte, error) {
+	cfg := clashConfig{
+		MixedPort: 7890,
+		Mode:      "rule",
+		LogLevel:  "warning",
+		Proxies:   []clashProxy{},
+		Rules:     []string{"MATCH," + selectorTag},
+	}
+	names := []string{}
+	for _, p := range proxies {
+		cfg.Proxies = append(cfg.Proxies, toClash(p))
+		names = append(names, p.Name)
+	}
+	if len(names) == 0 {
+		names = append(names, "DIRECT")
+	}
+	cfg.ProxyGroups = []clashGroup{{Name: selectorTag, Type: "select", Proxies: names}}
+
+	return yaml.Marshal(cfg)
+}
+
+func toClash(p *Proxy) clashProxy {
+	cp := clashProxy{
+		Name:    p.Name,
+		Type:    p.Protocol,
+		Server:  p.Server,
+		Port:    p.Port,
+		UDP:     true,
+		Network: p.Network,
+	}
+
+	switch p.Protocol {
+	case ProtocolVLESS:
+		cp.UUID, cp.Flow = p.UUID, p.Flow
+	case ProtocolVMess:
+		aid := p.AlterID
+		cp.UUID, cp.AlterID, cp.Cipher = p.UUID, &aid, "auto"
+	case ProtocolTrojan:
+		cp.Password = p.Password
+	case ProtocolShadowsocks:
+		cp.Cipher, cp.Password, cp.Network = p.Method, p.Password, ""
+		return cp
+	}
+
+	if p.Security != "" {
+		cp.ALPN, cp.SkipCertVerify, cp.ClientFingerprint = p.ALPN, p.Insecure, p.Fingerprint
+		if p.Protocol == ProtocolTrojan {
+			cp.SNI = p.SNI
+		} else {
+			cp.TLS, cp.ServerName = true, p.SNI
+		}
+	}
+	if p.Security == SecurityReality {
+		cp.RealityOpts = &clashRealityOpts{PublicKey: p.PublicKey, ShortID: p.ShortID}
+		if cp.ClientFingerprint == "" {
+			cp.ClientFingerprint = "chrome"
+		}
+	}
+
+	switch p.Network {
+	case "ws":
+		cp.WSOpts = &clashWSOpts{Path: p.Path}
+		if p.Host != "" {
+			cp.WSOpts.Headers = map[string]string{"Host": p.Host}
+		}
+	case "grpc":
+		cp.GRPCOpts = &clashGRPCOpts{ServiceName: p.ServiceName}
+	case "http":
+		cp.Network = "h2"
+		cp.H2Opts = &clashH2Opts{Host: splitList(p.Host), Path: p.Path}
+	}
+	return cp
+}
+
+// ================= SING-BOX =================
+
+type singBoxConfig struct {
+	Log       map[string]any `json:"log"`
+	Inbounds  []any          `json:"inbounds"`
+	Outbounds []any          `json:"outbounds"`
+	Route     map[string]any `json:"route"`
+}
+
+type singBoxOutbound struct {
+	Type       string            `json:"type"`
+	Tag        string            `json:"tag"`
+	Server     string            `json:"server"`
+	ServerPort int               `json:"server_port"`
+	UUID       string            `json:"uuid,omitempty"`
+	AlterID    int               `json:"alter_id,omitempty"`
+	Security   string            `json:"security,omitempty"`
+	Method     string            `json:"method,omitempty"`
+	Password   string            `json:"password,omitempty"`
+	Flow       string            `json:"flow,omitempty"`
+	TLS        *singBoxTLS       `json:"tls,omitempty"`
+	Transport  *singBoxTransport `json:"transport,omitempty"`
+}
+
+type singBoxTLS struct {
+	Enabled    bool            `json:"enabled"`
+	ServerName string          `json:"server_name,omitempty"`
+	Insecure   bool            `json:"insecure,omitempty"`
+	ALPN       []string        `json:"alpn,omitempty"`
+	UTLS       *singBoxUTLS    `json:"utls,omitempty"`
+	Reality    *singBoxReality `json:"reality,omitempty"`
+}
+
+type singBoxUTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+type singBoxReality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id,omitempty"`
+}
+
+type singBoxTransport struct {
+	Type        string            `json:"type"`
+	Path        string            `json:"path,omitempty"`
+	Host        []string          `json:"host,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"service_name,omitempty"`
+}
+
+// renderSingBox конфиг sing-box с TUN: все серверы в селекторе, весь трафик через него
+func renderSingBox(proxies []*Proxy) ([]byte, error) {
+	tags := []string{}
+	var outbounds []any
+	for _, p := range proxies {
+		outbounds = append(outbounds, toSingBox(p))
+		tags = append(tags, p.Name)
+	}
+	tags = append(tags, "direct")
+
+	cfg := singBoxConfig{
+		Log: map[string]any{"level": "warn"},
+		Inbounds: []any{map[string]any{
+			"type":         "tun",
+			"tag":          "tun-in",
+			"address":      []string{"172.19.0.1/30"},
+			"auto_route":   true,
+			"strict_route": true,
+		}},
+		Outbounds: append(
+			[]any{map[string]any{"type": "selector", "tag": selectorTag, "outbounds": tags}},
+			append(outbounds, map[string]any{"type": "direct", "tag": "direct"})...,
+		),
+		Route: map[string]any{"final": selectorTag, "auto_detect_interface": true},
+	}
+
+	return json.MarshalIndent(cfg, "", "  ")
+}
+
+func toSingBox(p *Proxy) singBoxOutbound {
+	out := singBoxOutbound{
+		Type:       p.Protocol,
+		Tag:        p.Name,
+		Server:     p.Server,
+		ServerPort: p.Port,
+	}
+
+	switch p.Protocol {
+	case ProtocolVLESS:
+		out.UUID, out.Flow = p.UUID, p.Flow
+	case ProtocolVMess:
+		out.UUID, out.AlterID, out.Security = p.UUID, p.AlterID, "auto"
+	case ProtocolTrojan:
+		out.Password = p.Password
+	case ProtocolShadowsocks:
+		out.Type, out.Method, out.Password = "shadowsocks", p.Method, p.Password
+		return out
+	}
+
+	if p.Security != "" {
+		out.TLS = &singBoxTLS{Enabled: true, ServerName: p.SNI, Insecure: p.Insecure, ALPN: p.ALPN}
+		fingerprint := p.Fingerprint
+		if p.Security == SecurityReality {
+			out.TLS.Reality = &singBoxReality{Enabled: true, PublicKey: p.PublicKey, ShortID: p.ShortID}
+			if fingerprint == "" {
+				fingerprint = "chrome" // reality в sing-box работает только с uTLS
+			}
+		}
+		if fingerprint != "" {
+			out.TLS.UTLS = &singBoxUTLS{Enabled: true, Fingerprint: fingerprint}
+		}
+	}
+
+	switch p.Network {
+	case "ws":
+		out.Transport = &singBoxTransport{Type: "ws", Path: p.Path}
+		if p.Host != "" {
+			out.Transport.Headers = map[string]string{"Host": p.Host}
+		}
+	case "grpc":
+		out.Transport = &singBoxTransport{Type: "grpc", ServiceName: p.ServiceName}
+	case "http":
+		out.Transport = &singBoxTransport{Type: "http", Path: p.Path, Host: splitList(p.Host)}
+	}
+	return out
+}
@@ -0,0 +1,276 @@
+package subscription
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Протоколы ссылок подключения
+const (
+	ProtocolVLESS       = "vless"
+	ProtocolVMess       = "vmess"
+	ProtocolTrojan      = "trojan"
+	ProtocolShadowsocks = "ss"
+)
+
+// Шифрование транспорта
+const (
+	SecurityTLS     = "tls"
+	SecurityReality = "reality"
+)
+
+// ErrUnsupportedLink ссылка не распознана или протокол не поддерживается конвертером
+var ErrUnsupportedLink = errors.New("unsupported proxy link")
+
+// Proxy параметры подключения из ссылки vless:// / vmess:// / trojan:// / ss://
+type Proxy struct {
+	Protocol string
+	Name     string
+	Server   string
+	Port     int
+
+	UUID     string // vless, vmess
+	AlterID  int    // vmess
+	Password string // trojan, ss
+	Method   string // шифр ss
+	Flow     string // vless, например xtls-rprx-vision
+
+	Network     string // tcp, ws, grpc, http
+	Path        string // ws, http
+	Host        string // ws, http
+	ServiceName string // grpc
+
+	Security    string // "", SecurityTLS или SecurityReality
+	SNI         string
+	Fingerprint string // uTLS, например chrome
+	PublicKey   string // reality
+	ShortID     string // reality
+	ALPN        []string
+	Insecure    bool
+}
+
+// ParseLink разбирает ссылку подключения
+func ParseLink(link string) (*Proxy, error) {
+	link = strings.TrimSpace(link)
+	scheme, _, ok := strings.Cut(link, "://")
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedLink, link)
+	}
+
+	switch strings.ToLower(scheme) {
+	case ProtocolVLESS, ProtocolTrojan:
+		return parseURLLink(link)
+	case ProtocolVMess:
+		return parseVMess(link)
+	case ProtocolShadowsocks:
+		return parseShadowsocks(link)
+	}
+	return nil, fmt.Errorf("%w: scheme %q", ErrUnsupportedLink, scheme)
+}
+
+// parseURLLink разбирает vless:// и trojan:// (параметры в query, имя во fragment)
+func parseURLLink(link string) (*Proxy, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedLink, err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil || u.User == nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedLink, link)
+	}
+
+	q := u.Query()
+	p := &Proxy{
+		Protocol:    strings.ToLower(u.Scheme),
+		Name:        u.Fragment,
+		Server:      u.Hostname(),
+		Port:        port,
+		Flow:        q.Get("flow"),
+		Network:     q.Get("type"),
+		Path:        q.Get("path"),
+		Host:        q.Get("host"),
+		ServiceName: q.Get("serviceName"),
+		Security:    q.Get("security"),
+		SNI:         q.Get("sni"),
+		Fingerprint: q.Get("fp"),
+		PublicKey:   q.Get("pbk"),
+		ShortID:     q.Get("sid"),
+		ALPN:        splitList(q.Get("alpn")),
+		Insecure:    q.Get("allowInsecure") == "1" || q.Get("allowInsecure") == "true",
+	}
+	if p.Protocol == ProtocolVLESS {
+		p.UUID = u.User.Username()
+	} else {
+		p.Password = u.User.Username()
+		if p.Security == "" {
+			p.Security = SecurityTLS // trojan всегда поверх TLS
+		}
+	}
+	if p.Security == "none" {
+		p.Security = ""
+	}
+	return p.normalize(), nil
+}
+
+// vmessJSON ссылка vmess:// в формате v2rayN (base64 JSON)
+type vmessJSON struct {
+	PS   string     `json:"ps"`
+	Add  string     `json:"add"`
+	Port flexString `json:"port"`
+	ID   string     `json:"id"`
+	Aid  flexString `json:"aid"`
+	Net  string     `json:"net"`
+	Host string     `json:"host"`
+	Path string     `json:"path"`
+	TLS  string     `json:"tls"`
+	SNI  string     `json:"sni"`
+	ALPN string     `json:"alpn"`
+	FP   string     `json:"fp"`
+}
+
+// flexString поле JSON, которое клиенты пишут то строкой, то числом
+type flexString string
+
+func (f *flexString) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*f = flexString(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*f = flexString(n.String())
+	return nil
+}
+
+// parseVMess разбирает vmess:// в формате v2rayN
+func parseVMess(link string) (*Proxy, error) {
+	raw, err := decodeBase64(link[len("vmess://"):])
+	if err != nil {
+		return nil, fmt.Errorf("%w: vmess: %v", ErrUnsupportedLink, err)
+	}
+	var v vmessJSON
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("%w: vmess: %v", ErrUnsupportedLink, err)
+	}
+	port, err := strconv.Atoi(string(v.Port))
+	if err != nil || v.Add == "" || v.ID == "" {
+		return nil, fmt.Errorf("%w: vmess %q", ErrUnsupportedLink, v.PS)
+	}
+	aid, _ := strconv.Atoi(string(v.Aid))
+
+	p := &Proxy{
+		Protocol:    ProtocolVMess,
+		Name:        v.PS,
+		Server:      v.Add,
+		Port:        port,
+		UUID:        v.ID,
+		AlterID:     aid,
+		Network:     v.Net,
+		Path:        v.Path,
+		Host:        v.Host,
+		SNI:         v.SNI,
+		Fingerprint: v.FP,
+		ALPN:        splitList(v.ALPN),
+	}
+	if v.TLS == SecurityTLS {
+		p.Security = SecurityTLS
+	}
+	if p.Network == "grpc" {
+		p.ServiceName, p.Path = p.Path, ""
+	}
+	return p.normalize(), nil
+}
+
+// parseShadowsocks разбирает ss:// в формате SIP002 и в старом формате (всё в base64)
+func parseShadowsocks(link string) (*Proxy, error) {
+	body := link[len("ss://"):]
+	name := ""
+	if i := strings.IndexByte(body, '#'); i >= 0 {
+		name, _ = url.PathUnescape(body[i+1:])
+		body = body[:i]
+	}
+	// Старый формат: ss://base64(method:password@host:port)
+	if !strings.Contains(body, "@") {
+		raw, err := decodeBase64(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: ss: %v", ErrUnsupportedLink, err)
+		}
+		body = string(raw)
+	}
+
+	u, err := url.Parse("ss://" + body)
+	if err != nil || u.User == nil {
+		return nil, fmt.Errorf("%w: ss %q", ErrUnsupportedLink, name)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: ss %q", ErrUnsupportedLink, name)
+	}
+
+	method, password := u.User.Username(), ""
+	if pass, ok := u.User.Password(); ok {
+		password = pass
+	} else if raw, err := decodeBase64(method); err == nil {
+		method, password, _ = strings.Cut(string(raw), ":")
+	}
+	if method == "" || password == "" {
+		return nil, fmt.Errorf("%w: ss %q", ErrUnsupportedLink, name)
+	}
+
+	return &Proxy{
+		Protocol: ProtocolShadowsocks,
+		Name:     name,
+		Server:   u.Hostname(),
+		Port:     port,
+		Method:   method,
+		Password: password,
+		Network:  "tcp",
+	}, nil
+}
+
+// normalize заполняет значения по умолчанию
+func (p *Proxy) normalize() *Proxy {
+	if p.Network == "" || p.Network == "raw" {
+		p.Network = "tcp"
+	}
+	if p.Network == "h2" {
+		p.Network = "http"
+	}
+	if p.Name == "" {
+		p.Name = fmt.Sprintf("%s:%d", p.Server, p.Port)
+	}
+	return p
+}
+
+// decodeBase64 декодирует base64 в любом из вариантов: стандартный / URL-safe, с выравниванием или без
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if raw, err := enc.DecodeString(s); err == nil {
+			return raw, nil
+		}
+	}
+	return nil, errors.New("invalid base64")
+}
+
+// splitList разбирает список через запятую ("h2,http/1.1")
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}